@@ -7,7 +7,7 @@ import (
 )
 
 var addCmd = &cobra.Command{
-	Use:  "add <path> ...",
+	Use:  "add <pathspec>...",
 	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := configure(); err != nil {
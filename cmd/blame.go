@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var blameOptions mygit.BlameOptions
+
+var blameCmd = &cobra.Command{
+	Use:  "blame [<rev>] [--] <file>",
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		if len(args) == 2 {
+			blameOptions.Rev = args[0]
+		}
+		if err := mygit.Blame(os.Stdout, blameOptions, args[len(args)-1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	blameCmd.Flags().StringArrayVarP(&blameOptions.Ranges, "L", "L", nil, "-L <start>,<end>")
+	blameCmd.Flags().BoolVarP(&blameOptions.Porcelain, "porcelain", "p", false, "--porcelain")
+	blameCmd.Flags().StringArrayVar(&blameOptions.IgnoreRevs, "ignore-rev", nil, "--ignore-rev <rev>")
+	blameCmd.Flags().StringArrayVar(&blameOptions.IgnoreRevsFiles, "ignore-revs-file", nil, "--ignore-revs-file <file>")
+	rootCmd.AddCommand(blameCmd)
+}
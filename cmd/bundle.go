@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var (
+	bundleCreateOptions mygit.BundleCreateOptions
+	bundleAll           bool
+	bundleBranches      bool
+	bundleTags          bool
+	bundleQuiet         bool
+)
+
+var bundleCmd = &cobra.Command{
+	Use: "bundle (create | verify | list-heads | unbundle)",
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:  "create <file> <revision-range>...",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		// --all, --branches and --tags are parsed as flags by cobra
+		var revs []string
+		if bundleAll {
+			revs = append(revs, "--all")
+		}
+		if bundleBranches {
+			revs = append(revs, "--branches")
+		}
+		if bundleTags {
+			revs = append(revs, "--tags")
+		}
+		revs = append(revs, args[1:]...)
+		if err := mygit.BundleCreate(args[0], revs, bundleCreateOptions); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var bundleVerifyCmd = &cobra.Command{
+	Use:  "verify <file>",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		if err := mygit.BundleVerify(os.Stdout, args[0], bundleQuiet); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var bundleListHeadsCmd = &cobra.Command{
+	Use:  "list-heads <file> [<refname>...]",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		if err := mygit.BundleListHeads(os.Stdout, args[0], args[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var bundleUnbundleCmd = &cobra.Command{
+	Use:  "unbundle <file>",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		if err := mygit.BundleUnbundle(os.Stdout, args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	bundleCreateCmd.Flags().IntVar(&bundleCreateOptions.Version, "version", 2, "--version <version>")
+	bundleCreateCmd.Flags().BoolVar(&bundleAll, "all", false, "--all")
+	bundleCreateCmd.Flags().BoolVar(&bundleBranches, "branches", false, "--branches")
+	bundleCreateCmd.Flags().BoolVar(&bundleTags, "tags", false, "--tags")
+	bundleVerifyCmd.Flags().BoolVarP(&bundleQuiet, "quiet", "q", false, "--quiet")
+	bundleCmd.AddCommand(bundleCreateCmd, bundleVerifyCmd, bundleListHeadsCmd, bundleUnbundleCmd)
+	rootCmd.AddCommand(bundleCmd)
+}
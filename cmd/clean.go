@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var cleanOptions mygit.CleanOptions
+
+var cleanCmd = &cobra.Command{
+	Use: "clean [<pathspec>...]",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		cleanOptions.Pathspec = args
+		if err := mygit.Clean(os.Stdout, cleanOptions); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	cleanCmd.Flags().BoolVarP(&cleanOptions.DryRun, "dry-run", "n", false, "--dry-run")
+	cleanCmd.Flags().CountVarP(&cleanOptions.Force, "force", "f", "--force")
+	cleanCmd.Flags().BoolVarP(&cleanOptions.Directories, "directories", "d", false, "-d")
+	cleanCmd.Flags().BoolVarP(&cleanOptions.NoIgnoreRules, "no-ignore-rules", "x", false, "-x")
+	cleanCmd.Flags().BoolVarP(&cleanOptions.IgnoredOnly, "ignored-only", "X", false, "-X")
+	cleanCmd.Flags().StringArrayVarP(&cleanOptions.Excludes, "exclude", "e", nil, "--exclude=<pattern>")
+	cleanCmd.Flags().BoolVarP(&cleanOptions.Quiet, "quiet", "q", false, "--quiet")
+	rootCmd.AddCommand(cleanCmd)
+}
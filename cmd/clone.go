@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var cloneOptions mygit.CloneOptions
+
+var cloneCmd = &cobra.Command{
+	Use:  "clone <repository> [<directory>]",
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		// the directory defaults to the humanish name of the source
+		source := strings.TrimSuffix(strings.TrimRight(args[0], "/"), "/.git")
+		dir := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(source), ".git"), ".bundle")
+		if len(args) == 2 {
+			dir = args[1]
+		}
+		if err := config.Configure(config.WithGitDirectory(gitDirectoryFlag), config.WithPath(filepath.Join(pathFlag, dir))); err != nil {
+			log.Fatalln(err)
+		}
+		if err := mygit.Clone(os.Stderr, args[0], cloneOptions); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	cloneCmd.Flags().StringVarP(&cloneOptions.Branch, "branch", "b", "", "--branch <name>")
+	cloneCmd.Flags().StringArrayVarP(&cloneOptions.Config, "config", "c", nil, "--config <key>=<value>")
+	cloneCmd.Flags().BoolVar(&cloneOptions.NoHardlinks, "no-hardlinks", false, "--no-hardlinks")
+	cloneCmd.Flags().BoolVarP(&cloneOptions.Quiet, "quiet", "q", false, "--quiet")
+	rootCmd.AddCommand(cloneCmd)
+}
@@ -4,12 +4,18 @@ import (
 	"encoding/hex"
 	"fmt"
 	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/richardjennings/mygit/internal/mygit/message"
 	"github.com/spf13/cobra"
 	"log"
 	"os"
+	"strings"
 )
 
-var commitMessage string
+var (
+	commitMessage string
+	commitCleanup string
+	commitOptions mygit.CommitOptions
+)
 
 var commitCmd = &cobra.Command{
 	Use: "commit",
@@ -21,7 +27,20 @@ var commitCmd = &cobra.Command{
 		if cmd.Flags().Changed("message") {
 			msg = []byte(commitMessage)
 		}
-		sha, err := mygit.Commit(msg)
+		if cmd.Flags().Changed("gpg-sign") {
+			// -S without a key id signs with user.signingKey
+			commitOptions.Sign = true
+			commitOptions.SigningKey = strings.TrimSpace(commitOptions.SigningKey)
+		}
+		if cmd.Flags().Changed("cleanup") {
+			mode, err := message.ParseCleanupMode(commitCleanup)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			commitOptions.Cleanup = mode
+		}
+		sha, err := mygit.Commit(msg, commitOptions)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -32,5 +51,18 @@ var commitCmd = &cobra.Command{
 
 func init() {
 	commitCmd.Flags().StringVarP(&commitMessage, "message", "m", "", "--message")
+	commitCmd.Flags().StringVarP(&commitOptions.SigningKey, "gpg-sign", "S", "", "--gpg-sign[=<keyid>]")
+	commitCmd.Flags().Lookup("gpg-sign").NoOptDefVal = " "
+	commitCmd.Flags().BoolVar(&commitOptions.NoSign, "no-gpg-sign", false, "--no-gpg-sign")
+	commitCmd.Flags().BoolVarP(&commitOptions.NoVerify, "no-verify", "n", false, "--no-verify")
+	commitCmd.Flags().BoolVarP(&commitOptions.All, "all", "a", false, "--all")
+	commitCmd.Flags().BoolVar(&commitOptions.Amend, "amend", false, "--amend")
+	commitCmd.Flags().BoolVar(&commitOptions.NoEdit, "no-edit", false, "--no-edit")
+	commitCmd.Flags().BoolVar(&commitOptions.AllowEmpty, "allow-empty", false, "--allow-empty")
+	commitCmd.Flags().StringVar(&commitOptions.Author, "author", "", "--author=<author>")
+	commitCmd.Flags().StringVar(&commitOptions.Date, "date", "", "--date=<date>")
+	commitCmd.Flags().StringVar(&commitCleanup, "cleanup", "", "--cleanup=<mode>")
+	commitCmd.Flags().StringArrayVar(&commitOptions.Trailers, "trailer", nil, "--trailer <token>[(=|:)<value>]")
+	commitCmd.Flags().BoolVarP(&commitOptions.Signoff, "signoff", "s", false, "--signoff")
 	rootCmd.AddCommand(commitCmd)
 }
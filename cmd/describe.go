@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var describeOptions mygit.DescribeOptions
+
+var describeCmd = &cobra.Command{
+	Use:  "describe [<commit-ish>]",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		rev := ""
+		if len(args) == 1 {
+			rev = args[0]
+		}
+		if err := mygit.Describe(os.Stdout, describeOptions, rev); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	describeCmd.Flags().BoolVar(&describeOptions.Tags, "tags", false, "--tags")
+	describeCmd.Flags().BoolVar(&describeOptions.Long, "long", false, "--long")
+	describeCmd.Flags().StringVar(&describeOptions.Dirty, "dirty", "", "--dirty[=<mark>]")
+	describeCmd.Flags().Lookup("dirty").NoOptDefVal = "-dirty"
+	describeCmd.Flags().IntVar(&describeOptions.Abbrev, "abbrev", 7, "--abbrev=<n>")
+	describeCmd.Flags().IntVar(&describeOptions.Candidates, "candidates", 10, "--candidates=<n>")
+	rootCmd.AddCommand(describeCmd)
+}
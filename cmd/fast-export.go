@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var (
+	fastExportOptions  mygit.FastExportOptions
+	fastExportAll      bool
+	fastExportBranches bool
+	fastExportTags     bool
+)
+
+var fastExportCmd = &cobra.Command{
+	Use: "fast-export [<revision-range>...]",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		// --all, --branches and --tags are parsed as flags by cobra
+		var revs []string
+		if fastExportAll {
+			revs = append(revs, "--all")
+		}
+		if fastExportBranches {
+			revs = append(revs, "--branches")
+		}
+		if fastExportTags {
+			revs = append(revs, "--tags")
+		}
+		revs = append(revs, args...)
+		if err := mygit.FastExport(os.Stdout, revs, fastExportOptions); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	fastExportCmd.Flags().StringVar(&fastExportOptions.ExportMarks, "export-marks", "", "--export-marks <file>")
+	fastExportCmd.Flags().StringVar(&fastExportOptions.ImportMarks, "import-marks", "", "--import-marks <file>")
+	fastExportCmd.Flags().BoolVar(&fastExportAll, "all", false, "--all")
+	fastExportCmd.Flags().BoolVar(&fastExportBranches, "branches", false, "--branches")
+	fastExportCmd.Flags().BoolVar(&fastExportTags, "tags", false, "--tags")
+	rootCmd.AddCommand(fastExportCmd)
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var fastImportOptions mygit.FastImportOptions
+
+var fastImportCmd = &cobra.Command{
+	Use:  "fast-import",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		if err := mygit.FastImport(os.Stdin, os.Stdout, fastImportOptions); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	fastImportCmd.Flags().StringVar(&fastImportOptions.ExportMarks, "export-marks", "", "--export-marks <file>")
+	fastImportCmd.Flags().StringVar(&fastImportOptions.ImportMarks, "import-marks", "", "--import-marks <file>")
+	fastImportCmd.Flags().BoolVar(&fastImportOptions.Force, "force", false, "--force")
+	rootCmd.AddCommand(fastImportCmd)
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var fetchOptions mygit.FetchOptions
+
+var fetchCmd = &cobra.Command{
+	Use:  "fetch [<remote> [<refspec>...]]",
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		remote := mygit.DefaultRemote
+		if len(args) > 0 {
+			remote, args = args[0], args[1:]
+		}
+		if err := mygit.Fetch(os.Stderr, remote, args, fetchOptions); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	fetchCmd.Flags().BoolVarP(&fetchOptions.Force, "force", "f", false, "--force")
+	fetchCmd.Flags().BoolVarP(&fetchOptions.Tags, "tags", "t", false, "--tags")
+	fetchCmd.Flags().BoolVarP(&fetchOptions.Quiet, "quiet", "q", false, "--quiet")
+	rootCmd.AddCommand(fetchCmd)
+}
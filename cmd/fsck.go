@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var fsckOptions mygit.FsckOptions
+
+var fsckCmd = &cobra.Command{
+	Use:  "fsck",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		if err := mygit.Fsck(os.Stdout, fsckOptions); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	fsckCmd.Flags().BoolVar(&fsckOptions.Unreachable, "unreachable", false, "--unreachable")
+	fsckCmd.Flags().BoolVar(&fsckOptions.NoDangling, "no-dangling", false, "--no-dangling")
+	rootCmd.AddCommand(fsckCmd)
+}
@@ -6,16 +6,19 @@ import (
 	"log"
 )
 
+var initOptions mygit.InitOptions
+
 var initCmd = &cobra.Command{
 	Use: "init",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := configure(); err != nil {
 			log.Fatalln(err)
 		}
-		return mygit.Init()
+		return mygit.Init(initOptions)
 	},
 }
 
 func init() {
+	initCmd.Flags().StringVar(&initOptions.ObjectFormat, "object-format", "", "--object-format=<format>")
 	rootCmd.AddCommand(initCmd)
 }
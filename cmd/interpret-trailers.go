@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var (
+	interpretTrailersOptions mygit.InterpretTrailersOptions
+	interpretTrailersParse   bool
+)
+
+var interpretTrailersCmd = &cobra.Command{
+	Use: "interpret-trailers [<file>...]",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		if interpretTrailersParse {
+			interpretTrailersOptions.OnlyTrailers = true
+			interpretTrailersOptions.OnlyInput = true
+			interpretTrailersOptions.Unfold = true
+		}
+		if err := mygit.InterpretTrailers(os.Stdout, os.Stdin, interpretTrailersOptions, args...); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	interpretTrailersCmd.Flags().StringArrayVar(&interpretTrailersOptions.Trailers, "trailer", nil, "--trailer <token>[(=|:)<value>]")
+	interpretTrailersCmd.Flags().BoolVar(&interpretTrailersOptions.InPlace, "in-place", false, "--in-place")
+	interpretTrailersCmd.Flags().BoolVar(&interpretTrailersOptions.OnlyTrailers, "only-trailers", false, "--only-trailers")
+	interpretTrailersCmd.Flags().BoolVar(&interpretTrailersOptions.OnlyInput, "only-input", false, "--only-input")
+	interpretTrailersCmd.Flags().BoolVar(&interpretTrailersOptions.Unfold, "unfold", false, "--unfold")
+	interpretTrailersCmd.Flags().BoolVar(&interpretTrailersParse, "parse", false, "--parse")
+	rootCmd.AddCommand(interpretTrailersCmd)
+}
@@ -1,21 +1,37 @@
 package cmd
 
 import (
+	"fmt"
 	"github.com/richardjennings/mygit/internal/mygit"
 	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/date"
+	"github.com/richardjennings/mygit/internal/mygit/pretty"
 	"github.com/spf13/cobra"
 	"log"
 	"os"
 	"os/exec"
+	"time"
+)
+
+var (
+	logDate    string
+	logOneline bool
+	logFormat  string
+	logSince   string
+	logUntil   string
+	logOptions mygit.LogOptions
 )
 
 var logCmd = &cobra.Command{
-	Use:  "log",
-	Args: cobra.ExactArgs(0),
+	Use: "log [--] [<pathspec>...]",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := configure(); err != nil {
 			log.Fatalln(err)
 		}
+		if err := logFlags(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		cmdPath, cmdArgs := config.Pager()
 		c := exec.Command(cmdPath, cmdArgs...)
 		w, err := c.StdinPipe()
@@ -23,7 +39,7 @@ var logCmd = &cobra.Command{
 			return err
 		}
 		c.Stdout = os.Stdout
-		err = mygit.Log(w)
+		err = mygit.Log(w, logOptions, args...)
 		if err != nil {
 			return err
 		}
@@ -32,6 +48,53 @@ var logCmd = &cobra.Command{
 	},
 }
 
+// logFlags sets the options of log which are parsed from flags
+func logFlags() error {
+	var err error
+	logOptions.Date, err = date.ParseMode(logDate)
+	if err != nil {
+		return err
+	}
+	if logOneline {
+		logOptions.Format = &pretty.Format{Name: "oneline"}
+		logOptions.AbbrevCommit = true
+	}
+	if logFormat != "" {
+		if logOptions.Format, err = pretty.Parse(logFormat); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	if logSince != "" {
+		if logOptions.Since, err = date.ParseApprox(logSince, now); err != nil {
+			return err
+		}
+	}
+	if logUntil != "" {
+		if logOptions.Until, err = date.ParseApprox(logUntil, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func init() {
+	logCmd.Flags().StringVar(&logDate, "date", "default", "--date=<format>")
+	logCmd.Flags().BoolVar(&logOptions.ShowSignature, "show-signature", false, "--show-signature")
+	logCmd.Flags().IntVarP(&logOptions.MaxCount, "max-count", "n", 0, "--max-count=<number>")
+	logCmd.Flags().BoolVar(&logOneline, "oneline", false, "--oneline")
+	logCmd.Flags().StringVar(&logFormat, "pretty", "", "--pretty=<format>")
+	logCmd.Flags().StringVar(&logFormat, "format", "", "--format=<format>")
+	logCmd.Flags().BoolVar(&logOptions.AbbrevCommit, "abbrev-commit", false, "--abbrev-commit")
+	logCmd.Flags().StringArrayVar(&logOptions.Authors, "author", nil, "--author=<pattern>")
+	logCmd.Flags().StringArrayVar(&logOptions.Grep, "grep", nil, "--grep=<pattern>")
+	logCmd.Flags().StringVar(&logSince, "since", "", "--since=<date>")
+	logCmd.Flags().StringVar(&logSince, "after", "", "--after=<date>")
+	logCmd.Flags().StringVar(&logUntil, "until", "", "--until=<date>")
+	logCmd.Flags().StringVar(&logUntil, "before", "", "--before=<date>")
+	logCmd.Flags().BoolVar(&logOptions.Stat, "stat", false, "--stat")
+	logCmd.Flags().BoolVar(&logOptions.NameStatus, "name-status", false, "--name-status")
+	logCmd.Flags().BoolVar(&logOptions.Graph, "graph", false, "--graph")
+	logCmd.Flags().BoolVar(&logOptions.Reverse, "reverse", false, "--reverse")
 	rootCmd.AddCommand(logCmd)
 }
@@ -8,12 +8,12 @@ import (
 )
 
 var lsFilesCmd = &cobra.Command{
-	Use: "ls-files",
+	Use: "ls-files [<pathspec>...]",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := configure(); err != nil {
 			log.Fatalln(err)
 		}
-		files, err := mygit.LsFiles()
+		files, err := mygit.LsFiles(args...)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var mvOptions mygit.MvOptions
+
+var mvCmd = &cobra.Command{
+	Use:  "mv <source>... <destination>",
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		if err := mygit.Mv(os.Stdout, mvOptions, args[:len(args)-1], args[len(args)-1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	mvCmd.Flags().BoolVarP(&mvOptions.Force, "force", "f", false, "--force")
+	mvCmd.Flags().BoolVarP(&mvOptions.DryRun, "dry-run", "n", false, "--dry-run")
+	mvCmd.Flags().BoolVarP(&mvOptions.SkipErrors, "skip-errors", "k", false, "-k")
+	mvCmd.Flags().BoolVarP(&mvOptions.Verbose, "verbose", "v", false, "--verbose")
+	rootCmd.AddCommand(mvCmd)
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var pushOptions mygit.PushOptions
+
+var pushCmd = &cobra.Command{
+	Use:  "push [<remote> [<refspec>...]]",
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		remote := mygit.DefaultRemote
+		if len(args) > 0 {
+			remote, args = args[0], args[1:]
+		}
+		if err := mygit.Push(os.Stderr, remote, args, pushOptions); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	pushCmd.Flags().BoolVarP(&pushOptions.Force, "force", "f", false, "--force")
+	pushCmd.Flags().BoolVarP(&pushOptions.Quiet, "quiet", "q", false, "--quiet")
+	pushCmd.Flags().BoolVar(&pushOptions.NoVerify, "no-verify", false, "--no-verify")
+	rootCmd.AddCommand(pushCmd)
+}
@@ -11,13 +11,13 @@ import (
 var restoreStaged bool
 
 var restoreCmd = &cobra.Command{
-	Use:  "restore",
-	Args: cobra.ExactArgs(1),
+	Use:  "restore <pathspec>...",
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := configure(); err != nil {
 			log.Fatalln(err)
 		}
-		if err := mygit.Restore(args[0], restoreStaged); err != nil {
+		if err := mygit.Restore(restoreStaged, args...); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
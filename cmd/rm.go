@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var rmOptions mygit.RmOptions
+
+var rmCmd = &cobra.Command{
+	Use:  "rm <pathspec>...",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		if err := mygit.Rm(os.Stdout, rmOptions, args...); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rmCmd.Flags().BoolVar(&rmOptions.Cached, "cached", false, "--cached")
+	rmCmd.Flags().BoolVarP(&rmOptions.Recursive, "recursive", "r", false, "-r")
+	rmCmd.Flags().BoolVarP(&rmOptions.Force, "force", "f", false, "--force")
+	rmCmd.Flags().BoolVarP(&rmOptions.Quiet, "quiet", "q", false, "--quiet")
+	rmCmd.Flags().BoolVar(&rmOptions.IgnoreUnmatch, "ignore-unmatch", false, "--ignore-unmatch")
+	rootCmd.AddCommand(rmCmd)
+}
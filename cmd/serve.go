@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	serveOptions mygit.ServeOptions
+	serveStdio   bool
+)
+
+var serveCmd = &cobra.Command{
+	Use:  "serve (--http <address> | --stdio [<command> <directory>])",
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		if serveStdio == (serveOptions.HTTP != "") {
+			fmt.Println("fatal: exactly one of --http and --stdio is required")
+			os.Exit(1)
+		}
+		if serveStdio {
+			serveOptions.Command = os.Getenv("SSH_ORIGINAL_COMMAND")
+			if len(args) > 0 {
+				serveOptions.Command = strings.Join(args, " ")
+			}
+		}
+		if err := mygit.Serve(os.Stdin, os.Stdout, os.Stderr, serveOptions); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveOptions.HTTP, "http", "", "--http <address>")
+	serveCmd.Flags().BoolVar(&serveStdio, "stdio", false, "--stdio")
+	serveCmd.Flags().BoolVar(&serveOptions.ReceivePack, "receive-pack", false, "--receive-pack")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var shortlogOptions mygit.ShortlogOptions
+
+var shortlogCmd = &cobra.Command{
+	Use: "shortlog [<revision-range>]",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configure(); err != nil {
+			log.Fatalln(err)
+		}
+		if err := mygit.Shortlog(os.Stdout, shortlogOptions, args...); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	shortlogCmd.Flags().BoolVarP(&shortlogOptions.Summary, "summary", "s", false, "--summary")
+	shortlogCmd.Flags().BoolVarP(&shortlogOptions.Numbered, "numbered", "n", false, "--numbered")
+	shortlogCmd.Flags().BoolVarP(&shortlogOptions.Email, "email", "e", false, "--email")
+	rootCmd.AddCommand(shortlogCmd)
+}
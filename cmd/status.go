@@ -3,18 +3,57 @@ package cmd
 import (
 	"fmt"
 	"github.com/richardjennings/mygit/internal/mygit"
+	"github.com/richardjennings/mygit/internal/mygit/config"
 	"github.com/spf13/cobra"
 	"os"
 )
 
+var (
+	statusPorcelain      string
+	statusShort          bool
+	statusLong           bool
+	statusBranch         bool
+	statusNullTerminated bool
+	statusUntrackedFiles string
+)
+
 var statusCmd = &cobra.Command{
-	Use: "status",
+	Use: "status [<pathspec>...]",
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := configure(); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-		if err := mygit.Status(os.Stdout); err != nil {
+		opts := mygit.StatusOptions{Branch: statusBranch, NullTerminated: statusNullTerminated, Pathspec: args}
+		switch {
+		case cmd.Flags().Changed("porcelain"):
+			switch statusPorcelain {
+			case "v1", "1":
+				opts.Format = mygit.StatusFormatPorcelainV1
+			case "v2", "2":
+				opts.Format = mygit.StatusFormatPorcelainV2
+			default:
+				fmt.Printf("fatal: unsupported porcelain version '%s'\n", statusPorcelain)
+				os.Exit(1)
+			}
+		case statusShort:
+			opts.Format = mygit.StatusFormatShort
+		case statusLong:
+			opts.Format = mygit.StatusFormatLong
+		case statusNullTerminated:
+			// -z implies porcelain v1 unless another format is given
+			opts.Format = mygit.StatusFormatPorcelainV1
+		}
+		if !cmd.Flags().Changed("untracked-files") {
+			statusUntrackedFiles, _ = config.Value("status.showUntrackedFiles")
+		}
+		mode, err := mygit.ParseUntrackedFilesMode(statusUntrackedFiles)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts.UntrackedFiles = mode
+		if err := mygit.Status(os.Stdout, opts); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
@@ -22,5 +61,13 @@ var statusCmd = &cobra.Command{
 }
 
 func init() {
+	statusCmd.Flags().StringVar(&statusPorcelain, "porcelain", "", "--porcelain[=<version>]")
+	statusCmd.Flags().Lookup("porcelain").NoOptDefVal = "v1"
+	statusCmd.Flags().BoolVarP(&statusShort, "short", "s", false, "--short")
+	statusCmd.Flags().BoolVar(&statusLong, "long", false, "--long")
+	statusCmd.Flags().BoolVarP(&statusBranch, "branch", "b", false, "--branch")
+	statusCmd.Flags().BoolVarP(&statusNullTerminated, "null", "z", false, "-z")
+	statusCmd.Flags().StringVarP(&statusUntrackedFiles, "untracked-files", "u", "normal", "--untracked-files=<mode>")
+	statusCmd.Flags().Lookup("untracked-files").NoOptDefVal = "all"
 	rootCmd.AddCommand(statusCmd)
 }
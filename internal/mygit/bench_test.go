@@ -0,0 +1,104 @@
+package mygit
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/gfs"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// benchmarkSizes are the number of files in the synthetic working trees.
+var benchmarkSizes = []int{1000, 10000, 100000}
+
+func BenchmarkLs(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		dir := benchmarkTree(b, n)
+		b.Run(fmt.Sprintf("files=%d", n), func(b *testing.B) {
+			benchmarkConfigure(b, dir)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := gfs.Ls(config.Path()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAdd(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		dir := benchmarkTree(b, n)
+		b.Run(fmt.Sprintf("files=%d", n), func(b *testing.B) {
+			benchmarkConfigure(b, dir)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := os.RemoveAll(config.IndexFilePath()); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				if err := Add("."); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkStatus(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		dir := benchmarkTree(b, n)
+		b.Run(fmt.Sprintf("files=%d", n), func(b *testing.B) {
+			benchmarkConfigure(b, dir)
+			if err := Add("."); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := Status(io.Discard, StatusOptions{Format: StatusFormatPorcelainV1}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// benchmarkTree creates an initialised repository containing n small files
+// spread over nested directories of 100 files, with an ignored directory.
+func benchmarkTree(b *testing.B, n int) string {
+	dir := b.TempDir()
+	benchmarkConfigure(b, dir)
+	if err := Init(InitOptions{}); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		d := filepath.Join(dir, fmt.Sprintf("d%d", i/1000), fmt.Sprintf("d%d", i/100))
+		if i%100 == 0 {
+			if err := os.MkdirAll(d, 0755); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(d, fmt.Sprintf("f%d", i)), []byte(fmt.Sprintf("file %d\n", i)), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	ignored := filepath.Join(dir, ".idea", "cache")
+	if err := os.MkdirAll(ignored, 0755); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < n/10; i++ {
+		if err := os.WriteFile(filepath.Join(ignored, fmt.Sprintf("f%d", i)), nil, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func benchmarkConfigure(b *testing.B, path string) {
+	if err := config.Configure(config.WithGitDirectory(config.DefaultGitDirectory), config.WithPath(path)); err != nil {
+		b.Fatal(err)
+	}
+}
@@ -0,0 +1,567 @@
+package mygit
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/diff"
+	"github.com/richardjennings/mygit/internal/mygit/gfs"
+	"github.com/richardjennings/mygit/internal/mygit/mailmap"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/pathspec"
+	"github.com/richardjennings/mygit/internal/mygit/revision"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// BlameOptions configures Blame
+type BlameOptions struct {
+	// Rev is the revision blamed from instead of HEAD
+	Rev string
+	// Ranges are the lines to blame, as <start>,<end> or <start>,+<count>
+	Ranges []string
+	// Porcelain writes the blame in the format for machines
+	Porcelain bool
+	// IgnoreRevs are revisions whose changes are blamed on their parents
+	IgnoreRevs []string
+	// IgnoreRevsFiles are files of revisions to ignore, in addition to
+	// blame.ignoreRevsFile
+	IgnoreRevsFiles []string
+}
+
+// blameLine is a line of the blamed file and the commit it is blamed on,
+// with its path and line number in that commit
+type blameLine struct {
+	text   string
+	final  int
+	orig   int
+	commit *objects.Commit
+	path   string
+}
+
+// suspect is a commit and path which lines may be blamed on
+type suspect struct {
+	commit *objects.Commit
+	path   string
+	lines  []*blameLine
+}
+
+// blame holds the commits and files read while blaming
+type blame struct {
+	commits  map[string]*objects.Commit
+	files    map[string]*gfs.FileSet
+	ignored  map[string]bool
+	previous map[string]string
+	mailmap  *mailmap.Mailmap
+}
+
+// Blame writes the commit which last changed each line of the file at path
+// in the history of HEAD. Changes made by ignored revisions are blamed on
+// their parents where they replace lines, and files are followed through
+// renames. Authors and committers are shown as mapped by the mailmap.
+func Blame(o io.Writer, opts BlameOptions, path string) error {
+	rev := opts.Rev
+	if rev == "" {
+		rev = config.DefaultHeadFile
+	}
+	sha, err := revision.Resolve(rev)
+	if err != nil {
+		return err
+	}
+	b := &blame{
+		commits:  make(map[string]*objects.Commit),
+		files:    make(map[string]*gfs.FileSet),
+		ignored:  make(map[string]bool),
+		previous: make(map[string]string),
+	}
+	if err := b.ignore(opts); err != nil {
+		return err
+	}
+	if b.mailmap, err = mailmap.New(); err != nil {
+		return err
+	}
+	head, err := b.commit(sha)
+	if err != nil {
+		return err
+	}
+	path = filepath.ToSlash(filepath.Join(pathspec.Prefix(), path))
+	f, err := b.file(head, path)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return fmt.Errorf("fatal: no such path '%s' in %s", path, rev)
+	}
+	text, err := blobLines(f.Sha.AsHexBytes())
+	if err != nil {
+		return err
+	}
+	selected, err := blameRanges(opts.Ranges, path, len(text))
+	if err != nil {
+		return err
+	}
+	var lines []*blameLine
+	for _, i := range selected {
+		lines = append(lines, &blameLine{text: text[i], final: i, orig: i})
+	}
+	queue := []*suspect{{commit: head, path: path, lines: lines}}
+	for len(queue) > 0 {
+		// the most recently committed suspect is blamed first, so that
+		// lines reaching a commit by several paths are passed on together
+		sort.SliceStable(queue, func(i, j int) bool {
+			return queue[i].commit.CommittedTime.After(queue[j].commit.CommittedTime)
+		})
+		s := queue[0]
+		queue = queue[1:]
+		parents, err := b.pass(s)
+		if err != nil {
+			return err
+		}
+		for _, p := range parents {
+			queue = mergeSuspect(queue, p)
+		}
+	}
+	if opts.Porcelain {
+		return b.writePorcelain(o, lines)
+	}
+	return b.write(o, lines, path)
+}
+
+// mergeSuspect adds s to queue, adding its lines to a suspect of the same
+// commit and path already queued.
+func mergeSuspect(queue []*suspect, s *suspect) []*suspect {
+	for _, q := range queue {
+		if string(q.commit.Sha) == string(s.commit.Sha) && q.path == s.path {
+			q.lines = append(q.lines, s.lines...)
+			return queue
+		}
+	}
+	return append(queue, s)
+}
+
+// pass passes the lines of s which are unchanged in a parent to that parent,
+// blaming the remaining lines on the commit of s.
+func (b *blame) pass(s *suspect) ([]*suspect, error) {
+	f, err := b.file(s.commit, s.path)
+	if err != nil {
+		return nil, err
+	}
+	var text []string
+	var parents []*suspect
+	var guesses []int
+	var first *suspect
+	remaining := s.lines
+	for i, sha := range s.commit.Parents {
+		if len(remaining) == 0 {
+			break
+		}
+		c, err := b.commit(sha)
+		if err != nil {
+			return nil, err
+		}
+		pf, err := b.parentFile(s.commit, c, s.path, f)
+		if err != nil {
+			return nil, err
+		}
+		if pf == nil {
+			continue
+		}
+		p := &suspect{commit: c, path: pf.Path}
+		if i == 0 {
+			b.previous[string(s.commit.Sha)] = fmt.Sprintf("%s %s", c.Sha, pf.Path)
+			first = p
+		}
+		if pf.Sha.Same(f.Sha) {
+			p.lines, remaining = remaining, nil
+			parents = append(parents, p)
+			break
+		}
+		if text == nil {
+			if text, err = blobLines(f.Sha.AsHexBytes()); err != nil {
+				return nil, err
+			}
+		}
+		ptext, err := blobLines(pf.Sha.AsHexBytes())
+		if err != nil {
+			return nil, err
+		}
+		same, guess := lineMap(diff.Lines(ptext, text), len(text))
+		if i == 0 {
+			guesses = guess
+		}
+		var kept []*blameLine
+		for _, l := range remaining {
+			if same[l.orig] >= 0 {
+				l.orig = same[l.orig]
+				p.lines = append(p.lines, l)
+			} else {
+				kept = append(kept, l)
+			}
+		}
+		remaining = kept
+		if len(p.lines) > 0 {
+			parents = append(parents, p)
+		}
+	}
+	// the changed lines of an ignored commit are blamed on the lines they
+	// replace in its first parent
+	if b.ignored[string(s.commit.Sha)] && first != nil && guesses != nil {
+		var kept []*blameLine
+		var guessed []*blameLine
+		for _, l := range remaining {
+			if guesses[l.orig] >= 0 {
+				l.orig = guesses[l.orig]
+				guessed = append(guessed, l)
+			} else {
+				kept = append(kept, l)
+			}
+		}
+		remaining = kept
+		if len(guessed) > 0 {
+			if len(first.lines) == 0 {
+				parents = append(parents, first)
+			}
+			first.lines = append(first.lines, guessed...)
+		}
+	}
+	for _, l := range remaining {
+		l.commit, l.path = s.commit, s.path
+	}
+	return parents, nil
+}
+
+// lineMap returns for each of n lines of b the line of a it is the same as,
+// and the line of a it replaces by position within a changed hunk, or -1.
+func lineMap(edits []diff.Edit, n int) ([]int, []int) {
+	same := make([]int, n)
+	guess := make([]int, n)
+	for i := range same {
+		same[i], guess[i] = -1, -1
+	}
+	// each hunk between equal lines replaces its deleted lines in order
+	var deleted, inserted []int
+	flush := func() {
+		for i, v := range inserted {
+			if i < len(deleted) {
+				guess[v] = deleted[i]
+			}
+		}
+		deleted, inserted = nil, nil
+	}
+	for _, e := range edits {
+		switch e.Op {
+		case diff.Equal:
+			same[e.B] = e.A
+			flush()
+		case diff.Delete:
+			deleted = append(deleted, e.A)
+		case diff.Insert:
+			inserted = append(inserted, e.B)
+		}
+	}
+	flush()
+	return same, guess
+}
+
+// parentFile returns the file of parent which path of c came from, the same
+// path or a file renamed to it, or nil when it was added by c.
+func (b *blame) parentFile(c *objects.Commit, parent *objects.Commit, path string, f *gfs.File) (*gfs.File, error) {
+	pf, err := b.file(parent, path)
+	if err != nil || pf != nil {
+		return pf, err
+	}
+	pfiles, err := b.fileSet(parent)
+	if err != nil {
+		return nil, err
+	}
+	files, err := b.fileSet(c)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []*gfs.File
+	for _, v := range pfiles.Files() {
+		if _, ok := files.Contains(v.Path); ok {
+			continue
+		}
+		if v.Sha.Same(f.Sha) {
+			return v, nil
+		}
+		candidates = append(candidates, v)
+	}
+	// a file removed by c which is mostly the same is renamed
+	text, err := blobLines(f.Sha.AsHexBytes())
+	if err != nil {
+		return nil, err
+	}
+	var best *gfs.File
+	var bestScore float64
+	for _, v := range candidates {
+		ptext, err := blobLines(v.Sha.AsHexBytes())
+		if err != nil {
+			return nil, err
+		}
+		common := 0
+		for _, e := range diff.Lines(ptext, text) {
+			if e.Op == diff.Equal {
+				common++
+			}
+		}
+		score := float64(2*common) / float64(len(ptext)+len(text))
+		if score >= 0.5 && score > bestScore {
+			best, bestScore = v, score
+		}
+	}
+	return best, nil
+}
+
+func (b *blame) commit(sha []byte) (*objects.Commit, error) {
+	if c, ok := b.commits[string(sha)]; ok {
+		return c, nil
+	}
+	c, err := objects.ReadCommit(sha)
+	if err != nil {
+		return nil, err
+	}
+	c.Author, c.AuthorEmail = b.mailmap.Map(c.Author, c.AuthorEmail)
+	c.Committer, c.CommitterEmail = b.mailmap.Map(c.Committer, c.CommitterEmail)
+	b.commits[string(sha)] = c
+	return c, nil
+}
+
+func (b *blame) fileSet(c *objects.Commit) (*gfs.FileSet, error) {
+	if fs, ok := b.files[string(c.Sha)]; ok {
+		return fs, nil
+	}
+	files, err := objects.CommittedFiles(c.Sha)
+	if err != nil {
+		return nil, err
+	}
+	fs := gfs.NewFileSet(files)
+	b.files[string(c.Sha)] = fs
+	return fs, nil
+}
+
+// file returns the file at path in the tree of c, nil if there is none
+func (b *blame) file(c *objects.Commit, path string) (*gfs.File, error) {
+	fs, err := b.fileSet(c)
+	if err != nil {
+		return nil, err
+	}
+	f, _ := fs.Contains(path)
+	return f, nil
+}
+
+// ignore reads the revisions to ignore from the options and
+// blame.ignoreRevsFile, which lists one revision per line with # comments.
+func (b *blame) ignore(opts BlameOptions) error {
+	revs := opts.IgnoreRevs
+	files := opts.IgnoreRevsFiles
+	if v, ok := config.Value("blame.ignoreRevsFile"); ok && v != "" {
+		files = append([]string{expandPath(v)}, files...)
+	}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("fatal: could not open object name list: %s", file)
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line, _, _ := strings.Cut(sc.Text(), "#")
+			if line = strings.TrimSpace(line); line != "" {
+				revs = append(revs, line)
+			}
+		}
+		_ = f.Close()
+		if err := sc.Err(); err != nil {
+			return err
+		}
+	}
+	for _, rev := range revs {
+		sha, err := revision.Resolve(rev)
+		if err != nil {
+			return err
+		}
+		b.ignored[string(sha)] = true
+	}
+	return nil
+}
+
+// blameRanges returns the indexes of the lines of path selected by ranges,
+// every line when there are none.
+func blameRanges(ranges []string, path string, n int) ([]int, error) {
+	if len(ranges) == 0 {
+		ranges = []string{"1,"}
+	}
+	selected := make([]bool, n)
+	for _, r := range ranges {
+		start, end, err := parseBlameRange(r, n)
+		if err != nil {
+			return nil, err
+		}
+		if start >= n && n > 0 || start > n {
+			return nil, fmt.Errorf("fatal: file %s has only %d lines", path, n)
+		}
+		for i := start; i < end && i < n; i++ {
+			selected[i] = true
+		}
+	}
+	var lines []int
+	for i, v := range selected {
+		if v {
+			lines = append(lines, i)
+		}
+	}
+	return lines, nil
+}
+
+// parseBlameRange parses <start>,<end> where either may be omitted and end
+// may be +<count> lines from start or -<count> lines before it, returning the
+// zero based start and the end after the last line.
+func parseBlameRange(r string, n int) (int, int, error) {
+	s, e, hasEnd := strings.Cut(r, ",")
+	start := 1
+	if s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 {
+			return 0, 0, fmt.Errorf("fatal: -L invalid line number: %s", s)
+		}
+		start = v
+	}
+	end := n
+	switch {
+	case !hasEnd || e == "":
+	case e[0] == '+' || e[0] == '-':
+		v, err := strconv.Atoi(e[1:])
+		if err != nil || v < 0 {
+			return 0, 0, fmt.Errorf("fatal: -L invalid line number: %s", e)
+		}
+		if v == 0 {
+			v = 1
+		}
+		if e[0] == '+' {
+			end = start + v - 1
+		} else {
+			end, start = start, start-v+1
+			if start < 1 {
+				start = 1
+			}
+		}
+	default:
+		v, err := strconv.Atoi(e)
+		if err != nil || v < 1 {
+			return 0, 0, fmt.Errorf("fatal: -L invalid line number: %s", e)
+		}
+		end = v
+		if end < start {
+			start, end = end, start
+		}
+	}
+	return start - 1, end, nil
+}
+
+// blobLines returns the lines of the blob sha without their newlines
+func blobLines(sha []byte) ([]string, error) {
+	obj, err := objects.ReadObject(sha)
+	if err != nil {
+		return nil, err
+	}
+	content, err := objects.ReadContent(obj)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, v := range lines {
+		lines[i] = strings.TrimSuffix(v, "\n")
+	}
+	return lines, nil
+}
+
+// write writes the blame as git does by default, with the abbreviated
+// commit, its path when any line comes from a path other than path, the
+// author, date and line number. Lines of root commits are marked with ^.
+func (b *blame) write(o io.Writer, lines []*blameLine, path string) error {
+	var showPath bool
+	var pathWidth, authorWidth int
+	final := 0
+	for _, l := range lines {
+		if l.path != path {
+			showPath = true
+		}
+		if w := len(l.path); w > pathWidth {
+			pathWidth = w
+		}
+		if w := utf8.RuneCountInString(l.commit.Author); w > authorWidth {
+			authorWidth = w
+		}
+		final = l.final + 1
+	}
+	lineWidth := len(strconv.Itoa(final))
+	for _, l := range lines {
+		sha := string(l.commit.Sha)[:8]
+		if len(l.commit.Parents) == 0 {
+			sha = "^" + sha[:7]
+		}
+		name := ""
+		if showPath {
+			name = fmt.Sprintf(" %-*s", pathWidth, l.path)
+		}
+		pad := strings.Repeat(" ", authorWidth-utf8.RuneCountInString(l.commit.Author))
+		when := l.commit.AuthoredTime.Format("2006-01-02 15:04:05 -0700")
+		if _, err := fmt.Fprintf(o, "%s%s (%s%s %s %*d) %s\n", sha, name, l.commit.Author, pad, when, lineWidth, l.final+1, l.text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePorcelain writes the blame for machines. Each group of consecutive
+// lines from a commit starts with a header, followed by the details of the
+// commit the first time it is shown.
+func (b *blame) writePorcelain(o io.Writer, lines []*blameLine) error {
+	shown := make(map[string]bool)
+	for i := 0; i < len(lines); {
+		l := lines[i]
+		n := 1
+		for i+n < len(lines) {
+			next := lines[i+n]
+			if next.commit != l.commit || next.final != l.final+n || next.orig != l.orig+n {
+				break
+			}
+			n++
+		}
+		c := l.commit
+		_, _ = fmt.Fprintf(o, "%s %d %d %d\n", c.Sha, l.orig+1, l.final+1, n)
+		if !shown[string(c.Sha)] {
+			shown[string(c.Sha)] = true
+			summary, _, _ := strings.Cut(string(c.Message), "\n")
+			_, _ = fmt.Fprintf(o, "author %s\nauthor-mail <%s>\nauthor-time %d\nauthor-tz %s\n", c.Author, c.AuthorEmail, c.AuthoredTime.Unix(), c.AuthoredTime.Format("-0700"))
+			_, _ = fmt.Fprintf(o, "committer %s\ncommitter-mail <%s>\ncommitter-time %d\ncommitter-tz %s\n", c.Committer, c.CommitterEmail, c.CommittedTime.Unix(), c.CommittedTime.Format("-0700"))
+			_, _ = fmt.Fprintf(o, "summary %s\n", summary)
+			if len(c.Parents) == 0 {
+				_, _ = fmt.Fprintln(o, "boundary")
+			}
+			if p, ok := b.previous[string(c.Sha)]; ok {
+				_, _ = fmt.Fprintf(o, "previous %s\n", p)
+			}
+			_, _ = fmt.Fprintf(o, "filename %s\n", l.path)
+		}
+		for j := 0; j < n; j++ {
+			if j > 0 {
+				_, _ = fmt.Fprintf(o, "%s %d %d\n", c.Sha, lines[i+j].orig+1, lines[i+j].final+1)
+			}
+			if _, err := fmt.Fprintf(o, "\t%s\n", lines[i+j].text); err != nil {
+				return err
+			}
+		}
+		i += n
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/bundle"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/revision"
+	"github.com/richardjennings/mygit/internal/mygit/transport"
+	"io"
+	"os"
+	"strings"
+)
+
+// BundleCreateOptions configures BundleCreate
+type BundleCreateOptions struct {
+	// Version is the bundle format version, 2 or 3
+	Version int
+}
+
+// BundleCreate writes a bundle to file containing the refs named in revs and
+// the objects reachable from them, except those reachable from excluded
+// revisions such as ^v1 or v1..main. Commits which are excluded but are
+// parents of included commits become prerequisites of the bundle.
+func BundleCreate(file string, revs []string, opts BundleCreateOptions) error {
+	if opts.Version == 0 {
+		opts.Version = 2
+	}
+	if opts.Version != 2 && opts.Version != 3 {
+		return fmt.Errorf("fatal: unsupported bundle version %d", opts.Version)
+	}
+	r, err := revision.ParseRange(revs)
+	if err != nil {
+		return err
+	}
+	excluded := make(map[string]struct{})
+	for _, v := range r.Exclude {
+		sha, err := objects.Peel(config.ObjectPath(), v)
+		if err != nil {
+			return err
+		}
+		ancestors, err := objects.Ancestors(sha)
+		if err != nil {
+			return err
+		}
+		for k := range ancestors {
+			excluded[k] = struct{}{}
+		}
+	}
+	h := &bundle.Header{Version: opts.Version}
+	// version 2 bundles can only hold sha1 objects
+	if config.Hash() != config.SHA1 {
+		h.Version = 3
+	}
+	if h.Version == 3 {
+		h.Capabilities = map[string]string{bundle.ObjectFormat: config.Hash().Name}
+	}
+	var tips [][]byte
+	seen := make(map[string]struct{})
+	for _, name := range r.Refs {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		sha, err := revision.Resolve(name)
+		if err != nil {
+			return err
+		}
+		peeled, err := objects.Peel(config.ObjectPath(), sha)
+		if err != nil {
+			return err
+		}
+		if _, ok := excluded[string(peeled)]; ok {
+			continue
+		}
+		h.Refs = append(h.Refs, &bundle.Ref{Name: name, Sha: sha})
+		tips = append(tips, sha)
+	}
+	if len(h.Refs) == 0 {
+		return errors.New("fatal: Refusing to create empty bundle.")
+	}
+	if h.Prerequisites, err = prerequisites(tips, excluded); err != nil {
+		return err
+	}
+	// objects reachable from the prerequisites are already in the receiving
+	// repository
+	var boundary [][]byte
+	for _, p := range h.Prerequisites {
+		boundary = append(boundary, p.Sha)
+	}
+	known, err := objects.Reachable(config.ObjectPath(), boundary, func([]byte) bool { return false })
+	if err != nil {
+		return err
+	}
+	knownSet := make(map[string]struct{}, len(known))
+	for _, v := range known {
+		knownSet[string(v)] = struct{}{}
+	}
+	shas, err := objects.Reachable(config.ObjectPath(), tips, func(sha []byte) bool {
+		_, ok := knownSet[string(sha)]
+		return ok
+	})
+	if err != nil {
+		return err
+	}
+	lock := file + ".lock"
+	f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("fatal: unable to create '%s': %w", lock, err)
+	}
+	defer func() { _ = os.Remove(lock) }()
+	if err := h.Write(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := objects.WritePack(f, config.ObjectPath(), shas); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(lock, file)
+}
+
+// prerequisites returns the excluded parents of the commits reachable from
+// tips which are not excluded.
+func prerequisites(tips [][]byte, excluded map[string]struct{}) ([]*bundle.Prerequisite, error) {
+	var r []*bundle.Prerequisite
+	seen := make(map[string]struct{})
+	var queue [][]byte
+	for _, v := range tips {
+		sha, err := objects.Peel(config.ObjectPath(), v)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, sha)
+	}
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if _, ok := seen[string(sha)]; ok {
+			continue
+		}
+		seen[string(sha)] = struct{}{}
+		if _, ok := excluded[string(sha)]; ok {
+			c, err := objects.ReadCommit(sha)
+			if err != nil {
+				return nil, err
+			}
+			subject, _, _ := strings.Cut(strings.TrimSpace(string(c.Message)), "\n")
+			r = append(r, &bundle.Prerequisite{Sha: sha, Comment: subject})
+			continue
+		}
+		obj, err := objects.ReadObject(sha)
+		if err != nil {
+			return nil, err
+		}
+		if obj.Typ != objects.ObjectCommit {
+			continue
+		}
+		c, err := objects.ReadCommit(sha)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, c.Parents...)
+	}
+	return r, nil
+}
+
+// BundleVerify checks that file is a bundle whose prerequisites are in the
+// repository and describes its contents.
+func BundleVerify(o io.Writer, file string, quiet bool) error {
+	h, r, err := bundle.Open(file)
+	if err != nil {
+		return err
+	}
+	_ = r.Close()
+	if err := transport.CheckPrerequisites(h); err != nil {
+		return err
+	}
+	if !quiet {
+		if len(h.Refs) == 1 {
+			_, _ = fmt.Fprintln(o, "The bundle contains this ref:")
+		} else {
+			_, _ = fmt.Fprintf(o, "The bundle contains these %d refs:\n", len(h.Refs))
+		}
+		for _, v := range h.Refs {
+			_, _ = fmt.Fprintf(o, "%s %s\n", v.Sha, v.Name)
+		}
+		switch len(h.Prerequisites) {
+		case 0:
+			_, _ = fmt.Fprintln(o, "The bundle records a complete history.")
+		case 1:
+			_, _ = fmt.Fprintln(o, "The bundle requires this ref:")
+		default:
+			_, _ = fmt.Fprintf(o, "The bundle requires these %d refs:\n", len(h.Prerequisites))
+		}
+		for _, v := range h.Prerequisites {
+			_, _ = fmt.Fprintf(o, "%s %s\n", v.Sha, v.Comment)
+		}
+		format := h.Capabilities[bundle.ObjectFormat]
+		if format == "" {
+			format = config.SHA1.Name
+		}
+		_, _ = fmt.Fprintf(o, "The bundle uses this hash algorithm: %s\n", format)
+	}
+	_, _ = fmt.Fprintf(o, "%s is okay\n", file)
+	return nil
+}
+
+// BundleListHeads lists the refs in file, only those matching names when
+// names are given.
+func BundleListHeads(o io.Writer, file string, names []string) error {
+	h, r, err := bundle.Open(file)
+	if err != nil {
+		return err
+	}
+	_ = r.Close()
+	for _, v := range h.Refs {
+		if len(names) > 0 && !bundleRefMatches(v.Name, names) {
+			continue
+		}
+		_, _ = fmt.Fprintf(o, "%s %s\n", v.Sha, v.Name)
+	}
+	return nil
+}
+
+// bundleRefMatches reports whether name is one of names, or ends with one of
+// them after a slash.
+func bundleRefMatches(name string, names []string) bool {
+	for _, v := range names {
+		if name == v || strings.HasSuffix(name, "/"+v) {
+			return true
+		}
+	}
+	return false
+}
+
+// BundleUnbundle stores the objects of file in the repository and lists its
+// refs, which are not updated.
+func BundleUnbundle(o io.Writer, file string) error {
+	h, r, err := bundle.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	if err := transport.CheckPrerequisites(h); err != nil {
+		return err
+	}
+	if _, err := objects.UnpackObjects(r, config.ObjectPath()); err != nil {
+		return err
+	}
+	for _, v := range h.Refs {
+		if !objects.Exists(v.Sha) {
+			return fmt.Errorf("fatal: bundle is missing %s %s", v.Sha, v.Name)
+		}
+		_, _ = fmt.Fprintf(o, "%s %s\n", v.Sha, v.Name)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package bundle
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	signatureV2 = "# v2 git bundle\n"
+	signatureV3 = "# v3 git bundle\n"
+
+	// ObjectFormat is the object format capability of version 3 bundles
+	ObjectFormat = "object-format"
+)
+
+type (
+	// Header is the part of a bundle before its pack, listing the commits
+	// which must already exist and the refs the bundle provides.
+	Header struct {
+		Version int
+		// Capabilities of a version 3 bundle, such as object-format=sha1
+		Capabilities  map[string]string
+		Prerequisites []*Prerequisite
+		Refs          []*Ref
+	}
+	// Prerequisite is a commit which is not in the bundle but is needed by
+	// the commits in the bundle. Comment is usually the subject of the
+	// commit.
+	Prerequisite struct {
+		Sha     []byte
+		Comment string
+	}
+	// Ref is a ref provided by the bundle, the hash is hex encoded
+	Ref struct {
+		Name string
+		Sha  []byte
+	}
+)
+
+// IsBundle reports whether the file at path is a bundle.
+func IsBundle(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+	b := make([]byte, len(signatureV2))
+	if _, err := io.ReadFull(f, b); err != nil {
+		return false
+	}
+	return string(b) == signatureV2 || string(b) == signatureV3
+}
+
+// Open opens the bundle at path and reads its header. The returned reader is
+// positioned at the start of the pack.
+func Open(path string) (*Header, io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error: could not open '%s'", path)
+	}
+	r := bufio.NewReader(f)
+	h, err := ReadHeader(r)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("error: '%s' does not look like a v2 or v3 bundle file: %w", path, err)
+	}
+	return h, struct {
+		io.Reader
+		io.Closer
+	}{r, f}, nil
+}
+
+// ReadHeader reads a bundle header from r up to the blank line before the
+// pack.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	h := &Header{Capabilities: make(map[string]string)}
+	l, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	switch l {
+	case signatureV2:
+		h.Version = 2
+	case signatureV3:
+		h.Version = 3
+	default:
+		return nil, errors.New("invalid signature")
+	}
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		l = strings.TrimSuffix(l, "\n")
+		switch {
+		case l == "":
+			return h, nil
+		case strings.HasPrefix(l, "@") && h.Version == 3:
+			k, v, _ := strings.Cut(l[1:], "=")
+			if k != ObjectFormat {
+				return nil, fmt.Errorf("unknown capability '%s'", l[1:])
+			}
+			if _, err := config.LookupObjectFormat(v); err != nil {
+				return nil, err
+			}
+			h.Capabilities[k] = v
+		case strings.HasPrefix(l, "-"):
+			sha, comment, _ := strings.Cut(l[1:], " ")
+			if len(sha) != h.hexSize() {
+				return nil, fmt.Errorf("invalid prerequisite '%s'", l)
+			}
+			h.Prerequisites = append(h.Prerequisites, &Prerequisite{Sha: []byte(sha), Comment: comment})
+		default:
+			sha, name, _ := strings.Cut(l, " ")
+			if len(sha) != h.hexSize() || name == "" {
+				return nil, fmt.Errorf("invalid ref '%s'", l)
+			}
+			h.Refs = append(h.Refs, &Ref{Name: name, Sha: []byte(sha)})
+		}
+	}
+}
+
+// hexSize is the length of the hex hashes of the object format of the
+// bundle.
+func (h *Header) hexSize() int {
+	f, err := config.LookupObjectFormat(h.Capabilities[ObjectFormat])
+	if err != nil {
+		return 0
+	}
+	return f.HexSize()
+}
+
+// Write writes the header to w, the pack should follow.
+func (h *Header) Write(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	switch h.Version {
+	case 2:
+		_, _ = b.WriteString(signatureV2)
+	case 3:
+		_, _ = b.WriteString(signatureV3)
+		keys := make([]string, 0, len(h.Capabilities))
+		for k := range h.Capabilities {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			_, _ = fmt.Fprintf(b, "@%s=%s\n", k, h.Capabilities[k])
+		}
+	default:
+		return fmt.Errorf("fatal: unsupported bundle version %d", h.Version)
+	}
+	for _, p := range h.Prerequisites {
+		_, _ = fmt.Fprintf(b, "-%s %s\n", p.Sha, p.Comment)
+	}
+	for _, r := range h.Refs {
+		_, _ = fmt.Fprintf(b, "%s %s\n", r.Sha, r.Name)
+	}
+	_, _ = b.WriteString("\n")
+	return b.Flush()
+}
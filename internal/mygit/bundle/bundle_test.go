@@ -0,0 +1,43 @@
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_Header(t *testing.T) {
+	sha := []byte(strings.Repeat("a", 40))
+	h := &Header{
+		Version:       3,
+		Capabilities:  map[string]string{ObjectFormat: "sha1"},
+		Prerequisites: []*Prerequisite{{Sha: sha, Comment: "first"}},
+		Refs:          []*Ref{{Name: "refs/heads/main", Sha: sha}},
+	}
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, h.Write(buf))
+	expected := "# v3 git bundle\n@object-format=sha1\n-" + string(sha) + " first\n" + string(sha) + " refs/heads/main\n\n"
+	assert.Equal(t, expected, buf.String())
+	buf.WriteString("PACK")
+	r := bufio.NewReader(buf)
+	actual, err := ReadHeader(r)
+	assert.NoError(t, err)
+	assert.Equal(t, h, actual)
+	rest, _ := r.Peek(4)
+	assert.Equal(t, "PACK", string(rest))
+
+	tests := []string{
+		"# v4 git bundle\n\n",
+		"# v2 git bundle\n@object-format=sha1\n\n",
+		"# v3 git bundle\n@filter=blob:none\n\n",
+		"# v2 git bundle\n-abc first\n\n",
+		"# v2 git bundle\n" + string(sha) + "\n\n",
+		"# v2 git bundle\n",
+	}
+	for _, tt := range tests {
+		_, err := ReadHeader(bufio.NewReader(strings.NewReader(tt)))
+		assert.Error(t, err, tt)
+	}
+}
@@ -0,0 +1,176 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/gfs"
+	"github.com/richardjennings/mygit/internal/mygit/index"
+	"github.com/richardjennings/mygit/internal/mygit/pathspec"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CleanOptions configures Clean
+type CleanOptions struct {
+	// DryRun only reports what would be removed
+	DryRun bool
+	// Force is the number of times --force was given, it is required unless
+	// clean.requireForce is false. Twice also removes nested repositories.
+	Force int
+	// Directories removes untracked directories
+	Directories bool
+	// NoIgnoreRules also removes ignored files
+	NoIgnoreRules bool
+	// IgnoredOnly only removes ignored files
+	IgnoredOnly bool
+	// Excludes are additional ignore patterns
+	Excludes []string
+	// Quiet only reports errors
+	Quiet bool
+	// Pathspec limits cleaning to matching paths
+	Pathspec []string
+}
+
+// Clean removes untracked files from the working directory.
+func Clean(o io.Writer, opts CleanOptions) error {
+	if opts.Force == 0 && !opts.DryRun {
+		c, err := config.RepositoryConfig()
+		if err != nil {
+			return err
+		}
+		if requireForce, ok := c.Bool("clean.requireForce"); !ok || requireForce {
+			return errors.New("fatal: clean.requireForce defaults to true and neither -n nor -f given; refusing to clean")
+		}
+	}
+	if opts.NoIgnoreRules && opts.IgnoredOnly {
+		return errors.New("fatal: -x and -X cannot be used together")
+	}
+	ps, err := pathspec.New(pathspec.Prefix(), opts.Pathspec...)
+	if err != nil {
+		return err
+	}
+	idx, err := index.ReadIndex()
+	if err != nil {
+		return err
+	}
+	files, ignored, err := gfs.LsWithOptions(config.Path(), gfs.LsOptions{NoIgnoreRules: opts.NoIgnoreRules, Excludes: opts.Excludes})
+	if err != nil {
+		return err
+	}
+	idxSet := gfs.NewFileSet(idx.Files())
+	idxSet.MergeFromWD(gfs.NewFileSet(files))
+	var untracked []string
+	for _, v := range idxSet.Files() {
+		if v.WdStatus == gfs.WDUntracked {
+			untracked = append(untracked, v.Path)
+		}
+	}
+	trackedDirs := make(map[string]struct{})
+	for _, v := range idx.Files() {
+		for d := filepath.Dir(v.Path); d != "."; d = filepath.Dir(d) {
+			trackedDirs[d] = struct{}{}
+		}
+	}
+	// candidates are removed, protected paths must be kept
+	candidates, protected := untracked, ignored
+	if opts.IgnoredOnly {
+		candidates, protected = nil, untracked
+		for _, v := range ignored {
+			if _, ok := idxSet.Contains(v); !ok {
+				candidates = append(candidates, v)
+			}
+		}
+	}
+
+	sep := string(filepath.Separator)
+	var remove []string
+	selected := make(map[string]struct{})
+	for _, v := range candidates {
+		if !ps.Match(strings.TrimSuffix(v, sep)) {
+			continue
+		}
+		p, ok := cleanPath(v, trackedDirs, protected, opts.Directories)
+		if !ok {
+			continue
+		}
+		if _, ok := selected[p]; ok {
+			continue
+		}
+		selected[p] = struct{}{}
+		remove = append(remove, p)
+	}
+	sort.Strings(remove)
+
+	msg := "Removing %s\n"
+	if opts.DryRun {
+		msg = "Would remove %s\n"
+	}
+	for _, v := range remove {
+		abs := filepath.Join(config.Path(), v)
+		if strings.HasSuffix(v, sep) {
+			if _, err := os.Stat(filepath.Join(abs, config.Config.GitDirectory)); err == nil && opts.Force < 2 {
+				// nested repository
+				if !opts.Quiet {
+					if _, err := fmt.Fprintf(o, "Skipping repository %s\n", v); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+		if !opts.Quiet || opts.DryRun {
+			if _, err := fmt.Fprintf(o, msg, v); err != nil {
+				return err
+			}
+		}
+		if opts.DryRun {
+			continue
+		}
+		if err := os.RemoveAll(abs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanPath returns the path to remove for candidate. Within untracked
+// directories this is the top-most directory containing no protected paths,
+// which is only removed when directories is set.
+func cleanPath(candidate string, trackedDirs map[string]struct{}, protected []string, directories bool) (string, bool) {
+	sep := string(filepath.Separator)
+	isDir := strings.HasSuffix(candidate, sep)
+	parts := strings.Split(strings.TrimSuffix(candidate, sep), sep)
+	for i := 1; i <= len(parts); i++ {
+		d := filepath.Join(parts[:i]...)
+		if i == len(parts) && !isDir {
+			break
+		}
+		if _, ok := trackedDirs[d]; ok {
+			continue
+		}
+		// d is an untracked directory
+		if !directories {
+			return "", false
+		}
+		if !containsPrefix(protected, d+sep) {
+			return d + sep, true
+		}
+	}
+	if isDir {
+		return "", false
+	}
+	return candidate, true
+}
+
+func containsPrefix(paths []string, prefix string) bool {
+	for _, v := range paths {
+		if strings.HasPrefix(v, prefix) {
+			return true
+		}
+	}
+	return false
+}
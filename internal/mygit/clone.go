@@ -0,0 +1,302 @@
+package mygit
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/bundle"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"github.com/richardjennings/mygit/internal/mygit/transport"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultRemote is the name of the remote created by Clone
+const DefaultRemote = "origin"
+
+// CloneOptions configures Clone
+type CloneOptions struct {
+	// Branch is checked out instead of the branch pointed to by the
+	// source HEAD
+	Branch string
+	// NoHardlinks copies object files instead of hard linking them
+	NoHardlinks bool
+	// Quiet only reports errors
+	Quiet bool
+	// Config are key=value pairs set in the configuration of the new
+	// repository before it is checked out
+	Config []string
+}
+
+// Clone clones the repository at source into the configured path, which
+// must not exist or be an empty directory. The objects of a local source are
+// hard linked or copied, otherwise they are fetched from an http url or
+// bundle file. The branches of source become remote-tracking branches of the
+// remote origin and the branch pointed to by its HEAD is checked out.
+func Clone(o io.Writer, source string, opts CloneOptions) error {
+	url := source
+	var srcGitDir string
+	if !transport.IsHTTP(source) {
+		var err error
+		if url, err = filepath.Abs(strings.TrimPrefix(source, "file://")); err != nil {
+			return err
+		}
+		if !bundle.IsBundle(url) {
+			if srcGitDir, err = transport.LocalGitDir(source); err != nil {
+				return err
+			}
+		}
+	}
+	if entries, err := os.ReadDir(config.Path()); err == nil && len(entries) > 0 {
+		return fmt.Errorf("fatal: destination path '%s' already exists and is not an empty directory.", config.Path())
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if !opts.Quiet {
+		_, _ = fmt.Fprintf(o, "Cloning into '%s'...\n", filepath.Base(config.Path()))
+	}
+	// the clone names objects as the source does
+	format, err := transport.ObjectFormat(url)
+	if err != nil {
+		return err
+	}
+	if err := Init(InitOptions{ObjectFormat: format.Name}); err != nil {
+		return err
+	}
+	var srcRefs map[string][]byte
+	var remoteHead string
+	if srcGitDir != "" {
+		srcRefs, remoteHead, err = cloneLocal(srcGitDir, !opts.NoHardlinks)
+	} else {
+		srcRefs, remoteHead, err = cloneFetch(o, url, opts.Quiet)
+	}
+	if err != nil {
+		return err
+	}
+	for name, sha := range srcRefs {
+		var local string
+		switch {
+		case strings.HasPrefix(name, "refs/heads/"):
+			local = fmt.Sprintf("refs/remotes/%s/%s", DefaultRemote, strings.TrimPrefix(name, "refs/heads/"))
+		case strings.HasPrefix(name, "refs/tags/"):
+			local = name
+		default:
+			continue
+		}
+		if err := updateRefHex(local, sha); err != nil {
+			return err
+		}
+	}
+	if remoteHead != "" && srcRefs[remoteHead] != nil {
+		remoteTracking := fmt.Sprintf("refs/remotes/%s/%s", DefaultRemote, strings.TrimPrefix(remoteHead, "refs/heads/"))
+		if err := refs.UpdateSymbolicRef(fmt.Sprintf("refs/remotes/%s/HEAD", DefaultRemote), remoteTracking); err != nil {
+			return err
+		}
+	}
+	branch := remoteHead
+	if opts.Branch != "" {
+		branch = "refs/heads/" + opts.Branch
+		if srcRefs[branch] == nil {
+			return fmt.Errorf("fatal: Remote branch %s not found in upstream %s", opts.Branch, DefaultRemote)
+		}
+	}
+	cnf, err := config.RepositoryConfig()
+	if err != nil {
+		return err
+	}
+	if err := cnf.Set(fmt.Sprintf("remote.%s.url", DefaultRemote), url); err != nil {
+		return err
+	}
+	if err := cnf.Set(fmt.Sprintf("remote.%s.fetch", DefaultRemote), fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", DefaultRemote)); err != nil {
+		return err
+	}
+	for _, v := range opts.Config {
+		key, value, found := strings.Cut(v, "=")
+		if !found {
+			value = "true"
+		}
+		if err := cnf.Set(key, value); err != nil {
+			return err
+		}
+	}
+	if branch == "" {
+		return cnf.Write(config.GitConfigPath())
+	}
+	name := strings.TrimPrefix(branch, "refs/heads/")
+	if err := cnf.Set(fmt.Sprintf("branch.%s.remote", name), DefaultRemote); err != nil {
+		return err
+	}
+	if err := cnf.Set(fmt.Sprintf("branch.%s.merge", name), branch); err != nil {
+		return err
+	}
+	if err := cnf.Write(config.GitConfigPath()); err != nil {
+		return err
+	}
+	sha := srcRefs[branch]
+	if sha == nil {
+		if !opts.Quiet {
+			_, _ = fmt.Fprintln(o, "warning: You appear to have cloned an empty repository.")
+		}
+		return refs.UpdateHead(name)
+	}
+	// check out while HEAD is unborn so that the index starts empty
+	if err := checkout(sha); err != nil {
+		return err
+	}
+	if err := updateRefHex(branch, sha); err != nil {
+		return err
+	}
+	if err := refs.UpdateHead(name); err != nil {
+		return err
+	}
+	return runHook("post-checkout", []string{string(config.Hash().ZeroHex()), string(sha), "1"})
+}
+
+// cloneLocal links or copies the objects of the repository at gitDir and
+// returns its refs and the branch its HEAD points to.
+func cloneLocal(gitDir string, link bool) (map[string][]byte, string, error) {
+	if err := copyObjects(filepath.Join(gitDir, config.Config.ObjectsDirectory), config.ObjectPath(), link); err != nil {
+		return nil, "", err
+	}
+	srcRefs, err := refs.ListRefsIn(gitDir)
+	if err != nil {
+		return nil, "", err
+	}
+	head, err := refs.SymbolicRefIn(gitDir, config.DefaultHeadFile)
+	if err != nil || head != "" {
+		return srcRefs, head, err
+	}
+	// HEAD is detached
+	sha, err := refs.ReadRefIn(gitDir, config.DefaultHeadFile)
+	if err != nil {
+		return nil, "", err
+	}
+	return srcRefs, branchAt(sha, srcRefs), nil
+}
+
+// cloneFetch fetches the branches and tags of the repository at url and
+// returns its refs and the branch its HEAD points to.
+func cloneFetch(o io.Writer, url string, quiet bool) (map[string][]byte, string, error) {
+	t, err := transport.New(url, transport.WithProgress(progressWriter(o, quiet)))
+	if err != nil {
+		return nil, "", err
+	}
+	advertised, err := t.Refs()
+	if err != nil {
+		return nil, "", err
+	}
+	srcRefs := make(map[string][]byte)
+	var head *transport.Ref
+	var wants [][]byte
+	seen := make(map[string]struct{})
+	for _, v := range advertised {
+		if v.Name == config.DefaultHeadFile {
+			head = v
+			continue
+		}
+		srcRefs[v.Name] = v.Sha
+		if !strings.HasPrefix(v.Name, "refs/heads/") && !strings.HasPrefix(v.Name, "refs/tags/") {
+			continue
+		}
+		if _, ok := seen[string(v.Sha)]; !ok {
+			seen[string(v.Sha)] = struct{}{}
+			wants = append(wants, v.Sha)
+		}
+	}
+	if len(wants) > 0 {
+		if err := t.Fetch(wants); err != nil {
+			return nil, "", err
+		}
+	}
+	switch {
+	case head == nil:
+		return srcRefs, "", nil
+	case head.Target != "":
+		return srcRefs, head.Target, nil
+	}
+	return srcRefs, branchAt(head.Sha, srcRefs), nil
+}
+
+// branchAt returns the first branch pointing at sha, used when the branch
+// HEAD points to is not known.
+func branchAt(sha []byte, branches map[string][]byte) string {
+	if sha == nil {
+		return ""
+	}
+	var names []string
+	for k, v := range branches {
+		if strings.HasPrefix(k, "refs/heads/") && string(v) == string(sha) {
+			names = append(names, k)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// updateRefHex points the fully qualified ref name at the hex sha.
+func updateRefHex(name string, sha []byte) error {
+	raw, err := hex.DecodeString(string(sha))
+	if err != nil {
+		return err
+	}
+	return refs.UpdateRef(name, raw)
+}
+
+// copyObjects links or copies loose objects and packs from the object store
+// src into dst, skipping objects which already exist.
+func copyObjects(src string, dst string, link bool) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel == "info" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		target := filepath.Join(dst, rel)
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		if link {
+			if err := os.Link(path, target); err == nil {
+				return nil
+			}
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = out.Close() }()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -2,14 +2,18 @@ package config
 
 import (
 	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/date"
 	"os"
 	"path/filepath"
+	"runtime"
+	"time"
 )
 
 const (
 	DefaultGitDirectory       = ".git"
 	DefaultPath               = "."
 	DefaultHeadFile           = "HEAD"
+	DefaultConfigFile         = "config"
 	DefaultIndexFile          = "index"
 	DefaultObjectsDirectory   = "objects"
 	DefaultRefsDirectory      = "refs"
@@ -33,6 +37,9 @@ type (
 		GitIgnore          []string
 		Editor             string
 		EditorArgs         []string
+		Workers            int
+		// ObjectFormat is the hash algorithm objects are named with
+		ObjectFormat *ObjectFormat
 	}
 	Opt func(m *Cnf) error
 )
@@ -55,6 +62,18 @@ func WithGitDirectory(name string) Opt {
 	}
 }
 
+// WithWorkers sets the number of concurrent workers used when scanning and
+// hashing the working directory.
+func WithWorkers(n int) Opt {
+	return func(m *Cnf) error {
+		if n < 1 {
+			return fmt.Errorf("invalid number of workers %d", n)
+		}
+		m.Workers = n
+		return nil
+	}
+}
+
 func Configure(opts ...Opt) error {
 	c := &Cnf{
 		GitDirectory:       DefaultGitDirectory,
@@ -66,7 +85,8 @@ func Configure(opts ...Opt) error {
 		RefsHeadsDirectory: DefaultRefsHeadsDirectory,
 		DefaultBranch:      DefaultBranch,
 		Editor:             DefaultEditor,
-		GitIgnore: []string{ //@todo read from .gitignore
+		Workers:            runtime.NumCPU(),
+		GitIgnore: []string{ // in addition to .gitignore files
 			".idea/",
 		},
 	}
@@ -83,6 +103,11 @@ func Configure(opts ...Opt) error {
 		c.Path = p
 	}
 	Config = *c
+	f, err := repositoryObjectFormat()
+	if err != nil {
+		return err
+	}
+	Config.ObjectFormat = f
 	return nil
 }
 
@@ -118,6 +143,31 @@ func GitHeadPath() string {
 	return filepath.Join(Config.Path, Config.GitDirectory, Config.HeadFile)
 }
 
+func GitConfigPath() string {
+	return filepath.Join(Config.Path, Config.GitDirectory, DefaultConfigFile)
+}
+
+// RepositoryConfig reads the repository git configuration file.
+func RepositoryConfig() (*GitConfig, error) {
+	return ReadGitConfig(GitConfigPath())
+}
+
+// Value returns the value of key from the repository git configuration.
+func Value(key string) (string, bool) {
+	c, err := RepositoryConfig()
+	if err != nil {
+		return "", false
+	}
+	return c.Get(key)
+}
+
+func Workers() int {
+	if Config.Workers < 1 {
+		return 1
+	}
+	return Config.Workers
+}
+
 func Pager() (string, []string) {
 	return "/usr/bin/less", []string{"-X", "-F"}
 }
@@ -144,6 +194,23 @@ func AuthorEmail() string {
 	return "default@default.com"
 }
 
+// AuthorDate is the time GIT_AUTHOR_DATE is set to, or the current time.
+func AuthorDate() (time.Time, error) {
+	if v, ok := os.LookupEnv("GIT_AUTHOR_DATE"); ok {
+		return date.Parse(v)
+	}
+	return time.Now(), nil
+}
+
+// CommitterDate is the time GIT_COMMITTER_DATE is set to, or the current
+// time.
+func CommitterDate() (time.Time, error) {
+	if v, ok := os.LookupEnv("GIT_COMMITTER_DATE"); ok {
+		return date.Parse(v)
+	}
+	return time.Now(), nil
+}
+
 func CommitterName() string {
 	if v, ok := os.LookupEnv("GIT_COMMITTER_NAME"); ok {
 		return v
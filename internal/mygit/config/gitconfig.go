@@ -0,0 +1,317 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type (
+	// GitConfig represents the entries of a git configuration file such as
+	// .git/config in the order in which they appear.
+	GitConfig struct {
+		entries []*gitConfigEntry
+	}
+	gitConfigEntry struct {
+		section    string
+		subsection string
+		name       string
+		value      string
+	}
+)
+
+// ReadGitConfig reads a git configuration file. A missing file results in an
+// empty configuration.
+func ReadGitConfig(path string) (*GitConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &GitConfig{}, nil
+		}
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return ParseGitConfig(f)
+}
+
+// ParseGitConfig parses git configuration file syntax.
+func ParseGitConfig(r io.Reader) (*GitConfig, error) {
+	c := &GitConfig{}
+	var section, subsection string
+	s := bufio.NewScanner(r)
+	n := 0
+	for s.Scan() {
+		n++
+		line := strings.TrimSpace(s.Text())
+		// line continuation
+		for strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") && s.Scan() {
+			n++
+			line = line[:len(line)-1] + s.Text()
+		}
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			e := strings.Index(line, "]")
+			if e < 0 {
+				return nil, fmt.Errorf("bad config line %d", n)
+			}
+			header := strings.TrimSpace(line[1:e])
+			if q := strings.Index(header, "\""); q >= 0 {
+				section = strings.ToLower(strings.TrimSpace(header[:q]))
+				subsection = strings.TrimSuffix(header[q+1:], "\"")
+				subsection = strings.ReplaceAll(subsection, "\\\"", "\"")
+				subsection = strings.ReplaceAll(subsection, "\\\\", "\\")
+			} else if d := strings.Index(header, "."); d >= 0 {
+				// deprecated [section.subsection] syntax
+				section = strings.ToLower(header[:d])
+				subsection = strings.ToLower(header[d+1:])
+			} else {
+				section = strings.ToLower(header)
+				subsection = ""
+			}
+			line = strings.TrimSpace(line[e+1:])
+			if line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+		}
+		if section == "" {
+			return nil, fmt.Errorf("bad config line %d", n)
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok {
+			// a name without a value is a boolean true
+			value = "true"
+		} else {
+			value = parseGitConfigValue(value)
+		}
+		c.entries = append(c.entries, &gitConfigEntry{section: section, subsection: subsection, name: name, value: value})
+	}
+	return c, s.Err()
+}
+
+// parseGitConfigValue handles quoting, escapes and trailing comments.
+func parseGitConfigValue(v string) string {
+	var b strings.Builder
+	quoted := false
+	v = strings.TrimSpace(v)
+	for i := 0; i < len(v); i++ {
+		ch := v[i]
+		switch {
+		case ch == '"':
+			quoted = !quoted
+		case ch == '\\' && i+1 < len(v):
+			i++
+			switch v[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			default:
+				b.WriteByte(v[i])
+			}
+		case (ch == '#' || ch == ';') && !quoted:
+			return strings.TrimSpace(b.String())
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	if quoted {
+		return b.String()
+	}
+	return strings.TrimRight(b.String(), " \t")
+}
+
+// splitKey splits a key such as branch.main.remote into its section,
+// subsection and name parts.
+func splitKey(key string) (string, string, string, error) {
+	s := strings.Index(key, ".")
+	e := strings.LastIndex(key, ".")
+	if s < 0 || e == len(key)-1 || s == 0 {
+		return "", "", "", fmt.Errorf("error: key does not contain a section: %s", key)
+	}
+	section := strings.ToLower(key[:s])
+	name := strings.ToLower(key[e+1:])
+	subsection := ""
+	if s != e {
+		subsection = key[s+1 : e]
+	}
+	return section, subsection, name, nil
+}
+
+func (e *gitConfigEntry) matches(section, subsection, name string) bool {
+	return e.section == section && e.subsection == subsection && e.name == name
+}
+
+// Get returns the last value set for key.
+func (c *GitConfig) Get(key string) (string, bool) {
+	values := c.GetAll(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// GetAll returns all the values set for key in order.
+func (c *GitConfig) GetAll(key string) []string {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return nil
+	}
+	var values []string
+	for _, v := range c.entries {
+		if v.matches(section, subsection, name) {
+			values = append(values, v.value)
+		}
+	}
+	return values
+}
+
+// Bool returns the value of key interpreted as a git boolean.
+func (c *GitConfig) Bool(key string) (bool, bool) {
+	v, ok := c.Get(key)
+	if !ok {
+		return false, false
+	}
+	switch strings.ToLower(v) {
+	case "true", "yes", "on", "1":
+		return true, true
+	}
+	return false, true
+}
+
+// Subsections returns the distinct subsection names of section in order.
+func (c *GitConfig) Subsections(section string) []string {
+	section = strings.ToLower(section)
+	var names []string
+	seen := make(map[string]bool)
+	for _, v := range c.entries {
+		if v.section == section && v.subsection != "" && !seen[v.subsection] {
+			seen[v.subsection] = true
+			names = append(names, v.subsection)
+		}
+	}
+	return names
+}
+
+// Set replaces all values of key with value.
+func (c *GitConfig) Set(key string, value string) error {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	found := false
+	var entries []*gitConfigEntry
+	for _, v := range c.entries {
+		if v.matches(section, subsection, name) {
+			if found {
+				continue
+			}
+			v.value = value
+			found = true
+		}
+		entries = append(entries, v)
+	}
+	c.entries = entries
+	if !found {
+		return c.Add(key, value)
+	}
+	return nil
+}
+
+// Add adds a value for key, keeping any existing values.
+func (c *GitConfig) Add(key string, value string) error {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	entry := &gitConfigEntry{section: section, subsection: subsection, name: name, value: value}
+	// insert after the last entry of the same section
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		if c.entries[i].section == section && c.entries[i].subsection == subsection {
+			c.entries = append(c.entries[:i+1], append([]*gitConfigEntry{entry}, c.entries[i+1:]...)...)
+			return nil
+		}
+	}
+	c.entries = append(c.entries, entry)
+	return nil
+}
+
+// Unset removes all values of key.
+func (c *GitConfig) Unset(key string) error {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	var entries []*gitConfigEntry
+	for _, v := range c.entries {
+		if !v.matches(section, subsection, name) {
+			entries = append(entries, v)
+		}
+	}
+	c.entries = entries
+	return nil
+}
+
+// RemoveSection removes all the entries of a section and subsection.
+func (c *GitConfig) RemoveSection(section string, subsection string) {
+	section = strings.ToLower(section)
+	var entries []*gitConfigEntry
+	for _, v := range c.entries {
+		if v.section != section || v.subsection != subsection {
+			entries = append(entries, v)
+		}
+	}
+	c.entries = entries
+}
+
+// Bytes returns the configuration in git configuration file syntax.
+func (c *GitConfig) Bytes() []byte {
+	buf := bytes.NewBuffer(nil)
+	var section, subsection string
+	// group entries by section preserving the order of first appearance
+	var order []*gitConfigEntry
+	grouped := make(map[[2]string][]*gitConfigEntry)
+	for _, v := range c.entries {
+		k := [2]string{v.section, v.subsection}
+		if _, ok := grouped[k]; !ok {
+			order = append(order, v)
+		}
+		grouped[k] = append(grouped[k], v)
+	}
+	for _, o := range order {
+		section, subsection = o.section, o.subsection
+		if subsection == "" {
+			_, _ = fmt.Fprintf(buf, "[%s]\n", section)
+		} else {
+			sub := strings.ReplaceAll(subsection, "\\", "\\\\")
+			sub = strings.ReplaceAll(sub, "\"", "\\\"")
+			_, _ = fmt.Fprintf(buf, "[%s \"%s\"]\n", section, sub)
+		}
+		for _, v := range grouped[[2]string{section, subsection}] {
+			_, _ = fmt.Fprintf(buf, "\t%s = %s\n", v.name, formatGitConfigValue(v.value))
+		}
+	}
+	return buf.Bytes()
+}
+
+func formatGitConfigValue(v string) string {
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t")
+	escaped := r.Replace(v)
+	if v != strings.TrimSpace(v) || strings.ContainsAny(v, "#;") {
+		return "\"" + escaped + "\""
+	}
+	return escaped
+}
+
+// Write writes the configuration to path.
+func (c *GitConfig) Write(path string) error {
+	return os.WriteFile(path, c.Bytes(), 0644)
+}
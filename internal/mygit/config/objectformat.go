@@ -0,0 +1,120 @@
+package config
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ObjectFormat is the hash algorithm a repository names objects with, as
+// set by extensions.objectFormat
+type ObjectFormat struct {
+	Name string
+	// Size is the length of a hash in bytes
+	Size int
+	new  func() hash.Hash
+}
+
+var (
+	SHA1   = &ObjectFormat{Name: "sha1", Size: sha1.Size, new: sha1.New}
+	SHA256 = &ObjectFormat{Name: "sha256", Size: sha256.Size, new: sha256.New}
+
+	// formats caches the object format of other repositories by git
+	// directory
+	formats   = map[string]*ObjectFormat{}
+	formatsMu sync.Mutex
+)
+
+// LookupObjectFormat returns the object format called name.
+func LookupObjectFormat(name string) (*ObjectFormat, error) {
+	switch strings.ToLower(name) {
+	case "", SHA1.Name:
+		return SHA1, nil
+	case SHA256.Name:
+		return SHA256, nil
+	}
+	return nil, fmt.Errorf("unknown object format '%s'", name)
+}
+
+// New returns a new hash.Hash computing hashes of the object format.
+func (f *ObjectFormat) New() hash.Hash {
+	return f.new()
+}
+
+// Sum returns the hash of b.
+func (f *ObjectFormat) Sum(b []byte) []byte {
+	h := f.new()
+	_, _ = h.Write(b)
+	return h.Sum(nil)
+}
+
+// HexSize is the length of a hash in hex.
+func (f *ObjectFormat) HexSize() int {
+	return f.Size * 2
+}
+
+// ZeroHex is the hex hash of all zeros, which stands for a missing object.
+func (f *ObjectFormat) ZeroHex() []byte {
+	return []byte(strings.Repeat("0", f.HexSize()))
+}
+
+// IsHex reports whether s is a lower case hex hash of the object format.
+func (f *ObjectFormat) IsHex(s string) bool {
+	return len(s) == f.HexSize() && strings.Trim(s, "0123456789abcdef") == ""
+}
+
+// Hash returns the object format of the repository, SHA-1 unless
+// extensions.objectFormat says otherwise.
+func Hash() *ObjectFormat {
+	if Config.ObjectFormat == nil {
+		return SHA1
+	}
+	return Config.ObjectFormat
+}
+
+// HashOf returns the object format of the repository at gitDir, which is
+// read from its configuration unless it is the configured repository. A
+// configuration which cannot be read is taken to be SHA-1.
+func HashOf(gitDir string) *ObjectFormat {
+	gitDir = filepath.Clean(gitDir)
+	if gitDir == filepath.Clean(GitPath()) {
+		return Hash()
+	}
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if f, ok := formats[gitDir]; ok {
+		return f
+	}
+	// a repository without a configuration yet is not cached
+	path := filepath.Join(gitDir, DefaultConfigFile)
+	if _, err := os.Stat(path); err != nil {
+		return SHA1
+	}
+	c, err := ReadGitConfig(path)
+	if err != nil {
+		return SHA1
+	}
+	v, _ := c.Get("extensions.objectformat")
+	f, err := LookupObjectFormat(v)
+	if err != nil {
+		return SHA1
+	}
+	formats[gitDir] = f
+	return f
+}
+
+// repositoryObjectFormat reads extensions.objectFormat from the repository
+// configuration.
+func repositoryObjectFormat() (*ObjectFormat, error) {
+	c, err := RepositoryConfig()
+	if err != nil {
+		return nil, err
+	}
+	v, _ := c.Get("extensions.objectformat")
+	return LookupObjectFormat(v)
+}
@@ -0,0 +1,231 @@
+package date
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mode is a format for displaying the date of a commit or tag
+type Mode int
+
+const (
+	// Default is the date in the zone of the identity, as git log shows it
+	Default Mode = iota
+	// Local is the default format in the local zone
+	Local
+	ISO
+	ISOStrict
+	RFC
+	Short
+	// Raw is the seconds since the epoch and the zone, as objects store it
+	Raw
+	Unix
+	Relative
+)
+
+var modes = map[string]Mode{
+	"default":    Default,
+	"local":      Local,
+	"iso":        ISO,
+	"iso8601":    ISO,
+	"iso-strict": ISOStrict,
+	"rfc":        RFC,
+	"rfc2822":    RFC,
+	"short":      Short,
+	"raw":        Raw,
+	"unix":       Unix,
+	"relative":   Relative,
+}
+
+// layouts are the formats accepted by Parse in addition to the raw format.
+// Layouts without a zone are in the local zone.
+var layouts = []string{
+	"Mon Jan 2 15:04:05 2006 -0700",
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05-0700",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// ParseMode returns the Mode called name, such as iso or relative.
+func ParseMode(name string) (Mode, error) {
+	m, ok := modes[name]
+	if !ok {
+		return Default, fmt.Errorf("fatal: unknown date format %s", name)
+	}
+	return m, nil
+}
+
+// Parse parses a date as given in GIT_AUTHOR_DATE or GIT_COMMITTER_DATE,
+// either in the raw format of objects, as @ followed by seconds since the
+// epoch, in RFC 2822 or in ISO 8601.
+func Parse(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := ParseRaw(strings.TrimPrefix(s, "@")); err == nil {
+		return t, nil
+	}
+	if v, ok := strings.CutPrefix(s, "@"); ok {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("fatal: invalid date format: %s", s)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	for _, v := range layouts {
+		if t, err := time.ParseInLocation(v, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("fatal: invalid date format: %s", s)
+}
+
+// approxUnits are the units of relative dates such as 2 weeks ago
+var approxUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+}
+
+// ParseApprox parses a date as Parse does or relative to now, as in
+// 2 weeks ago, 3.days.ago, yesterday or now, as log --since accepts.
+func ParseApprox(s string, now time.Time) (time.Time, error) {
+	if t, err := Parse(s); err == nil {
+		return t, nil
+	}
+	fields := strings.Fields(strings.ReplaceAll(strings.ToLower(s), ".", " "))
+	switch {
+	case len(fields) == 1 && fields[0] == "now":
+		return now, nil
+	case len(fields) == 1 && fields[0] == "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	case len(fields) == 3 && fields[2] == "ago" || len(fields) == 2:
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			break
+		}
+		unit := strings.TrimSuffix(fields[1], "s")
+		switch unit {
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		case "year":
+			return now.AddDate(-n, 0, 0), nil
+		}
+		if d, ok := approxUnits[unit]; ok {
+			return now.Add(-time.Duration(n) * d), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("fatal: invalid date format: %s", s)
+}
+
+// ParseRaw parses the seconds since the epoch followed by the zone, such as
+// 1700000000 +0100, returning a time in that zone.
+func ParseRaw(s string) (time.Time, error) {
+	sec, tz, ok := strings.Cut(s, " ")
+	n, err := strconv.ParseInt(sec, 10, 64)
+	if !ok || err != nil || len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return time.Time{}, fmt.Errorf("invalid raw date %q", s)
+	}
+	hh, err1 := strconv.Atoi(tz[1:3])
+	mm, err2 := strconv.Atoi(tz[3:5])
+	if err1 != nil || err2 != nil {
+		return time.Time{}, fmt.Errorf("invalid raw date %q", s)
+	}
+	offset := hh*3600 + mm*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return time.Unix(n, 0).In(time.FixedZone("", offset)), nil
+}
+
+// FormatRaw formats t as the seconds since the epoch followed by its zone.
+func FormatRaw(t time.Time) string {
+	return fmt.Sprintf("%d %s", t.Unix(), t.Format("-0700"))
+}
+
+// Format formats t, which is in the zone of the identity it was read from.
+func (m Mode) Format(t time.Time) string {
+	switch m {
+	case Local:
+		return t.Local().Format("Mon Jan 2 15:04:05 2006")
+	case ISO:
+		return t.Format("2006-01-02 15:04:05 -0700")
+	case ISOStrict:
+		return t.Format("2006-01-02T15:04:05-07:00")
+	case RFC:
+		return t.Format("Mon, 2 Jan 2006 15:04:05 -0700")
+	case Short:
+		return t.Format("2006-01-02")
+	case Raw:
+		return FormatRaw(t)
+	case Unix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case Relative:
+		return relative(t, time.Now())
+	}
+	return t.Format("Mon Jan 2 15:04:05 2006 -0700")
+}
+
+// relative describes how long before now t is, rounding as git does.
+func relative(t time.Time, now time.Time) string {
+	diff := int64(now.Sub(t) / time.Second)
+	if diff < 0 {
+		return "in the future"
+	}
+	if diff < 90 {
+		return ago(diff, "second")
+	}
+	// turn it into minutes
+	diff = (diff + 30) / 60
+	if diff < 90 {
+		return ago(diff, "minute")
+	}
+	// turn it into hours
+	diff = (diff + 30) / 60
+	if diff < 36 {
+		return ago(diff, "hour")
+	}
+	// we deal with number of days from here on
+	diff = (diff + 12) / 24
+	if diff < 14 {
+		return ago(diff, "day")
+	}
+	// say weeks for the past 10 weeks or so
+	if diff < 70 {
+		return ago((diff+3)/7, "week")
+	}
+	// say months for the past 12 months or so
+	if diff < 365 {
+		return ago((diff+15)/30, "month")
+	}
+	// give years and months for 5 years or so
+	if diff < 1825 {
+		months := (diff*12*2 + 365) / (365 * 2)
+		years := plural(months/12, "year")
+		if months%12 == 0 {
+			return years + " ago"
+		}
+		return fmt.Sprintf("%s, %s ago", years, plural(months%12, "month"))
+	}
+	return ago((diff+183)/365, "year")
+}
+
+func ago(n int64, unit string) string {
+	return plural(n, unit) + " ago"
+}
+
+func plural(n int64, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,109 @@
+package date
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_Parse(t *testing.T) {
+	tests := []struct {
+		date     string
+		expected string
+	}{
+		{"1700000000 +0100", "1700000000 +0100"},
+		{"1700000000 -0530", "1700000000 -0530"},
+		{"@1700000000", "1700000000 +0000"},
+		{"@1700000000 +0200", "1700000000 +0200"},
+		{"Tue, 14 Nov 2023 23:13:20 +0100", "1700000000 +0100"},
+		{"Tue Nov 14 23:13:20 2023 +0100", "1700000000 +0100"},
+		{"2023-11-14T23:13:20+01:00", "1700000000 +0100"},
+		{"2023-11-14T22:13:20Z", "1700000000 +0000"},
+		{"2023-11-14 23:13:20 +0100", "1700000000 +0100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.date, func(t *testing.T) {
+			actual, err := Parse(tt.date)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, FormatRaw(actual))
+		})
+	}
+	_, err := Parse("yesterday-ish")
+	assert.EqualError(t, err, "fatal: invalid date format: yesterday-ish")
+}
+
+func Test_Format(t *testing.T) {
+	d, err := ParseRaw("1700000000 +0100")
+	assert.NoError(t, err)
+	tests := []struct {
+		mode     string
+		expected string
+	}{
+		{"default", "Tue Nov 14 23:13:20 2023 +0100"},
+		{"iso", "2023-11-14 23:13:20 +0100"},
+		{"iso-strict", "2023-11-14T23:13:20+01:00"},
+		{"rfc", "Tue, 14 Nov 2023 23:13:20 +0100"},
+		{"short", "2023-11-14"},
+		{"raw", "1700000000 +0100"},
+		{"unix", "1700000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			m, err := ParseMode(tt.mode)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, m.Format(d))
+		})
+	}
+	_, err = ParseMode("sometime")
+	assert.EqualError(t, err, "fatal: unknown date format sometime")
+}
+
+func Test_Relative(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		ago      time.Duration
+		expected string
+	}{
+		{-time.Hour, "in the future"},
+		{time.Second, "1 second ago"},
+		{89 * time.Second, "89 seconds ago"},
+		{90 * time.Second, "2 minutes ago"},
+		{3 * time.Hour, "3 hours ago"},
+		{40 * time.Hour, "2 days ago"},
+		{20 * 24 * time.Hour, "3 weeks ago"},
+		{100 * 24 * time.Hour, "3 months ago"},
+		{400 * 24 * time.Hour, "1 year, 1 month ago"},
+		{730 * 24 * time.Hour, "2 years ago"},
+		{3000 * 24 * time.Hour, "8 years ago"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, relative(now.Add(-tt.ago), now))
+		})
+	}
+}
+
+func Test_ParseApprox(t *testing.T) {
+	now := time.Unix(1700000000, 0).UTC()
+	tests := []struct {
+		date     string
+		expected int64
+	}{
+		{"now", 1700000000},
+		{"yesterday", 1700000000 - 86400},
+		{"2 weeks ago", 1700000000 - 14*86400},
+		{"3.days.ago", 1700000000 - 3*86400},
+		{"1 hour", 1700000000 - 3600},
+		{"1 year ago", 1668464000},
+		{"@1600000000", 1600000000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.date, func(t *testing.T) {
+			actual, err := ParseApprox(tt.date, now)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, actual.Unix())
+		})
+	}
+	_, err := ParseApprox("two weeks ago", now)
+	assert.EqualError(t, err, "fatal: invalid date format: two weeks ago")
+}
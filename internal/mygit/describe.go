@@ -0,0 +1,309 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/pathspec"
+	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"github.com/richardjennings/mygit/internal/mygit/revision"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DescribeOptions configures Describe
+type DescribeOptions struct {
+	// Tags uses lightweight tags as well as annotated tags
+	Tags bool
+	// Long always shows the number of commits and the abbreviated commit,
+	// even when a tag names the commit
+	Long bool
+	// Dirty is appended when the working tree or index differ from HEAD,
+	// which is only checked when it is not empty
+	Dirty string
+	// Abbrev is the length of the abbreviated commit, at least 4, or 0 to
+	// show only the tag
+	Abbrev int
+	// Candidates is the most tags considered, at most 31, or 0 to only
+	// describe a commit which is tagged
+	Candidates int
+}
+
+// describeTag is the tag chosen to name a commit
+type describeTag struct {
+	name      string
+	annotated bool
+	when      time.Time
+}
+
+// describeCandidate is a tag found walking the history, depth is the number
+// of commits walked which are not reachable from it
+type describeCandidate struct {
+	*describeTag
+	depth int
+	flag  uint32
+}
+
+// describeCommit is a commit walked by describe, flags has the flag of each
+// candidate the commit is reachable from
+type describeCommit struct {
+	*objects.Commit
+	flags uint32
+}
+
+// describeMaxCandidates is the most candidates which have a flag
+const describeMaxCandidates = 31
+
+// Describe writes a name for rev, HEAD when empty, from the nearest tag
+// reachable from it as <tag>-<commits>-g<abbreviated commit>, where commits
+// is the number of commits reachable from rev which are not reachable from
+// the tag. A commit which is tagged is named by its tag alone.
+func Describe(o io.Writer, opts DescribeOptions, rev string) error {
+	if opts.Long && opts.Abbrev == 0 {
+		return errors.New("fatal: options '--long' and '--abbrev=0' cannot be used together")
+	}
+	if opts.Dirty != "" && rev != "" {
+		return errors.New("fatal: option '--dirty' and commit-ishes cannot be used together")
+	}
+	if rev == "" {
+		rev = config.DefaultHeadFile
+	}
+	sha, err := revision.Resolve(rev)
+	if err != nil {
+		return fmt.Errorf("fatal: Not a valid object name %s", rev)
+	}
+	if sha, err = objects.Peel(config.ObjectPath(), sha); err != nil {
+		return err
+	}
+	names, err := describeTags()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errors.New("fatal: No names found, cannot describe anything.")
+	}
+	eligible := func(t *describeTag) bool {
+		return t != nil && (opts.Tags || t.annotated)
+	}
+	name := ""
+	depth := 0
+	if t := names[string(sha)]; eligible(t) {
+		name = t.name
+	} else if opts.Candidates <= 0 {
+		return fmt.Errorf("fatal: no tag exactly matches '%s'", sha)
+	} else {
+		best, err := describeWalk(sha, names, eligible, opts.Candidates)
+		if err != nil {
+			return err
+		}
+		name, depth = best.name, best.depth
+	}
+	if opts.Abbrev > 0 && (depth > 0 || opts.Long) {
+		n := opts.Abbrev
+		if n < objects.MinAbbrev {
+			n = objects.MinAbbrev
+		}
+		if n > len(sha) {
+			n = len(sha)
+		}
+		name = fmt.Sprintf("%s-%d-g%s", name, depth, sha[:n])
+	}
+	if opts.Dirty != "" {
+		dirty, err := worktreeDirty(sha)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			name += opts.Dirty
+		}
+	}
+	_, err = fmt.Fprintln(o, name)
+	return err
+}
+
+// describeWalk returns the candidate nearest to the commit sha, walking its
+// history most recently committed first as git does. The first tags found
+// become candidates, each flagging the commits reachable from it so that
+// its depth counts the commits walked without its flag. The walk stops when
+// more tags are found than max candidates, or when an annotated tag is found
+// and nothing is left to walk, then continues only until every commit left
+// is reachable from the best candidate.
+func describeWalk(sha []byte, names map[string]*describeTag, eligible func(*describeTag) bool, max int) (*describeCandidate, error) {
+	if max > describeMaxCandidates {
+		max = describeMaxCandidates
+	}
+	commits := make(map[string]*describeCommit)
+	read := func(sha []byte) (*describeCommit, error) {
+		if c, ok := commits[string(sha)]; ok {
+			return c, nil
+		}
+		c, err := objects.ReadCommit(sha)
+		if err != nil {
+			return nil, err
+		}
+		commits[string(sha)] = &describeCommit{Commit: c}
+		return commits[string(sha)], nil
+	}
+	// queue is ordered most recently committed first, commits committed at
+	// the same time keep the order they were found in
+	var queue []*describeCommit
+	push := func(c *describeCommit) {
+		i := sort.Search(len(queue), func(i int) bool {
+			return queue[i].CommittedTime.Before(c.CommittedTime)
+		})
+		queue = append(queue[:i], append([]*describeCommit{c}, queue[i:]...)...)
+	}
+	// parents are queued when first found and given the flags of c
+	visit := func(c *describeCommit) error {
+		for _, p := range c.Parents {
+			_, seen := commits[string(p)]
+			pc, err := read(p)
+			if err != nil {
+				return err
+			}
+			if !seen {
+				push(pc)
+			}
+			pc.flags |= c.flags
+		}
+		return nil
+	}
+	start, err := read(sha)
+	if err != nil {
+		return nil, err
+	}
+	queue = []*describeCommit{start}
+	var candidates []*describeCandidate
+	walked, annotated := 0, 0
+	unannotated := false
+	var gaveUp *describeCommit
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		walked++
+		if t := names[string(c.Sha)]; t != nil {
+			if !eligible(t) {
+				unannotated = true
+			} else if len(candidates) < max {
+				cand := &describeCandidate{describeTag: t, depth: walked - 1, flag: 1 << len(candidates)}
+				candidates = append(candidates, cand)
+				c.flags |= cand.flag
+				if t.annotated {
+					annotated++
+				}
+			} else {
+				gaveUp = c
+				break
+			}
+		}
+		for _, cand := range candidates {
+			if c.flags&cand.flag == 0 {
+				cand.depth++
+			}
+		}
+		// the only path left is covered by the candidates
+		if annotated > 0 && len(queue) == 0 {
+			break
+		}
+		if err := visit(c); err != nil {
+			return nil, err
+		}
+	}
+	if len(candidates) == 0 {
+		if unannotated {
+			return nil, fmt.Errorf("fatal: No annotated tags can describe '%s'.\nHowever, there were unannotated tags: try --tags.", sha)
+		}
+		return nil, fmt.Errorf("fatal: No tags can describe '%s'.\nTry --always, or create some tags.", sha)
+	}
+	// the nearest candidate, or the first found of those as near
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].depth < candidates[j].depth })
+	best := candidates[0]
+	if gaveUp != nil {
+		push(gaveUp)
+	}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if c.flags&best.flag != 0 {
+			done := true
+			for _, v := range queue {
+				if v.flags&best.flag == 0 {
+					done = false
+					break
+				}
+			}
+			if done {
+				break
+			}
+		} else {
+			best.depth++
+		}
+		if err := visit(c); err != nil {
+			return nil, err
+		}
+	}
+	return best, nil
+}
+
+// describeTags returns the tags by the commit they name. An annotated tag is
+// preferred to a lightweight tag, and a newer annotated tag to an older one.
+func describeTags() (map[string]*describeTag, error) {
+	all, err := refs.ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	var tagRefs []string
+	for k := range all {
+		if strings.HasPrefix(k, "refs/tags/") {
+			tagRefs = append(tagRefs, k)
+		}
+	}
+	sort.Strings(tagRefs)
+	names := make(map[string]*describeTag)
+	for _, k := range tagRefs {
+		t := &describeTag{name: strings.TrimPrefix(k, "refs/tags/")}
+		obj, err := objects.ReadObject(all[k])
+		if err != nil {
+			return nil, err
+		}
+		if obj.Typ == objects.ObjectTag {
+			tag, err := objects.ReadTag(all[k])
+			if err != nil {
+				return nil, err
+			}
+			t.annotated, t.when = true, tag.TaggedTime
+		}
+		sha, err := objects.Peel(config.ObjectPath(), all[k])
+		if err != nil {
+			return nil, err
+		}
+		if obj, err = objects.ReadObject(sha); err != nil {
+			return nil, err
+		}
+		if obj.Typ != objects.ObjectCommit {
+			continue
+		}
+		prev, ok := names[string(sha)]
+		if !ok || t.annotated && (!prev.annotated || t.when.After(prev.when)) {
+			names[string(sha)] = t
+		}
+	}
+	return names, nil
+}
+
+// worktreeDirty reports whether the index or the tracked files of the
+// working tree differ from the commit sha.
+func worktreeDirty(sha []byte) (bool, error) {
+	ps, err := pathspec.New("")
+	if err != nil {
+		return false, err
+	}
+	entries, err := statusEntries(sha, UntrackedFilesNo, ps)
+	if err != nil {
+		return false, err
+	}
+	return len(entries) > 0, nil
+}
@@ -0,0 +1,130 @@
+package diff
+
+// Op is the kind of an Edit
+type Op int
+
+const (
+	// Equal is a line in both a and b
+	Equal Op = iota
+	// Delete is a line of a which is not in b
+	Delete
+	// Insert is a line of b which is not in a
+	Insert
+)
+
+// Edit is a line of a diff of a and b, where A and B are the indexes of the
+// line in a and b or -1 when it is not in one of them.
+type Edit struct {
+	Op Op
+	A  int
+	B  int
+}
+
+// Lines returns the shortest list of edits which turns a into b, using the
+// linear space variant of the Myers algorithm.
+func Lines(a []string, b []string) []Edit {
+	d := &differ{a: a, b: b}
+	d.compare(0, len(a), 0, len(b))
+	return d.edits
+}
+
+// differ holds the edits of a and b found so far, which are appended in
+// order as the lines are compared.
+type differ struct {
+	a     []string
+	b     []string
+	edits []Edit
+}
+
+// compare appends the edits of a[aLo:aHi] and b[bLo:bHi]. After removing the
+// common prefix and suffix the lines are split at the middle snake of a
+// shortest edit script, and each half is compared in turn.
+func (d *differ) compare(aLo int, aHi int, bLo int, bHi int) {
+	pre := 0
+	for aLo+pre < aHi && bLo+pre < bHi && d.a[aLo+pre] == d.b[bLo+pre] {
+		d.edits = append(d.edits, Edit{Op: Equal, A: aLo + pre, B: bLo + pre})
+		pre++
+	}
+	aLo, bLo = aLo+pre, bLo+pre
+	suf := 0
+	for aHi-suf > aLo && bHi-suf > bLo && d.a[aHi-1-suf] == d.b[bHi-1-suf] {
+		suf++
+	}
+	aHi, bHi = aHi-suf, bHi-suf
+	switch {
+	case aLo == aHi:
+		for y := bLo; y < bHi; y++ {
+			d.edits = append(d.edits, Edit{Op: Insert, A: -1, B: y})
+		}
+	case bLo == bHi:
+		for x := aLo; x < aHi; x++ {
+			d.edits = append(d.edits, Edit{Op: Delete, A: x, B: -1})
+		}
+	default:
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		for ; x < u; x, y = x+1, y+1 {
+			d.edits = append(d.edits, Edit{Op: Equal, A: x, B: y})
+		}
+		d.compare(u, aHi, v, bHi)
+	}
+	for i := suf; i > 0; i-- {
+		d.edits = append(d.edits, Edit{Op: Equal, A: aHi + suf - i, B: bHi + suf - i})
+	}
+}
+
+// middleSnake returns the start x, y and end u, v of the snake in the middle
+// of a shortest edit script of a[aLo:aHi] and b[bLo:bHi], found by searching
+// from both ends at once. Only the furthest reaching x of each diagonal
+// k = x - y is kept, forwards in vf and backwards from the ends in vb.
+func (d *differ) middleSnake(aLo int, aHi int, bLo int, bHi int) (int, int, int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+	off := max + 1
+	vf := make([]int, 2*max+3)
+	vb := make([]int, 2*max+3)
+	for e := 0; e <= max; e++ {
+		for k := -e; k <= e; k += 2 {
+			var x int
+			if k == -e || k != e && vf[off+k-1] < vf[off+k+1] {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			vf[off+k] = x
+			// the backward search of e-1 edits on the same diagonal
+			if kb := delta - k; odd && kb >= -(e-1) && kb <= e-1 && x+vb[off+kb] >= n {
+				return aLo + x0, bLo + y0, aLo + x, bLo + y
+			}
+		}
+		for k := -e; k <= e; k += 2 {
+			var x int
+			if k == -e || k != e && vb[off+k-1] < vb[off+k+1] {
+				x = vb[off+k+1]
+			} else {
+				x = vb[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			vb[off+k] = x
+			// the forward search of e edits on the same diagonal
+			if kf := delta - k; !odd && kf >= -e && kf <= e && x+vf[off+kf] >= n {
+				return aHi - x, bHi - y, aHi - x0, bHi - y0
+			}
+		}
+	}
+	// unreachable, the searches meet within max edits
+	return aLo, bLo, aHi, bHi
+}
@@ -0,0 +1,60 @@
+package diff
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_Lines(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        string
+		b        string
+		expected string
+	}{
+		{"empty", "", "", ""},
+		{"same", "abc", "abc", "=a =b =c"},
+		{"insert", "", "ab", "+a +b"},
+		{"delete", "ab", "", "-a -b"},
+		{"middle", "abcd", "axyd", "=a -b -c +x +y =d"},
+		{"myers", "abcabba", "cbabac", "-a +c =b -c =a =b -b =a +c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := strings.Split(tt.a, ""), strings.Split(tt.b, "")
+			var out []string
+			for _, e := range Lines(a, b) {
+				switch e.Op {
+				case Equal:
+					assert.Equal(t, a[e.A], b[e.B])
+					out = append(out, "="+a[e.A])
+				case Delete:
+					out = append(out, "-"+a[e.A])
+				case Insert:
+					out = append(out, "+"+b[e.B])
+				}
+			}
+			assert.Equal(t, tt.expected, strings.Join(out, " "))
+		})
+	}
+}
+
+func Test_Lines_Rewritten(t *testing.T) {
+	n := 5000
+	a, b := make([]string, n), make([]string, n)
+	for i := range a {
+		a[i] = fmt.Sprintf("a%d", i)
+		b[i] = fmt.Sprintf("b%d", i)
+	}
+	edits := Lines(a, b)
+	assert.Len(t, edits, 2*n)
+	for i, e := range edits {
+		if i < n {
+			assert.Equal(t, Edit{Op: Delete, A: i, B: -1}, e)
+		} else {
+			assert.Equal(t, Edit{Op: Insert, A: -1, B: i - n}, e)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package mygit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/revision"
+	"io"
+	"strings"
+)
+
+// FastExportOptions configures FastExport
+type FastExportOptions struct {
+	// ExportMarks is a file to write the marks of the exported objects to
+	ExportMarks string
+	// ImportMarks is a file of marks from a previous export, the objects
+	// named in it are referred to by mark instead of being exported again
+	ImportMarks string
+}
+
+// fastExporter tracks the marks assigned to exported objects
+type fastExporter struct {
+	w     *bufio.Writer
+	marks *marks
+	// names are the refs commits are exported on
+	names map[string]string
+}
+
+// FastExport writes the history of the refs named in revs to o as a
+// fast-import stream. Blobs are written before the commits which add them,
+// commits are written parents first and annotated tags and refs which point
+// at commits exported on another ref follow.
+func FastExport(o io.Writer, revs []string, opts FastExportOptions) error {
+	r, err := revision.ParseRange(revs)
+	if err != nil {
+		return err
+	}
+	m := newMarks()
+	if opts.ImportMarks != "" {
+		if err := m.load(opts.ImportMarks); err != nil {
+			return err
+		}
+	}
+	// commits whose history is excluded, or which were exported previously,
+	// are not exported
+	excluded := make(map[string]struct{})
+	for _, v := range r.Exclude {
+		sha, err := objects.Peel(config.ObjectPath(), v)
+		if err != nil {
+			return err
+		}
+		ancestors, err := objects.Ancestors(sha)
+		if err != nil {
+			return err
+		}
+		for k := range ancestors {
+			excluded[k] = struct{}{}
+		}
+	}
+	e := &fastExporter{w: bufio.NewWriter(o), marks: m, names: make(map[string]string)}
+	var order [][]byte
+	seen := make(map[string]struct{})
+	var tips []*exportRef
+	for _, name := range r.Refs {
+		if name == config.DefaultHeadFile {
+			continue
+		}
+		sha, err := revision.Resolve(name)
+		if err != nil {
+			return err
+		}
+		peeled, err := objects.Peel(config.ObjectPath(), sha)
+		if err != nil {
+			return err
+		}
+		tips = append(tips, &exportRef{name: name, sha: sha, peeled: peeled})
+		skip := func(c string) bool {
+			_, ok := excluded[c]
+			return ok || m.has([]byte(c))
+		}
+		if order, err = topoOrder(order, seen, peeled, name, e.names, skip); err != nil {
+			return err
+		}
+	}
+	for _, sha := range order {
+		if err := e.commit(sha, excluded); err != nil {
+			return err
+		}
+	}
+	for _, t := range tips {
+		if err := e.ref(t); err != nil {
+			return err
+		}
+	}
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	if opts.ExportMarks != "" {
+		return m.save(opts.ExportMarks)
+	}
+	return nil
+}
+
+// exportRef is a ref to export, peeled is the commit an annotated tag
+// points to
+type exportRef struct {
+	name   string
+	sha    []byte
+	peeled []byte
+}
+
+// topoOrder appends the commits reachable from sha to order with parents
+// before children, naming each by the first ref it is reachable from.
+func topoOrder(order [][]byte, seen map[string]struct{}, sha []byte, name string, names map[string]string, skip func(string) bool) ([][]byte, error) {
+	if _, ok := seen[string(sha)]; ok || skip(string(sha)) {
+		return order, nil
+	}
+	seen[string(sha)] = struct{}{}
+	obj, err := objects.ReadObject(sha)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Typ != objects.ObjectCommit {
+		return order, nil
+	}
+	names[string(sha)] = name
+	c, err := objects.ReadCommit(sha)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range c.Parents {
+		if order, err = topoOrder(order, seen, p, name, names, skip); err != nil {
+			return nil, err
+		}
+	}
+	return append(order, sha), nil
+}
+
+// commit writes the blobs added by the commit sha followed by the commit.
+// Parents which are not exported are dropped, in which case every file of
+// the commit is listed.
+func (e *fastExporter) commit(sha []byte, excluded map[string]struct{}) error {
+	c, err := objects.ReadCommit(sha)
+	if err != nil {
+		return err
+	}
+	var parents []string
+	var from []byte
+	for _, p := range c.Parents {
+		if _, ok := excluded[string(p)]; ok {
+			continue
+		}
+		if from == nil {
+			from = p
+		}
+		parents = append(parents, e.marks.ref(p))
+	}
+	changes, err := objects.DiffCommits(from, sha)
+	if err != nil {
+		return err
+	}
+	for _, v := range changes {
+		if v.To == nil || v.To.Mode == 0160000 || e.marks.has(v.To.Sha.AsHexBytes()) {
+			continue
+		}
+		if err := e.blob(v.To.Sha.AsHexBytes()); err != nil {
+			return err
+		}
+	}
+	headers, message, err := rawObject(sha)
+	if err != nil {
+		return err
+	}
+	name := e.names[string(sha)]
+	// without a from command the commit would follow the last commit
+	// exported on the same ref
+	if len(parents) == 0 {
+		_, _ = fmt.Fprintf(e.w, "reset %s\n", name)
+	}
+	_, _ = fmt.Fprintf(e.w, "commit %s\nmark %s\n", name, e.marks.add(sha))
+	for _, k := range []string{"author", "committer", "encoding"} {
+		if v, ok := headers[k]; ok {
+			_, _ = fmt.Fprintf(e.w, "%s %s\n", k, v)
+		}
+	}
+	_ = e.data(bytes.NewReader(message), int64(len(message)))
+	for i, p := range parents {
+		if i == 0 {
+			_, _ = fmt.Fprintf(e.w, "from %s\n", p)
+		} else {
+			_, _ = fmt.Fprintf(e.w, "merge %s\n", p)
+		}
+	}
+	for _, v := range changes {
+		switch {
+		case v.To == nil:
+			_, _ = fmt.Fprintf(e.w, "D %s\n", quotePath(v.Path, true))
+		case v.To.Mode == 0160000:
+			_, _ = fmt.Fprintf(e.w, "M %o %s %s\n", v.To.Mode, v.To.Sha.AsHexString(), quotePath(v.Path, true))
+		default:
+			_, _ = fmt.Fprintf(e.w, "M %o %s %s\n", v.To.Mode, e.marks.ref(v.To.Sha.AsHexBytes()), quotePath(v.Path, true))
+		}
+	}
+	_, _ = e.w.WriteString("\n")
+	return nil
+}
+
+// blob writes the blob sha with a new mark.
+func (e *fastExporter) blob(sha []byte) error {
+	r, err := objects.OpenObject(sha)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	_, _ = fmt.Fprintf(e.w, "blob\nmark %s\n", e.marks.add(sha))
+	// blobs are streamed rather than read into memory
+	if err := e.data(r, r.Size); err != nil {
+		return err
+	}
+	_, _ = e.w.WriteString("\n")
+	return nil
+}
+
+// ref writes an annotated tag, or a reset for a ref which does not point at
+// a commit exported under its name.
+func (e *fastExporter) ref(t *exportRef) error {
+	if !bytes.Equal(t.sha, t.peeled) {
+		tag, err := objects.ReadTag(t.sha)
+		if err != nil {
+			return err
+		}
+		headers, message, err := rawObject(t.sha)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(e.w, "tag %s\nfrom %s\n", strings.TrimPrefix(t.name, "refs/tags/"), e.marks.ref(tag.Object))
+		if v, ok := headers["tagger"]; ok {
+			_, _ = fmt.Fprintf(e.w, "tagger %s\n", v)
+		}
+		_ = e.data(bytes.NewReader(message), int64(len(message)))
+		_, _ = e.w.WriteString("\n")
+		return nil
+	}
+	if e.names[string(t.sha)] == t.name {
+		return nil
+	}
+	_, _ = fmt.Fprintf(e.w, "reset %s\nfrom %s\n\n", t.name, e.marks.ref(t.sha))
+	return nil
+}
+
+// data writes size bytes read from r as a counted data command, ending with
+// a newline.
+func (e *fastExporter) data(r io.Reader, size int64) error {
+	_, _ = fmt.Fprintf(e.w, "data %d\n", size)
+	w := &lastByteWriter{w: e.w}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	if size > 0 && w.last != '\n' {
+		_, _ = e.w.WriteString("\n")
+	}
+	return nil
+}
+
+// lastByteWriter records the last byte written to w
+type lastByteWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (l *lastByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		l.last = p[len(p)-1]
+	}
+	return l.w.Write(p)
+}
+
+// rawObject returns the header fields and message of a commit or tag
+// exactly as stored, keeping timezones which are not otherwise read.
+func rawObject(sha []byte) (map[string]string, []byte, error) {
+	obj, err := objects.ReadObject(sha)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := objects.ReadContent(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, message, _ := bytes.Cut(content, []byte("\n\n"))
+	headers := make(map[string]string)
+	for _, l := range strings.Split(string(header), "\n") {
+		// continuation lines of multi-line headers such as gpgsig start with
+		// a space
+		if k, v, ok := strings.Cut(l, " "); ok && k != "" {
+			if _, ok := headers[k]; !ok {
+				headers[k] = v
+			}
+		}
+	}
+	return headers, message, nil
+}
@@ -0,0 +1,848 @@
+package mygit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/date"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"github.com/richardjennings/mygit/internal/mygit/revision"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FastImportOptions configures FastImport
+type FastImportOptions struct {
+	// ImportMarks is a file of marks to load before reading the stream
+	ImportMarks string
+	// ExportMarks is a file to write the marks to after reading the stream
+	ExportMarks string
+	// Force updates refs even when the new commit does not contain the old
+	Force bool
+}
+
+type (
+	// fastImporter holds the state of the branches written by a stream
+	fastImporter struct {
+		r       *bufio.Reader
+		o       io.Writer
+		pending *string
+		marks   *marks
+		// branches are the refs committed to, reset or tagged by the stream
+		branches    map[string]*importBranch
+		exportMarks string
+		done        bool
+		force       bool
+	}
+	// importBranch is the current commit of a ref and the files of its tree,
+	// which are read when first needed
+	importBranch struct {
+		sha   []byte
+		files map[string]*importFile
+	}
+	// importFile is a tree entry, sha is hex encoded
+	importFile struct {
+		mode uint32
+		sha  []byte
+	}
+	// marks maps the marks of a stream to hex encoded object hashes
+	marks struct {
+		shas  map[int][]byte
+		marks map[string]int
+		next  int
+	}
+)
+
+// FastImport reads a fast-import stream from r, writing its blobs, commits
+// and tags to the object store and then updating the refs it names. Refs
+// are only updated when the new commit contains the old one unless forced.
+// The text of progress commands is written to o.
+func FastImport(r io.Reader, o io.Writer, opts FastImportOptions) error {
+	f := &fastImporter{
+		r:           bufio.NewReader(r),
+		o:           o,
+		marks:       newMarks(),
+		branches:    make(map[string]*importBranch),
+		exportMarks: opts.ExportMarks,
+		force:       opts.Force,
+	}
+	if opts.ImportMarks != "" {
+		if err := f.marks.load(opts.ImportMarks); err != nil {
+			return err
+		}
+	}
+	if err := f.parse(); err != nil {
+		return err
+	}
+	return f.checkpoint()
+}
+
+// parse reads commands until the end of the stream or a done command.
+func (f *fastImporter) parse() error {
+	requireDone := false
+	for {
+		l, err := f.readLine()
+		if errors.Is(err, io.EOF) {
+			if requireDone {
+				return errors.New("fatal: stream ends early")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case l == "":
+		case l == "blob":
+			err = f.blob()
+		case strings.HasPrefix(l, "commit "):
+			err = f.commit(strings.TrimPrefix(l, "commit "))
+		case strings.HasPrefix(l, "tag "):
+			err = f.tag(strings.TrimPrefix(l, "tag "))
+		case strings.HasPrefix(l, "reset "):
+			err = f.reset(strings.TrimPrefix(l, "reset "))
+		case l == "checkpoint":
+			err = f.checkpoint()
+		case strings.HasPrefix(l, "progress "):
+			_, _ = fmt.Fprintln(f.o, l)
+		case l == "done":
+			return nil
+		case strings.HasPrefix(l, "feature "):
+			feature := strings.TrimPrefix(l, "feature ")
+			if feature == "done" {
+				requireDone = true
+				continue
+			}
+			err = f.feature(feature)
+		case strings.HasPrefix(l, "option "):
+			// options are specific to an importer
+		default:
+			err = fmt.Errorf("fatal: Unsupported command: %s", l)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// feature enables a feature required by the stream.
+func (f *fastImporter) feature(feature string) error {
+	name, arg, _ := strings.Cut(feature, "=")
+	switch name {
+	case "date-format":
+		if arg != "raw" {
+			return fmt.Errorf("fatal: unknown --date-format argument %s", arg)
+		}
+	case "import-marks", "import-marks-if-exists":
+		if _, err := os.Stat(arg); err != nil && name == "import-marks-if-exists" {
+			return nil
+		}
+		return f.marks.load(arg)
+	case "export-marks":
+		f.exportMarks = arg
+	case "force":
+		f.force = true
+	default:
+		return fmt.Errorf("fatal: This version of fast-import does not support feature %s.", feature)
+	}
+	return nil
+}
+
+// readLine returns the next line which is not a comment.
+func (f *fastImporter) readLine() (string, error) {
+	if f.pending != nil {
+		l := *f.pending
+		f.pending = nil
+		return l, nil
+	}
+	for {
+		l, err := f.r.ReadString('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || l == "") {
+			return "", err
+		}
+		if !strings.HasPrefix(l, "#") {
+			return strings.TrimSuffix(l, "\n"), nil
+		}
+	}
+}
+
+// optional returns the argument of the next line when it starts with
+// prefix, leaving the line to be read again otherwise.
+func (f *fastImporter) optional(prefix string) (string, bool, error) {
+	l, err := f.readLine()
+	if errors.Is(err, io.EOF) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if !strings.HasPrefix(l, prefix) {
+		f.pending = &l
+		return "", false, nil
+	}
+	return strings.TrimPrefix(l, prefix), true, nil
+}
+
+// mark reads an optional mark command.
+func (f *fastImporter) mark() (int, error) {
+	arg, ok, err := f.optional("mark :")
+	if err != nil || !ok {
+		return 0, err
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("fatal: invalid mark: :%s", arg)
+	}
+	return n, nil
+}
+
+// data reads a data command with either a byte count or a delimiter.
+func (f *fastImporter) data() ([]byte, error) {
+	l, err := f.readLine()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	arg, ok := strings.CutPrefix(l, "data ")
+	if !ok {
+		return nil, fmt.Errorf("fatal: Expected 'data n' command, found: %s", l)
+	}
+	var b []byte
+	if delim, ok := strings.CutPrefix(arg, "<<"); ok {
+		for {
+			l, err := f.r.ReadString('\n')
+			if err != nil {
+				return nil, fmt.Errorf("fatal: EOF in data (terminator '%s' not found)", delim)
+			}
+			if strings.TrimSuffix(l, "\n") == delim {
+				break
+			}
+			b = append(b, l...)
+		}
+	} else {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("fatal: invalid data length: %s", arg)
+		}
+		b = make([]byte, n)
+		if _, err := io.ReadFull(f.r, b); err != nil {
+			return nil, fmt.Errorf("fatal: EOF in data (%d bytes remaining)", n)
+		}
+	}
+	// data may be followed by a newline
+	if c, err := f.r.ReadByte(); err == nil && c != '\n' {
+		_ = f.r.UnreadByte()
+	}
+	return b, nil
+}
+
+// blob reads a blob command and writes the blob.
+func (f *fastImporter) blob() error {
+	mark, err := f.mark()
+	if err != nil {
+		return err
+	}
+	if _, _, err := f.optional("original-oid "); err != nil {
+		return err
+	}
+	content, err := f.data()
+	if err != nil {
+		return err
+	}
+	sha, err := writeObject(objects.ObjectBlob, content)
+	if err != nil {
+		return err
+	}
+	if mark > 0 {
+		f.marks.set(mark, sha)
+	}
+	return nil
+}
+
+// commit reads a commit command, writes the tree and commit and advances
+// the branch ref to it.
+func (f *fastImporter) commit(ref string) error {
+	if !strings.HasPrefix(ref, "refs/") {
+		return fmt.Errorf("fatal: Branch name doesn't conform to GIT standards: %s", ref)
+	}
+	mark, err := f.mark()
+	if err != nil {
+		return err
+	}
+	if _, _, err := f.optional("original-oid "); err != nil {
+		return err
+	}
+	author, _, err := f.ident("author ")
+	if err != nil {
+		return err
+	}
+	committer, ok, err := f.ident("committer ")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("fatal: Expected committer but didn't get one")
+	}
+	if author == "" {
+		author = committer
+	}
+	encoding, _, err := f.optional("encoding ")
+	if err != nil {
+		return err
+	}
+	message, err := f.data()
+	if err != nil {
+		return err
+	}
+	b, ok := f.branches[ref]
+	if !ok {
+		b = &importBranch{files: make(map[string]*importFile)}
+		f.branches[ref] = b
+	}
+	var parents [][]byte
+	if arg, ok, err := f.optional("from "); err != nil {
+		return err
+	} else if ok {
+		sha, err := f.commitish(arg)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, sha)
+		if b.files, err = treeFiles(sha); err != nil {
+			return err
+		}
+	} else if b.sha != nil {
+		parents = append(parents, b.sha)
+		if err := b.load(); err != nil {
+			return err
+		}
+	}
+	for {
+		arg, ok, err := f.optional("merge ")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		sha, err := f.commitish(arg)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, sha)
+	}
+	if err := f.fileChanges(b.files); err != nil {
+		return err
+	}
+	tree, err := writeFileTree(b.files)
+	if err != nil {
+		return err
+	}
+	content := bytes.NewBuffer(nil)
+	_, _ = fmt.Fprintf(content, "tree %s\n", tree)
+	for _, p := range parents {
+		_, _ = fmt.Fprintf(content, "parent %s\n", p)
+	}
+	_, _ = fmt.Fprintf(content, "author %s\ncommitter %s\n", author, committer)
+	if encoding != "" {
+		_, _ = fmt.Fprintf(content, "encoding %s\n", encoding)
+	}
+	_, _ = fmt.Fprintf(content, "\n%s", message)
+	sha, err := writeObject(objects.ObjectCommit, content.Bytes())
+	if err != nil {
+		return err
+	}
+	b.sha = sha
+	if mark > 0 {
+		f.marks.set(mark, sha)
+	}
+	return nil
+}
+
+// ident reads an optional author, committer or tagger command, which must
+// use the raw date format.
+func (f *fastImporter) ident(prefix string) (string, bool, error) {
+	arg, ok, err := f.optional(prefix)
+	if err != nil || !ok {
+		return "", ok, err
+	}
+	_, raw, found := strings.Cut(arg, "> ")
+	if !strings.Contains(arg, "<") || !found || len(strings.Fields(raw)) != 2 {
+		return "", false, fmt.Errorf("fatal: invalid ident: %s", arg)
+	}
+	if _, err := date.ParseRaw(raw); err != nil {
+		return "", false, fmt.Errorf("fatal: Invalid raw date \"%s\" in ident: %s", raw, arg)
+	}
+	return arg, true, nil
+}
+
+// fileChanges applies the M, D, R, C and deleteall commands of a commit to
+// files.
+func (f *fastImporter) fileChanges(files map[string]*importFile) error {
+	for {
+		l, err := f.readLine()
+		if errors.Is(err, io.EOF) || l == "" {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(l, "M "):
+			err = f.modify(files, strings.TrimPrefix(l, "M "))
+		case strings.HasPrefix(l, "D "):
+			var path string
+			if path, _, err = parsePath(strings.TrimPrefix(l, "D "), true); err == nil {
+				removePath(files, path)
+			}
+		case strings.HasPrefix(l, "R ") || strings.HasPrefix(l, "C "):
+			err = copyPath(files, l[2:], l[0] == 'R')
+		case l == "deleteall":
+			for k := range files {
+				delete(files, k)
+			}
+		case strings.HasPrefix(l, "N "):
+			err = errors.New("fatal: notes are not supported")
+		default:
+			f.pending = &l
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// modify applies an M command, whose data is inline, a mark or a hash.
+func (f *fastImporter) modify(files map[string]*importFile, arg string) error {
+	fields := strings.SplitN(arg, " ", 3)
+	if len(fields) != 3 {
+		return fmt.Errorf("fatal: Missing space after mode: M %s", arg)
+	}
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return fmt.Errorf("fatal: Corrupt mode: M %s", arg)
+	}
+	switch mode {
+	case 0644:
+		mode = 0100644
+	case 0755:
+		mode = 0100755
+	case 0100644, 0100755, 0120000, 0160000, 040000:
+	default:
+		return fmt.Errorf("fatal: Corrupt mode: M %s", arg)
+	}
+	path, _, err := parsePath(fields[2], true)
+	if err != nil {
+		return err
+	}
+	var sha []byte
+	switch ref := fields[1]; {
+	case ref == "inline":
+		content, err := f.data()
+		if err != nil {
+			return err
+		}
+		if sha, err = writeObject(objects.ObjectBlob, content); err != nil {
+			return err
+		}
+	case strings.HasPrefix(ref, ":"):
+		if sha, err = f.marks.lookup(ref); err != nil {
+			return err
+		}
+	case len(ref) == config.Hash().HexSize():
+		sha = []byte(strings.ToLower(ref))
+	default:
+		return fmt.Errorf("fatal: Invalid dataref: M %s", arg)
+	}
+	removePath(files, path)
+	if mode == 040000 {
+		tree, err := objects.ReadObjectTree(sha)
+		if err != nil {
+			return err
+		}
+		for _, v := range tree.FlattenTree() {
+			files[strings.TrimPrefix(path+"/"+v.Path, "/")] = &importFile{mode: v.Mode, sha: v.Sha.AsHexBytes()}
+		}
+		return nil
+	}
+	// a file replaces any file at a parent path
+	for dir := path; strings.Contains(dir, "/"); {
+		dir = dir[:strings.LastIndex(dir, "/")]
+		delete(files, dir)
+	}
+	files[path] = &importFile{mode: uint32(mode), sha: sha}
+	return nil
+}
+
+// commitish resolves the argument of a from or merge command, a mark, a
+// branch of the stream or a revision of the repository.
+func (f *fastImporter) commitish(arg string) ([]byte, error) {
+	if strings.HasPrefix(arg, ":") {
+		return f.marks.lookup(arg)
+	}
+	if b, ok := f.branches[arg]; ok && b.sha != nil {
+		return b.sha, nil
+	}
+	sha, err := revision.Resolve(arg)
+	if err != nil {
+		return nil, fmt.Errorf("fatal: Invalid ref name or SHA1 expression: %s", arg)
+	}
+	return objects.Peel(config.ObjectPath(), sha)
+}
+
+// tag reads a tag command and writes an annotated tag.
+func (f *fastImporter) tag(name string) error {
+	mark, err := f.mark()
+	if err != nil {
+		return err
+	}
+	arg, ok, err := f.optional("from ")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("fatal: Expected from command")
+	}
+	target, err := f.commitish(arg)
+	if err != nil {
+		return err
+	}
+	if _, _, err := f.optional("original-oid "); err != nil {
+		return err
+	}
+	tagger, _, err := f.ident("tagger ")
+	if err != nil {
+		return err
+	}
+	message, err := f.data()
+	if err != nil {
+		return err
+	}
+	obj, err := objects.ReadObject(target)
+	if err != nil {
+		return err
+	}
+	content := bytes.NewBuffer(nil)
+	_, _ = fmt.Fprintf(content, "object %s\ntype %s\ntag %s\n", target, obj.Typ, name)
+	if tagger != "" {
+		_, _ = fmt.Fprintf(content, "tagger %s\n", tagger)
+	}
+	_, _ = fmt.Fprintf(content, "\n%s", message)
+	sha, err := writeObject(objects.ObjectTag, content.Bytes())
+	if err != nil {
+		return err
+	}
+	f.branches["refs/tags/"+name] = &importBranch{sha: sha}
+	if mark > 0 {
+		f.marks.set(mark, sha)
+	}
+	return nil
+}
+
+// reset reads a reset command, which points a branch at a commit or makes
+// the next commit on it a root commit.
+func (f *fastImporter) reset(ref string) error {
+	if !strings.HasPrefix(ref, "refs/") {
+		return fmt.Errorf("fatal: Branch name doesn't conform to GIT standards: %s", ref)
+	}
+	b := &importBranch{files: make(map[string]*importFile)}
+	arg, ok, err := f.optional("from ")
+	if err != nil {
+		return err
+	}
+	if ok {
+		if b.sha, err = f.commitish(arg); err != nil {
+			return err
+		}
+		b.files = nil
+	}
+	f.branches[ref] = b
+	return nil
+}
+
+// checkpoint updates the refs of the stream and writes the marks.
+func (f *fastImporter) checkpoint() error {
+	names := make([]string, 0, len(f.branches))
+	for k, b := range f.branches {
+		if b.sha != nil {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	var failed []string
+	for _, name := range names {
+		sha := f.branches[name].sha
+		old, err := refs.ReadRef(name)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(old, sha) {
+			continue
+		}
+		if old != nil && !f.force {
+			ok, err := contains(sha, old)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				failed = append(failed, fmt.Sprintf("warning: Not updating %s (new tip %s does not contain %s)", name, sha, old))
+				continue
+			}
+		}
+		if err := updateRefHex(name, sha); err != nil {
+			return err
+		}
+	}
+	if f.exportMarks != "" {
+		if err := f.marks.save(f.exportMarks); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// contains reports whether the commit an object peels to has the commit old
+// peels to in its history.
+func contains(sha []byte, old []byte) (bool, error) {
+	sha, err := objects.Peel(config.ObjectPath(), sha)
+	if err != nil {
+		return false, err
+	}
+	if old, err = objects.Peel(config.ObjectPath(), old); err != nil {
+		return false, nil
+	}
+	obj, err := objects.ReadObject(sha)
+	if err != nil || obj.Typ != objects.ObjectCommit {
+		return false, err
+	}
+	return objects.IsAncestor(old, sha)
+}
+
+// load reads the files of the tree of the branch commit.
+func (b *importBranch) load() error {
+	if b.files != nil {
+		return nil
+	}
+	var err error
+	b.files, err = treeFiles(b.sha)
+	return err
+}
+
+// treeFiles returns the tree entries of the commit sha by path.
+func treeFiles(sha []byte) (map[string]*importFile, error) {
+	committed, err := objects.CommittedFiles(sha)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*importFile, len(committed))
+	for _, v := range committed {
+		files[v.Path] = &importFile{mode: v.Mode, sha: v.Sha.AsHexBytes()}
+	}
+	return files, nil
+}
+
+// writeFileTree writes the trees for files and returns the hex hash of the
+// root tree.
+func writeFileTree(files map[string]*importFile) ([]byte, error) {
+	paths := make([]string, 0, len(files))
+	for k := range files {
+		paths = append(paths, k)
+	}
+	// sorting full paths orders each tree as git does, with directories
+	// sorted as if their names ended with a slash
+	sort.Strings(paths)
+	root := &objects.Object{Typ: objects.ObjectTree}
+	dirs := map[string]*objects.Object{"": root}
+	for _, p := range paths {
+		parent := root
+		parts := strings.Split(p, "/")
+		for i := range parts[:len(parts)-1] {
+			key := strings.Join(parts[:i+1], "/")
+			dir, ok := dirs[key]
+			if !ok {
+				dir = &objects.Object{Typ: objects.ObjectTree, Path: parts[i]}
+				parent.Objects = append(parent.Objects, dir)
+				dirs[key] = dir
+			}
+			parent = dir
+		}
+		raw, err := hex.DecodeString(string(files[p].sha))
+		if err != nil {
+			return nil, err
+		}
+		parent.Objects = append(parent.Objects, &objects.Object{Typ: objects.ObjectBlob, Path: p, Sha: raw, Mode: files[p].mode})
+	}
+	sha, err := root.WriteTree()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(sha)), nil
+}
+
+// writeObject writes content as an object of type typ and returns its hex
+// hash.
+func writeObject(typ fmt.Stringer, content []byte) ([]byte, error) {
+	header := []byte(fmt.Sprintf("%s %d%s", typ, len(content), string(byte(0))))
+	sha, err := objects.WriteObject(header, content, "", config.ObjectPath())
+	if err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(sha)), nil
+}
+
+// removePath removes the file at path or the files below the directory
+// path.
+func removePath(files map[string]*importFile, path string) {
+	delete(files, path)
+	for k := range files {
+		if path == "" || strings.HasPrefix(k, path+"/") {
+			delete(files, k)
+		}
+	}
+}
+
+// copyPath applies an R or C command, moving or copying a file or directory.
+func copyPath(files map[string]*importFile, arg string, move bool) error {
+	src, rest, err := parsePath(arg, false)
+	if err != nil {
+		return err
+	}
+	dst, _, err := parsePath(strings.TrimPrefix(rest, " "), true)
+	if err != nil {
+		return err
+	}
+	found := make(map[string]*importFile)
+	for k, v := range files {
+		if k == src {
+			found[dst] = v
+		} else if strings.HasPrefix(k, src+"/") {
+			found[dst+strings.TrimPrefix(k, src)] = v
+		}
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("fatal: Path %s not in branch", src)
+	}
+	if move {
+		removePath(files, src)
+	}
+	removePath(files, dst)
+	for k, v := range found {
+		files[k] = v
+	}
+	return nil
+}
+
+// parsePath reads a path which may be quoted in the C style. Unless last is
+// set an unquoted path ends at the first space.
+func parsePath(s string, last bool) (string, string, error) {
+	if !strings.HasPrefix(s, "\"") {
+		if last {
+			return s, "", nil
+		}
+		path, rest, ok := strings.Cut(s, " ")
+		if !ok {
+			return "", "", fmt.Errorf("fatal: Missing space after source: %s", s)
+		}
+		return path, " " + rest, nil
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			path, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("fatal: Invalid path: %s", s)
+			}
+			return path, s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("fatal: Invalid path: %s", s)
+}
+
+func newMarks() *marks {
+	return &marks{shas: make(map[int][]byte), marks: make(map[string]int), next: 1}
+}
+
+// load reads a marks file of lines of a mark and a hex hash.
+func (m *marks) load(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("fatal: cannot read '%s': %w", path, err)
+	}
+	for _, l := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if l == "" {
+			continue
+		}
+		mark, sha, _ := strings.Cut(l, " ")
+		n, err := strconv.Atoi(strings.TrimPrefix(mark, ":"))
+		if err != nil || !strings.HasPrefix(mark, ":") || len(sha) != config.Hash().HexSize() {
+			return fmt.Errorf("fatal: corrupt mark line: %s", l)
+		}
+		m.set(n, []byte(sha))
+	}
+	return nil
+}
+
+// save writes the marks file, in mark order.
+func (m *marks) save(path string) error {
+	ids := make([]int, 0, len(m.shas))
+	for k := range m.shas {
+		ids = append(ids, k)
+	}
+	sort.Ints(ids)
+	buf := bytes.NewBuffer(nil)
+	for _, k := range ids {
+		_, _ = fmt.Fprintf(buf, ":%d %s\n", k, m.shas[k])
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func (m *marks) set(n int, sha []byte) {
+	m.shas[n] = sha
+	m.marks[string(sha)] = n
+	if n >= m.next {
+		m.next = n + 1
+	}
+}
+
+// add marks sha with the next mark and returns it.
+func (m *marks) add(sha []byte) string {
+	n := m.next
+	m.set(n, sha)
+	return fmt.Sprintf(":%d", n)
+}
+
+func (m *marks) has(sha []byte) bool {
+	_, ok := m.marks[string(sha)]
+	return ok
+}
+
+// ref returns the mark of sha, or sha when it is not marked.
+func (m *marks) ref(sha []byte) string {
+	if n, ok := m.marks[string(sha)]; ok {
+		return fmt.Sprintf(":%d", n)
+	}
+	return string(sha)
+}
+
+// lookup returns the hash for a mark such as :1.
+func (m *marks) lookup(mark string) ([]byte, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(mark, ":"))
+	if err == nil {
+		if sha, ok := m.shas[n]; ok {
+			return sha, nil
+		}
+	}
+	return nil, fmt.Errorf("fatal: mark %s not declared", mark)
+}
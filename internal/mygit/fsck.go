@@ -0,0 +1,333 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/gfs"
+	"github.com/richardjennings/mygit/internal/mygit/index"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FsckOptions configures Fsck
+type FsckOptions struct {
+	// Unreachable reports every object which is not reachable, rather than
+	// only the dangling objects which no other object refers to
+	Unreachable bool
+	// NoDangling does not report dangling objects
+	NoDangling bool
+}
+
+type (
+	// fsck holds the objects found in the object store and the number of
+	// errors reported
+	fsck struct {
+		o     io.Writer
+		types map[string]string
+		// corrupt objects could not be read or do not hash to their name
+		corrupt map[string]struct{}
+		errors  int
+	}
+	// fsckLink is a reference to an object of type typ, from the object
+	// from unless it is a ref or index entry
+	fsckLink struct {
+		sha     []byte
+		typ     string
+		from    []byte
+		fromTyp string
+	}
+)
+
+// Fsck checks the hashes and syntax of every loose and packed object, the
+// checksums of packs and the index and that refs point to objects. Objects
+// missing from the history of refs and the index are reported, as are
+// dangling objects which nothing refers to.
+func Fsck(o io.Writer, opts FsckOptions) error {
+	f := &fsck{o: o, types: make(map[string]string), corrupt: make(map[string]struct{})}
+	dir := config.ObjectPath()
+	if err := objects.VerifyPacks(dir); err != nil {
+		f.errorf("error: %s", err)
+	}
+	all, err := objects.Objects(dir)
+	if err != nil {
+		return err
+	}
+	for _, sha := range all {
+		typ, err := objects.Verify(dir, sha)
+		var check *objects.CheckError
+		switch {
+		case errors.As(err, &check):
+			f.errorf("error in %s %s: %s", typ, sha, check)
+			f.types[string(sha)] = typ.String()
+		case errors.Is(err, objects.ErrHashMismatch):
+			f.errorf("error: %s", err)
+			f.corrupt[string(sha)] = struct{}{}
+		case err != nil:
+			f.errorf("error: %s: object corrupt or missing: %s", sha, err)
+			f.corrupt[string(sha)] = struct{}{}
+		default:
+			f.types[string(sha)] = typ.String()
+		}
+	}
+	roots, err := f.refs()
+	if err != nil {
+		return err
+	}
+	logs, err := f.reflogs()
+	if err != nil {
+		return err
+	}
+	roots = append(roots, logs...)
+	roots = append(roots, f.index()...)
+	reachable := f.walk(roots)
+
+	var unreachable [][]byte
+	referenced := make(map[string]struct{})
+	for _, sha := range all {
+		if _, ok := reachable[string(sha)]; ok {
+			continue
+		}
+		if _, ok := f.corrupt[string(sha)]; ok {
+			continue
+		}
+		unreachable = append(unreachable, sha)
+		links, err := f.links(sha, f.types[string(sha)])
+		if err != nil {
+			continue
+		}
+		for _, v := range links {
+			referenced[string(v.sha)] = struct{}{}
+		}
+	}
+	for _, sha := range unreachable {
+		_, ok := referenced[string(sha)]
+		switch {
+		case opts.Unreachable:
+			_, _ = fmt.Fprintf(o, "unreachable %s %s\n", f.types[string(sha)], sha)
+		case !opts.NoDangling && !ok:
+			_, _ = fmt.Fprintf(o, "dangling %s %s\n", f.types[string(sha)], sha)
+		}
+	}
+	if f.errors > 0 {
+		return fmt.Errorf("error: %d problems found", f.errors)
+	}
+	return nil
+}
+
+func (f *fsck) errorf(format string, a ...any) {
+	f.errors++
+	_, _ = fmt.Fprintf(f.o, format+"\n", a...)
+}
+
+// refs checks that loose and packed refs and HEAD hold a hash of an object
+// and returns the objects they point to.
+func (f *fsck) refs() ([]*fsckLink, error) {
+	gitDir := config.GitPath()
+	values := make(map[string]string)
+	packed, err := refs.PackedRefs()
+	if err != nil {
+		f.errorf("error: packed-refs: %s", err)
+	}
+	for k, v := range packed {
+		values[k] = string(v)
+	}
+	// loose refs take precedence over packed refs
+	err = filepath.WalkDir(filepath.Join(gitDir, "refs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(gitDir, path)
+		if err != nil {
+			return err
+		}
+		values[filepath.ToSlash(rel)] = strings.TrimSpace(string(b))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var roots []*fsckLink
+	for _, name := range names {
+		if link := f.ref(name, values[name]); link != nil {
+			roots = append(roots, link)
+		}
+	}
+	head, err := os.ReadFile(config.GitHeadPath())
+	if err != nil {
+		f.errorf("error: HEAD: %s", err)
+		return roots, nil
+	}
+	if target, ok := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: "); ok {
+		if !strings.HasPrefix(target, "refs/") {
+			f.errorf("error: HEAD points to something strange (%s)", target)
+		} else if _, ok := values[target]; !ok {
+			_, _ = fmt.Fprintf(f.o, "notice: HEAD points to an unborn branch (%s)\n", strings.TrimPrefix(target, "refs/heads/"))
+		}
+	} else if link := f.ref(config.DefaultHeadFile, strings.TrimSpace(string(head))); link != nil {
+		roots = append(roots, link)
+	}
+	return roots, nil
+}
+
+// ref checks the value of a ref, returning the object it points to unless
+// it is a symbolic ref.
+func (f *fsck) ref(name string, value string) *fsckLink {
+	if strings.HasPrefix(value, "ref: ") {
+		return nil
+	}
+	if !config.Hash().IsHex(value) {
+		f.errorf("error: %s: badRefContent: %s", name, value)
+		return nil
+	}
+	if _, ok := f.types[value]; !ok {
+		f.errorf("error: %s: invalid sha1 pointer %s", name, value)
+		return nil
+	}
+	return &fsckLink{sha: []byte(value)}
+}
+
+// reflogs returns the objects recorded in the reflogs, which keep the
+// previous values of refs from being reported as dangling.
+func (f *fsck) reflogs() ([]*fsckLink, error) {
+	var r []*fsckLink
+	err := filepath.WalkDir(filepath.Join(config.GitPath(), "logs"), func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// each entry starts with the old and new hashes of the ref
+		for _, l := range strings.Split(string(b), "\n") {
+			for i, sha := range strings.Fields(l) {
+				if _, ok := f.types[sha]; ok && i < 2 {
+					r = append(r, &fsckLink{sha: []byte(sha)})
+				}
+			}
+		}
+		return nil
+	})
+	return r, err
+}
+
+// index checks the checksum of the index and returns the blobs of its
+// entries.
+func (f *fsck) index() []*fsckLink {
+	if err := index.Verify(); err != nil {
+		f.errorf("error: index: %s", err)
+		return nil
+	}
+	idx, err := index.ReadIndex()
+	if err != nil {
+		f.errorf("error: index: %s", err)
+		return nil
+	}
+	var r []*fsckLink
+	for _, v := range idx.Files() {
+		// submodule commits are in another repository
+		if fi, ok := v.Finfo.(*gfs.Finfo); ok && fi.MMode == 0160000 {
+			continue
+		}
+		r = append(r, &fsckLink{sha: v.Sha.AsHexBytes(), typ: objects.ObjectBlob.String()})
+	}
+	return r
+}
+
+// walk marks the objects reachable from roots, reporting missing objects
+// and objects of the wrong type.
+func (f *fsck) walk(roots []*fsckLink) map[string]struct{} {
+	reachable := make(map[string]struct{})
+	missing := make(map[string]struct{})
+	stack := roots
+	for len(stack) > 0 {
+		l := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := reachable[string(l.sha)]; ok {
+			continue
+		}
+		typ, ok := f.types[string(l.sha)]
+		if _, corrupt := f.corrupt[string(l.sha)]; corrupt {
+			reachable[string(l.sha)] = struct{}{}
+			continue
+		}
+		if !ok {
+			if l.from != nil {
+				_, _ = fmt.Fprintf(f.o, "broken link from %7s %s\n              to %7s %s\n", l.fromTyp, l.from, l.typ, l.sha)
+			}
+			if _, ok := missing[string(l.sha)]; !ok {
+				missing[string(l.sha)] = struct{}{}
+				f.errorf("missing %s %s", l.typ, l.sha)
+			}
+			continue
+		}
+		reachable[string(l.sha)] = struct{}{}
+		if l.typ != "" && l.typ != typ {
+			f.errorf("error: object %s is a %s, not a %s", l.sha, typ, l.typ)
+			continue
+		}
+		links, err := f.links(l.sha, typ)
+		if err != nil {
+			f.errorf("error: %s: %s", l.sha, err)
+			continue
+		}
+		stack = append(stack, links...)
+	}
+	return reachable
+}
+
+// links returns the objects referred to by a commit, tree or tag.
+func (f *fsck) links(sha []byte, typ string) ([]*fsckLink, error) {
+	var r []*fsckLink
+	switch typ {
+	case objects.ObjectCommit.String():
+		c, err := objects.ReadCommit(sha)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, &fsckLink{sha: c.Tree, typ: objects.ObjectTree.String(), from: sha, fromTyp: typ})
+		for _, p := range c.Parents {
+			r = append(r, &fsckLink{sha: p, typ: typ, from: sha, fromTyp: typ})
+		}
+	case objects.ObjectTree.String():
+		obj, err := objects.ReadObject(sha)
+		if err != nil {
+			return nil, err
+		}
+		t, err := objects.ReadTree(obj)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range t.Items {
+			if v.Mode != 0160000 {
+				r = append(r, &fsckLink{sha: v.Sha, typ: v.Typ.String(), from: sha, fromTyp: typ})
+			}
+		}
+	case objects.ObjectTag.String():
+		t, err := objects.ReadTag(sha)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, &fsckLink{sha: t.Object, typ: t.Typ.String(), from: sha, fromTyp: typ})
+	}
+	return r, nil
+}
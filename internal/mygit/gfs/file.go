@@ -3,12 +3,16 @@ package gfs
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/richardjennings/mygit/internal/mygit/config"
 	"github.com/richardjennings/mygit/internal/mygit/ignore"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -39,10 +43,13 @@ type (
 		IdxStatus IndexStatus
 		WdStatus  WDStatus
 		Sha       *Sha
+		Mode      uint32
 		Finfo     os.FileInfo
 	}
+	// Sha is a SHA-1 or SHA-256 hash
 	Sha struct {
-		hash [20]byte
+		hash [32]byte
+		size int
 	}
 	IndexStatus uint8
 	WDStatus    uint8
@@ -61,7 +68,7 @@ type (
 		Uid    uint32
 		Gid    uint32
 		SSize  uint32
-		Sha    [20]byte
+		Sha    []byte
 		NName  string
 	}
 	Mtime struct {
@@ -70,14 +77,17 @@ type (
 	}
 )
 
+// NewSha returns the Sha of a hex or raw SHA-1 or SHA-256 hash.
 func NewSha(b []byte) (*Sha, error) {
-	if len(b) == 40 {
-		s := &Sha{}
-		_, _ = hex.Decode(s.hash[:], b)
+	switch len(b) {
+	case 40, 64:
+		s := &Sha{size: len(b) / 2}
+		if _, err := hex.Decode(s.hash[:], b); err != nil {
+			return nil, fmt.Errorf("invalid sha %s", b)
+		}
 		return s, nil
-	}
-	if len(b) == 20 {
-		s := &Sha{}
+	case 20, 32:
+		s := &Sha{size: len(b)}
 		copy(s.hash[:], b)
 		return s, nil
 	}
@@ -91,27 +101,21 @@ func (s *Sha) Same(ss *Sha) bool {
 	if s == nil {
 		return false
 	}
-	return s.hash == ss.hash
+	return *s == *ss
 }
 
 func (s Sha) AsHexString() string {
-	return hex.EncodeToString(s.hash[:])
+	return hex.EncodeToString(s.hash[:s.size])
 }
 func (s Sha) AsHexBytes() []byte {
-	b := make([]byte, 40)
-	hex.Encode(b, s.hash[:])
+	b := make([]byte, s.size*2)
+	hex.Encode(b, s.hash[:s.size])
 	return b
 }
 
-func (s Sha) AsArray() [20]byte {
-	var r [20]byte
-	copy(r[:], s.hash[:])
-	return r
-}
-
 // @todo this is more AsSlice ...
 func (s Sha) AsBytes() []byte {
-	return s.hash[:]
+	return s.hash[:s.size]
 }
 
 func (is IndexStatus) String() string {
@@ -158,28 +162,158 @@ func (wds WDStatus) String() string {
 	}
 }
 
-// Ls recursively lists files in path
+// LsOptions configures LsWithOptions
+type LsOptions struct {
+	// NoIgnoreRules does not apply the configured, .gitignore and exclude
+	// file ignore rules
+	NoIgnoreRules bool
+	// Excludes are additional ignore patterns which take precedence over
+	// the ignore rules
+	Excludes []string
+}
+
+// Ls recursively lists files in path. Directories are read and files are
+// stat'd concurrently by a bounded pool of workers. Ignore rules are evaluated
+// at directory level so that ignored directories are skipped rather than
+// walked.
 func Ls(path string) ([]*File, error) {
-	var files []*File
-	if err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	files, _, err := LsWithOptions(path, LsOptions{})
+	return files, err
+}
+
+// LsWithOptions lists files in path as Ls, also returning the paths of the
+// ignored files and directories. Ignored directories are not walked and their
+// paths end with a separator.
+func LsWithOptions(path string, opts LsOptions) ([]*File, []string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(path, config.Path()), string(filepath.Separator))
+	// the ignore files of the parents of path are read once
+	ignores := ignore.NewCache()
+	if !info.IsDir() {
+		if ignores.IsIgnored(path) {
+			return nil, []string{rel}, nil
 		}
-		if info.IsDir() {
-			return nil
+		return []*File{{Path: rel, Finfo: info}}, nil, nil
+	}
+	if rel != "" && ignores.IsIgnoredDir(path) {
+		return nil, []string{rel + string(filepath.Separator)}, nil
+	}
+	var m *ignore.Matcher
+	if opts.NoIgnoreRules {
+		m = ignore.Overrides(opts.Excludes...)
+	} else {
+		if m, err = ignores.ForDirectory(rel); err != nil {
+			return nil, nil, err
+		}
+		m = m.WithOverrides(opts.Excludes...)
+	}
+	w := &walker{sem: make(chan struct{}, config.Workers())}
+	w.walk(path, rel, m)
+	w.wg.Wait()
+	if w.err != nil {
+		return nil, nil, w.err
+	}
+	sort.Slice(w.files, func(i, j int) bool { return w.files[i].Path < w.files[j].Path })
+	sort.Strings(w.ignored)
+	return w.files, w.ignored, nil
+}
+
+// walker walks a directory tree, reading sub-directories in new goroutines
+// while there is capacity in sem.
+type walker struct {
+	sem     chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	files   []*File
+	ignored []string
+	err     error
+}
+
+// walk lists dir, which is rel relative to the repository root, using the
+// ignore rules in m which include those from dir.
+func (w *walker) walk(dir string, rel string, m *ignore.Matcher) {
+	if w.failed() {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	var files []*File
+	var ignored []string
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		relPath := filepath.Join(rel, e.Name())
+		if e.IsDir() {
+			// equivalent of filepath.SkipDir
+			if m.Match(relPath, true) {
+				if e.Name() != config.Config.GitDirectory {
+					ignored = append(ignored, relPath+string(filepath.Separator))
+				}
+				continue
+			}
+			cm, err := m.WithDirectory(relPath)
+			if err != nil {
+				w.fail(err)
+				return
+			}
+			select {
+			case w.sem <- struct{}{}:
+				w.wg.Add(1)
+				go func(path string, relPath string, cm *ignore.Matcher) {
+					defer w.wg.Done()
+					defer func() { <-w.sem }()
+					w.walk(path, relPath, cm)
+				}(path, relPath, cm)
+			default:
+				// no spare workers, walk in this goroutine
+				w.walk(path, relPath, cm)
+			}
+			continue
 		}
 		// do not add ignored files
-		if !ignore.IsIgnored(path) {
-			files = append(files, &File{
-				Path:  strings.TrimPrefix(path, config.WorkingDirectory()),
-				Finfo: info,
-			})
+		if m.Match(relPath, false) {
+			if e.Name() != config.Config.GitDirectory {
+				ignored = append(ignored, relPath)
+			}
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// removed since the directory was read
+				continue
+			}
+			w.fail(err)
+			return
 		}
-		return nil
-	}); err != nil {
-		return files, err
+		files = append(files, &File{
+			Path:  relPath,
+			Finfo: info,
+		})
+	}
+	w.mu.Lock()
+	w.files = append(w.files, files...)
+	w.ignored = append(w.ignored, ignored...)
+	w.mu.Unlock()
+}
+
+func (w *walker) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
 	}
-	return files, nil
+}
+
+func (w *walker) failed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err != nil
 }
 
 func NewFileSet(files []*File) *FileSet {
@@ -245,9 +379,11 @@ func (fs *FileSet) MergeFromWD(fss *FileSet) {
 
 		if fs.idx[v.Path].Finfo == nil {
 			// this is a commit file and not in the index
-			// @todo should this be able to happen ?
 			fs.idx[v.Path].WdStatus = WDUntracked
-			fs.idx[v.Path].IdxStatus = IndexUntracked
+			if fs.idx[v.Path].IdxStatus != IndexDeletedInIndex {
+				// @todo should this be able to happen ?
+				fs.idx[v.Path].IdxStatus = IndexUntracked
+			}
 		} else {
 			if v.Finfo.ModTime() != fs.idx[v.Path].Finfo.ModTime() {
 				fs.idx[v.Path].WdStatus = WDWorktreeChangedSinceIndex
@@ -258,13 +394,25 @@ func (fs *FileSet) MergeFromWD(fss *FileSet) {
 		}
 	}
 	for _, v := range fs.files {
-		if _, ok := fss.idx[v.Path]; !ok {
-			// file exists in commit but not in index
+		if v.gitlink() {
+			// submodules are not checked out, an empty directory is expected
+			continue
+		}
+		if _, ok := fss.idx[v.Path]; !ok && v.IdxStatus != IndexDeletedInIndex {
+			// file exists in index but not in working directory
 			v.WdStatus = WDDeletedInWorktree
 		}
 	}
 }
 
+// gitlink reports whether f is a submodule commit in a tree or the index
+func (f *File) gitlink() bool {
+	if fi, ok := f.Finfo.(*Finfo); ok && fi.MMode == 0160000 {
+		return true
+	}
+	return f.Mode == 0160000
+}
+
 func (fs *FileSet) Add(file *File) {
 	fs.idx[file.Path] = file
 	fs.files = append(fs.files, file)
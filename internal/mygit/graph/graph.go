@@ -0,0 +1,157 @@
+package graph
+
+import "strings"
+
+// Graph draws the lines of history to the left of commits, as log --graph
+// does. Commits must be given children first.
+type Graph struct {
+	columns []string
+	next    []string
+	lines   []string
+	width   int
+	started bool
+}
+
+// Update moves the graph to the commit sha with parents. Its lines start
+// with the commit line, followed by lines opening the columns of merged
+// parents and moving columns left into the columns they join.
+func (g *Graph) Update(sha string, parents []string) {
+	if g.started {
+		g.columns = g.next
+	}
+	g.started = true
+	idx := index(g.columns, sha)
+	if idx < 0 {
+		g.columns = append(g.columns, sha)
+		idx = len(g.columns) - 1
+	}
+	// lanes are the columns after opening the columns of new parents right
+	// of the commit, target is the column each lane continues in
+	var lanes, target []int
+	var next []string
+	add := func(c string) int {
+		k := index(next, c)
+		if k < 0 {
+			next = append(next, c)
+			k = len(next) - 1
+		}
+		return k
+	}
+	opened := 0
+	for i, c := range g.columns {
+		if i != idx {
+			lanes = append(lanes, i+opened)
+			target = append(target, add(c))
+			continue
+		}
+		for j, p := range parents {
+			isNew := index(next, p) < 0 && index(g.columns[i+1:], p) < 0
+			k := add(p)
+			if j == 0 {
+				lanes = append(lanes, i)
+				target = append(target, k)
+			} else if isNew {
+				opened++
+				lanes = append(lanes, i+opened)
+				target = append(target, k)
+			}
+		}
+	}
+	g.next = next
+	g.width = 2 * max(len(g.columns)+opened, len(next))
+
+	g.lines = []string{g.row(func(i int) byte {
+		if i == idx {
+			return '*'
+		}
+		return '|'
+	}, len(g.columns))}
+	if opened > 0 {
+		b := g.blank()
+		for i := range g.columns {
+			if i <= idx {
+				b[2*i] = '|'
+			} else {
+				b[2*(i+opened)-1] = '\\'
+			}
+		}
+		for m := 1; m <= opened; m++ {
+			b[2*(idx+m)-1] = '\\'
+		}
+		g.lines = append(g.lines, string(b))
+	}
+	// each line moves every lane left of its target one column left
+	for {
+		moving := false
+		for k := range lanes {
+			if lanes[k] > target[k] {
+				moving = true
+			}
+		}
+		if !moving {
+			break
+		}
+		b := g.blank()
+		for k, p := range lanes {
+			if p > target[k] {
+				b[2*p-1] = '/'
+				lanes[k]--
+			} else {
+				b[2*p] = '|'
+			}
+		}
+		g.lines = append(g.lines, string(b))
+	}
+}
+
+// Next returns the graph of the next line of the commit, the commit line
+// first and padding once the lines of the commit are shown.
+func (g *Graph) Next() string {
+	if len(g.lines) > 0 {
+		l := g.lines[0]
+		g.lines = g.lines[1:]
+		return l
+	}
+	return g.row(func(int) byte { return '|' }, len(g.next))
+}
+
+// Padding returns the graph of a line shown before the commit line
+func (g *Graph) Padding() string {
+	return g.row(func(int) byte { return '|' }, len(g.columns))
+}
+
+// Remainder returns the lines of the commit which are still to be shown
+// before the next commit.
+func (g *Graph) Remainder() []string {
+	lines := g.lines
+	g.lines = nil
+	return lines
+}
+
+func (g *Graph) row(c func(i int) byte, n int) string {
+	b := g.blank()
+	for i := 0; i < n; i++ {
+		b[2*i] = c(i)
+	}
+	return string(b)
+}
+
+func (g *Graph) blank() []byte {
+	return []byte(strings.Repeat(" ", g.width))
+}
+
+func index(columns []string, c string) int {
+	for i, v := range columns {
+		if v == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,75 @@
+package graph
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_Graph(t *testing.T) {
+	type commit struct {
+		sha     string
+		parents []string
+	}
+	tests := []struct {
+		name     string
+		commits  []commit
+		expected string
+	}{
+		{
+			"linear",
+			[]commit{{"c", []string{"b"}}, {"b", []string{"a"}}, {"a", nil}},
+			"* c\n* b\n* a\n",
+		},
+		{
+			"merge",
+			[]commit{
+				{"m", []string{"a2", "b2"}},
+				{"b2", []string{"b1"}},
+				{"b1", []string{"base"}},
+				{"a2", []string{"base"}},
+				{"base", nil},
+			},
+			"*   m\n|\\  \n| * b2\n| * b1\n* | a2\n|/  \n* base\n",
+		},
+		{
+			"far collapse",
+			[]commit{
+				{"m", []string{"a", "n"}},
+				{"n", []string{"b", "c"}},
+				{"c", []string{"base"}},
+				{"b", []string{"base"}},
+				{"a", []string{"base"}},
+				{"base", nil},
+			},
+			"*   m\n|\\  \n| *   n\n| |\\  \n| | * c\n| * | b\n| |/  \n* | a\n|/  \n* base\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Graph{}
+			var b strings.Builder
+			for _, c := range tt.commits {
+				g.Update(c.sha, c.parents)
+				b.WriteString(g.Next() + c.sha + "\n")
+				for _, l := range g.Remainder() {
+					b.WriteString(l + "\n")
+				}
+			}
+			assert.Equal(t, tt.expected, b.String())
+		})
+	}
+}
+
+func Test_Graph_Padding(t *testing.T) {
+	g := &Graph{}
+	g.Update("m", []string{"a", "b"})
+	assert.Equal(t, "*   ", g.Next())
+	assert.Equal(t, "|\\  ", g.Next())
+	assert.Equal(t, "| | ", g.Next())
+	g.Update("b", []string{"a"})
+	assert.Equal(t, "| | ", g.Padding())
+	assert.Equal(t, "| * ", g.Next())
+	assert.Equal(t, "|/  ", g.Next())
+	assert.Equal(t, "|   ", g.Next())
+}
@@ -0,0 +1,32 @@
+package mygit
+
+import (
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/hooks"
+	"os"
+	"path/filepath"
+)
+
+// runHook runs the hook name of the repository in the working directory,
+// writing its output to stderr as git does. Hooks are found in
+// core.hooksPath when it is set.
+func runHook(name string, args []string, opts ...hooks.Opt) error {
+	opts = append([]hooks.Opt{hooks.WithDir(config.Path()), hooks.WithOutput(os.Stderr)}, opts...)
+	if v, ok := config.Value("core.hooksPath"); ok {
+		opts = append(opts, hooks.WithHooksPath(expandPath(v)))
+	}
+	return hooks.Run(config.GitPath(), name, args, opts...)
+}
+
+// commitHookEnv is the environment of the commit hooks, which do not start
+// an editor unless the message is edited.
+func commitHookEnv(edit bool) hooks.Opt {
+	env := []string{"GIT_INDEX_FILE=" + config.IndexFilePath()}
+	if path, err := filepath.Abs(config.IndexFilePath()); err == nil {
+		env[0] = "GIT_INDEX_FILE=" + path
+	}
+	if !edit {
+		env = append(env, "GIT_EDITOR=:")
+	}
+	return hooks.WithEnv(env...)
+}
@@ -0,0 +1,117 @@
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Directory is the directory of hooks in a git directory
+const Directory = "hooks"
+
+type (
+	options struct {
+		dir       string
+		hooksPath string
+		stdin     io.Reader
+		output    io.Writer
+		env       []string
+	}
+	Opt func(o *options)
+)
+
+// WithDir sets the working directory of the hook, which defaults to the git
+// directory.
+func WithDir(dir string) Opt {
+	return func(o *options) {
+		o.dir = dir
+	}
+}
+
+// WithHooksPath sets the directory the hooks are found in, as core.hooksPath
+// does, instead of the hooks directory of the git directory.
+func WithHooksPath(path string) Opt {
+	return func(o *options) {
+		o.hooksPath = path
+	}
+}
+
+// WithStdin sets the standard input of the hook.
+func WithStdin(r io.Reader) Opt {
+	return func(o *options) {
+		o.stdin = r
+	}
+}
+
+// WithOutput sets where the standard output and error of the hook are
+// written.
+func WithOutput(w io.Writer) Opt {
+	return func(o *options) {
+		o.output = w
+	}
+}
+
+// WithEnv adds KEY=value pairs to the environment of the hook.
+func WithEnv(env ...string) Opt {
+	return func(o *options) {
+		o.env = append(o.env, env...)
+	}
+}
+
+// Path returns the path of the hook name in gitDir.
+func Path(gitDir string, name string) string {
+	return filepath.Join(gitDir, Directory, name)
+}
+
+// Exists reports whether the hook name in gitDir exists and is executable.
+func Exists(gitDir string, name string) bool {
+	return executable(Path(gitDir, name))
+}
+
+func executable(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir() && fi.Mode().Perm()&0111 != 0
+}
+
+// Run runs the hook name in gitDir with args. A missing or non-executable
+// hook is ignored. An error is returned when the hook exits with a non-zero
+// status.
+func Run(gitDir string, name string, args []string, opts ...Opt) error {
+	gitDir, err := filepath.Abs(gitDir)
+	if err != nil {
+		return err
+	}
+	o := &options{dir: gitDir, output: io.Discard}
+	for _, opt := range opts {
+		opt(o)
+	}
+	path := Path(gitDir, name)
+	if o.hooksPath != "" {
+		path = filepath.Join(o.hooksPath, name)
+	}
+	if !executable(path) {
+		return nil
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Dir = o.dir
+	cmd.Stdin = o.stdin
+	cmd.Stdout = o.output
+	cmd.Stderr = o.output
+	cmd.Env = append(os.Environ(), "GIT_DIR="+gitDir)
+	cmd.Env = append(cmd.Env, o.env...)
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return fmt.Errorf("%s hook exited with status %d", name, exit.ExitCode())
+		}
+		return err
+	}
+	return nil
+}
@@ -1,23 +1,332 @@
 package ignore
 
 import (
+	"bufio"
+	"errors"
 	"github.com/richardjennings/mygit/internal/mygit/config"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 )
 
+// FileName is the name of the per directory ignore file
+const FileName = ".gitignore"
+
+type (
+	// Matcher matches repository relative paths against gitignore patterns.
+	// A Matcher is immutable, WithDirectory returns a new Matcher for a
+	// sub-directory so that it can be shared between goroutines.
+	Matcher struct {
+		patterns []*pattern
+		// overrides take precedence over patterns, as for patterns given on
+		// the command line
+		overrides []*pattern
+		// static matchers do not read .gitignore files
+		static bool
+	}
+	pattern struct {
+		re      *regexp.Regexp
+		negate  bool
+		dirOnly bool
+	}
+)
+
+// New returns a Matcher with the configured ignore patterns and the patterns
+// from .git/info/exclude.
+func New() (*Matcher, error) {
+	m := &Matcher{}
+	for _, v := range config.Config.GitIgnore {
+		m.add("", v)
+	}
+	if err := m.read("", filepath.Join(config.GitPath(), "info", "exclude")); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ForDirectory returns a Matcher including the patterns from each .gitignore
+// file from the repository root down to and including dir.
+func ForDirectory(dir string) (*Matcher, error) {
+	return NewCache().ForDirectory(dir)
+}
+
+// Cache builds the Matcher of each directory once from the Matcher of its
+// parent, so that checking many paths reads each ignore file once. A Cache
+// is used for a single command, ignore files changed once read are not seen.
+type Cache struct {
+	mu   sync.Mutex
+	dirs map[string]*Matcher
+}
+
+// NewCache returns an empty Cache
+func NewCache() *Cache {
+	return &Cache{dirs: make(map[string]*Matcher)}
+}
+
+// ForDirectory returns a Matcher including the patterns from each .gitignore
+// file from the repository root down to and including dir.
+func (c *Cache) ForDirectory(dir string) (*Matcher, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.forDirectory(dir)
+}
+
+func (c *Cache) forDirectory(dir string) (*Matcher, error) {
+	if dir == "." {
+		dir = ""
+	}
+	if m, ok := c.dirs[dir]; ok {
+		return m, nil
+	}
+	var m *Matcher
+	var err error
+	if dir == "" {
+		m, err = New()
+	} else {
+		m, err = c.forDirectory(filepath.Dir(dir))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m, err = m.WithDirectory(dir); err != nil {
+		return nil, err
+	}
+	c.dirs[dir] = m
+	return m, nil
+}
+
+// Overrides returns a Matcher using only patterns, ignoring the configured
+// patterns and .gitignore files.
+func Overrides(patterns ...string) *Matcher {
+	return (&Matcher{static: true}).WithOverrides(patterns...)
+}
+
+// WithOverrides returns a Matcher including patterns which take precedence
+// over all other patterns.
+func (m *Matcher) WithOverrides(patterns ...string) *Matcher {
+	o := &Matcher{}
+	for _, v := range patterns {
+		o.add("", v)
+	}
+	return &Matcher{
+		patterns:  m.patterns,
+		overrides: append(m.overrides[:len(m.overrides):len(m.overrides)], o.patterns...),
+		static:    m.static,
+	}
+}
+
+// WithDirectory returns a Matcher including the patterns read from the
+// .gitignore file in dir, which is relative to the repository root.
+func (m *Matcher) WithDirectory(dir string) (*Matcher, error) {
+	if m.static {
+		return m, nil
+	}
+	c := &Matcher{patterns: m.patterns[:len(m.patterns):len(m.patterns)], overrides: m.overrides}
+	if err := c.read(dir, filepath.Join(config.Path(), dir, FileName)); err != nil {
+		return nil, err
+	}
+	if len(c.patterns) == len(m.patterns) {
+		return m, nil
+	}
+	return c, nil
+}
+
+// Match reports whether path, relative to the repository root, is ignored.
+// Parent directories are not considered, see IsIgnored.
+func (m *Matcher) Match(path string, isDir bool) bool {
+	if filepath.Base(path) == config.Config.GitDirectory {
+		return true
+	}
+	path = filepath.ToSlash(path)
+	if ignored, ok := match(m.overrides, path, isDir); ok {
+		return ignored
+	}
+	ignored, _ := match(m.patterns, path, isDir)
+	return ignored
+}
+
+// match returns whether the last of patterns matching path ignores it, and
+// whether any pattern matched.
+func match(patterns []*pattern, path string, isDir bool) (bool, bool) {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		p := patterns[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(path) {
+			return !p.negate, true
+		}
+	}
+	return false, false
+}
+
+func (m *Matcher) read(dir string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+			return nil
+		}
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		m.add(dir, s.Text())
+	}
+	return s.Err()
+}
+
+// add parses a gitignore pattern line from a file in directory dir.
+func (m *Matcher) add(dir string, line string) {
+	line = strings.TrimSuffix(line, "\r")
+	// trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return
+	}
+	p := &pattern{}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' && len(line) > 1 && (line[1] == '#' || line[1] == '!') {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return
+	}
+	// a pattern with a separator at the beginning or middle is relative to
+	// the directory of the .gitignore file, otherwise it matches at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	prefix := ""
+	if dir != "" {
+		prefix = regexp.QuoteMeta(filepath.ToSlash(dir)) + "/"
+	}
+	expr := globToRegexp(line)
+	if anchored {
+		expr = "^" + prefix + expr + "$"
+	} else {
+		expr = "^" + prefix + "(?:.*/)?" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		// invalid patterns are skipped as git does
+		return
+	}
+	p.re = re
+	m.patterns = append(m.patterns, p)
+}
+
+// globToRegexp translates a gitignore glob into a regular expression.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				atStart := i == 0 || glob[i-1] == '/'
+				j := i + 2
+				if atStart && j < len(glob) && glob[j] == '/' {
+					// "**/" matches zero or more directories
+					b.WriteString("(?:.*/)?")
+					i = j
+					continue
+				}
+				if atStart && j == len(glob) {
+					// trailing "/**" matches everything inside
+					b.WriteString(".*")
+					i = j
+					continue
+				}
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			e := strings.IndexByte(glob[i+1:], ']')
+			if e < 0 {
+				b.WriteString("\\[")
+				continue
+			}
+			class := glob[i+1 : i+1+e]
+			if e == 0 {
+				// a leading ] is part of the class
+				e2 := strings.IndexByte(glob[i+2:], ']')
+				if e2 < 0 {
+					b.WriteString("\\[")
+					continue
+				}
+				class = glob[i+1 : i+2+e2]
+				e = e2 + 1
+			}
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += e + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// IsIgnored reports whether path, which may be absolute or relative to the
+// repository root, is ignored either directly or because a parent directory
+// is ignored.
 func IsIgnored(path string) bool {
+	return NewCache().IsIgnored(path)
+}
+
+// IsIgnoredDir reports whether a directory and everything beneath it is
+// ignored, in which case it does not need to be walked.
+func IsIgnoredDir(path string) bool {
+	return NewCache().IsIgnoredDir(path)
+}
+
+// IsIgnored is IsIgnored using the Matchers of c
+func (c *Cache) IsIgnored(path string) bool {
+	return c.isIgnored(path, false)
+}
+
+// IsIgnoredDir is IsIgnoredDir using the Matchers of c
+func (c *Cache) IsIgnoredDir(path string) bool {
+	return c.isIgnored(path, true)
+}
+
+func (c *Cache) isIgnored(path string, isDir bool) bool {
 	// remove absolute portion of Path
 	path = strings.TrimPrefix(path, config.Path())
 	path = strings.TrimPrefix(path, string(filepath.Separator))
+	path = strings.TrimSuffix(path, string(filepath.Separator))
 	if path == "" {
 		return true
 	}
-	// @todo fix literal string prefix matching and iteration
-	for _, v := range config.Config.GitIgnore {
-		if strings.HasPrefix(path, v) {
+	parts := strings.Split(path, string(filepath.Separator))
+	for i := range parts {
+		p := filepath.Join(parts[:i+1]...)
+		last := i == len(parts)-1
+		m, err := c.ForDirectory(filepath.Dir(p))
+		if err != nil {
+			return false
+		}
+		if m.Match(p, isDir || !last) {
 			return true
 		}
 	}
-	return strings.HasPrefix(path, config.Config.GitDirectory+string(filepath.Separator))
+	return false
 }
@@ -0,0 +1,75 @@
+package ignore
+
+import (
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Matcher(t *testing.T) {
+	dir := t.TempDir()
+	if err := config.Configure(config.WithPath(dir)); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, FileName), []byte("# comment\n*.log\n!keep.log\nbuild/\n/root.txt\ndocs/**/*.tmp\nnode_modules\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "sub", FileName), []byte("local\n/anchored\n"), 0644))
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{".git", true, true},
+		{".idea", true, true},
+		{"a.log", false, true},
+		{"sub/b.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"sub/build", true, true},
+		{"root.txt", false, true},
+		{"sub/root.txt", false, false},
+		{"docs/x.tmp", false, true},
+		{"docs/a/b/x.tmp", false, true},
+		{"other/x.tmp", false, false},
+		{"node_modules", true, true},
+		{"sub/node_modules/x/y.js", false, true},
+		{"sub/local", false, true},
+		{"local", false, false},
+		{"sub/anchored", false, true},
+		{"sub/deeper/anchored", false, false},
+		{"main.go", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if tt.isDir {
+				assert.Equal(t, tt.ignored, IsIgnoredDir(filepath.Join(dir, tt.path)))
+			} else {
+				assert.Equal(t, tt.ignored, IsIgnored(filepath.Join(dir, tt.path)))
+			}
+		})
+	}
+}
+
+func Test_Cache(t *testing.T) {
+	dir := t.TempDir()
+	if err := config.Configure(config.WithPath(dir)); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "a", "b"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a", FileName), []byte("*.log\n"), 0644))
+	c := NewCache()
+	assert.True(t, c.IsIgnored(filepath.Join(dir, "a", "b", "x.log")))
+	assert.False(t, c.IsIgnored(filepath.Join(dir, "x.log")))
+	m, err := c.ForDirectory(filepath.Join("a", "b"))
+	assert.NoError(t, err)
+	assert.True(t, m.Match(filepath.Join("a", "b", "y.log"), false))
+
+	// each ignore file is read once
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a", FileName), nil, 0644))
+	assert.True(t, c.IsIgnored(filepath.Join(dir, "a", "z.log")))
+	assert.False(t, NewCache().IsIgnored(filepath.Join(dir, "a", "z.log")))
+}
@@ -1,6 +1,7 @@
 package index
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/richardjennings/mygit/internal/mygit/config"
@@ -17,7 +18,7 @@ type (
 	Index struct {
 		header *indexHeader
 		items  []*indexItem
-		sig    [20]byte
+		sig    []byte
 	}
 	indexHeader struct {
 		Sig        [4]byte
@@ -26,8 +27,13 @@ type (
 	}
 	indexItem struct {
 		*indexItemP
-		Name []byte
+		// Sha is the raw hash of the blob, its size is set by the object
+		// format of the repository
+		Sha   []byte
+		Flags uint16 // length of filename
+		Name  []byte
 	}
+	// indexItemP is the fixed size stat data of an entry
 	indexItemP struct {
 		CTimeS uint32
 		CTimeN uint32
@@ -39,8 +45,6 @@ type (
 		Uid    uint32
 		Gid    uint32
 		Size   uint32
-		Sha    [20]byte
-		Flags  uint16 // length of filename
 	}
 )
 
@@ -49,7 +53,7 @@ func (idx *Index) Files() []*gfs.File {
 	var files []*gfs.File
 	for _, v := range idx.items {
 		s, _ := gfs.NewSha(v.Sha[:])
-		idx := &gfs.File{Path: string(v.Name), Sha: s, Finfo: fromIndexItemP(v.indexItemP)}
+		idx := &gfs.File{Path: string(v.Name), Sha: s, Finfo: fromIndexItem(v)}
 		files = append(files, idx)
 	}
 	return files
@@ -59,7 +63,7 @@ func (idx *Index) File(path string) *gfs.File {
 	for _, v := range idx.items {
 		if string(v.Name) == path {
 			s, _ := gfs.NewSha(v.Sha[:])
-			return &gfs.File{Path: string(v.Name), Sha: s, Finfo: fromIndexItemP(v.indexItemP)}
+			return &gfs.File{Path: string(v.Name), Sha: s, Finfo: fromIndexItem(v)}
 		}
 	}
 	return nil
@@ -81,49 +85,57 @@ func (idx *Index) Rm(path string) error {
 // Add adds a fs.File to the Index Struct. A call to idx.Write is required
 // to flush the changes to the filesystem.
 func (idx *Index) Add(f *gfs.File) error {
+	i, found := idx.find(f.Path)
 	// if delete, remove from Index
 	if f.WdStatus == gfs.WDDeletedInWorktree {
-		for i, v := range idx.items {
-			if string(v.Name) == f.Path {
-				idx.items = append(idx.items[0:i], idx.items[i+1:]...)
-				idx.header.NumEntries--
-				return nil
-			}
+		if !found {
+			return errors.New("somehow the file was not found in Index items to be removed")
 		}
-		return errors.New("somehow the file was not found in Index items to be removed")
+		idx.items = append(idx.items[0:i], idx.items[i+1:]...)
+		idx.header.NumEntries--
+		return nil
 	} else if f.WdStatus == gfs.WDUntracked {
-		// just add and sort all of them for now
 		item, err := item(f)
 		if err != nil {
 			return err
 		}
-		idx.items = append(idx.items, item)
+		if found {
+			idx.items[i] = item
+			return nil
+		}
+		// insert keeping items sorted by name
+		idx.items = append(idx.items, nil)
+		copy(idx.items[i+1:], idx.items[i:])
+		idx.items[i] = item
 		idx.header.NumEntries++
-		// and sort @todo more efficient
-		sort.Slice(idx.items, func(i, j int) bool {
-			return string(idx.items[i].Name) < string(idx.items[j].Name)
-		})
 	} else if f.WdStatus == gfs.WDWorktreeChangedSinceIndex {
-		for i, v := range idx.items {
-			if string(v.Name) == f.Path {
-				item, err := item(f)
-				if err != nil {
-					return err
-				}
-				idx.items[i] = item
+		if found {
+			item, err := item(f)
+			if err != nil {
+				return err
 			}
+			idx.items[i] = item
 		}
 	}
 
 	return nil
 }
 
+// find returns the position of path in the sorted index items and whether
+// it is present.
+func (idx *Index) find(path string) (int, bool) {
+	i := sort.Search(len(idx.items), func(i int) bool {
+		return string(idx.items[i].Name) >= path
+	})
+	return i, i < len(idx.items) && string(idx.items[i].Name) == path
+}
+
 func item(f *gfs.File) (*indexItem, error) {
 	if f.Sha == nil {
 		return nil, errors.New("missing Sha from working directory file toIndexItem")
 	}
 	if f.Finfo == nil {
-		info, err := os.Stat(filepath.Join(config.Path(), f.Path))
+		info, err := os.Lstat(filepath.Join(config.Path(), f.Path))
 		if err != nil {
 			return nil, err
 		}
@@ -147,20 +159,30 @@ func item(f *gfs.File) (*indexItem, error) {
 		item.Ino = fi.Ino
 		item.Mode = fi.MMode
 		item.Uid = fi.Uid
+		item.Gid = fi.Gid
+		item.Size = fi.SSize
+		if item.Mode == 0 {
+			item.Mode = uint32(0100644)
+		}
 	default:
 		setItemOsSpecificStat(f.Finfo, item)
 		item.Dev = uint32(f.Finfo.Sys().(*syscall.Stat_t).Dev)
 		item.Ino = uint32(f.Finfo.Sys().(*syscall.Stat_t).Ino)
-		if f.Finfo.IsDir() {
+		switch {
+		case f.Finfo.IsDir():
 			item.Mode = uint32(040000)
-		} else {
+		case f.Finfo.Mode()&os.ModeSymlink != 0:
+			item.Mode = uint32(0120000)
+		case f.Finfo.Mode()&0111 != 0:
+			item.Mode = uint32(0100755)
+		default:
 			item.Mode = uint32(0100644)
 		}
 		item.Uid = f.Finfo.Sys().(*syscall.Stat_t).Uid
 		item.Gid = f.Finfo.Sys().(*syscall.Stat_t).Gid
 		item.Size = uint32(f.Finfo.Size())
 	}
-	item.Sha = f.Sha.AsArray()
+	item.Sha = f.Sha.AsBytes()
 	nameLen := len(f.Path)
 	if nameLen < 0xFFF {
 		item.Flags = uint16(len(f.Path))
@@ -172,6 +194,13 @@ func item(f *gfs.File) (*indexItem, error) {
 	return item, nil
 }
 
+// padding is the number of NUL bytes following an entry to end its name and
+// make its length a multiple of 8
+func padding(item *indexItem) int {
+	n := binary.Size(item.indexItemP) + len(item.Sha) + 2 + len(item.Name)
+	return 8 - n%8
+}
+
 func NewIndex() *Index {
 	return &Index{header: &indexHeader{
 		Sig:        [4]byte{'D', 'I', 'R', 'C'},
@@ -180,7 +209,7 @@ func NewIndex() *Index {
 	}}
 }
 
-func fromIndexItemP(p *indexItemP) *gfs.Finfo {
+func fromIndexItem(p *indexItem) *gfs.Finfo {
 	f := &gfs.Finfo{
 		CTimeS: p.CTimeS,
 		CTimeN: p.CTimeN,
@@ -1,9 +1,12 @@
 package index
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/richardjennings/mygit/internal/mygit/config"
+	"io"
 	"os"
 )
 
@@ -23,15 +26,21 @@ func ReadIndex() (*Index, error) {
 	if err := binary.Read(f, binary.BigEndian, index.header); err != nil {
 		return nil, err
 	}
+	size := config.Hash().Size
 	// read num items from header
 	for i := 0; i < int(index.header.NumEntries); i++ {
-		itemP := &indexItemP{}
-		if err := binary.Read(f, binary.BigEndian, itemP); err != nil {
+		item := indexItem{indexItemP: &indexItemP{}, Sha: make([]byte, size)}
+		if err := binary.Read(f, binary.BigEndian, item.indexItemP); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(f, item.Sha); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(f, binary.BigEndian, &item.Flags); err != nil {
 			return nil, err
 		}
 		// mask 4 bits out of 12bits of item flags to get filename length
-		l := itemP.Flags & 0xFFF // 12 1s
-		item := indexItem{indexItemP: itemP}
+		l := item.Flags & 0xFFF // 12 1s
 		// read l bytes into Name
 		item.Name = make([]byte, l)
 		if err := binary.Read(f, binary.BigEndian, &item.Name); err != nil {
@@ -39,14 +48,38 @@ func ReadIndex() (*Index, error) {
 		}
 		index.items = append(index.items, &item)
 		// now read some bytes to make the total read for the item a multiple of 8
-		padding := make([]byte, 8-(62+l)%8)
-		if err := binary.Read(f, binary.BigEndian, &padding); err != nil {
+		pad := make([]byte, padding(&item))
+		if err := binary.Read(f, binary.BigEndian, &pad); err != nil {
 			return nil, err
 		}
 	}
+	index.sig = make([]byte, size)
 	if err := binary.Read(f, binary.BigEndian, &index.sig); err != nil {
 		return nil, err
 	}
 
 	return index, nil
 }
+
+// Verify checks the signature, version and trailing checksum of the Git
+// Index. A missing index is valid.
+func Verify() error {
+	b, err := os.ReadFile(config.IndexFilePath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	h := config.Hash()
+	if len(b) < 12+h.Size || !bytes.Equal(b[0:4], []byte("DIRC")) {
+		return errors.New("bad signature")
+	}
+	if v := binary.BigEndian.Uint32(b[4:8]); v < 2 || v > 4 {
+		return fmt.Errorf("bad index version %d", v)
+	}
+	if !bytes.Equal(h.Sum(b[:len(b)-h.Size]), b[len(b)-h.Size:]) {
+		return fmt.Errorf("bad index file %s signature", h.Name)
+	}
+	return nil
+}
@@ -19,13 +19,13 @@ func ObjectTree(files []*gfs.File) *objects.Object {
 	for _, v := range files {
 		parts := strings.Split(strings.TrimPrefix(v.Path, config.WorkingDirectory()), string(filepath.Separator))
 		if len(parts) == 1 {
-			root.Objects = append(root.Objects, &objects.Object{Typ: objects.ObjectBlob, Path: v.Path, Sha: v.Sha.AsBytes()})
+			root.Objects = append(root.Objects, &objects.Object{Typ: objects.ObjectBlob, Path: v.Path, Sha: v.Sha.AsBytes(), Mode: fileMode(v)})
 			continue // top level file
 		}
 		pn = root
 		for i, p := range parts {
 			if i == len(parts)-1 {
-				pn.Objects = append(pn.Objects, &objects.Object{Typ: objects.ObjectBlob, Path: v.Path, Sha: v.Sha.AsBytes()})
+				pn.Objects = append(pn.Objects, &objects.Object{Typ: objects.ObjectBlob, Path: v.Path, Sha: v.Sha.AsBytes(), Mode: fileMode(v)})
 				continue // leaf
 			}
 			// key for cached nodes
@@ -44,3 +44,11 @@ func ObjectTree(files []*gfs.File) *objects.Object {
 
 	return root
 }
+
+// fileMode returns the tree entry mode of an index file.
+func fileMode(f *gfs.File) uint32 {
+	if fi, ok := f.Finfo.(*gfs.Finfo); ok && fi.MMode != 0 {
+		return fi.MMode
+	}
+	return 0100644
+}
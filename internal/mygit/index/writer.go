@@ -1,7 +1,6 @@
 package index
 
 import (
-	"crypto/sha1"
 	"encoding/binary"
 	"errors"
 	"github.com/richardjennings/mygit/internal/mygit/config"
@@ -22,7 +21,7 @@ func (idx *Index) Write() error {
 	defer func() { _ = f.Close() }()
 	// use a multi-writer to allow both writing the the file whilst incrementally generating
 	// a Sha hash of the content as it is written
-	h := sha1.New()
+	h := config.Hash().New()
 	mw := io.MultiWriter(f, h)
 
 	// write header
@@ -34,19 +33,25 @@ func (idx *Index) Write() error {
 		if err := binary.Write(mw, binary.BigEndian, item.indexItemP); err != nil {
 			return err
 		}
+		if _, err := mw.Write(item.Sha); err != nil {
+			return err
+		}
+		if err := binary.Write(mw, binary.BigEndian, item.Flags); err != nil {
+			return err
+		}
 		// write name
 		if _, err := mw.Write(item.Name); err != nil {
 			return err
 		}
 		// write padding
-		padding := make([]byte, 8-(62+len(item.Name))%8)
-		if _, err := mw.Write(padding); err != nil {
+		pad := make([]byte, padding(item))
+		if _, err := mw.Write(pad); err != nil {
 			return err
 		}
 	}
 	// use the generated hash
 	sha := h.Sum(nil)
-	copy(idx.sig[:], sha)
+	idx.sig = sha
 	// write Sha hash of Index
 	if err := binary.Write(f, binary.BigEndian, &sha); err != nil {
 		return err
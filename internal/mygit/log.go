@@ -0,0 +1,764 @@
+package mygit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/date"
+	"github.com/richardjennings/mygit/internal/mygit/diff"
+	"github.com/richardjennings/mygit/internal/mygit/gfs"
+	"github.com/richardjennings/mygit/internal/mygit/graph"
+	"github.com/richardjennings/mygit/internal/mygit/mailmap"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/pathspec"
+	"github.com/richardjennings/mygit/internal/mygit/pretty"
+	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogOptions configures Log
+type LogOptions struct {
+	// Date is the format of the date of each commit
+	Date date.Mode
+	// ShowSignature shows the result of verifying signed commits
+	ShowSignature bool
+	// MaxCount is the most commits shown when greater than zero
+	MaxCount int
+	// Format is the format of each commit, pretty.Medium when nil
+	Format *pretty.Format
+	// AbbrevCommit abbreviates the commit names of built in formats
+	AbbrevCommit bool
+	// Authors are patterns one of which the author must match
+	Authors []string
+	// Grep are patterns one of which the message must match
+	Grep []string
+	// Since shows commits committed at or after it unless it is zero
+	Since time.Time
+	// Until shows commits committed at or before it unless it is zero
+	Until time.Time
+	// Stat shows the number of lines changed in each file
+	Stat bool
+	// NameStatus shows the name and status of each changed file
+	NameStatus bool
+	// Graph draws the history to the left of the commits
+	Graph bool
+	// Reverse shows the commits oldest first
+	Reverse bool
+}
+
+// logCommit is a commit shown by log, with its parents in the history shown
+type logCommit struct {
+	*objects.Commit
+	parents []string
+}
+
+// logWalk is the history of HEAD simplified to the commits changing the
+// pathspec and matching the filters of the options
+type logWalk struct {
+	ps       *pathspec.Pathspec
+	opts     LogOptions
+	authors  []*regexp.Regexp
+	mailmap  *mailmap.Mailmap
+	grep     []*regexp.Regexp
+	followed map[string][][]byte
+	shown    map[string]bool
+}
+
+// Log writes the commits in the history of the current branch, most
+// recently committed first. When paths are given only commits changing
+// files matching the pathspec are shown, following only a parent of a merge
+// which the merge took the files from unchanged. Authors are matched and
+// shown as mapped by the mailmap.
+func Log(o io.Writer, opts LogOptions, paths ...string) error {
+	if opts.Graph && opts.Reverse {
+		return errors.New("fatal: options '--reverse' and '--graph' cannot be used together")
+	}
+	ps, err := pathspec.New(pathspec.Prefix(), paths...)
+	if err != nil {
+		return err
+	}
+	w := &logWalk{
+		ps:       ps,
+		opts:     opts,
+		followed: make(map[string][][]byte),
+		shown:    make(map[string]bool),
+	}
+	if w.mailmap, err = mailmap.New(); err != nil {
+		return err
+	}
+	if w.authors, err = compilePatterns(opts.Authors); err != nil {
+		return err
+	}
+	if w.grep, err = compilePatterns(opts.Grep); err != nil {
+		return err
+	}
+	branch, err := refs.CurrentBranch()
+	if err != nil {
+		return err
+	}
+	commitSha, err := refs.HeadSHA(branch)
+	if err != nil {
+		return err
+	}
+	order, err := w.walk(commitSha)
+	if err != nil {
+		return err
+	}
+	commits := w.visible(order)
+	if opts.Graph {
+		commits = logTopoOrder(commits)
+	}
+	if opts.MaxCount > 0 && len(commits) > opts.MaxCount {
+		commits = commits[:opts.MaxCount]
+	}
+	if opts.Reverse {
+		for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+			commits[i], commits[j] = commits[j], commits[i]
+		}
+	}
+	return w.write(o, commits)
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, v := range patterns {
+		re, err := regexp.Compile("(?m)" + v)
+		if err != nil {
+			return nil, fmt.Errorf("fatal: command line, '%s': %w", v, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// walk visits the commits reachable from sha through the parents followed
+// after simplification, most recently committed first, deciding which
+// change the pathspec. It stops once no more commits can be shown.
+func (w *logWalk) walk(sha []byte) ([]*objects.Commit, error) {
+	var order []*objects.Commit
+	seen := map[string]bool{string(sha): true}
+	head, err := objects.ReadCommit(sha)
+	if err != nil {
+		return nil, err
+	}
+	// the walk ends once enough commits are shown, unless they are
+	// reordered before being limited, they are reversed after
+	limit, shown := 0, 0
+	if !w.opts.Graph {
+		limit = w.opts.MaxCount
+	}
+	queue := []*objects.Commit{head}
+	for len(queue) > 0 {
+		c := queue[0]
+		// the queue is newest first, no commit left is recent enough
+		if !w.opts.Since.IsZero() && c.CommittedTime.Before(w.opts.Since) {
+			break
+		}
+		queue = queue[1:]
+		order = append(order, c)
+		followed, show, err := w.simplify(c)
+		if err != nil {
+			return nil, err
+		}
+		w.followed[string(c.Sha)] = followed
+		w.shown[string(c.Sha)] = show && w.match(c)
+		if w.shown[string(c.Sha)] {
+			if shown++; shown == limit {
+				break
+			}
+		}
+		for _, p := range followed {
+			if seen[string(p)] {
+				continue
+			}
+			seen[string(p)] = true
+			pc, err := objects.ReadCommit(p)
+			if err != nil {
+				return nil, err
+			}
+			// commits committed at the same time keep the order they were
+			// found in
+			i := sort.Search(len(queue), func(i int) bool {
+				return queue[i].CommittedTime.Before(pc.CommittedTime)
+			})
+			queue = append(queue[:i], append([]*objects.Commit{pc}, queue[i:]...)...)
+		}
+	}
+	return order, nil
+}
+
+// simplify returns the parents of c followed and whether c is shown. Without
+// a pathspec every commit is shown. With one a commit is shown when it
+// changes a matching file compared to each parent, and only the first parent
+// of a merge which it does not change is followed.
+func (w *logWalk) simplify(c *objects.Commit) ([][]byte, bool, error) {
+	if w.ps.Empty() {
+		return c.Parents, true, nil
+	}
+	if len(c.Parents) == 0 {
+		changed, err := w.changes(nil, c.Sha)
+		return nil, changed, err
+	}
+	for _, p := range c.Parents {
+		changed, err := w.changes(p, c.Sha)
+		if err != nil {
+			return nil, false, err
+		}
+		if !changed {
+			return [][]byte{p}, false, nil
+		}
+	}
+	return c.Parents, true, nil
+}
+
+// changes reports whether any file matching the pathspec differs between
+// commits a and b
+func (w *logWalk) changes(a []byte, b []byte) (bool, error) {
+	changes, err := objects.DiffCommits(a, b)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range changes {
+		if w.ps.Match(v.Path) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// match reports whether c matches the author, message and date filters
+func (w *logWalk) match(c *objects.Commit) bool {
+	if !w.opts.Since.IsZero() && c.CommittedTime.Before(w.opts.Since) {
+		return false
+	}
+	if !w.opts.Until.IsZero() && c.CommittedTime.After(w.opts.Until) {
+		return false
+	}
+	name, email := w.mailmap.Map(c.Author, c.AuthorEmail)
+	if !matchAny(w.authors, fmt.Sprintf("%s <%s>", name, email)) {
+		return false
+	}
+	return matchAny(w.grep, string(c.Message))
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// visible returns the shown commits of order with their parents rewritten
+// to the nearest shown commits of the followed history.
+func (w *logWalk) visible(order []*objects.Commit) []*logCommit {
+	rewritten := make(map[string][]string)
+	var rewrite func(sha string) []string
+	rewrite = func(sha string) []string {
+		if v, ok := rewritten[sha]; ok {
+			return v
+		}
+		rewritten[sha] = nil
+		var parents []string
+		for _, p := range w.followed[sha] {
+			if w.shown[string(p)] {
+				parents = appendUnique(parents, string(p))
+				continue
+			}
+			for _, v := range rewrite(string(p)) {
+				parents = appendUnique(parents, v)
+			}
+		}
+		rewritten[sha] = parents
+		return parents
+	}
+	var commits []*logCommit
+	for _, c := range order {
+		if w.shown[string(c.Sha)] {
+			commits = append(commits, &logCommit{Commit: c, parents: rewrite(string(c.Sha))})
+		}
+	}
+	return commits
+}
+
+func appendUnique(list []string, v string) []string {
+	for _, s := range list {
+		if s == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+// logTopoOrder orders commits so that no parent is shown before its children,
+// showing the history of the last parent of a merge before the others.
+func logTopoOrder(commits []*logCommit) []*logCommit {
+	index := make(map[string]*logCommit)
+	children := make(map[string]int)
+	for _, c := range commits {
+		index[string(c.Sha)] = c
+	}
+	for _, c := range commits {
+		for _, p := range c.parents {
+			children[p]++
+		}
+	}
+	var stack []*logCommit
+	for i := len(commits) - 1; i >= 0; i-- {
+		if children[string(commits[i].Sha)] == 0 {
+			stack = append(stack, commits[i])
+		}
+	}
+	var sorted []*logCommit
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		sorted = append(sorted, c)
+		for _, p := range c.parents {
+			if children[p]--; children[p] == 0 {
+				if pc, ok := index[p]; ok {
+					stack = append(stack, pc)
+				}
+			}
+		}
+	}
+	return sorted
+}
+
+// write writes commits in the format of the options, each line after the
+// graph when drawing it. As in git, formats other than oneline and tformat
+// separate commits rather than terminating them, so a message without a
+// trailing newline is ended by what follows it.
+func (w *logWalk) write(o io.Writer, commits []*logCommit) error {
+	format := w.opts.Format
+	if format == nil {
+		format = pretty.Medium
+	}
+	terminated := format.Terminator || format.Name == "oneline"
+	popts := pretty.Options{Date: w.opts.Date, Abbrev: w.opts.AbbrevCommit, Mailmap: w.mailmap}
+	var g *graph.Graph
+	if w.opts.Graph {
+		g = &graph.Graph{}
+	}
+	var b bytes.Buffer
+	prefix := func(f func() string) {
+		if g != nil {
+			b.WriteString(f())
+		}
+	}
+	missingNewline := false
+	for i, c := range commits {
+		if g != nil {
+			g.Update(string(c.Sha), c.parents)
+		}
+		if i > 0 && !terminated {
+			if !missingNewline {
+				prefix(g.Padding)
+			}
+			b.WriteString("\n")
+		}
+		msg := format.Commit(c.Commit, popts)
+		if format.Separated() {
+			msg += "\n"
+		}
+		if w.opts.ShowSignature && c.Sig != nil {
+			sig := bytes.NewBuffer(nil)
+			showSignature(sig, c.Commit)
+			first, rest, _ := strings.Cut(msg, "\n")
+			msg = first + "\n" + sig.String() + rest
+		}
+		for _, l := range strings.SplitAfter(msg, "\n") {
+			if l != "" {
+				prefix(g.Next)
+				b.WriteString(l)
+			}
+		}
+		missingNewline = !strings.HasSuffix(msg, "\n")
+		if g != nil {
+			if remainder := g.Remainder(); len(remainder) > 0 {
+				if missingNewline {
+					b.WriteString("\n")
+				}
+				b.WriteString(strings.Join(remainder, "\n"))
+				if !missingNewline {
+					b.WriteString("\n")
+				}
+			}
+		}
+		if terminated {
+			if !missingNewline {
+				prefix(g.Next)
+			}
+			b.WriteString("\n")
+			missingNewline = false
+		}
+		changes, err := w.diffLines(c.Commit)
+		if err != nil {
+			return err
+		}
+		if len(changes) > 0 && format.Name != "oneline" {
+			if !missingNewline {
+				prefix(g.Next)
+			}
+			b.WriteString("\n")
+			missingNewline = false
+		}
+		for _, l := range changes {
+			prefix(g.Next)
+			b.WriteString(l + "\n")
+		}
+		if _, err := o.Write(b.Bytes()); err != nil {
+			return err
+		}
+		b.Reset()
+	}
+	return nil
+}
+
+// fileChange is a file changed by a commit
+type fileChange struct {
+	status  string
+	from    string
+	to      string
+	added   int
+	deleted int
+	binary  bool
+	size    [2]int
+	// same is the size of the lines in both blobs
+	same int
+}
+
+// diffLines returns the --stat and --name-status lines of the changes c
+// makes to its first parent, none for merges.
+func (w *logWalk) diffLines(c *objects.Commit) ([]string, error) {
+	if !w.opts.Stat && !w.opts.NameStatus || len(c.Parents) > 1 {
+		return nil, nil
+	}
+	changes, err := w.fileChanges(c)
+	if err != nil || len(changes) == 0 {
+		return nil, err
+	}
+	var lines []string
+	if w.opts.Stat {
+		lines = append(lines, diffStat(changes)...)
+	}
+	if w.opts.NameStatus {
+		for _, v := range changes {
+			if v.from != v.to && v.from != "" && v.to != "" {
+				lines = append(lines, fmt.Sprintf("%s\t%s\t%s", v.status, v.from, v.to))
+			} else {
+				lines = append(lines, fmt.Sprintf("%s\t%s%s", v.status, v.from, strings.TrimPrefix(v.to, v.from)))
+			}
+		}
+	}
+	return lines, nil
+}
+
+// fileChanges returns the files matching the pathspec which c changes
+// compared to its first parent, pairing deleted and added files which are
+// the same, or mostly the same within the rename limit, as renames.
+func (w *logWalk) fileChanges(c *objects.Commit) ([]*fileChange, error) {
+	var parent []byte
+	if len(c.Parents) > 0 {
+		parent = c.Parents[0]
+	}
+	changes, err := objects.DiffCommits(parent, c.Sha)
+	if err != nil {
+		return nil, err
+	}
+	var deleted, added []*objects.TreeChange
+	var result []*fileChange
+	for _, v := range changes {
+		if !w.ps.Match(v.Path) {
+			continue
+		}
+		switch {
+		case v.To == nil:
+			deleted = append(deleted, v)
+		case v.From == nil:
+			added = append(added, v)
+		default:
+			fc, err := changeLines(v.From, v.To)
+			if err != nil {
+				return nil, err
+			}
+			fc.status, fc.from, fc.to = "M", v.Path, v.Path
+			result = append(result, fc)
+		}
+	}
+	renamed := make(map[*objects.TreeChange]bool)
+	pairs := make(map[*objects.TreeChange]*objects.TreeChange)
+	// files with the same content are paired first
+	for _, a := range added {
+		for _, d := range deleted {
+			if !renamed[d] && d.From.Sha.Same(a.To.Sha) {
+				renamed[d], pairs[a] = true, d
+				break
+			}
+		}
+	}
+	// comparing the rest is quadratic, so is skipped past the rename limit
+	limit := renameLimit()
+	inexact := limit == 0 || (len(added)-len(pairs))*(len(deleted)-len(pairs)) <= limit*limit
+	for _, a := range added {
+		best, bestScore := pairs[a], 100
+		var bestChange *fileChange
+		if best != nil {
+			fc, err := changeLines(best.From, a.To)
+			if err != nil {
+				return nil, err
+			}
+			bestChange = fc
+		} else if inexact {
+			bestScore = 0
+			for _, d := range deleted {
+				if renamed[d] {
+					continue
+				}
+				fc, err := changeLines(d.From, a.To)
+				if err != nil {
+					return nil, err
+				}
+				score := similarity(d.From, a.To, fc)
+				if score >= 50 && score > bestScore {
+					best, bestChange, bestScore = d, fc, score
+				}
+			}
+		}
+		if best == nil {
+			fc, err := changeLines(nil, a.To)
+			if err != nil {
+				return nil, err
+			}
+			fc.status, fc.to = "A", a.Path
+			result = append(result, fc)
+			continue
+		}
+		renamed[best] = true
+		bestChange.status = fmt.Sprintf("R%03d", bestScore)
+		bestChange.from, bestChange.to = best.Path, a.Path
+		result = append(result, bestChange)
+	}
+	for _, d := range deleted {
+		if renamed[d] {
+			continue
+		}
+		fc, err := changeLines(d.From, nil)
+		if err != nil {
+			return nil, err
+		}
+		fc.status, fc.from = "D", d.Path
+		result = append(result, fc)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].path() < result[j].path() })
+	return result, nil
+}
+
+// defaultRenameLimit is the rename limit when diff.renameLimit is not set,
+// as in git
+const defaultRenameLimit = 1000
+
+// renameLimit is diff.renameLimit. Inexact renames are not looked for when
+// the added files times the deleted files exceed its square, 0 is no limit.
+func renameLimit() int {
+	if v, ok := config.Value("diff.renameLimit"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return defaultRenameLimit
+}
+
+func (f *fileChange) path() string {
+	if f.to != "" {
+		return f.to
+	}
+	return f.from
+}
+
+// changeLines counts the lines added and deleted between the blobs of from
+// and to, either of which may be nil.
+func changeLines(from *gfs.File, to *gfs.File) (*fileChange, error) {
+	fc := &fileChange{}
+	var text [2][]string
+	for i, f := range []*gfs.File{from, to} {
+		if f == nil {
+			continue
+		}
+		obj, err := objects.ReadObject(f.Sha.AsHexBytes())
+		if err != nil {
+			return nil, err
+		}
+		content, err := objects.ReadContent(obj)
+		if err != nil {
+			return nil, err
+		}
+		fc.size[i] = len(content)
+		head := content
+		if len(head) > 8000 {
+			head = head[:8000]
+		}
+		if bytes.IndexByte(head, 0) >= 0 {
+			fc.binary = true
+		}
+		lines := strings.SplitAfter(string(content), "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		text[i] = lines
+	}
+	if fc.binary {
+		return fc, nil
+	}
+	for _, e := range diff.Lines(text[0], text[1]) {
+		switch e.Op {
+		case diff.Equal:
+			fc.same += len(text[0][e.A])
+		case diff.Insert:
+			fc.added++
+		case diff.Delete:
+			fc.deleted++
+		}
+	}
+	return fc, nil
+}
+
+// similarity is the percentage of the larger of from and to which is in
+// both, 100 when the blobs are the same.
+func similarity(from *gfs.File, to *gfs.File, fc *fileChange) int {
+	if from.Sha.Same(to.Sha) {
+		return 100
+	}
+	size := fc.size[0]
+	if fc.size[1] > size {
+		size = fc.size[1]
+	}
+	if fc.binary || size == 0 {
+		return 0
+	}
+	return 100 * fc.same / size
+}
+
+// statWidth is the width of the --stat output
+const statWidth = 80
+
+// diffStat returns the lines of --stat for changes, scaling the graph of
+// each file to fit as git does.
+func diffStat(changes []*fileChange) []string {
+	maxChange, nameWidth, numberWidth := 0, 0, 0
+	names := make([]string, len(changes))
+	binary := false
+	for i, v := range changes {
+		names[i] = v.path()
+		if v.from != "" && v.to != "" && v.from != v.to {
+			names[i] = renameName(v.from, v.to)
+		}
+		if n := len(names[i]); n > nameWidth {
+			nameWidth = n
+		}
+		if v.binary {
+			binary = true
+			continue
+		}
+		if n := v.added + v.deleted; n > maxChange {
+			maxChange = n
+		}
+	}
+	numberWidth = len(strconv.Itoa(maxChange))
+	if binary && numberWidth < 3 {
+		numberWidth = 3
+	}
+	graphWidth := maxChange
+	if nameWidth+numberWidth+6+graphWidth > statWidth {
+		if graphWidth > statWidth*3/8-numberWidth-6 {
+			graphWidth = statWidth*3/8 - numberWidth - 6
+			if graphWidth < 6 {
+				graphWidth = 6
+			}
+		}
+		if nameWidth > statWidth-numberWidth-6-graphWidth {
+			nameWidth = statWidth - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = statWidth - numberWidth - 6 - nameWidth
+		}
+	}
+	var lines []string
+	var insertions, deletions int
+	for i, v := range changes {
+		name := names[i]
+		if len(name) > nameWidth {
+			name = "..." + name[len(name)-nameWidth+3:]
+		}
+		if v.binary {
+			lines = append(lines, fmt.Sprintf(" %-*s | %*s %d -> %d bytes", nameWidth, name, numberWidth, "Bin", v.size[0], v.size[1]))
+			continue
+		}
+		insertions += v.added
+		deletions += v.deleted
+		add, del := v.added, v.deleted
+		if graphWidth <= maxChange {
+			total := scaleLinear(add+del, graphWidth, maxChange)
+			if total < 2 && add > 0 && del > 0 {
+				total = 2
+			}
+			if add < del {
+				add = scaleLinear(add, graphWidth, maxChange)
+				del = total - add
+			} else {
+				del = scaleLinear(del, graphWidth, maxChange)
+				add = total - del
+			}
+		}
+		l := fmt.Sprintf(" %-*s | %*d", nameWidth, name, numberWidth, v.added+v.deleted)
+		if add+del > 0 {
+			l += " " + strings.Repeat("+", add) + strings.Repeat("-", del)
+		}
+		lines = append(lines, l)
+	}
+	summary := fmt.Sprintf(" %d %s changed", len(changes), plural(len(changes), "file", "files"))
+	if insertions > 0 || deletions == 0 {
+		summary += fmt.Sprintf(", %d %s(+)", insertions, plural(insertions, "insertion", "insertions"))
+	}
+	if deletions > 0 || insertions == 0 {
+		summary += fmt.Sprintf(", %d %s(-)", deletions, plural(deletions, "deletion", "deletions"))
+	}
+	return append(lines, summary)
+}
+
+func scaleLinear(it int, width int, maxChange int) int {
+	if it == 0 {
+		return 0
+	}
+	return 1 + it*(width-1)/maxChange
+}
+
+// renameName shows a rename from a to b, with the directories common to
+// both outside of braces, as in dir/{a => b}/file.
+func renameName(a string, b string) string {
+	pfx := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '/' {
+			pfx = i + 1
+		}
+	}
+	sfx := 0
+	for i, j := len(a)-1, len(b)-1; i >= pfx && j >= pfx && a[i] == b[j]; i, j = i-1, j-1 {
+		if a[i] == '/' {
+			sfx = len(a) - i
+		}
+	}
+	if pfx == 0 && sfx == 0 {
+		return a + " => " + b
+	}
+	return a[:pfx] + "{" + a[pfx:len(a)-sfx] + " => " + b[pfx:len(b)-sfx] + "}" + a[len(a)-sfx:]
+}
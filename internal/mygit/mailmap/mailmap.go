@@ -0,0 +1,154 @@
+package mailmap
+
+import (
+	"bufio"
+	"errors"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileName is the name of the mailmap file at the root of the working tree
+const FileName = ".mailmap"
+
+type (
+	// Mailmap maps the names and emails recorded in commits to the canonical
+	// identities of their authors. A nil Mailmap maps nothing.
+	Mailmap struct {
+		entries map[string]*entry
+	}
+	// entry holds the mappings of a commit email, by default and by the
+	// lower case commit name
+	entry struct {
+		identity
+		names map[string]identity
+	}
+	identity struct {
+		name  string
+		email string
+	}
+)
+
+// New returns a Mailmap with the mappings of the .mailmap file of the
+// working tree followed by those of the file configured as mailmap.file.
+func New() (*Mailmap, error) {
+	m := &Mailmap{entries: make(map[string]*entry)}
+	if err := m.read(filepath.Join(config.Path(), FileName)); err != nil {
+		return nil, err
+	}
+	if v, ok := config.Value("mailmap.file"); ok && v != "" {
+		if rest, ok := strings.CutPrefix(v, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				v = filepath.Join(home, rest)
+			}
+		}
+		if err := m.read(v); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Parse returns a Mailmap with the mappings read from r
+func Parse(r io.Reader) (*Mailmap, error) {
+	m := &Mailmap{entries: make(map[string]*entry)}
+	return m, m.parse(r)
+}
+
+func (m *Mailmap) read(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	return m.parse(f)
+}
+
+// parse adds the mappings of lines in one of the forms
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+func (m *Mailmap) parse(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name1, email1, rest, ok := nameAndEmail(line)
+		if !ok {
+			continue
+		}
+		name2, email2, _, ok := nameAndEmail(rest)
+		if ok {
+			m.add(identity{name1, email1}, name2, email2)
+		} else {
+			m.add(identity{name: name1}, "", email1)
+		}
+	}
+	return s.Err()
+}
+
+// nameAndEmail parses an optional name followed by an email in angle
+// brackets at the start of s, returning what follows.
+func nameAndEmail(s string) (string, string, string, bool) {
+	name, rest, ok := strings.Cut(s, "<")
+	if !ok {
+		return "", "", "", false
+	}
+	email, rest, ok := strings.Cut(rest, ">")
+	if !ok {
+		return "", "", "", false
+	}
+	return strings.TrimSpace(name), email, rest, true
+}
+
+// add maps the commit email, and the commit name when not empty, to proper
+func (m *Mailmap) add(proper identity, name string, email string) {
+	key := strings.ToLower(email)
+	e, ok := m.entries[key]
+	if !ok {
+		e = &entry{names: make(map[string]identity)}
+		m.entries[key] = e
+	}
+	if name == "" {
+		if proper.name != "" {
+			e.name = proper.name
+		}
+		if proper.email != "" {
+			e.email = proper.email
+		}
+		return
+	}
+	e.names[strings.ToLower(name)] = proper
+}
+
+// Map returns the canonical name and email of the identity recorded in a
+// commit as name and email. Emails and names are compared ignoring case.
+func (m *Mailmap) Map(name string, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+	e, ok := m.entries[strings.ToLower(email)]
+	if !ok {
+		return name, email
+	}
+	proper, ok := e.names[strings.ToLower(name)]
+	if !ok {
+		proper = e.identity
+	}
+	if proper.name != "" {
+		name = proper.name
+	}
+	if proper.email != "" {
+		email = proper.email
+	}
+	return name, email
+}
@@ -0,0 +1,45 @@
+package mailmap
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_Map(t *testing.T) {
+	m, err := Parse(strings.NewReader(`# comment
+Proper Name <commit@email.xx>
+<proper@email.xx> <other@email.xx>
+Jane Doe <jane@desktop.(none)>
+Joe R. Developer <joe@example.com>
+Joe R. Developer <joe@example.com> Joe <bugs@company.xx>
+Jane Doe <jane@example.com> <JANE@laptop.(none)>
+`))
+	assert.NoError(t, err)
+	tests := []struct {
+		name          string
+		email         string
+		expectedName  string
+		expectedEmail string
+	}{
+		{"A", "commit@email.xx", "Proper Name", "commit@email.xx"},
+		{"A", "Commit@Email.xx", "Proper Name", "Commit@Email.xx"},
+		{"B", "other@email.xx", "B", "proper@email.xx"},
+		{"jane", "jane@desktop.(none)", "Jane Doe", "jane@desktop.(none)"},
+		{"jane", "jane@laptop.(none)", "Jane Doe", "jane@example.com"},
+		{"JOE", "bugs@company.xx", "Joe R. Developer", "joe@example.com"},
+		{"Jim", "bugs@company.xx", "Jim", "bugs@company.xx"},
+		{"Unknown", "u@example.com", "Unknown", "u@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.email, func(t *testing.T) {
+			name, email := m.Map(tt.name, tt.email)
+			assert.Equal(t, tt.expectedName, name)
+			assert.Equal(t, tt.expectedEmail, email)
+		})
+	}
+	var none *Mailmap
+	name, email := none.Map("A", "a@b.c")
+	assert.Equal(t, "A", name)
+	assert.Equal(t, "a@b.c", email)
+}
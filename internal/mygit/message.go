@@ -0,0 +1,188 @@
+package mygit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/message"
+	"io"
+	"os"
+	"strings"
+)
+
+// InterpretTrailersOptions configures InterpretTrailers
+type InterpretTrailersOptions struct {
+	// Trailers are added as key=value or key: value
+	Trailers []string
+	// InPlace writes each file instead of the output
+	InPlace bool
+	// OnlyTrailers writes only the trailers
+	OnlyTrailers bool
+	// OnlyInput does not add Trailers
+	OnlyInput bool
+	// Unfold joins the continuation lines of each trailer
+	Unfold bool
+}
+
+// InterpretTrailers adds trailers to the messages in files, or read from in
+// when there are no files, writing each to o or back to its file.
+func InterpretTrailers(o io.Writer, in io.Reader, opts InterpretTrailersOptions, files ...string) error {
+	var trailers []*message.Trailer
+	if !opts.OnlyInput {
+		for _, v := range opts.Trailers {
+			t, err := message.ParseTrailer(v)
+			if err != nil {
+				return err
+			}
+			trailers = append(trailers, t)
+		}
+	}
+	interpret := func(msg []byte) []byte {
+		cc := commentChar()
+		if len(trailers) > 0 {
+			msg = message.AddTrailers(msg, trailers, cc)
+		}
+		if !opts.OnlyTrailers {
+			return msg
+		}
+		var b bytes.Buffer
+		for _, t := range message.Trailers(msg, cc) {
+			if opts.Unfold {
+				t = t.Unfold()
+			}
+			b.WriteString(t.String() + "\n")
+		}
+		return b.Bytes()
+	}
+	if len(files) == 0 {
+		if opts.InPlace {
+			return errors.New("fatal: no input file given for in-place editing")
+		}
+		msg, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		_, err = o.Write(interpret(msg))
+		return err
+	}
+	for _, file := range files {
+		msg, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("fatal: could not read input file '%s'", file)
+		}
+		if opts.InPlace {
+			err = os.WriteFile(file, interpret(msg), 0644)
+		} else {
+			_, err = o.Write(interpret(msg))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commentChar is the character comment lines of a message start with, as
+// core.commentChar configures.
+func commentChar() byte {
+	if v, ok := config.Value("core.commentChar"); ok && v != "" && v != "auto" {
+		return v[0]
+	}
+	return '#'
+}
+
+// cleanupMode is mode, or the mode commit.cleanup configures when it is the
+// default. The default strips comments only from an edited message.
+func cleanupMode(mode message.CleanupMode, edit bool) (message.CleanupMode, error) {
+	if mode == message.CleanupDefault {
+		if v, ok := config.Value("commit.cleanup"); ok {
+			var err error
+			if mode, err = message.ParseCleanupMode(v); err != nil {
+				return mode, err
+			}
+		}
+	}
+	if mode != message.CleanupDefault {
+		return mode, nil
+	}
+	if edit {
+		return message.CleanupStrip, nil
+	}
+	return message.CleanupWhitespace, nil
+}
+
+// commitTemplate returns the content of the commit.template file, nil when
+// it is not configured.
+func commitTemplate() ([]byte, error) {
+	v, ok := config.Value("commit.template")
+	if !ok || v == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(expandPath(v))
+	if err != nil {
+		return nil, fmt.Errorf("fatal: could not read '%s'", v)
+	}
+	return b, nil
+}
+
+// commitTrailers are the trailers Commit adds to the message, with the
+// Signed-off-by trailer of committer when signing off.
+func commitTrailers(opts CommitOptions, committer string) ([]*message.Trailer, error) {
+	var trailers []*message.Trailer
+	for _, v := range opts.Trailers {
+		t, err := message.ParseTrailer(v)
+		if err != nil {
+			return nil, err
+		}
+		trailers = append(trailers, t)
+	}
+	if opts.Signoff {
+		trailers = append(trailers, &message.Trailer{Key: "Signed-off-by", Value: committer})
+	}
+	return trailers, nil
+}
+
+// editComment is the comment below the message being edited, explaining how
+// it is cleaned up followed by the status of the commit.
+func editComment(mode message.CleanupMode, cc byte) (string, error) {
+	var b strings.Builder
+	b.WriteString("\n")
+	if mode == message.CleanupStrip {
+		b.WriteString(message.Comment(fmt.Sprintf("Please enter the commit message for your changes. Lines starting\nwith '%c' will be ignored, and an empty message aborts the commit.\n", cc), cc))
+	} else {
+		if mode == message.CleanupScissors {
+			b.WriteString(fmt.Sprintf("%c %s\n", cc, message.Scissors))
+			b.WriteString(message.Comment("Do not modify or remove the line above.\nEverything below it will be ignored.\n", cc))
+		}
+		b.WriteString(message.Comment(fmt.Sprintf("Please enter the commit message for your changes. Lines starting\nwith '%c' will be kept; you may remove them yourself if you want to.\nAn empty message aborts the commit.\n", cc), cc))
+	}
+	b.WriteString(message.Comment("\n", cc))
+	status := bytes.NewBuffer(nil)
+	if err := Status(status, StatusOptions{}); err != nil {
+		return "", err
+	}
+	b.WriteString(message.Comment(status.String(), cc))
+	return b.String(), nil
+}
+
+// templateUntouched reports whether msg is the commit template cleaned up
+// with mode, followed by nothing other than sign-offs.
+func templateUntouched(msg []byte, template []byte, mode message.CleanupMode, cc byte) bool {
+	rest, ok := bytes.CutPrefix(msg, message.Cleanup(template, mode, cc))
+	if !ok {
+		rest = msg
+	}
+	return restIsEmpty(rest)
+}
+
+// restIsEmpty reports whether msg has no lines other than blank lines and
+// sign-offs.
+func restIsEmpty(msg []byte) bool {
+	for _, line := range strings.Split(string(msg), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "Signed-off-by:") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package message
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CleanupMode is how a commit message is cleaned up before it is committed
+type CleanupMode int
+
+const (
+	// CleanupDefault is CleanupStrip when the message is edited and
+	// CleanupWhitespace otherwise
+	CleanupDefault CleanupMode = iota
+	// CleanupStrip removes comment lines as well as whitespace
+	CleanupStrip
+	// CleanupWhitespace removes leading and trailing blank lines, trailing
+	// whitespace and repeated blank lines
+	CleanupWhitespace
+	// CleanupVerbatim leaves the message as it is
+	CleanupVerbatim
+	// CleanupScissors removes the Scissors line and everything below it
+	// as well as whitespace
+	CleanupScissors
+)
+
+// Scissors is the line of an edited message below which everything is
+// ignored when cleaning up with CleanupScissors.
+const Scissors = "------------------------ >8 ------------------------"
+
+var cleanupModes = map[string]CleanupMode{
+	"default":    CleanupDefault,
+	"strip":      CleanupStrip,
+	"whitespace": CleanupWhitespace,
+	"verbatim":   CleanupVerbatim,
+	"scissors":   CleanupScissors,
+}
+
+// ParseCleanupMode returns the CleanupMode called name, such as strip.
+func ParseCleanupMode(name string) (CleanupMode, error) {
+	m, ok := cleanupModes[name]
+	if !ok {
+		return CleanupDefault, fmt.Errorf("fatal: Invalid cleanup mode %s", name)
+	}
+	return m, nil
+}
+
+// Cleanup cleans up msg with mode, where comment lines start with
+// commentChar. CleanupDefault cleans up as CleanupStrip.
+func Cleanup(msg []byte, mode CleanupMode, commentChar byte) []byte {
+	switch mode {
+	case CleanupVerbatim:
+		return msg
+	case CleanupScissors:
+		return stripSpace(msg[:scissorsIndex(msg, commentChar)], 0)
+	case CleanupWhitespace:
+		return stripSpace(msg, 0)
+	}
+	return stripSpace(msg, commentChar)
+}
+
+// scissorsIndex is the position of the scissors line in msg, the length of
+// msg when there is none.
+func scissorsIndex(msg []byte, commentChar byte) int {
+	line := string(commentChar) + " " + Scissors + "\n"
+	s := string(msg)
+	if strings.HasPrefix(s, line) {
+		return 0
+	}
+	if i := strings.Index(s, "\n"+line); i >= 0 {
+		return i + 1
+	}
+	return len(msg)
+}
+
+// stripSpace removes trailing whitespace from each line, leading and
+// trailing blank lines and repeated blank lines, as well as lines starting
+// with commentChar unless it is 0. Each line ends with a newline.
+func stripSpace(msg []byte, commentChar byte) []byte {
+	var b strings.Builder
+	blank := 0
+	for _, line := range strings.SplitAfter(string(msg), "\n") {
+		if commentChar != 0 && len(line) > 0 && line[0] == commentChar {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r\n")
+		if line == "" {
+			blank++
+			continue
+		}
+		if blank > 0 && b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		blank = 0
+		b.WriteString(line + "\n")
+	}
+	return []byte(b.String())
+}
+
+// Comment prefixes each line of s with commentChar, as the status is shown
+// in the message being edited.
+func Comment(s string, commentChar byte) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line == "" {
+			continue
+		}
+		b.WriteByte(commentChar)
+		if line != "\n" && line[0] != '\t' {
+			b.WriteByte(' ')
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
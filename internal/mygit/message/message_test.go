@@ -0,0 +1,70 @@
+package message
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_Cleanup(t *testing.T) {
+	msg := "\n\nsubject  \n# comment\n\n\n\nbody\t\n\n# " + Scissors + "\ndiff\n\n"
+	tests := []struct {
+		mode     CleanupMode
+		expected string
+	}{
+		{CleanupDefault, "subject\n\nbody\n\ndiff\n"},
+		{CleanupStrip, "subject\n\nbody\n\ndiff\n"},
+		{CleanupWhitespace, "subject\n# comment\n\nbody\n\n# " + Scissors + "\ndiff\n"},
+		{CleanupVerbatim, msg},
+		{CleanupScissors, "subject\n# comment\n\nbody\n"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, string(Cleanup([]byte(msg), tt.mode, '#')))
+	}
+	assert.Equal(t, "a\n", string(Cleanup([]byte(";x\na"), CleanupStrip, ';')))
+	_, err := ParseCleanupMode("all")
+	assert.EqualError(t, err, "fatal: Invalid cleanup mode all")
+}
+
+func Test_Comment(t *testing.T) {
+	assert.Equal(t, "# On branch main\n#\n#\tnew file:   a\n", Comment("On branch main\n\n\tnew file:   a\n", '#'))
+}
+
+func Test_AddTrailers(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      string
+		trailers []string
+		expected string
+	}{
+		{"empty", "", []string{"a=b"}, "\na: b\n"},
+		{"subject", "subject\n", []string{"a=b"}, "subject\n\na: b\n"},
+		{"subject paragraph", "subj\nFixes: 1\n", []string{"a=b"}, "subj\nFixes: 1\n\na: b\n"},
+		{"block", "subj\n\nFixes : 1\n  continued\nBug: 2\n", []string{"Bug:2", "c = d"}, "subj\n\nFixes : 1\n  continued\nBug: 2\nc: d\n"},
+		{"same key", "subj\n\nFixes: 1\n", []string{"fixes=1"}, "subj\n\nFixes: 1\n"},
+		{"empty value", "subj\n\nbody\n", []string{"empty="}, "subj\n\nbody\n\nempty: \n"},
+		{"equals in message", "subj\n\nKey=1\n", []string{"a=b"}, "subj\n\nKey=1\n\na: b\n"},
+		{"generated", "subj\n\na\nb\nc\nSigned-off-by: A <a@b>\nx: 1\ny: 2\n", []string{"x=y"}, "subj\n\na\nb\nc\nSigned-off-by: A <a@b>\nx: 1\ny: 2\nx: y\n"},
+		{"comments", "subj\n\nbody\n# comment\n", []string{"Acked-by=y", "acked-by=y"}, "subj\n\nbody\n\nAcked-by: y\n# comment\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var trailers []*Trailer
+			for _, s := range tt.trailers {
+				tr, err := ParseTrailer(s)
+				assert.NoError(t, err)
+				trailers = append(trailers, tr)
+			}
+			assert.Equal(t, tt.expected, string(AddTrailers([]byte(tt.msg), trailers, '#')))
+		})
+	}
+}
+
+func Test_Trailers(t *testing.T) {
+	trailers := Trailers([]byte("subj\n\nFixes : 1\n  cont\nBug: 2\n"), '#')
+	assert.Len(t, trailers, 2)
+	assert.Equal(t, "Fixes: 1 cont", trailers[0].Unfold().String())
+	assert.Equal(t, "Bug: 2", trailers[1].String())
+	assert.Nil(t, Trailers([]byte("subj\n\nbody\n"), '#'))
+	_, err := ParseTrailer("=x")
+	assert.EqualError(t, err, "fatal: empty trailer token in trailer '=x'")
+}
@@ -0,0 +1,172 @@
+package message
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Trailer is a Key: Value line in the last paragraph of a message, such as
+// Signed-off-by: Name <email>
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+// gitPrefixes are the trailers git generates, a paragraph with any of which
+// is a trailer block when at least a quarter of its lines are trailers.
+var gitPrefixes = []string{"Signed-off-by: ", "(cherry picked from commit "}
+
+// ParseTrailer parses a trailer given as key=value or key: value.
+func ParseTrailer(s string) (*Trailer, error) {
+	key, value := s, ""
+	if i := strings.IndexAny(s, "=:"); i >= 0 {
+		key, value = s[:i], s[i+1:]
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, fmt.Errorf("fatal: empty trailer token in trailer '%s'", s)
+	}
+	return &Trailer{Key: key, Value: strings.TrimSpace(value)}, nil
+}
+
+// String returns the trailer as a line without its newline
+func (t *Trailer) String() string {
+	if t.Key == "" {
+		return t.Value
+	}
+	return t.Key + ": " + t.Value
+}
+
+// Unfold returns the trailer with its continuation lines joined by a space
+func (t *Trailer) Unfold() *Trailer {
+	return &Trailer{Key: t.Key, Value: strings.Join(strings.Fields(t.Value), " ")}
+}
+
+// Trailers returns the trailers of msg, where comment lines start with
+// commentChar. The value of a trailer with continuation lines includes them.
+func Trailers(msg []byte, commentChar byte) []*Trailer {
+	p := parse(msg, commentChar)
+	if !p.isBlock {
+		return nil
+	}
+	return p.trailers
+}
+
+// AddTrailers adds trailers to the trailer block of msg, starting a new one
+// when msg has none, before any comment lines at its end. A trailer is not
+// added when it is the same as the trailer before it.
+func AddTrailers(msg []byte, trailers []*Trailer, commentChar byte) []byte {
+	p := parse(msg, commentChar)
+	var b strings.Builder
+	b.WriteString(strings.Join(p.lines[:p.end], ""))
+	if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+		b.WriteString("\n")
+	}
+	var last *Trailer
+	if p.isBlock && len(p.trailers) > 0 {
+		last = p.trailers[len(p.trailers)-1].Unfold()
+	}
+	added := false
+	for _, t := range trailers {
+		if last != nil && strings.EqualFold(last.Key, t.Key) && last.Value == t.Value {
+			continue
+		}
+		if !p.isBlock && !added {
+			b.WriteString("\n")
+		}
+		added = true
+		b.WriteString(t.String() + "\n")
+		last = t
+	}
+	b.WriteString(strings.Join(p.lines[p.end:], ""))
+	return []byte(b.String())
+}
+
+// parsed is a message split into lines, where lines from end are blank and
+// comment lines and the paragraph before end is a trailer block if isBlock.
+type parsed struct {
+	lines    []string
+	end      int
+	isBlock  bool
+	trailers []*Trailer
+}
+
+func parse(msg []byte, commentChar byte) *parsed {
+	p := &parsed{lines: strings.SplitAfter(string(msg), "\n")}
+	if p.lines[len(p.lines)-1] == "" {
+		p.lines = p.lines[:len(p.lines)-1]
+	}
+	p.end = len(p.lines)
+	cut := string(commentChar) + " " + Scissors + "\n"
+	for i, line := range p.lines {
+		if line == cut {
+			p.end = i
+			break
+		}
+	}
+	for p.end > 0 && (blank(p.lines[p.end-1]) || p.lines[p.end-1][0] == commentChar) {
+		p.end--
+	}
+	start := p.end
+	for start > 0 && !blank(p.lines[start-1]) {
+		start--
+	}
+	// the first paragraph is the subject rather than trailers
+	if start == 0 {
+		return p
+	}
+	var other int
+	var generated bool
+	for _, line := range p.lines[start:p.end] {
+		line = strings.TrimRight(line, "\n")
+		if line[0] == ' ' || line[0] == '\t' {
+			if n := len(p.trailers); n > 0 {
+				p.trailers[n-1].Value += "\n" + line
+			}
+			continue
+		}
+		for _, prefix := range gitPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				generated = true
+			}
+		}
+		if t, ok := parseLine(line); ok {
+			p.trailers = append(p.trailers, t)
+		} else if strings.HasPrefix(line, gitPrefixes[1]) {
+			p.trailers = append(p.trailers, &Trailer{Value: line})
+		} else {
+			other++
+		}
+	}
+	n := len(p.trailers)
+	p.isBlock = n > 0 && (other == 0 || generated && n*3 >= other)
+	return p
+}
+
+// parseLine parses a trailer line of a message, a token of letters, digits
+// and hyphens followed by a colon.
+func parseLine(line string) (*Trailer, bool) {
+	i := 0
+	for i < len(line) && (isAlnum(line[i]) || line[i] == '-') {
+		i++
+	}
+	if i == 0 {
+		return nil, false
+	}
+	j := i
+	for j < len(line) && (line[j] == ' ' || line[j] == '\t') {
+		j++
+	}
+	if j == len(line) || line[j] != ':' {
+		return nil, false
+	}
+	return &Trailer{Key: line[:i], Value: strings.TrimSpace(line[j+1:])}, true
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func blank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
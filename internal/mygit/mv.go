@@ -0,0 +1,162 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/gfs"
+	"github.com/richardjennings/mygit/internal/mygit/index"
+	"github.com/richardjennings/mygit/internal/mygit/pathspec"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MvOptions configures Mv
+type MvOptions struct {
+	// Force overwrites an existing destination file
+	Force bool
+	// DryRun only reports what would be moved
+	DryRun bool
+	// SkipErrors skips sources which cannot be moved
+	SkipErrors bool
+	// Verbose reports the files as they are moved
+	Verbose bool
+}
+
+type move struct {
+	src   string
+	dst   string
+	files []*gfs.File
+}
+
+// Mv moves or renames files and directories in the working directory and the
+// index. With multiple sources destination must be an existing directory.
+func Mv(o io.Writer, opts MvOptions, sources []string, destination string) error {
+	idx, err := index.ReadIndex()
+	if err != nil {
+		return err
+	}
+	dst, err := repositoryPath(destination)
+	if err != nil {
+		return err
+	}
+	dstInfo, err := os.Lstat(filepath.Join(config.Path(), dst))
+	dstIsDir := err == nil && dstInfo.IsDir()
+	if len(sources) > 1 && !dstIsDir {
+		return fmt.Errorf("fatal: destination '%s' is not a directory", destination)
+	}
+	var moves []*move
+	for _, v := range sources {
+		m, err := planMove(idx, v, dst, dstIsDir, opts.Force)
+		if err != nil {
+			if opts.SkipErrors {
+				continue
+			}
+			return err
+		}
+		moves = append(moves, m)
+	}
+	for _, m := range moves {
+		if opts.DryRun || opts.Verbose {
+			if _, err := fmt.Fprintf(o, "Renaming %s to %s\n", m.src, m.dst); err != nil {
+				return err
+			}
+		}
+		if opts.DryRun {
+			continue
+		}
+		if err := os.Rename(filepath.Join(config.Path(), m.src), filepath.Join(config.Path(), m.dst)); err != nil {
+			return err
+		}
+		if existing := idx.File(m.dst); existing != nil {
+			// forced overwrite of a tracked file
+			if err := idx.Rm(m.dst); err != nil {
+				return err
+			}
+		}
+		for _, f := range m.files {
+			if err := idx.Rm(f.Path); err != nil {
+				return err
+			}
+			// the stat information is unchanged by the rename
+			moved := &gfs.File{
+				Path:     m.dst + strings.TrimPrefix(f.Path, m.src),
+				Sha:      f.Sha,
+				Finfo:    f.Finfo,
+				WdStatus: gfs.WDUntracked,
+			}
+			if err := idx.Add(moved); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.DryRun {
+		return nil
+	}
+	return idx.Write()
+}
+
+// planMove validates moving source to dst, returning the index files to be
+// moved.
+func planMove(idx *index.Index, source string, dst string, dstIsDir bool, force bool) (*move, error) {
+	src, err := repositoryPath(source)
+	if err != nil {
+		return nil, err
+	}
+	if dstIsDir {
+		dst = path.Join(dst, path.Base(src))
+	}
+	m := &move{src: src, dst: dst}
+	info, err := os.Lstat(filepath.Join(config.Path(), src))
+	if err != nil {
+		return nil, fmt.Errorf("fatal: bad source, source=%s, destination=%s", src, dst)
+	}
+	if _, err := os.Stat(filepath.Dir(filepath.Join(config.Path(), dst))); err != nil {
+		return nil, fmt.Errorf("fatal: destination directory does not exist, source=%s, destination=%s", src, dst)
+	}
+	_, dstErr := os.Lstat(filepath.Join(config.Path(), dst))
+	dstExists := !errors.Is(dstErr, os.ErrNotExist)
+	if info.IsDir() {
+		if dst == src || strings.HasPrefix(dst, src+"/") {
+			return nil, fmt.Errorf("fatal: can not move directory into itself, source=%s, destination=%s", src, dst)
+		}
+		for _, f := range idx.Files() {
+			if strings.HasPrefix(f.Path, src+"/") {
+				m.files = append(m.files, f)
+			}
+		}
+		if len(m.files) == 0 {
+			return nil, fmt.Errorf("fatal: source directory is empty, source=%s, destination=%s", src, dst)
+		}
+		if dstExists {
+			return nil, fmt.Errorf("fatal: destination already exists, source=%s, destination=%s", src, dst)
+		}
+		return m, nil
+	}
+	f := idx.File(src)
+	if f == nil {
+		return nil, fmt.Errorf("fatal: not under version control, source=%s, destination=%s", src, dst)
+	}
+	m.files = []*gfs.File{f}
+	if dstExists && !force {
+		return nil, fmt.Errorf("fatal: destination exists, source=%s, destination=%s", src, dst)
+	}
+	return m, nil
+}
+
+// repositoryPath returns a path given relative to the current directory
+// relative to the repository root.
+func repositoryPath(p string) (string, error) {
+	prefix := pathspec.Prefix()
+	r := path.Clean(path.Join(prefix, filepath.ToSlash(p)))
+	if r == ".." || strings.HasPrefix(r, "../") {
+		return "", fmt.Errorf("fatal: '%s' is outside repository", p)
+	}
+	if r == "." {
+		return "", nil
+	}
+	return r, nil
+}
@@ -1,12 +1,18 @@
 package mygit
 
 import (
+	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/date"
 	"github.com/richardjennings/mygit/internal/mygit/gfs"
+	"github.com/richardjennings/mygit/internal/mygit/hooks"
 	"github.com/richardjennings/mygit/internal/mygit/index"
+	"github.com/richardjennings/mygit/internal/mygit/message"
 	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/pathspec"
 	"github.com/richardjennings/mygit/internal/mygit/refs"
 	"io"
 	"log"
@@ -14,15 +20,45 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"time"
 )
 
+// InitOptions configures Init
+type InitOptions struct {
+	// ObjectFormat is the hash algorithm objects are named with, sha1 or
+	// sha256
+	ObjectFormat string
+}
+
 // Init initializes a git repository
-func Init() error {
+func Init(opts InitOptions) error {
+	format, err := config.LookupObjectFormat(opts.ObjectFormat)
+	if err != nil {
+		return fmt.Errorf("fatal: %w", err)
+	}
 	path := config.GitPath()
+	if _, err := os.Stat(config.GitHeadPath()); err == nil && opts.ObjectFormat != "" && format != config.Hash() {
+		return errors.New("fatal: attempt to reinitialize repository with different hash")
+	}
 	if err := os.MkdirAll(path, 0755); err != nil {
 		return err
 	}
+	if format != config.SHA1 {
+		cnf, err := config.RepositoryConfig()
+		if err != nil {
+			return err
+		}
+		// version 1 repositories must understand their extensions
+		if err := cnf.Set("core.repositoryformatversion", "1"); err != nil {
+			return err
+		}
+		if err := cnf.Set("extensions.objectformat", format.Name); err != nil {
+			return err
+		}
+		if err := cnf.Write(config.GitConfigPath()); err != nil {
+			return err
+		}
+		config.Config.ObjectFormat = format
+	}
 	for _, v := range []string{
 		config.ObjectPath(),
 		config.RefsDirectory(),
@@ -36,28 +72,19 @@ func Init() error {
 	return os.WriteFile(config.GitHeadPath(), []byte(fmt.Sprintf("ref: %s\n", config.Config.DefaultBranch)), 0644)
 }
 
-// Log prints out the commit log for the current branch
-func Log(o io.Writer) error {
-	branch, err := refs.CurrentBranch()
-	if err != nil {
-		return err
-	}
-	commitSha, err := refs.HeadSHA(branch)
+// Add adds files matching the pathspec patterns to the Index.
+func Add(paths ...string) error {
+	ps, err := pathspec.New(pathspec.Prefix(), paths...)
 	if err != nil {
 		return err
 	}
-	for c, err := objects.ReadCommit(commitSha); c != nil && err == nil; c, err = objects.ReadCommit(c.Parents[0]) {
-		_, _ = fmt.Fprintf(o, "commit %s\nAuthor: %s <%s>\nDate:   %s\n\n%8s\n", c.Sha, c.Author, c.AuthorEmail, c.AuthoredTime.String(), c.Message)
-		if len(c.Parents) == 0 {
-			break
-		}
-	}
-
-	return nil
+	return stageFiles(ps, true)
 }
 
-// Add adds one or more file paths to the Index.
-func Add(paths ...string) error {
+// stageFiles updates the index with the modified and deleted files of the
+// working directory matching ps, and with untracked files when untracked is
+// true.
+func stageFiles(ps *pathspec.Pathspec, untracked bool) error {
 	idx, err := index.ReadIndex()
 	if err != nil {
 		return err
@@ -68,55 +95,42 @@ func Add(paths ...string) error {
 		return err
 	}
 	var updates []*gfs.File
-	for _, p := range paths {
-		if p == "." {
-			// special case meaning add everything
-			for _, v := range wdFiles.Files() {
-				switch v.WdStatus {
-				case gfs.WDUntracked, gfs.WDWorktreeChangedSinceIndex, gfs.WDDeletedInWorktree:
-					updates = append(updates, v)
-				}
-			}
-		} else {
-			found := false
-			for _, v := range wdFiles.Files() {
-				if v.Path == p {
-					switch v.WdStatus {
-					case gfs.WDUntracked, gfs.WDWorktreeChangedSinceIndex, gfs.WDDeletedInWorktree:
-						updates = append(updates, v)
-					}
-					found = true
-					break
-				}
-			}
-			if !found {
-				// try directory @todo more efficient implementation
-				for _, v := range wdFiles.Files() {
-					if strings.HasPrefix(v.Path, p+string(filepath.Separator)) {
-						switch v.WdStatus {
-						case gfs.WDUntracked, gfs.WDWorktreeChangedSinceIndex, gfs.WDDeletedInWorktree:
-							updates = append(updates, v)
-						}
-						found = true
-					}
-				}
-			}
-
-			if !found {
-				return fmt.Errorf("fatal: pathspec '%s' did not match any files (directories not implemented yet)", p)
+	var known []string
+	for _, v := range wdFiles.Files() {
+		known = append(known, v.Path)
+		if !ps.Match(v.Path) {
+			continue
+		}
+		switch v.WdStatus {
+		case gfs.WDUntracked:
+			if untracked {
+				updates = append(updates, v)
 			}
+		case gfs.WDWorktreeChangedSinceIndex, gfs.WDDeletedInWorktree:
+			updates = append(updates, v)
 		}
 	}
+	if unmatched := ps.Unmatched(known); len(unmatched) > 0 {
+		return fmt.Errorf("fatal: pathspec '%s' did not match any files", unmatched[0])
+	}
+	// add new and modified files to the object store
+	var blobFiles []*gfs.File
+	var blobPaths []string
 	for _, v := range updates {
 		switch v.WdStatus {
 		case gfs.WDUntracked, gfs.WDWorktreeChangedSinceIndex:
-			// add the file to the object store
-			obj, err := objects.WriteBlob(v.Path)
-			if err != nil {
-				return err
-			}
-			v.Sha, _ = gfs.NewSha(obj.Sha)
+			blobFiles = append(blobFiles, v)
+			blobPaths = append(blobPaths, v.Path)
 		}
+	}
+	blobs, err := objects.WriteBlobs(blobPaths)
+	if err != nil {
+		return err
+	}
+	for i, v := range blobFiles {
+		v.Sha, _ = gfs.NewSha(blobs[i].Sha)
+	}
+	for _, v := range updates {
 		if err := idx.Add(v); err != nil {
 			return err
 		}
@@ -125,21 +139,82 @@ func Add(paths ...string) error {
 	return idx.Write()
 }
 
-// LsFiles returns a list of files in the index
-func LsFiles() ([]string, error) {
+// LsFiles returns a list of files in the index matching the pathspec
+// patterns
+func LsFiles(paths ...string) ([]string, error) {
+	ps, err := pathspec.New(pathspec.Prefix(), paths...)
+	if err != nil {
+		return nil, err
+	}
 	idx, err := index.ReadIndex()
 	if err != nil {
 		return nil, err
 	}
 	var files []string
 	for _, v := range idx.Files() {
-		files = append(files, v.Path)
+		if ps.Match(v.Path) {
+			files = append(files, v.Path)
+		}
 	}
 	return files, nil
 }
 
-// Commit writes a git commit object from the files in the index
-func Commit(message []byte) ([]byte, error) {
+// CommitOptions configures Commit
+type CommitOptions struct {
+	// Sign signs the commit, which commit.gpgSign also enables
+	Sign bool
+	// NoSign does not sign the commit when commit.gpgSign is set
+	NoSign bool
+	// SigningKey is the key to sign with instead of user.signingKey
+	SigningKey string
+	// NoVerify bypasses the pre-commit and commit-msg hooks
+	NoVerify bool
+	// All stages modified and deleted tracked files before committing
+	All bool
+	// Amend replaces the HEAD commit, keeping its parents and author
+	Amend bool
+	// NoEdit uses the message of the amended commit without the editor
+	NoEdit bool
+	// AllowEmpty allows a commit with the same tree as its parent
+	AllowEmpty bool
+	// Author is the author as Name <email> instead of the configured one
+	Author string
+	// Date is the author date instead of the current time
+	Date string
+	// Cleanup is how the message is cleaned up, as commit.cleanup
+	// configures by default
+	Cleanup message.CleanupMode
+	// Trailers are added to the message as key=value or key: value
+	Trailers []string
+	// Signoff adds a Signed-off-by trailer of the committer
+	Signoff bool
+}
+
+// Commit writes a git commit object from the files in the index. The
+// message is written in the editor when it is nil, starting from the
+// commit.template file when configured.
+func Commit(msg []byte, opts CommitOptions) ([]byte, error) {
+	if opts.All {
+		ps, err := pathspec.New(pathspec.Prefix())
+		if err != nil {
+			return nil, err
+		}
+		if err := stageFiles(ps, false); err != nil {
+			return nil, err
+		}
+	}
+	edit := msg == nil && !opts.NoEdit
+	mode, err := cleanupMode(opts.Cleanup, edit)
+	if err != nil {
+		return nil, err
+	}
+	env := commitHookEnv(edit)
+	// the index is read after pre-commit, which may change it
+	if !opts.NoVerify {
+		if err := runHook("pre-commit", nil, env); err != nil {
+			return nil, err
+		}
+	}
 	idx, err := index.ReadIndex()
 	if err != nil {
 		return nil, err
@@ -149,81 +224,191 @@ func Commit(message []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	// git has the --allow-empty flag which here defaults to true currently
-	// @todo check for changes to be committed.
-	previousCommits, err := refs.PreviousCommits()
+	commit, err := newCommit(opts)
 	if err != nil {
-		// @todo error types to check for e.g no previous commits as source of error
 		return nil, err
 	}
-	commit := &objects.Commit{
-		Tree:          tree,
-		Parents:       previousCommits,
-		Author:        fmt.Sprintf("%s <%s>", config.AuthorName(), config.AuthorEmail()),
-		AuthoredTime:  time.Now(),
-		Committer:     fmt.Sprintf("%s <%s>", config.CommitterName(), config.CommitterEmail()),
-		CommittedTime: time.Now(),
-	}
-	if message != nil {
-		commit.Message = message
-	} else {
-		// empty commit file
-		if err := os.WriteFile(config.EditorFile(), []byte{}, 0600); err != nil {
-			log.Fatalln(err)
+	commit.Tree = []byte(hex.EncodeToString(tree))
+	if !opts.AllowEmpty {
+		if err := checkChanges(commit, idx, opts.Amend); err != nil {
+			return nil, err
 		}
-		ed, args := config.Editor()
-		args = append(args, config.EditorFile())
-		cmd := exec.Command(ed, args...)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		err = cmd.Run()
-		if err != nil {
-			log.Fatalln(err)
+	}
+	source := []string{"message"}
+	var template []byte
+	if msg == nil && opts.Amend {
+		// the message of the amended commit is edited
+		msg, source = commit.Message, []string{"commit", "HEAD"}
+	} else if msg == nil {
+		source = nil
+		if edit {
+			if template, err = commitTemplate(); err != nil {
+				return nil, err
+			}
 		}
-		msg, err := os.ReadFile(args[0])
-		if err != nil {
-			log.Fatalln(msg)
+		if template != nil {
+			msg, source = template, []string{"template"}
 		}
-		commit.Message = msg
 	}
-	if len(commit.Message) == 0 {
+	trailers, err := commitTrailers(opts, fmt.Sprintf("%s <%s>", commit.Committer, commit.CommitterEmail))
+	if err != nil {
+		return nil, err
+	}
+	cc := commentChar()
+	if len(trailers) > 0 {
+		msg = message.AddTrailers(msg, trailers, cc)
+	}
+	if commit.Message, err = commitMessage(msg, edit, source, mode, opts, env); err != nil {
+		return nil, err
+	}
+	if len(commit.Message) == 0 || mode != message.CleanupVerbatim && restIsEmpty(commit.Message) {
 		return nil, errors.New("Aborting commit due to empty commit message.")
 	}
-	return objects.WriteCommit(commit)
-}
-
-// Status currently displays the file statuses comparing the working directory
-// to the index and the index to the last commit (if any).
-func Status(o io.Writer) error {
-	var err error
-	// index
-	idx, err := index.ReadIndex()
-	if err != nil {
-		return err
+	if template != nil && templateUntouched(commit.Message, template, mode, cc) {
+		return nil, errors.New("Aborting commit; you did not edit the message.")
 	}
-
-	commitSha, err := refs.LastCommit()
+	// as git does, the message ends with a newline
+	if !bytes.HasSuffix(commit.Message, []byte("\n")) {
+		commit.Message = append(commit.Message, '\n')
+	}
+	if signCommits(opts) {
+		if commit.Sig, err = signPayload(commit.Payload(), opts.SigningKey); err != nil {
+			return nil, err
+		}
+	}
+	sha, err := objects.WriteCommit(commit)
 	if err != nil {
-		// @todo error types to check for e.g no previous commits as source of error
-		return err
+		return nil, err
 	}
+	// the commit is made whatever the result of post-commit
+	_ = runHook("post-commit", nil, env)
+	return sha, nil
+}
 
-	files, err := index.Status(idx, commitSha)
-
+// newCommit returns a commit without a tree following HEAD, or replacing it
+// with its parents, author and message when amending.
+func newCommit(opts CommitOptions) (*objects.Commit, error) {
+	committed, err := config.CommitterDate()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	commit := &objects.Commit{
+		Author:         config.AuthorName(),
+		AuthorEmail:    config.AuthorEmail(),
+		Committer:      config.CommitterName(),
+		CommitterEmail: config.CommitterEmail(),
+		CommittedTime:  committed,
+	}
+	if opts.Amend {
+		head, err := refs.LastCommit()
+		if err != nil {
+			return nil, err
+		}
+		if head == nil {
+			return nil, errors.New("fatal: You have nothing to amend.")
+		}
+		c, err := objects.ReadCommit(head)
+		if err != nil {
+			return nil, err
+		}
+		commit.Parents = c.Parents
+		commit.Author, commit.AuthorEmail, commit.AuthoredTime = c.Author, c.AuthorEmail, c.AuthoredTime
+		commit.Message = c.Message
+	} else {
+		if commit.Parents, err = refs.PreviousCommits(); err != nil {
+			return nil, err
+		}
+		if commit.AuthoredTime, err = config.AuthorDate(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Author != "" {
+		name, email, ok := strings.Cut(strings.TrimSuffix(opts.Author, ">"), "<")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("fatal: --author '%s' is not 'Name <email>'", opts.Author)
+		}
+		commit.Author, commit.AuthorEmail = strings.TrimSpace(name), email
+	}
+	if opts.Date != "" {
+		if commit.AuthoredTime, err = date.Parse(opts.Date); err != nil {
+			return nil, err
+		}
+	}
+	return commit, nil
+}
 
-	for _, v := range files.Files() {
-		if v.IdxStatus == gfs.IndexNotUpdated && v.WdStatus == gfs.WDIndexAndWorkingTreeMatch {
-			continue
+// checkChanges returns an error when the tree of commit is the tree of its
+// first parent, or empty for a commit without parents. An amended merge may
+// have no changes.
+func checkChanges(commit *objects.Commit, idx *index.Index, amend bool) error {
+	if amend && len(commit.Parents) > 1 {
+		return nil
+	}
+	if len(commit.Parents) == 0 {
+		if len(idx.Files()) > 0 {
+			return nil
 		}
-		if _, err := fmt.Fprintf(o, "%s%s %s\n", v.IdxStatus, v.WdStatus, v.Path); err != nil {
+	} else {
+		parent, err := objects.ReadCommit(commit.Parents[0])
+		if err != nil {
 			return err
 		}
+		if string(parent.Tree) != string(commit.Tree) {
+			return nil
+		}
+	}
+	if amend {
+		return errors.New("You asked to amend the most recent commit, but doing so would make\nit empty. You can repeat your command with --allow-empty.")
+	}
+	// the long status shows why there is nothing to commit
+	buf := bytes.NewBuffer(nil)
+	if err := Status(buf, StatusOptions{}); err != nil {
+		return err
 	}
+	return errors.New(strings.TrimSuffix(buf.String(), "\n"))
+}
 
-	return nil
+// commitMessage returns msg, edited in the editor below a comment of the
+// status when edit is true, as left by the prepare-commit-msg and commit-msg
+// hooks and cleaned up with mode. The source of the message is given to
+// prepare-commit-msg.
+func commitMessage(msg []byte, edit bool, source []string, mode message.CleanupMode, opts CommitOptions, env hooks.Opt) ([]byte, error) {
+	file := config.EditorFile()
+	if len(msg) > 0 && !bytes.HasSuffix(msg, []byte("\n")) {
+		msg = append(msg, '\n')
+	}
+	if edit {
+		comment, err := editComment(mode, commentChar())
+		if err != nil {
+			return nil, err
+		}
+		msg = append(msg, comment...)
+	}
+	if err := os.WriteFile(file, msg, 0600); err != nil {
+		return nil, err
+	}
+	if err := runHook("prepare-commit-msg", append([]string{file}, source...), env); err != nil {
+		return nil, err
+	}
+	if edit {
+		ed, args := config.Editor()
+		cmd := exec.Command(ed, append(args, file)...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		if err := cmd.Run(); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.NoVerify {
+		if err := runHook("commit-msg", []string{file}, env); err != nil {
+			return nil, err
+		}
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return message.Cleanup(b, mode, commentChar()), nil
 }
 
 const DeleteBranchCheckedOutErrFmt = "error: Cannot delete branch '%s' checked out at '%s'"
@@ -269,13 +454,6 @@ func ListBranches(o io.Writer) error {
 }
 
 func SwitchBranch(name string) error {
-
-	// index
-	idx, err := index.ReadIndex()
-	if err != nil {
-		return err
-	}
-
 	// get commit sha
 	commitSha, err := refs.HeadSHA(name)
 	if err != nil {
@@ -286,6 +464,34 @@ func SwitchBranch(name string) error {
 		return fmt.Errorf("fatal: invalid reference: %s", name)
 	}
 
+	previous, err := refs.LastCommit()
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		previous = []byte(config.Hash().ZeroHex())
+	}
+
+	if err := checkout(commitSha); err != nil {
+		return err
+	}
+
+	// update HEAD
+	if err := refs.UpdateHead(name); err != nil {
+		return err
+	}
+	return runHook("post-checkout", []string{string(previous), string(commitSha), "1"})
+}
+
+// checkout updates the working directory and index to the files of the commit
+// commitSha, refusing to overwrite untracked or staged changes.
+func checkout(commitSha []byte) error {
+	// index
+	idx, err := index.ReadIndex()
+	if err != nil {
+		return err
+	}
+
 	currentCommit, err := refs.LastCommit()
 	if err != nil {
 		// @todo error types to check for e.g no previous commits as source of error
@@ -319,7 +525,7 @@ func SwitchBranch(name string) error {
 				errorWdFiles = append(errorWdFiles, v)
 				continue
 			}
-		} else {
+		} else if v.WdStatus != gfs.WDUntracked {
 			// should be deleted
 			deleteFiles = append(deleteFiles, v)
 		}
@@ -348,7 +554,7 @@ func SwitchBranch(name string) error {
 	}
 
 	for _, v := range deleteFiles {
-		if err := os.Remove(filepath.Join(config.Path(), v.Path)); err != nil {
+		if err := removeFile(v.Path); err != nil {
 			return err
 		}
 	}
@@ -356,30 +562,13 @@ func SwitchBranch(name string) error {
 	idx = index.NewIndex()
 
 	for _, v := range commitFiles {
-		obj, err := objects.ReadObject(v.Sha.AsHexBytes())
-		if err != nil {
-			return err
-		}
-		r, err := obj.ReadCloser()
-		if err != nil {
-			return err
-		}
-		buf := make([]byte, obj.HeaderLength)
-		if _, err := r.Read(buf); err != nil {
-			return err
-		}
-		f, err := os.OpenFile(filepath.Join(config.Path(), v.Path), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0655)
-		if err != nil {
-			return err
-		}
-
-		if _, err := io.Copy(f, r); err != nil {
-			return err
-		}
-		if err := r.Close(); err != nil {
-			return err
-		}
-		if err := f.Close(); err != nil {
+		if v.Mode == gitlinkMode {
+			// submodules are not checked out but stay in the index
+			if err := os.MkdirAll(filepath.Join(config.Path(), v.Path), 0755); err != nil {
+				return err
+			}
+			v.Finfo = &gfs.Finfo{MMode: gitlinkMode}
+		} else if err := checkoutFile(v); err != nil {
 			return err
 		}
 		v.WdStatus = gfs.WDUntracked
@@ -388,20 +577,16 @@ func SwitchBranch(name string) error {
 		}
 	}
 
-	if err := idx.Write(); err != nil {
-		return err
-	}
+	return idx.Write()
+}
 
-	// update HEAD
-	if err := refs.UpdateHead(name); err != nil {
+// Restore restores files matching the pathspec patterns in the working
+// directory from the index or, when staged, in the index from the last commit.
+func Restore(staged bool, paths ...string) error {
+	ps, err := pathspec.New(pathspec.Prefix(), paths...)
+	if err != nil {
 		return err
 	}
-
-	return nil
-
-}
-
-func Restore(path string, staged bool) error {
 	idx, err := index.ReadIndex()
 	if err != nil {
 		return err
@@ -410,57 +595,135 @@ func Restore(path string, staged bool) error {
 	if err != nil {
 		return err
 	}
+	if staged {
+		return restoreStaged(idx, currentCommit, ps)
+	}
 	currentStatus, err := index.Status(idx, currentCommit)
 	if err != nil {
 		return err
 	}
-	if staged {
-		// remove file from index
-		if err := idx.Rm(path); err != nil {
+	var known []string
+	var restore []*gfs.File
+	for _, v := range idx.Files() {
+		known = append(known, v.Path)
+		if !ps.Match(v.Path) {
+			continue
+		}
+		fileStatus, ok := currentStatus.Contains(v.Path)
+		if !ok {
+			continue
+		}
+		switch fileStatus.WdStatus {
+		case gfs.WDWorktreeChangedSinceIndex, gfs.WDDeletedInWorktree:
+			restore = append(restore, v)
+		}
+	}
+	if unmatched := ps.Unmatched(known); len(unmatched) > 0 {
+		return fmt.Errorf("error: pathspec '%s' did not match any file(s) known to git", unmatched[0])
+	}
+	// update working directory files with objects referenced by index
+	for _, v := range restore {
+		if err := checkoutFile(v); err != nil {
+			return err
+		}
+		mtime := v.Finfo.ModTime()
+		if err := os.Chtimes(filepath.Join(config.Path(), v.Path), mtime, mtime); err != nil {
 			return err
 		}
-		return idx.Write()
 	}
+	return nil
+}
 
-	fileStatus, ok := currentStatus.Contains(path)
-	// if the path not found or is untracked working directory fileStatus then error
-	if !ok || fileStatus.WdStatus == gfs.WDUntracked {
-		return fmt.Errorf("error: pathspec '%s' did not match any fileStatus(s) known to git", path)
+// restoreStaged resets index entries matching ps to their state in the commit
+// commitSha, removing entries which are not in the commit.
+func restoreStaged(idx *index.Index, commitSha []byte, ps *pathspec.Pathspec) error {
+	var commitFiles []*gfs.File
+	var err error
+	if commitSha != nil {
+		if commitFiles, err = objects.CommittedFiles(commitSha); err != nil {
+			return err
+		}
 	}
-	// if in index but not committed
-	if fileStatus.IdxStatus == gfs.IndexAddedInIndex && fileStatus.WdStatus != gfs.WDWorktreeChangedSinceIndex {
-		// there is nothing to do, right ? ...
-		return nil
+	commitSet := gfs.NewFileSet(commitFiles)
+	idxSet := gfs.NewFileSet(idx.Files())
+	var known []string
+	for _, v := range idxSet.Files() {
+		known = append(known, v.Path)
+		if !ps.Match(v.Path) {
+			continue
+		}
+		if _, ok := commitSet.Contains(v.Path); !ok {
+			// added in index, remove it
+			if err := idx.Rm(v.Path); err != nil {
+				return err
+			}
+		}
 	}
-
-	// update working directory fileStatus with object referenced by index
-	file := idx.File(path)
-	if file == nil {
-		// this should not happen
-		return errors.New("index did not return file for some reason")
+	for _, v := range commitFiles {
+		known = append(known, v.Path)
+		if !ps.Match(v.Path) {
+			continue
+		}
+		if f, ok := idxSet.Contains(v.Path); ok && f.Sha.Same(v.Sha) {
+			continue
+		}
+		// reset the index entry to the committed version, keeping the
+		// working directory stat information if its content matches
+		f := &gfs.File{Path: v.Path, Sha: v.Sha, WdStatus: gfs.WDUntracked, Finfo: &gfs.Finfo{MMode: v.Mode}}
+		if sha, err := objects.HashBlob(v.Path); err == nil && bytes.Equal(sha, v.Sha.AsBytes()) {
+			f.Finfo = nil
+		}
+		if err := idx.Add(f); err != nil {
+			return err
+		}
 	}
-	obj, err := objects.ReadObject(file.Sha.AsHexBytes())
-	if err != nil {
-		return err
+	if unmatched := ps.Unmatched(known); len(unmatched) > 0 {
+		return fmt.Errorf("error: pathspec '%s' did not match any file(s) known to git", unmatched[0])
 	}
-	fh, err := os.OpenFile(filepath.Join(config.Path(), path), os.O_TRUNC|os.O_RDWR, 0600)
+	return idx.Write()
+}
+
+const (
+	executableMode = 0100755
+	symlinkMode    = 0120000
+	gitlinkMode    = 0160000
+)
+
+// checkoutFile writes the blob referenced by f to the working directory.
+func checkoutFile(f *gfs.File) error {
+	obj, err := objects.OpenObject(f.Sha.AsHexBytes())
 	if err != nil {
 		return err
 	}
-	defer func() { _ = fh.Close() }()
-	reader, err := obj.ReadCloser()
-	if err != nil {
+	defer func() { _ = obj.Close() }()
+	path := filepath.Join(config.Path(), f.Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
-	if err := objects.ReadHeadBytes(reader, obj); err != nil {
-		return err
+	if f.Mode == symlinkMode {
+		target, err := io.ReadAll(obj)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return os.Symlink(string(target), path)
+	}
+	perm := os.FileMode(0644)
+	if f.Mode == executableMode {
+		perm = 0755
 	}
-	_, err = io.Copy(fh, reader)
+	fh, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
 	if err != nil {
 		return err
 	}
-	if err := fh.Close(); err != nil {
+	defer func() { _ = fh.Close() }()
+	if _, err := io.Copy(fh, obj); err != nil {
+		return err
+	}
+	if err := fh.Chmod(perm); err != nil {
 		return err
 	}
-	return os.Chtimes(filepath.Join(config.Path(), path), file.Finfo.ModTime(), file.Finfo.ModTime())
+	return fh.Close()
 }
@@ -1,16 +1,34 @@
 package mygit
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/ed25519"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
 	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/date"
 	"github.com/richardjennings/mygit/internal/mygit/gfs"
+	"github.com/richardjennings/mygit/internal/mygit/index"
+	"github.com/richardjennings/mygit/internal/mygit/message"
 	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/pktline"
+	"github.com/richardjennings/mygit/internal/mygit/pretty"
 	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"github.com/richardjennings/mygit/internal/mygit/server"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -20,7 +38,7 @@ func Test_Init(t *testing.T) {
 	dir := testDir(t)
 	defer func() { _ = os.RemoveAll(dir) }()
 	testConfigure(t, dir)
-	if err := Init(); err != nil {
+	if err := Init(InitOptions{}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -33,7 +51,7 @@ func Test_DefaultBranch(t *testing.T) {
 	dir := testDir(t)
 	defer func() { _ = os.RemoveAll(dir) }()
 	testConfigure(t, dir)
-	if err := Init(); err != nil {
+	if err := Init(InitOptions{}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -49,7 +67,7 @@ func Test_End_To_End(t *testing.T) {
 	testConfigure(t, dir)
 
 	// git init
-	if err := Init(); err != nil {
+	if err := Init(InitOptions{}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -223,25 +241,25 @@ func testAdd(t *testing.T, path string, numIdxFiles int) {
 
 func testStatus(t *testing.T, expected string) {
 	buf := bytes.NewBuffer(nil)
-	if err := Status(buf); err != nil {
+	if err := Status(buf, StatusOptions{Format: StatusFormatPorcelainV1}); err != nil {
 		t.Fatal(err)
 	}
 	assert.Equal(t, expected, buf.String())
 }
 
 func testRestore(t *testing.T, path string, staged bool) {
-	if err := Restore(path, staged); err != nil {
+	if err := Restore(staged, path); err != nil {
 		t.Fatal(err)
 	}
 }
 
 func testCommit(t *testing.T, message []byte) []byte {
-	sha, err := Commit(message)
+	sha, err := Commit(message, CommitOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(sha) != 20 {
-		t.Errorf("expected sha len 20 got %d", len(sha))
+	if len(sha) != config.Hash().Size {
+		t.Errorf("expected sha len %d got %d", config.Hash().Size, len(sha))
 	}
 	commitSha, err := gfs.NewSha(sha)
 	if err != nil {
@@ -268,7 +286,7 @@ func testCommit(t *testing.T, message []byte) []byte {
 
 func testLog(t *testing.T) []byte {
 	buf := bytes.NewBuffer(nil)
-	err := Log(buf)
+	err := Log(buf, LogOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -299,3 +317,1640 @@ func writeFile(t *testing.T, dir string, path string, content []byte) {
 		t.Fatal(err)
 	}
 }
+
+func Test_Status_Formats(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "a", []byte("a"))
+	testStatusFormat(t, StatusOptions{Format: StatusFormatPorcelainV2, Branch: true}, "# branch.oid (initial)\n# branch.head main\n? a\n")
+	testAdd(t, "a", 1)
+	first := testCommit(t, []byte("first"))
+	writeFile(t, dir, "b", []byte("b"))
+	testAdd(t, "b", 2)
+	testCommit(t, []byte("second"))
+
+	// track a remote branch pointing at the first commit
+	c, err := config.RepositoryConfig()
+	assert.NoError(t, err)
+	assert.NoError(t, c.Set("branch.main.remote", "origin"))
+	assert.NoError(t, c.Set("branch.main.merge", "refs/heads/main"))
+	assert.NoError(t, c.Write(config.GitConfigPath()))
+	assert.NoError(t, os.MkdirAll(filepath.Join(config.GitPath(), "refs", "remotes", "origin"), 0755))
+	writeFile(t, config.GitPath(), "refs/remotes/origin/main", []byte(fmt.Sprintf("%x\n", first)))
+
+	// rename a to c
+	assert.NoError(t, os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "c")))
+	testAdd(t, ".", 2)
+	writeFile(t, dir, "b", []byte("bb"))
+
+	testStatusFormat(t, StatusOptions{Format: StatusFormatShort, Branch: true}, "## main...origin/main [ahead 1]\n M b\nR  a -> c\n")
+	testStatusFormat(t, StatusOptions{Format: StatusFormatPorcelainV1, NullTerminated: true}, " M b\x00R  c\x00a\x00")
+
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Status(buf, StatusOptions{Format: StatusFormatPorcelainV2, Branch: true}))
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, "# branch.upstream origin/main", lines[2])
+	assert.Equal(t, "# branch.ab +1 -0", lines[3])
+	assert.True(t, strings.HasPrefix(lines[4], "1 .M N... 100644 100644 100644 "))
+	assert.True(t, strings.HasPrefix(lines[5], "2 R. N... 100644 100644 100644 "))
+	assert.True(t, strings.HasSuffix(lines[5], " R100 c\ta"))
+
+	buf.Reset()
+	assert.NoError(t, Status(buf, StatusOptions{}))
+	assert.Contains(t, buf.String(), "Your branch is ahead of 'origin/main' by 1 commit.\n")
+	assert.Contains(t, buf.String(), "Changes to be committed:\n")
+	assert.Contains(t, buf.String(), "\trenamed:    a -> c\n")
+	assert.Contains(t, buf.String(), "Changes not staged for commit:\n")
+	assert.Contains(t, buf.String(), "\tmodified:   b\n")
+}
+
+func testStatusFormat(t *testing.T, opts StatusOptions, expected string) {
+	buf := bytes.NewBuffer(nil)
+	if err := Status(buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, buf.String())
+}
+
+func Test_Status_Untracked_Files(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"tracked", "new/deep", "node_modules/pkg"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, d), 0755))
+	}
+	writeFile(t, dir, ".gitignore", []byte("node_modules/\n"))
+	writeFile(t, dir, "tracked/a", []byte("a"))
+	testAdd(t, "tracked/a", 1)
+	writeFile(t, dir, "tracked/b", []byte("b"))
+	writeFile(t, dir, "new/c", []byte("c"))
+	writeFile(t, dir, "new/deep/d", []byte("d"))
+	writeFile(t, dir, "node_modules/pkg/index.js", []byte("e"))
+
+	testStatusFormat(t, StatusOptions{Format: StatusFormatPorcelainV1}, "A  tracked/a\n?? .gitignore\n?? new/\n?? tracked/b\n")
+	testStatusFormat(t, StatusOptions{Format: StatusFormatPorcelainV1, UntrackedFiles: UntrackedFilesAll}, "A  tracked/a\n?? .gitignore\n?? new/c\n?? new/deep/d\n?? tracked/b\n")
+	testStatusFormat(t, StatusOptions{Format: StatusFormatPorcelainV1, UntrackedFiles: UntrackedFilesNo}, "A  tracked/a\n")
+}
+
+func Test_Pathspec(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	writeFile(t, dir, "src/a.go", []byte("a"))
+	writeFile(t, dir, "src/b.go", []byte("b"))
+	writeFile(t, dir, "src/README.md", []byte("c"))
+	writeFile(t, dir, "main.go", []byte("d"))
+
+	assert.Error(t, Add("nomatch"))
+	testAdd(t, "src/*.go", 2)
+	testAdd(t, ":(glob)*.go", 3)
+	testAdd(t, ".", 4)
+	files, err := LsFiles("src", ":!*.md")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"src/a.go", "src/b.go"}, files)
+	testCommit(t, []byte("first"))
+
+	writeFile(t, dir, "main.go", []byte("dd"))
+	testAdd(t, "main.go", 4)
+	testCommit(t, []byte("second"))
+
+	// log limited to a path only shows commits changing it
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Log(buf, LogOptions{}, "src"))
+	assert.Equal(t, 1, strings.Count(buf.String(), "commit "))
+	buf.Reset()
+	assert.NoError(t, Log(buf, LogOptions{}, "main.go"))
+	assert.Equal(t, 2, strings.Count(buf.String(), "commit "))
+
+	// restore --staged resets a modified file to the committed version
+	writeFile(t, dir, "src/a.go", []byte("aa"))
+	writeFile(t, dir, "src/b.go", []byte("bb"))
+	testAdd(t, "src", 4)
+	testStatusFormat(t, StatusOptions{Format: StatusFormatPorcelainV1, Pathspec: []string{"src/a.go"}}, "M  src/a.go\n")
+	assert.NoError(t, Restore(true, "src/*.go"))
+	testStatus(t, " M src/a.go\n M src/b.go\n")
+	assert.NoError(t, Restore(false, "src"))
+	testStatus(t, "")
+}
+
+func Test_Rm_Mv(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "d", "e"), 0755))
+	writeFile(t, dir, "a", []byte("a"))
+	writeFile(t, dir, "b", []byte("b"))
+	writeFile(t, dir, "d/c", []byte("c"))
+	writeFile(t, dir, "d/e/f", []byte("f"))
+	testAdd(t, ".", 4)
+	testCommit(t, []byte("first"))
+
+	// rm refuses to lose local modifications without -f
+	writeFile(t, dir, "a", []byte("aa"))
+	err := Rm(io.Discard, RmOptions{}, "a")
+	assert.EqualError(t, err, "error: the following file has local modifications:\n    a\n(use --cached to keep the file, or -f to force removal)")
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Rm(buf, RmOptions{Cached: true}, "a"))
+	assert.Equal(t, "rm 'a'\n", buf.String())
+	testStatus(t, "D  a\n?? a\n")
+	assert.NoError(t, Rm(io.Discard, RmOptions{Force: true}, "b"))
+	_, err = os.Stat(filepath.Join(dir, "b"))
+	assert.True(t, os.IsNotExist(err))
+	testStatus(t, "D  a\nD  b\n?? a\n")
+
+	// directories require -r
+	assert.EqualError(t, Rm(io.Discard, RmOptions{}, "d/e"), "fatal: not removing 'd/e' recursively without -r")
+	assert.NoError(t, Rm(io.Discard, RmOptions{Recursive: true}, "d/e"))
+	_, err = os.Stat(filepath.Join(dir, "d", "e"))
+	assert.True(t, os.IsNotExist(err))
+	assert.EqualError(t, Rm(io.Discard, RmOptions{}, "nomatch"), "fatal: pathspec 'nomatch' did not match any files")
+
+	// mv a file and a directory
+	assert.NoError(t, Add("a"))
+	testCommit(t, []byte("second"))
+	assert.EqualError(t, Mv(io.Discard, MvOptions{}, []string{"untracked"}, "x"), "fatal: bad source, source=untracked, destination=x")
+	assert.NoError(t, Mv(io.Discard, MvOptions{}, []string{"a"}, "z"))
+	testStatus(t, "R  a -> z\n")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "n"), 0755))
+	assert.NoError(t, Mv(io.Discard, MvOptions{}, []string{"d", "z"}, "n"))
+	testStatus(t, "R  d/c -> n/d/c\nR  a -> n/z\n")
+	files, err := LsFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"n/d/c", "n/z"}, files)
+}
+
+func Test_Clean(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"src", "tmp/deep", "build", "mixed"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, d), 0755))
+	}
+	writeFile(t, dir, ".gitignore", []byte("build/\n*.log\n"))
+	writeFile(t, dir, "src/main.go", []byte("main"))
+	testAdd(t, ".", 2)
+	writeFile(t, dir, "src/new.go", []byte("new"))
+	writeFile(t, dir, "tmp/deep/x", []byte("x"))
+	writeFile(t, dir, "build/out", []byte("out"))
+	writeFile(t, dir, "debug.log", []byte("log"))
+	writeFile(t, dir, "mixed/a", []byte("a"))
+	writeFile(t, dir, "mixed/b.log", []byte("b"))
+	writeFile(t, dir, "keep.txt", []byte("keep"))
+
+	testClean := func(opts CleanOptions, expected string) {
+		buf := bytes.NewBuffer(nil)
+		assert.NoError(t, Clean(buf, opts))
+		assert.Equal(t, expected, buf.String())
+	}
+	assert.Error(t, Clean(io.Discard, CleanOptions{}))
+	testClean(CleanOptions{DryRun: true}, "Would remove keep.txt\nWould remove src/new.go\n")
+	testClean(CleanOptions{DryRun: true, Directories: true}, "Would remove keep.txt\nWould remove mixed/a\nWould remove src/new.go\nWould remove tmp/\n")
+	testClean(CleanOptions{DryRun: true, Directories: true, NoIgnoreRules: true, Excludes: []string{"keep.txt"}}, "Would remove build/\nWould remove debug.log\nWould remove mixed/\nWould remove src/new.go\nWould remove tmp/\n")
+	testClean(CleanOptions{DryRun: true, IgnoredOnly: true}, "Would remove debug.log\n")
+	testClean(CleanOptions{DryRun: true, IgnoredOnly: true, Directories: true}, "Would remove build/\nWould remove debug.log\nWould remove mixed/b.log\n")
+	testClean(CleanOptions{DryRun: true, Directories: true, Pathspec: []string{"tmp"}}, "Would remove tmp/\n")
+
+	testClean(CleanOptions{Force: 1, Directories: true, Excludes: []string{"keep.txt"}}, "Removing mixed/a\nRemoving src/new.go\nRemoving tmp/\n")
+	testStatus(t, "A  .gitignore\nA  src/main.go\n?? keep.txt\n")
+	for _, v := range []string{"build/out", "debug.log", "mixed/b.log", "keep.txt"} {
+		_, err := os.Stat(filepath.Join(dir, v))
+		assert.NoError(t, err)
+	}
+}
+
+func Test_Clone(t *testing.T) {
+	src := testDir(t)
+	defer func() { _ = os.RemoveAll(src) }()
+	testConfigure(t, src)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "d", "e"), 0755))
+	writeFile(t, src, "a", []byte("a"))
+	writeFile(t, src, "d/e/b", []byte("b"))
+	assert.NoError(t, os.Chmod(filepath.Join(src, "a"), 0755))
+	testAdd(t, ".", 2)
+	commit := testCommit(t, []byte("initial"))
+	assert.NoError(t, CreateBranch("topic"))
+
+	parent := testDir(t)
+	defer func() { _ = os.RemoveAll(parent) }()
+	dst := filepath.Join(parent, "clone")
+	testConfigure(t, dst)
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Clone(buf, src, CloneOptions{}))
+	assert.Equal(t, "Cloning into 'clone'...\n", buf.String())
+
+	b, err := os.ReadFile(filepath.Join(dst, "d", "e", "b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "b", string(b))
+	fi, err := os.Stat(filepath.Join(dst, "a"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+	testStatus(t, "")
+
+	branch, err := refs.CurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "main", branch)
+	for _, v := range []string{"refs/heads/main", "refs/remotes/origin/main", "refs/remotes/origin/topic", "refs/remotes/origin/HEAD"} {
+		sha, err := refs.ReadRef(v)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%x", commit), string(sha), v)
+	}
+	upstream, err := refs.Upstream("main")
+	assert.NoError(t, err)
+	assert.Equal(t, "refs/remotes/origin/main", upstream)
+	url, _ := config.Value("remote.origin.url")
+	assert.Equal(t, src, url)
+
+	// the tree written from the clone index matches the source
+	second, err := Commit([]byte("second"), CommitOptions{AllowEmpty: true})
+	assert.NoError(t, err)
+	c, err := objects.ReadCommit([]byte(fmt.Sprintf("%x", second)))
+	assert.NoError(t, err)
+	sc, err := objects.ReadCommit([]byte(fmt.Sprintf("%x", commit)))
+	assert.NoError(t, err)
+	assert.Equal(t, string(sc.Tree), string(c.Tree))
+
+	assert.Error(t, Clone(io.Discard, src, CloneOptions{}))
+	testConfigure(t, filepath.Join(parent, "other"))
+	assert.NoError(t, Clone(io.Discard, src, CloneOptions{Branch: "topic", NoHardlinks: true, Quiet: true}))
+	branch, err = refs.CurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "topic", branch)
+	assert.Error(t, Clone(io.Discard, filepath.Join(parent, "missing"), CloneOptions{}))
+}
+
+func Test_Clone_Gitlink(t *testing.T) {
+	parent := testDir(t)
+	defer func() { _ = os.RemoveAll(parent) }()
+	src := filepath.Join(parent, "src")
+	testConfigure(t, src)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	sub := strings.Repeat("ab", 20)
+	in := "blob\nmark :1\ndata 2\na\n\ncommit refs/heads/main\nmark :2\ncommitter T <t@t> 1700000000 +0000\ndata 5\ninit\nM 100644 :1 a\nM 160000 " + sub + " sub\n\n"
+	assert.NoError(t, FastImport(strings.NewReader(in), io.Discard, FastImportOptions{}))
+	head, err := refs.ReadRef("refs/heads/main")
+	assert.NoError(t, err)
+
+	dst := filepath.Join(parent, "dst")
+	testConfigure(t, dst)
+	assert.NoError(t, Clone(io.Discard, src, CloneOptions{Quiet: true}))
+	// the submodule is an empty directory and stays in the index
+	entries, err := os.ReadDir(filepath.Join(dst, "sub"))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	idx, err := index.ReadIndex()
+	assert.NoError(t, err)
+	files := gfs.NewFileSet(idx.Files())
+	f, ok := files.Contains("sub")
+	assert.True(t, ok)
+	if ok {
+		assert.Equal(t, sub, f.Sha.AsHexString())
+		assert.Equal(t, uint32(0160000), f.Finfo.(*gfs.Finfo).MMode)
+	}
+	testStatus(t, "")
+
+	// committing keeps the submodule
+	second, err := Commit([]byte("second"), CommitOptions{AllowEmpty: true})
+	assert.NoError(t, err)
+	c, err := objects.ReadCommit([]byte(fmt.Sprintf("%x", second)))
+	assert.NoError(t, err)
+	hc, err := objects.ReadCommit(head)
+	assert.NoError(t, err)
+	assert.Equal(t, string(hc.Tree), string(c.Tree))
+}
+
+func Test_Fetch_Push(t *testing.T) {
+	parent := testDir(t)
+	defer func() { _ = os.RemoveAll(parent) }()
+	upstream := filepath.Join(parent, "upstream")
+	one := filepath.Join(parent, "one")
+	two := filepath.Join(parent, "two")
+
+	testConfigure(t, upstream)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, upstream, "a", []byte("a"))
+	testAdd(t, ".", 1)
+	first := testCommit(t, []byte("first"))
+
+	testConfigure(t, one)
+	assert.NoError(t, Clone(io.Discard, upstream, CloneOptions{Quiet: true}))
+	testConfigure(t, two)
+	assert.NoError(t, Clone(io.Discard, upstream, CloneOptions{Quiet: true}))
+
+	// pushing to the checked out branch of a non-bare repository is refused
+	writeFile(t, two, "b", []byte("b"))
+	testAdd(t, ".", 2)
+	second := testCommit(t, []byte("second"))
+	buf := bytes.NewBuffer(nil)
+	assert.Error(t, Push(buf, DefaultRemote, []string{"main"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("To %s\n ! [remote rejected] main -> main (branch is currently checked out)\n", upstream), buf.String())
+
+	buf.Reset()
+	assert.NoError(t, Push(buf, DefaultRemote, []string{"main:topic"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("To %s\n * [new branch]      main -> topic\n", upstream), buf.String())
+	sha, err := refs.ReadRef("refs/remotes/origin/topic")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", second), string(sha))
+
+	testConfigure(t, one)
+	buf.Reset()
+	assert.NoError(t, Fetch(buf, DefaultRemote, nil, FetchOptions{}))
+	assert.Equal(t, fmt.Sprintf("From %s\n * [new branch]      topic -> origin/topic\n", upstream), buf.String())
+	c, err := objects.ReadCommit([]byte(fmt.Sprintf("%x", second)))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", first), string(c.Parents[0]))
+	buf.Reset()
+	assert.NoError(t, Fetch(buf, DefaultRemote, nil, FetchOptions{}))
+	assert.Equal(t, "", buf.String())
+
+	// a diverged push is rejected unless forced
+	writeFile(t, one, "c", []byte("c"))
+	testAdd(t, ".", 2)
+	third := testCommit(t, []byte("third"))
+	buf.Reset()
+	assert.Error(t, Push(buf, DefaultRemote, []string{"main:topic"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("To %s\n ! [rejected]        main -> topic (non-fast-forward)\n", upstream), buf.String())
+	buf.Reset()
+	assert.NoError(t, Push(buf, DefaultRemote, []string{"+main:topic"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("To %s\n + %s...%s main -> topic (forced update)\n", upstream, fmt.Sprintf("%x", second)[:7], fmt.Sprintf("%x", third)[:7]), buf.String())
+
+	// the configured refspec forces updates of remote-tracking refs
+	testConfigure(t, two)
+	buf.Reset()
+	assert.Error(t, Fetch(buf, DefaultRemote, []string{"topic:refs/remotes/origin/topic"}, FetchOptions{}))
+	assert.Equal(t, fmt.Sprintf("From %s\n ! [rejected]        topic -> origin/topic (non-fast-forward)\n", upstream), buf.String())
+	buf.Reset()
+	assert.NoError(t, Fetch(buf, DefaultRemote, nil, FetchOptions{}))
+	assert.Equal(t, fmt.Sprintf("From %s\n + %s...%s topic -> origin/topic (forced update)\n", upstream, fmt.Sprintf("%x", second)[:7], fmt.Sprintf("%x", third)[:7]), buf.String())
+
+	buf.Reset()
+	assert.NoError(t, Push(buf, DefaultRemote, []string{":topic"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("To %s\n - [deleted]         topic\n", upstream), buf.String())
+	sha, err = refs.ReadRef("refs/remotes/origin/topic")
+	assert.NoError(t, err)
+	assert.Nil(t, sha)
+	assert.Error(t, Push(io.Discard, DefaultRemote, []string{":topic"}, PushOptions{}))
+	assert.Error(t, Fetch(io.Discard, filepath.Join(parent, "missing"), nil, FetchOptions{}))
+}
+
+func Test_Fetch_Push_HTTP(t *testing.T) {
+	parent := testDir(t)
+	defer func() { _ = os.RemoveAll(parent) }()
+	upstream := filepath.Join(parent, "upstream")
+	local := filepath.Join(parent, "local")
+
+	testConfigure(t, upstream)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, upstream, "a", []byte("a"))
+	testAdd(t, ".", 1)
+	testCommit(t, []byte("first"))
+	testConfigure(t, local)
+	assert.NoError(t, Clone(io.Discard, upstream, CloneOptions{Quiet: true}))
+	testConfigure(t, upstream)
+	writeFile(t, upstream, "b", []byte("b"))
+	testAdd(t, ".", 2)
+	second := testCommit(t, []byte("second"))
+	assert.NoError(t, CreateBranch("topic"))
+
+	srv := httptest.NewServer(&testSmartHTTP{gitDir: filepath.Join(upstream, ".git")})
+	defer srv.Close()
+	url := srv.URL + "/upstream.git"
+
+	testConfigure(t, local)
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Fetch(buf, url, []string{"+refs/heads/*:refs/remotes/http/*"}, FetchOptions{}))
+	assert.Equal(t, fmt.Sprintf("remote: sending 3 objects\nFrom %s\n * [new branch]      main  -> http/main\n * [new branch]      topic -> http/topic\n", url), buf.String())
+	c, err := objects.ReadCommit([]byte(fmt.Sprintf("%x", second)))
+	assert.NoError(t, err)
+	assert.Equal(t, "second\n", string(c.Message))
+
+	writeFile(t, local, "c", []byte("c"))
+	testAdd(t, "c", 2)
+	third := testCommit(t, []byte("third"))
+	buf.Reset()
+	assert.NoError(t, Push(buf, url, []string{"main:feature"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("To %s\n * [new branch]      main -> feature\n", url), buf.String())
+	buf.Reset()
+	assert.Error(t, Push(buf, url, []string{"main:topic"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("To %s\n ! [rejected]        main -> topic (non-fast-forward)\n", url), buf.String())
+	sha, err := refs.ReadRefIn(filepath.Join(upstream, ".git"), "refs/heads/feature")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", third), string(sha))
+	assert.True(t, objects.ExistsIn(filepath.Join(upstream, ".git", "objects"), sha))
+
+	buf.Reset()
+	assert.NoError(t, Push(buf, url, []string{":feature"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("To %s\n - [deleted]         feature\n", url), buf.String())
+	assert.Error(t, Fetch(io.Discard, srv.URL+"/missing.git", nil, FetchOptions{}))
+}
+
+func Test_Fetch_Push_HTTP_Server(t *testing.T) {
+	parent := testDir(t)
+	defer func() { _ = os.RemoveAll(parent) }()
+	upstream := filepath.Join(parent, "upstream")
+	local := filepath.Join(parent, "local")
+
+	testConfigure(t, upstream)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, upstream, "a", []byte("a"))
+	testAdd(t, ".", 1)
+	testCommit(t, []byte("first"))
+	testConfigure(t, local)
+	assert.NoError(t, Clone(io.Discard, upstream, CloneOptions{Quiet: true}))
+	testConfigure(t, upstream)
+	writeFile(t, upstream, "b", []byte("b"))
+	testAdd(t, ".", 2)
+	second := testCommit(t, []byte("second"))
+	assert.NoError(t, CreateBranch("topic"))
+
+	srv := httptest.NewServer(&server.Handler{Root: parent, ReceivePack: true})
+	defer srv.Close()
+	url := srv.URL + "/upstream"
+
+	testConfigure(t, local)
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Fetch(buf, url, []string{"+refs/heads/*:refs/remotes/http/*"}, FetchOptions{}))
+	assert.Equal(t, fmt.Sprintf("remote: Enumerating objects: 3, done.\nFrom %s\n * [new branch]      main  -> http/main\n * [new branch]      topic -> http/topic\n", url), buf.String())
+	c, err := objects.ReadCommit([]byte(fmt.Sprintf("%x", second)))
+	assert.NoError(t, err)
+	assert.Equal(t, "second\n", string(c.Message))
+
+	writeFile(t, local, "c", []byte("c"))
+	testAdd(t, "c", 2)
+	third := testCommit(t, []byte("third"))
+	buf.Reset()
+	assert.NoError(t, Push(buf, url, []string{"main:feature"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("To %s\n * [new branch]      main -> feature\n", url), buf.String())
+	buf.Reset()
+	assert.Error(t, Push(buf, url, []string{"main:topic"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("To %s\n ! [rejected]        main -> topic (non-fast-forward)\n", url), buf.String())
+	sha, err := refs.ReadRefIn(filepath.Join(upstream, ".git"), "refs/heads/feature")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", third), string(sha))
+	assert.True(t, objects.ExistsIn(filepath.Join(upstream, ".git", "objects"), sha))
+
+	buf.Reset()
+	assert.NoError(t, Push(buf, url, []string{":feature"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("To %s\n - [deleted]         feature\n", url), buf.String())
+	assert.Error(t, Fetch(io.Discard, srv.URL+"/missing", nil, FetchOptions{}))
+
+	// hooks of the served repository run for each push
+	hooksDir := filepath.Join(upstream, ".git", "hooks")
+	assert.NoError(t, os.MkdirAll(hooksDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(hooksDir, "update"), []byte("#!/bin/sh\necho \"checking $1\"\n[ \"$1\" != refs/heads/blocked ]\n"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(hooksDir, "post-receive"), []byte("#!/bin/sh\ncat > ../received\n"), 0755))
+	buf.Reset()
+	assert.Error(t, Push(buf, url, []string{"main:blocked", "main:allowed"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("remote: checking refs/heads/blocked\nremote: checking refs/heads/allowed\nTo %s\n ! [remote rejected] main -> blocked (hook declined)\n * [new branch]      main -> allowed\n", url), buf.String())
+	received, err := os.ReadFile(filepath.Join(upstream, "received"))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s %x refs/heads/allowed\n", strings.Repeat("0", 40), third), string(received))
+}
+
+// testSmartHTTP is a minimal stand-in for a smart HTTP git server serving one
+// repository, speaking protocol v2 for upload-pack and report-status for
+// receive-pack.
+type testSmartHTTP struct {
+	gitDir string
+}
+
+func (s *testSmartHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/upstream.git/") {
+		http.NotFound(w, r)
+		return
+	}
+	service := strings.TrimPrefix(r.URL.Path, "/upstream.git/")
+	if service == "info/refs" {
+		service = r.URL.Query().Get("service")
+		w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
+		pw := pktline.NewWriter(w)
+		if service == "git-upload-pack" {
+			_ = pw.WriteString("version 2\n")
+			_ = pw.WriteString("ls-refs\n")
+			_ = pw.WriteString("fetch\n")
+			_ = pw.Flush()
+			return
+		}
+		_ = pw.WriteString("# service=git-receive-pack\n")
+		_ = pw.Flush()
+		all, _ := refs.ListRefsIn(s.gitDir)
+		caps := "\x00report-status side-band-64k"
+		for k, v := range all {
+			_ = pw.WriteString("%s %s%s\n", v, k, caps)
+			caps = ""
+		}
+		_ = pw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", service))
+	body := bufio.NewReader(r.Body)
+	pr := pktline.NewReader(body)
+	pw := pktline.NewWriter(w)
+	objectsDir := filepath.Join(s.gitDir, "objects")
+	if service == "git-receive-pack" {
+		var commands [][]string
+		for {
+			l, t, _ := pr.ReadLine()
+			if t != pktline.Data {
+				break
+			}
+			l, _, _ = strings.Cut(l, "\x00")
+			commands = append(commands, strings.Fields(l))
+		}
+		if _, err := body.Peek(1); err == nil {
+			_, _ = objects.UnpackObjects(body, objectsDir)
+		}
+		status := bytes.NewBuffer(nil)
+		sw := pktline.NewWriter(status)
+		_ = sw.WriteString("unpack ok\n")
+		for _, c := range commands {
+			if c[1] == strings.Repeat("0", 40) {
+				_ = refs.DeleteRefIn(s.gitDir, c[2])
+			} else {
+				raw, _ := hex.DecodeString(c[1])
+				_ = refs.UpdateRefIn(s.gitDir, c[2], raw)
+			}
+			_ = sw.WriteString("ok %s\n", c[2])
+		}
+		_ = sw.Flush()
+		_ = pw.WriteBand(pktline.BandData, status.Bytes())
+		_ = pw.Flush()
+		return
+	}
+	var command string
+	var wants, haves [][]byte
+	done := false
+	for {
+		l, t, _ := pr.ReadLine()
+		if t == pktline.Flush {
+			break
+		}
+		switch {
+		case strings.HasPrefix(l, "command="):
+			command = strings.TrimPrefix(l, "command=")
+		case strings.HasPrefix(l, "want "):
+			wants = append(wants, []byte(strings.TrimPrefix(l, "want ")))
+		case strings.HasPrefix(l, "have "):
+			haves = append(haves, []byte(strings.TrimPrefix(l, "have ")))
+		case l == "done":
+			done = true
+		}
+	}
+	if command == "ls-refs" {
+		all, _ := refs.ListRefsIn(s.gitDir)
+		head, _ := refs.SymbolicRefIn(s.gitDir, "HEAD")
+		_ = pw.WriteString("%s HEAD symref-target:%s\n", all[head], head)
+		var names []string
+		for k := range all {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			_ = pw.WriteString("%s %s\n", all[k], k)
+		}
+		_ = pw.Flush()
+		return
+	}
+	var common [][]byte
+	for _, v := range haves {
+		if objects.ExistsIn(objectsDir, v) {
+			common = append(common, v)
+		}
+	}
+	if !done {
+		_ = pw.WriteString("acknowledgments\n")
+		if len(common) == 0 {
+			_ = pw.WriteString("NAK\n")
+			_ = pw.Flush()
+			return
+		}
+		for _, v := range common {
+			_ = pw.WriteString("ACK %s\n", v)
+		}
+		_ = pw.WriteString("ready\n")
+		_ = pw.Delim()
+	}
+	theirs, _ := objects.Reachable(objectsDir, common, func([]byte) bool { return false })
+	known := make(map[string]bool)
+	for _, v := range theirs {
+		known[string(v)] = true
+	}
+	shas, _ := objects.Reachable(objectsDir, wants, func(sha []byte) bool { return known[string(sha)] })
+	pack := bytes.NewBuffer(nil)
+	_ = objects.WritePack(pack, objectsDir, shas)
+	_ = pw.WriteString("packfile\n")
+	_ = pw.WriteBand(pktline.BandProgress, []byte(fmt.Sprintf("sending %d objects\n", len(shas))))
+	_ = pw.WriteBand(pktline.BandData, pack.Bytes())
+	_ = pw.Flush()
+}
+
+func Test_Serve_Stdio(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, filepath.Join(dir, "repo"))
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "repo"), "a", []byte("a"))
+	testAdd(t, ".", 1)
+	sha := testCommit(t, []byte("first"))
+	testConfigure(t, dir)
+
+	// a client which only reads the advertisement closes stdin
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Serve(bytes.NewBufferString("0000"), buf, io.Discard, ServeOptions{Command: "git-upload-pack '/repo'"}))
+	r := pktline.NewReader(buf)
+	l, _, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x HEAD\x00side-band-64k ofs-delta thin-pack no-progress include-tag symref=HEAD:refs/heads/main agent=mygit", sha), l)
+	l, _, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x refs/heads/main", sha), l)
+
+	assert.Error(t, Serve(nil, io.Discard, io.Discard, ServeOptions{Command: "git-upload-pack 'missing'"}))
+	assert.Error(t, Serve(nil, io.Discard, io.Discard, ServeOptions{Command: "sh -c true"}))
+}
+
+func Test_Bundle(t *testing.T) {
+	parent := testDir(t)
+	defer func() { _ = os.RemoveAll(parent) }()
+	src := filepath.Join(parent, "src")
+	testConfigure(t, src)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, src, "a", []byte("a"))
+	testAdd(t, ".", 1)
+	first := fmt.Sprintf("%x", testCommit(t, []byte("first")))
+	writeFile(t, src, "b", []byte("b"))
+	testAdd(t, ".", 2)
+	second := fmt.Sprintf("%x", testCommit(t, []byte("second")))
+
+	// revisions which are not refs and empty ranges give no refs to bundle
+	full := filepath.Join(parent, "full.bundle")
+	assert.EqualError(t, BundleCreate(full, []string{"main~1"}, BundleCreateOptions{}), "fatal: Refusing to create empty bundle.")
+	assert.Error(t, BundleCreate(full, []string{"main..main"}, BundleCreateOptions{}))
+	assert.NoFileExists(t, full)
+
+	assert.NoError(t, CreateBranch("old"))
+	assert.NoError(t, BundleCreate(full, []string{"--all"}, BundleCreateOptions{}))
+	incremental := filepath.Join(parent, "incremental.bundle")
+	assert.NoError(t, BundleCreate(incremental, []string{first + "..main"}, BundleCreateOptions{Version: 3}))
+
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, BundleVerify(buf, incremental, false))
+	assert.Equal(t, fmt.Sprintf("The bundle contains this ref:\n%s refs/heads/main\nThe bundle requires this ref:\n%s first\nThe bundle uses this hash algorithm: sha1\n%s is okay\n", second, first, incremental), buf.String())
+	buf.Reset()
+	assert.NoError(t, BundleListHeads(buf, full, []string{"old"}))
+	assert.Equal(t, fmt.Sprintf("%s refs/heads/old\n", second), buf.String())
+
+	// clone from the full bundle
+	dst := filepath.Join(parent, "dst")
+	testConfigure(t, dst)
+	assert.NoError(t, Clone(io.Discard, full, CloneOptions{Quiet: true}))
+	testStatus(t, "")
+	sha, err := refs.ReadRef("refs/remotes/origin/old")
+	assert.NoError(t, err)
+	assert.Equal(t, second, string(sha))
+
+	// the incremental bundle needs the first commit
+	empty := filepath.Join(parent, "empty")
+	testConfigure(t, empty)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fmt.Sprintf("error: Repository lacks these prerequisite commits:\nerror: %s first", first), BundleVerify(io.Discard, incremental, true).Error())
+	assert.Error(t, BundleUnbundle(io.Discard, incremental))
+
+	// fetching the full bundle provides the prerequisite
+	assert.NoError(t, Fetch(io.Discard, full, []string{"old:refs/heads/old"}, FetchOptions{}))
+	buf.Reset()
+	assert.NoError(t, BundleUnbundle(buf, incremental))
+	assert.Equal(t, fmt.Sprintf("%s refs/heads/main\n", second), buf.String())
+}
+
+func Test_Fast_Export_Import(t *testing.T) {
+	parent := testDir(t)
+	defer func() { _ = os.RemoveAll(parent) }()
+	src := filepath.Join(parent, "src")
+	testConfigure(t, src)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, src, "a", []byte("a"))
+	writeFile(t, src, "sp ace", []byte("b"))
+	testAdd(t, ".", 2)
+	testCommit(t, []byte("first"))
+	assert.NoError(t, CreateBranch("topic"))
+	assert.NoError(t, os.Remove(filepath.Join(src, "a")))
+	writeFile(t, src, "c", []byte("c"))
+	testAdd(t, ".", 2)
+	testCommit(t, []byte("second"))
+	marks := filepath.Join(parent, "marks")
+	stream := bytes.NewBuffer(nil)
+	assert.NoError(t, FastExport(stream, []string{"--all"}, FastExportOptions{ExportMarks: marks}))
+	assert.Contains(t, stream.String(), "M 100644 :2 \"sp ace\"\n")
+	assert.Contains(t, stream.String(), "from :3\nD a\n")
+	assert.Contains(t, stream.String(), "reset refs/heads/topic\nfrom :3\n")
+	expected, err := refs.ListRefs()
+	assert.NoError(t, err)
+
+	// importing the export reproduces the same commits
+	dst := filepath.Join(parent, "dst")
+	testConfigure(t, dst)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, FastImport(bytes.NewReader(stream.Bytes()), io.Discard, FastImportOptions{}))
+	actual, err := refs.ListRefs()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// an incremental export only includes new commits
+	testConfigure(t, src)
+	writeFile(t, src, "d", []byte("d"))
+	testAdd(t, ".", 3)
+	third := testCommit(t, []byte("third"))
+	stream.Reset()
+	assert.NoError(t, FastExport(stream, []string{"main"}, FastExportOptions{ImportMarks: marks}))
+	assert.Equal(t, "blob\nmark :6\ndata 1\nd\n\ncommit refs/heads/main\nmark :7\n", stream.String()[:strings.Index(stream.String(), "author")])
+	assert.Contains(t, stream.String(), "from :5\nM 100644 :6 d\n\n")
+
+	// file commands and refs which do not fast-forward
+	testConfigure(t, dst)
+	in := `blob
+mark :1
+data 3
+one
+commit refs/heads/topic
+mark :2
+committer C <c@example.com> 1700000000 +0100
+data <<EOT
+moved
+EOT
+from refs/heads/topic
+R "sp ace" dir/e
+C dir/e dir/f
+M 644 :1 g
+D a
+M 100755 inline h
+data 1
+h
+progress imported
+`
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, FastImport(strings.NewReader(in), buf, FastImportOptions{}))
+	assert.Equal(t, "progress imported\n", buf.String())
+	sha, err := refs.ReadRef("refs/heads/topic")
+	assert.NoError(t, err)
+	files, err := objects.CommittedFiles(sha)
+	assert.NoError(t, err)
+	var paths []string
+	for _, v := range files {
+		paths = append(paths, fmt.Sprintf("%o %s", v.Mode, v.Path))
+	}
+	assert.Equal(t, []string{"100644 dir/e", "100644 dir/f", "100644 g", "100755 h"}, paths)
+	c, err := objects.ReadCommit(sha)
+	assert.NoError(t, err)
+	assert.Equal(t, "moved\n", string(c.Message))
+
+	root := "commit refs/heads/topic\ncommitter C <c@example.com> 1700000000 +0100\ndata 4\nroot\n"
+	assert.Error(t, FastImport(strings.NewReader(root), io.Discard, FastImportOptions{}))
+	assert.NoError(t, FastImport(strings.NewReader(root), io.Discard, FastImportOptions{Force: true}))
+	assert.Error(t, FastImport(strings.NewReader("commit refs/heads/x\ndata 0\n"), io.Discard, FastImportOptions{}))
+	assert.Error(t, FastImport(strings.NewReader("feature done\n"), io.Discard, FastImportOptions{}))
+	assert.Error(t, FastImport(strings.NewReader("unknown\n"), io.Discard, FastImportOptions{}))
+	assert.False(t, objects.Exists([]byte(fmt.Sprintf("%x", third))))
+}
+
+func Test_Fsck(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Fsck(buf, FsckOptions{}))
+	assert.Equal(t, "notice: HEAD points to an unborn branch (main)\n", buf.String())
+
+	writeFile(t, dir, "a", []byte("a"))
+	testAdd(t, ".", 1)
+	first := testCommit(t, []byte("first"))
+	writeFile(t, dir, "b", []byte("b"))
+	testAdd(t, ".", 2)
+	second := testCommit(t, []byte("second"))
+	buf.Reset()
+	assert.NoError(t, Fsck(buf, FsckOptions{}))
+	assert.Equal(t, "", buf.String())
+
+	// moving the branch back leaves the second commit dangling
+	assert.NoError(t, refs.UpdateBranchHead("main", first))
+	c, err := objects.ReadCommit([]byte(fmt.Sprintf("%x", second)))
+	assert.NoError(t, err)
+	buf.Reset()
+	assert.NoError(t, Fsck(buf, FsckOptions{}))
+	assert.Equal(t, fmt.Sprintf("dangling commit %x\n", second), buf.String())
+	buf.Reset()
+	assert.NoError(t, Fsck(buf, FsckOptions{Unreachable: true}))
+	assert.Contains(t, buf.String(), fmt.Sprintf("unreachable tree %s\n", c.Tree))
+
+	// a missing object, an object which does not hash to its name and refs
+	// which do not point to objects
+	assert.NoError(t, refs.UpdateBranchHead("main", second))
+	tree := filepath.Join(dir, ".git", "objects", string(c.Tree[:2]), string(c.Tree[2:]))
+	assert.NoError(t, os.Remove(tree))
+	blob := fmt.Sprintf("%x", sha1.Sum([]byte("blob 1\x00a")))
+	other := fmt.Sprintf("%x", sha1.Sum([]byte("blob 1\x00b")))
+	path := filepath.Join(dir, ".git", "objects", blob[:2], blob[2:])
+	content, err := os.ReadFile(filepath.Join(dir, ".git", "objects", other[:2], other[2:]))
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chmod(path, 0644))
+	assert.NoError(t, os.WriteFile(path, content, 0644))
+	writeFile(t, dir, ".git/refs/heads/garbage", []byte("garbage\n"))
+	writeFile(t, dir, ".git/refs/heads/nowhere", []byte(strings.Repeat("1", 40)+"\n"))
+	idx, err := os.ReadFile(filepath.Join(dir, ".git", "index"))
+	assert.NoError(t, err)
+	idx[len(idx)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ".git", "index"), idx, 0644))
+	buf.Reset()
+	assert.EqualError(t, Fsck(buf, FsckOptions{}), "error: 5 problems found")
+	assert.Equal(t, fmt.Sprintf(`error: hash mismatch for %s
+error: refs/heads/garbage: badRefContent: garbage
+error: refs/heads/nowhere: invalid sha1 pointer %s
+error: index: bad index file sha1 signature
+broken link from  commit %x
+              to    tree %s
+missing tree %s
+dangling blob %s
+`, blob, strings.Repeat("1", 40), second, c.Tree, c.Tree, other), buf.String())
+}
+
+func Test_Init_SHA256(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	assert.EqualError(t, Init(InitOptions{ObjectFormat: "md5"}), "fatal: unknown object format 'md5'")
+	if err := Init(InitOptions{ObjectFormat: "sha256"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, ".git", "config"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectformat = sha256\n", string(b))
+	assert.EqualError(t, Init(InitOptions{ObjectFormat: "sha1"}), "fatal: attempt to reinitialize repository with different hash")
+
+	// the object format is read from the repository configuration
+	testConfigure(t, dir)
+	assert.Equal(t, config.SHA256, config.Hash())
+	writeFile(t, dir, "hello", []byte("hello"))
+	testAdd(t, ".", 1)
+	blob := fmt.Sprintf("%x", sha256.Sum256([]byte("blob 5\x00hello")))
+	idx, err := index.ReadIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, blob, idx.Files()[0].Sha.AsHexString())
+	testStatus(t, "A  hello\n")
+
+	sha := testCommit(t, []byte("first"))
+	assert.Len(t, sha, sha256.Size)
+	testStatus(t, "")
+	head, err := refs.HeadSHA("main")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", sha), string(head))
+	files, err := objects.CommittedFiles(head)
+	assert.NoError(t, err)
+	assert.Equal(t, blob, files[0].Sha.AsHexString())
+
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Fsck(buf, FsckOptions{}))
+	assert.Equal(t, "", buf.String())
+
+	// a clone has the object format of its source
+	clone := testDir(t)
+	defer func() { _ = os.RemoveAll(clone) }()
+	testConfigure(t, clone)
+	assert.NoError(t, Clone(io.Discard, dir, CloneOptions{Quiet: true}))
+	testConfigure(t, clone)
+	assert.Equal(t, config.SHA256, config.Hash())
+	head, err = refs.HeadSHA("main")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", sha), string(head))
+	testStatus(t, "")
+	assert.NoError(t, Fsck(buf, FsckOptions{}))
+	assert.Equal(t, "", buf.String())
+}
+
+func Test_Serve_SHA256(t *testing.T) {
+	parent := testDir(t)
+	defer func() { _ = os.RemoveAll(parent) }()
+	upstream := filepath.Join(parent, "upstream")
+	testConfigure(t, upstream)
+	if err := Init(InitOptions{ObjectFormat: "sha256"}); err != nil {
+		t.Fatal(err)
+	}
+	testConfigure(t, upstream)
+	writeFile(t, upstream, "a", []byte("a"))
+	testAdd(t, ".", 1)
+	first := testCommit(t, []byte("first"))
+
+	srv := httptest.NewServer(&server.Handler{Root: parent, ReceivePack: true})
+	defer srv.Close()
+	url := srv.URL + "/upstream"
+
+	// the served repository has its own object format, not that of the
+	// process
+	local := filepath.Join(parent, "local")
+	testConfigure(t, local)
+	assert.Equal(t, config.SHA1, config.Hash())
+	assert.NoError(t, Clone(io.Discard, url, CloneOptions{Quiet: true}))
+	testConfigure(t, local)
+	assert.Equal(t, config.SHA256, config.Hash())
+	head, err := refs.HeadSHA("main")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", first), string(head))
+	testStatus(t, "")
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Fsck(buf, FsckOptions{}))
+	assert.Equal(t, "", buf.String())
+
+	writeFile(t, local, "b", []byte("b"))
+	testAdd(t, "b", 2)
+	second := testCommit(t, []byte("second"))
+	assert.NoError(t, Push(buf, url, []string{"main:feature"}, PushOptions{}))
+	assert.Equal(t, fmt.Sprintf("To %s\n * [new branch]      main -> feature\n", url), buf.String())
+	gitDir := filepath.Join(upstream, ".git")
+	sha, err := refs.ReadRefIn(gitDir, "refs/heads/feature")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", second), string(sha))
+	_, err = objects.Verify(filepath.Join(gitDir, "objects"), sha)
+	assert.NoError(t, err)
+}
+
+func Test_Object_Streaming(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	sha, err := objects.WriteStream(config.ObjectPath(), objects.ObjectBlob, int64(len(content)), bytes.NewReader(content))
+	assert.NoError(t, err)
+	expected := sha1.Sum(append([]byte(fmt.Sprintf("blob %d\x00", len(content))), content...))
+	assert.Equal(t, expected[:], sha)
+
+	r, err := objects.OpenObject([]byte(fmt.Sprintf("%x", sha)))
+	assert.NoError(t, err)
+	assert.Equal(t, objects.ObjectBlob, r.Typ)
+	assert.Equal(t, int64(len(content)), r.Size)
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, content, b)
+
+	// a short read does not leave an object or temporary file behind
+	_, err = objects.WriteStream(config.ObjectPath(), objects.ObjectBlob, 10, strings.NewReader("short"))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	entries, err := os.ReadDir(config.ObjectPath())
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// the hash is checked at the end of the content
+	wrong := fmt.Sprintf("%x", sha1.Sum([]byte("wrong")))
+	path := filepath.Join(config.ObjectPath(), wrong[:2], wrong[2:])
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.Link(filepath.Join(config.ObjectPath(), fmt.Sprintf("%x", sha[:1]), fmt.Sprintf("%x", sha[1:])), path))
+	r, err = objects.OpenObject([]byte(wrong))
+	assert.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, objects.ErrHashMismatch)
+	assert.NoError(t, r.Close())
+}
+
+func Test_Commit_Dates(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_AUTHOR_DATE", "2023-11-14T23:13:20+05:30")
+	t.Setenv("GIT_COMMITTER_DATE", "@99 -0800")
+	writeFile(t, dir, "a", []byte("a"))
+	testAdd(t, ".", 1)
+	sha := testCommit(t, []byte("first"))
+	obj, err := objects.ReadObject([]byte(fmt.Sprintf("%x", sha)))
+	assert.NoError(t, err)
+	content, err := objects.ReadContent(obj)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "author default <default@default.com> 1699983800 +0530\ncommitter default <default@default.com> 99 -0800\n")
+
+	c, err := objects.ReadCommit([]byte(fmt.Sprintf("%x", sha)))
+	assert.NoError(t, err)
+	assert.Equal(t, "1699983800 +0530", date.FormatRaw(c.AuthoredTime))
+	assert.Equal(t, "99 -0800", date.FormatRaw(c.CommittedTime))
+
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Log(buf, LogOptions{}))
+	assert.Contains(t, buf.String(), "Date:   Tue Nov 14 23:13:20 2023 +0530\n")
+	buf.Reset()
+	assert.NoError(t, Log(buf, LogOptions{Date: date.ISO}))
+	assert.Contains(t, buf.String(), "Date:   2023-11-14 23:13:20 +0530\n")
+
+	t.Setenv("GIT_AUTHOR_DATE", "not a date")
+	_, err = Commit([]byte("second"), CommitOptions{})
+	assert.EqualError(t, err, "fatal: invalid date format: not a date")
+}
+
+func Test_Commit_Signing(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// an ssh signing key and an allowed signers file trusting it
+	pub, key, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	writeFile(t, dir, "key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	wire := append([]byte("\x00\x00\x00\x0bssh-ed25519\x00\x00\x00\x20"), pub...)
+	writeFile(t, dir, "allowed", []byte("a@b.c ssh-ed25519 "+base64.StdEncoding.EncodeToString(wire)+"\n"))
+	c, err := config.RepositoryConfig()
+	assert.NoError(t, err)
+	assert.NoError(t, c.Set("gpg.format", "ssh"))
+	assert.NoError(t, c.Set("user.signingKey", "key"))
+	assert.NoError(t, c.Set("gpg.ssh.allowedSignersFile", "allowed"))
+	assert.NoError(t, c.Write(config.GitConfigPath()))
+
+	writeFile(t, dir, "a", []byte("a"))
+	testAdd(t, "a", 1)
+	signed, err := Commit([]byte("signed"), CommitOptions{Sign: true})
+	assert.NoError(t, err)
+	commit, err := objects.ReadCommit([]byte(fmt.Sprintf("%x", signed)))
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(commit.Sig, []byte("-----BEGIN SSH SIGNATURE-----\n")))
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, VerifyCommit(buf, "HEAD"))
+	assert.Contains(t, buf.String(), `Good "git" signature for a@b.c with ED25519 key SHA256:`)
+
+	buf.Reset()
+	assert.NoError(t, Log(buf, LogOptions{ShowSignature: true}))
+	assert.Contains(t, buf.String(), "\nGood \"git\" signature for a@b.c with ED25519 key SHA256:")
+
+	// a key without a principal is not trusted
+	writeFile(t, dir, "allowed", []byte(""))
+	buf.Reset()
+	assert.EqualError(t, VerifyCommit(buf, "HEAD"), "error: HEAD is signed by an untrusted key")
+	assert.Contains(t, buf.String(), "\nNo principal matched.\n")
+	writeFile(t, dir, "allowed", []byte("a@b.c ssh-ed25519 "+base64.StdEncoding.EncodeToString(wire)+"\n"))
+
+	writeFile(t, dir, "b", []byte("b"))
+	testAdd(t, "b", 2)
+	testCommit(t, []byte("unsigned"))
+	assert.EqualError(t, VerifyCommit(buf, "HEAD"), "error: no signature found in HEAD")
+
+	// a signature of other content does not verify
+	commit.Message = []byte("tampered\n")
+	sha, err := objects.WriteObject([]byte(fmt.Sprintf("commit %d\x00", len(commit.Bytes()))), commit.Bytes(), "", config.ObjectPath())
+	assert.NoError(t, err)
+	buf.Reset()
+	assert.EqualError(t, VerifyCommit(buf, fmt.Sprintf("%x", sha)), fmt.Sprintf("error: could not verify the signature of %x", sha))
+	assert.Equal(t, "Could not verify signature.\n", buf.String())
+}
+
+func Test_Hooks(t *testing.T) {
+	parent := testDir(t)
+	defer func() { _ = os.RemoveAll(parent) }()
+	upstream := filepath.Join(parent, "upstream")
+	local := filepath.Join(parent, "local")
+	testConfigure(t, upstream)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, upstream, "a", []byte("a"))
+	testAdd(t, ".", 1)
+	initial := testCommit(t, []byte("first"))
+	testConfigure(t, local)
+	assert.NoError(t, Clone(io.Discard, upstream, CloneOptions{Quiet: true}))
+
+	hooksDir := filepath.Join(config.GitPath(), "hooks")
+	assert.NoError(t, os.MkdirAll(hooksDir, 0755))
+	for name, script := range map[string]string{
+		"pre-commit":         "[ -f \"$GIT_INDEX_FILE\" ] && [ ! -f block ]",
+		"prepare-commit-msg": "echo \"prepare-commit-msg $2\" >> \"$GIT_DIR/hooks.log\"",
+		"commit-msg":         "grep -q WIP \"$1\" && exit 1\necho 'Ticket: ABC-1' >> \"$1\"",
+		"post-commit":        "echo post-commit >> \"$GIT_DIR/hooks.log\"\nexit 1",
+		"post-checkout":      "echo \"post-checkout $1 $2 $3\" >> \"$GIT_DIR/hooks.log\"",
+		"pre-push":           "echo \"pre-push $1 $2\" >> \"$GIT_DIR/hooks.log\"\ncat >> \"$GIT_DIR/hooks.log\"\n[ ! -f block ]",
+	} {
+		writeFile(t, hooksDir, name, []byte("#!/bin/sh\n"+script+"\n"))
+		assert.NoError(t, os.Chmod(filepath.Join(hooksDir, name), 0755))
+	}
+	hookLog := func() string {
+		b, err := os.ReadFile(filepath.Join(config.GitPath(), "hooks.log"))
+		assert.NoError(t, err)
+		assert.NoError(t, os.Remove(filepath.Join(config.GitPath(), "hooks.log")))
+		return string(b)
+	}
+
+	// commit-msg edits the message, the result of post-commit is ignored
+	writeFile(t, local, "b", []byte("b"))
+	testAdd(t, "b", 2)
+	first, err := Commit([]byte("feature"), CommitOptions{})
+	assert.NoError(t, err)
+	c, err := objects.ReadCommit([]byte(fmt.Sprintf("%x", first)))
+	assert.NoError(t, err)
+	assert.Equal(t, "feature\nTicket: ABC-1\n", string(c.Message))
+	assert.Equal(t, "prepare-commit-msg message\npost-commit\n", hookLog())
+
+	// pre-commit and commit-msg abort the commit unless bypassed
+	writeFile(t, local, "c", []byte("c"))
+	testAdd(t, "c", 3)
+	_, err = Commit([]byte("WIP"), CommitOptions{})
+	assert.EqualError(t, err, "commit-msg hook exited with status 1")
+	writeFile(t, local, "block", []byte("x"))
+	_, err = Commit([]byte("blocked"), CommitOptions{})
+	assert.EqualError(t, err, "pre-commit hook exited with status 1")
+	second, err := Commit([]byte("WIP"), CommitOptions{NoVerify: true})
+	assert.NoError(t, err)
+	c, err = objects.ReadCommit([]byte(fmt.Sprintf("%x", second)))
+	assert.NoError(t, err)
+	assert.Equal(t, "WIP\n", string(c.Message))
+	hookLog()
+
+	// pre-push is given the refs to update and can refuse the push
+	buf := bytes.NewBuffer(nil)
+	assert.EqualError(t, Push(buf, DefaultRemote, []string{"main:topic"}, PushOptions{}), fmt.Sprintf("error: failed to push some refs to '%s'", upstream))
+	zero := string(config.Hash().ZeroHex())
+	assert.Equal(t, fmt.Sprintf("pre-push origin %s\nrefs/heads/main %x refs/heads/topic %s\n", upstream, second, zero), hookLog())
+	assert.NoError(t, Push(buf, DefaultRemote, []string{"main:topic"}, PushOptions{NoVerify: true}))
+	assert.NoError(t, os.Remove(filepath.Join(local, "block")))
+
+	// post-checkout is given the previous and new HEAD
+	assert.NoError(t, CreateBranch("other"))
+	assert.NoError(t, SwitchBranch("other"))
+	assert.Equal(t, fmt.Sprintf("post-checkout %x %x 1\n", second, second), hookLog())
+
+	// core.hooksPath replaces the hooks directory
+	assert.NoError(t, os.MkdirAll(filepath.Join(local, "hooks"), 0755))
+	writeFile(t, local, "hooks/pre-commit", []byte("#!/bin/sh\nexit 3\n"))
+	assert.NoError(t, os.Chmod(filepath.Join(local, "hooks", "pre-commit"), 0755))
+	cnf, err := config.RepositoryConfig()
+	assert.NoError(t, err)
+	assert.NoError(t, cnf.Set("core.hooksPath", "hooks"))
+	assert.NoError(t, cnf.Write(config.GitConfigPath()))
+	_, err = Commit([]byte("third"), CommitOptions{})
+	assert.EqualError(t, err, "pre-commit hook exited with status 3")
+
+	// post-checkout runs after clone with the null commit as previous HEAD
+	testConfigure(t, filepath.Join(parent, "clone"))
+	assert.NoError(t, Clone(io.Discard, upstream, CloneOptions{Quiet: true, Config: []string{"core.hooksPath=" + hooksDir}}))
+	assert.Equal(t, fmt.Sprintf("post-checkout %s %x 1\n", zero, initial), hookLog())
+}
+
+func Test_Commit_Amend(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Commit([]byte("nothing"), CommitOptions{Amend: true})
+	assert.EqualError(t, err, "fatal: You have nothing to amend.")
+	_, err = Commit([]byte("nothing"), CommitOptions{})
+	assert.EqualError(t, err, "On branch main\n\nNo commits yet\n\nnothing to commit (create/copy files and use \"mygit add\" to track)")
+
+	writeFile(t, dir, "a", []byte("a"))
+	writeFile(t, dir, "b", []byte("b"))
+	testAdd(t, ".", 2)
+	first := testCommit(t, []byte("first"))
+	_, err = Commit([]byte("again"), CommitOptions{})
+	assert.EqualError(t, err, "On branch main\nnothing to commit, working tree clean")
+
+	// -a stages modified and deleted tracked files but not untracked files
+	writeFile(t, dir, "a", []byte("aa"))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "b")))
+	writeFile(t, dir, "u", []byte("u"))
+	t.Setenv("GIT_AUTHOR_NAME", "original")
+	second, err := Commit([]byte("second"), CommitOptions{All: true})
+	assert.NoError(t, err)
+	files, err := objects.CommittedFiles([]byte(fmt.Sprintf("%x", second)))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, "a", files[0].Path)
+
+	// amending keeps the parents, author and message of HEAD
+	t.Setenv("GIT_AUTHOR_NAME", "other")
+	amended, err := Commit(nil, CommitOptions{Amend: true, NoEdit: true, AllowEmpty: true})
+	assert.NoError(t, err)
+	assert.NotEqual(t, second, amended)
+	c, err := objects.ReadCommit([]byte(fmt.Sprintf("%x", amended)))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte(fmt.Sprintf("%x", first))}, c.Parents)
+	assert.Equal(t, "original", c.Author)
+	assert.Equal(t, "second\n", string(c.Message))
+	head, err := refs.LastCommit()
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", amended), string(head))
+
+	// the author and date can be given
+	amended, err = Commit([]byte("reworded"), CommitOptions{Amend: true, Author: "A U Thor <a@b.c>", Date: "@1700000000 +0100"})
+	assert.NoError(t, err)
+	c, err = objects.ReadCommit([]byte(fmt.Sprintf("%x", amended)))
+	assert.NoError(t, err)
+	assert.Equal(t, "A U Thor", c.Author)
+	assert.Equal(t, "a@b.c", c.AuthorEmail)
+	assert.Equal(t, "1700000000 +0100", date.FormatRaw(c.AuthoredTime))
+	assert.Equal(t, "reworded\n", string(c.Message))
+	_, err = Commit([]byte("x"), CommitOptions{Author: "nobody"})
+	assert.EqualError(t, err, "fatal: --author 'nobody' is not 'Name <email>'")
+
+	// an amend making HEAD the same as its parent needs --allow-empty
+	writeFile(t, dir, "a", []byte("a"))
+	writeFile(t, dir, "b", []byte("b"))
+	testAdd(t, ".", 3)
+	assert.NoError(t, os.Remove(filepath.Join(dir, "u")))
+	assert.NoError(t, Rm(io.Discard, RmOptions{Cached: true}, "u"))
+	_, err = Commit(nil, CommitOptions{Amend: true, NoEdit: true})
+	assert.EqualError(t, err, "You asked to amend the most recent commit, but doing so would make\nit empty. You can repeat your command with --allow-empty.")
+}
+
+func Test_Commit_Message(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	readMessage := func(sha []byte) string {
+		c, err := objects.ReadCommit([]byte(fmt.Sprintf("%x", sha)))
+		assert.NoError(t, err)
+		return string(c.Message)
+	}
+	signoff := fmt.Sprintf("Signed-off-by: %s <%s>", config.CommitterName(), config.CommitterEmail())
+
+	// a message which is not edited keeps comments but not extra whitespace,
+	// trailers are added before comments at the end
+	writeFile(t, dir, "a", []byte("a"))
+	testAdd(t, ".", 1)
+	sha, err := Commit([]byte("\nsubject  \n\n\n# not a comment\n"), CommitOptions{Trailers: []string{"Fixes=1"}, Signoff: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "subject\n\nFixes: 1\n"+signoff+"\n\n# not a comment\n", readMessage(sha))
+	sha, err = Commit([]byte(" verbatim \n\n\n"), CommitOptions{AllowEmpty: true, Cleanup: message.CleanupVerbatim})
+	assert.NoError(t, err)
+	assert.Equal(t, " verbatim \n\n\n", readMessage(sha))
+	_, err = Commit([]byte("\n\n"), CommitOptions{AllowEmpty: true, Signoff: true})
+	assert.EqualError(t, err, "Aborting commit due to empty commit message.")
+
+	// commit.cleanup configures the default
+	cnf, err := config.RepositoryConfig()
+	assert.NoError(t, err)
+	assert.NoError(t, cnf.Set("commit.cleanup", "strip"))
+	assert.NoError(t, cnf.Write(config.GitConfigPath()))
+	sha, err = Commit([]byte("subject\n# comment\n"), CommitOptions{AllowEmpty: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "subject\n", readMessage(sha))
+
+	// an edited template must be changed, below it the status is commented
+	writeFile(t, dir, "template", []byte("Subject\n\n# what changed\n"))
+	writeFile(t, dir, "editor", []byte(fmt.Sprintf("#!/bin/sh\ncd %s\ncp \"$1\" edited\n[ -f reword ] && sed -i s/Subject/Reworded/ \"$1\"\nexit 0\n", dir)))
+	assert.NoError(t, os.Chmod(filepath.Join(dir, "editor"), 0755))
+	config.Config.Editor = filepath.Join(dir, "editor")
+	assert.NoError(t, cnf.Set("commit.template", "template"))
+	assert.NoError(t, cnf.Write(config.GitConfigPath()))
+	_, err = Commit(nil, CommitOptions{AllowEmpty: true})
+	assert.EqualError(t, err, "Aborting commit; you did not edit the message.")
+	edited, err := os.ReadFile(filepath.Join(dir, "edited"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(edited), "Subject\n\n# what changed\n\n# Please enter the commit message for your changes. Lines starting\n# with '#' will be ignored, and an empty message aborts the commit.\n#\n# On branch main\n"))
+	writeFile(t, dir, "reword", nil)
+	sha, err = Commit(nil, CommitOptions{AllowEmpty: true, Cleanup: message.CleanupScissors})
+	assert.NoError(t, err)
+	assert.Equal(t, "Reworded\n\n# what changed\n", readMessage(sha))
+
+	// interpret-trailers adds trailers to input or files
+	out := bytes.NewBuffer(nil)
+	assert.NoError(t, InterpretTrailers(out, strings.NewReader("subject\n\nbody\n"), InterpretTrailersOptions{Trailers: []string{"a=b", "a: b"}}))
+	assert.Equal(t, "subject\n\nbody\n\na: b\n", out.String())
+	writeFile(t, dir, "msg", []byte("subject\n\nFixes: 1\n  continued\n"))
+	assert.NoError(t, InterpretTrailers(io.Discard, nil, InterpretTrailersOptions{Trailers: []string{"Bug=2"}, InPlace: true}, filepath.Join(dir, "msg")))
+	out.Reset()
+	assert.NoError(t, InterpretTrailers(out, nil, InterpretTrailersOptions{OnlyTrailers: true, Unfold: true}, filepath.Join(dir, "msg")))
+	assert.Equal(t, "Fixes: 1 continued\nBug: 2\n", out.String())
+}
+
+func Test_Blame(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	commitAs := func(name string, when string, msg string) string {
+		t.Setenv("GIT_AUTHOR_NAME", name)
+		t.Setenv("GIT_AUTHOR_DATE", when)
+		t.Setenv("GIT_COMMITTER_DATE", when)
+		sha, err := Commit([]byte(msg), CommitOptions{})
+		assert.NoError(t, err)
+		return fmt.Sprintf("%x", sha)
+	}
+	writeFile(t, dir, "f", []byte("one\ntwo\nthree\n"))
+	testAdd(t, ".", 1)
+	first := commitAs("Ann", "@1700000000 +0100", "first")
+	writeFile(t, dir, "f", []byte("one\n2\nthree\nfour\n"))
+	testAdd(t, ".", 1)
+	second := commitAs("Bo", "@1700003600 +0000", "second")
+	// the file is followed through a rename with a new first line
+	assert.NoError(t, Mv(io.Discard, MvOptions{}, []string{"f"}, "g"))
+	writeFile(t, dir, "g", []byte("zero\none\n2\nthree\nfour\n"))
+	testAdd(t, ".", 1)
+	third := commitAs("Ann", "@1700007200 +0000", "third")
+	// reformatting is blamed on the lines it replaced when ignored
+	writeFile(t, dir, "g", []byte("zero\nONE\n2\nthree\nfour\n"))
+	testAdd(t, ".", 1)
+	fourth := commitAs("Cy", "@1700010800 +0000", "format")
+
+	out := bytes.NewBuffer(nil)
+	assert.NoError(t, Blame(out, BlameOptions{}, "g"))
+	assert.Equal(t, fmt.Sprintf(`%s g (Ann 2023-11-15 00:13:20 +0000 1) zero
+%s g (Cy  2023-11-15 01:13:20 +0000 2) ONE
+%s f (Bo  2023-11-14 23:13:20 +0000 3) 2
+^%s f (Ann 2023-11-14 23:13:20 +0100 4) three
+%s f (Bo  2023-11-14 23:13:20 +0000 5) four
+`, third[:8], fourth[:8], second[:8], first[:7], second[:8]), out.String())
+
+	out.Reset()
+	assert.NoError(t, Blame(out, BlameOptions{Ranges: []string{"2,+2"}, IgnoreRevs: []string{fourth}}, "g"))
+	assert.Equal(t, fmt.Sprintf(`^%s f (Ann 2023-11-14 23:13:20 +0100 2) ONE
+%s f (Bo  2023-11-14 23:13:20 +0000 3) 2
+`, first[:7], second[:8]), out.String())
+
+	out.Reset()
+	assert.NoError(t, Blame(out, BlameOptions{Rev: second, Porcelain: true}, "f"))
+	assert.Equal(t, fmt.Sprintf(`%s 1 1 1
+author Ann
+author-mail <%s>
+author-time 1700000000
+author-tz +0100
+committer Ann
+committer-mail <%s>
+committer-time 1700000000
+committer-tz +0100
+summary first
+boundary
+filename f
+	one
+%s 2 2 1
+author Bo
+author-mail <%s>
+author-time 1700003600
+author-tz +0000
+committer Bo
+committer-mail <%s>
+committer-time 1700003600
+committer-tz +0000
+summary second
+previous %s f
+filename f
+	2
+%s 3 3 1
+	three
+%s 4 4 1
+	four
+`, first, config.AuthorEmail(), config.CommitterEmail(), second, config.AuthorEmail(), config.CommitterEmail(), first, first, second), out.String())
+
+	assert.EqualError(t, Blame(io.Discard, BlameOptions{Ranges: []string{"9"}}, "g"), "fatal: file g has only 5 lines")
+	assert.EqualError(t, Blame(io.Discard, BlameOptions{}, "f"), "fatal: no such path 'f' in HEAD")
+}
+
+func Test_Log(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	commitAs := func(name string, when string, msg string) string {
+		t.Setenv("GIT_AUTHOR_NAME", name)
+		t.Setenv("GIT_AUTHOR_DATE", when)
+		t.Setenv("GIT_COMMITTER_DATE", when)
+		sha, err := Commit([]byte(msg), CommitOptions{})
+		assert.NoError(t, err)
+		return fmt.Sprintf("%x", sha)
+	}
+	writeFile(t, dir, "a", []byte("one\ntwo\n"))
+	testAdd(t, ".", 1)
+	first := commitAs("Ann", "@1700000000 +0000", "first\n\nbody\n")
+	writeFile(t, dir, "b", []byte("b\n"))
+	testAdd(t, ".", 2)
+	second := commitAs("Bo", "@1700003600 +0000", "second\n")
+	writeFile(t, dir, "a", []byte("one\n2\nthree\n"))
+	testAdd(t, ".", 2)
+	third := commitAs("Ann", "@1700007200 +0000", "third\n")
+
+	oneline := &pretty.Format{Name: "oneline"}
+	tests := []struct {
+		name     string
+		opts     LogOptions
+		paths    []string
+		expected string
+	}{
+		{"oneline", LogOptions{Format: oneline, AbbrevCommit: true}, nil, fmt.Sprintf("%s third\n%s second\n%s first\n", third[:7], second[:7], first[:7])},
+		{"max count", LogOptions{Format: oneline, MaxCount: 1}, nil, third + " third\n"},
+		{"reverse", LogOptions{Format: &pretty.Format{Template: "%s", Terminator: true}, Reverse: true}, nil, "first\nsecond\nthird\n"},
+		{"format", LogOptions{Format: &pretty.Format{Template: "%an %at%n%b"}}, nil, "Ann 1700007200\n\nBo 1700003600\n\nAnn 1700000000\nbody\n"},
+		{"author", LogOptions{Format: &pretty.Format{Template: "%s", Terminator: true}, Authors: []string{"^Bo"}}, nil, "second\n"},
+		{"grep", LogOptions{Format: &pretty.Format{Template: "%s", Terminator: true}, Grep: []string{"^body", "thi"}}, nil, "third\nfirst\n"},
+		{"since until", LogOptions{Format: &pretty.Format{Template: "%s", Terminator: true}, Since: time.Unix(1700003600, 0), Until: time.Unix(1700005000, 0)}, nil, "second\n"},
+		{"paths", LogOptions{Format: &pretty.Format{Template: "%s", Terminator: true}}, []string{"a"}, "third\nfirst\n"},
+		{"graph", LogOptions{Format: oneline, AbbrevCommit: true, Graph: true}, []string{"b"}, fmt.Sprintf("* %s second\n", second[:7])},
+		{"name status", LogOptions{Format: oneline, AbbrevCommit: true, NameStatus: true, MaxCount: 2}, nil, fmt.Sprintf("%s third\nM\ta\n%s second\nA\tb\n", third[:7], second[:7])},
+		{"stat", LogOptions{Format: &pretty.Format{Template: "%s", Terminator: true}, Stat: true, MaxCount: 1}, nil, "third\n\n a | 3 ++-\n 1 file changed, 2 insertions(+), 1 deletion(-)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			assert.NoError(t, Log(buf, tt.opts, tt.paths...))
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+	assert.EqualError(t, Log(io.Discard, LogOptions{Graph: true, Reverse: true}), "fatal: options '--reverse' and '--graph' cannot be used together")
+
+	// the walk stops once enough commits are shown
+	assert.NoError(t, os.Remove(filepath.Join(config.ObjectPath(), first[:2], first[2:])))
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Log(buf, LogOptions{Format: &pretty.Format{Template: "%s", Terminator: true}, MaxCount: 2}))
+	assert.Equal(t, "third\nsecond\n", buf.String())
+	buf.Reset()
+	assert.NoError(t, Log(buf, LogOptions{Format: &pretty.Format{Template: "%s", Terminator: true}, MaxCount: 2, Reverse: true}))
+	assert.Equal(t, "second\nthird\n", buf.String())
+}
+
+func Test_Log_Renames(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	content := make(map[string]string)
+	for i, name := range []string{"x", "y", "z"} {
+		var b strings.Builder
+		for j := 1; j <= 20; j++ {
+			fmt.Fprintf(&b, "%d\n", i*20+j)
+		}
+		content[name] = b.String()
+		writeFile(t, dir, name, []byte(content[name]))
+	}
+	testAdd(t, ".", 3)
+	testCommit(t, []byte("first"))
+	for _, name := range []string{"x", "y", "z"} {
+		assert.NoError(t, os.Remove(filepath.Join(dir, name)))
+	}
+	writeFile(t, dir, "x2", []byte(content["x"]))
+	writeFile(t, dir, "y2", []byte(content["y"]+"99\n"))
+	writeFile(t, dir, "z2", []byte(content["z"]+"99\n"))
+	testAdd(t, ".", 3)
+	testCommit(t, []byte("second"))
+
+	opts := LogOptions{Format: &pretty.Format{Template: "%s", Terminator: true}, NameStatus: true, MaxCount: 1}
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, Log(buf, opts))
+	assert.Equal(t, "second\n\nR100\tx\tx2\nR095\ty\ty2\nR095\tz\tz2\n", buf.String())
+
+	// past the rename limit only files with the same content are renames
+	cnf, err := config.RepositoryConfig()
+	assert.NoError(t, err)
+	assert.NoError(t, cnf.Set("diff.renameLimit", "1"))
+	assert.NoError(t, cnf.Write(config.GitConfigPath()))
+	buf.Reset()
+	assert.NoError(t, Log(buf, opts))
+	assert.Equal(t, "second\n\nR100\tx\tx2\nD\ty\nA\ty2\nD\tz\nA\tz2\n", buf.String())
+}
+
+func Test_Shortlog(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	commitAs := func(name string, email string, when string, msg string) {
+		t.Setenv("GIT_AUTHOR_NAME", name)
+		t.Setenv("GIT_AUTHOR_EMAIL", email)
+		t.Setenv("GIT_AUTHOR_DATE", when)
+		t.Setenv("GIT_COMMITTER_DATE", when)
+		_, err := Commit([]byte(msg), CommitOptions{})
+		assert.NoError(t, err)
+	}
+	for i, v := range [][2]string{{"Ann", "ann@x.com"}, {"bob", "BOB@y.com"}, {"Ann", "ann@x.com"}, {"Zed", "z@z"}, {"Bob B", "bob@y.com"}} {
+		writeFile(t, dir, "f", []byte(fmt.Sprintf("%d\n", i+1)))
+		testAdd(t, "f", 1)
+		commitAs(v[0], v[1], fmt.Sprintf("@%d +0000", 1700000000+i), fmt.Sprintf("commit %d\n\nbody\n", i+1))
+	}
+
+	out := bytes.NewBuffer(nil)
+	assert.NoError(t, Shortlog(out, ShortlogOptions{}))
+	assert.Equal(t, "Ann (2):\n      commit 1\n      commit 3\n\nBob B (1):\n      commit 5\n\nZed (1):\n      commit 4\n\nbob (1):\n      commit 2\n\n", out.String())
+
+	// emails are mapped ignoring case
+	writeFile(t, dir, ".mailmap", []byte("Robert <rob@y.com> <bob@y.com>\nAnnie <ann@x.com>\n"))
+	out.Reset()
+	assert.NoError(t, Shortlog(out, ShortlogOptions{Summary: true, Numbered: true, Email: true}))
+	assert.Equal(t, "     2\tAnnie <ann@x.com>\n     2\tRobert <rob@y.com>\n     1\tZed <z@z>\n", out.String())
+	out.Reset()
+	assert.NoError(t, Shortlog(out, ShortlogOptions{}, "HEAD~2..HEAD"))
+	assert.Equal(t, "Robert (1):\n      commit 5\n\nZed (1):\n      commit 4\n\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, Log(out, LogOptions{Format: &pretty.Format{Template: "%an|%aN|%aE", Terminator: true}, Authors: []string{"Robert"}}))
+	assert.Equal(t, "Bob B|Robert|rob@y.com\nbob|Robert|rob@y.com\n", out.String())
+	out.Reset()
+	assert.NoError(t, Blame(out, BlameOptions{Porcelain: true}, "f"))
+	assert.Contains(t, out.String(), "\nauthor Robert\nauthor-mail <rob@y.com>\n")
+}
+
+func Test_Describe(t *testing.T) {
+	dir := testDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	testConfigure(t, dir)
+	if err := Init(InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var commits []string
+	var raw [][]byte
+	for i := 1; i <= 4; i++ {
+		writeFile(t, dir, "f", []byte(fmt.Sprintf("%d\n", i)))
+		testAdd(t, "f", 1)
+		t.Setenv("GIT_COMMITTER_DATE", fmt.Sprintf("@%d +0000", 1700000000+i))
+		sha, err := Commit([]byte(fmt.Sprintf("c%d", i)), CommitOptions{})
+		assert.NoError(t, err)
+		commits = append(commits, fmt.Sprintf("%x", sha))
+		raw = append(raw, sha)
+	}
+	describe := func(opts DescribeOptions, rev string) string {
+		out := bytes.NewBuffer(nil)
+		if err := Describe(out, opts, rev); err != nil {
+			return err.Error()
+		}
+		return out.String()
+	}
+	assert.Equal(t, "fatal: No names found, cannot describe anything.", describe(DescribeOptions{Candidates: 10, Abbrev: 7}, ""))
+
+	assert.NoError(t, refs.UpdateRef("refs/tags/light", raw[0]))
+	assert.Equal(t, fmt.Sprintf("fatal: No annotated tags can describe '%s'.\nHowever, there were unannotated tags: try --tags.", commits[3]), describe(DescribeOptions{Candidates: 10, Abbrev: 7}, ""))
+	assert.Equal(t, fmt.Sprintf("light-3-g%s\n", commits[3][:7]), describe(DescribeOptions{Candidates: 10, Tags: true, Abbrev: 7}, ""))
+
+	tag := fmt.Sprintf("object %s\ntype commit\ntag v1\ntagger T <t@t> 1700000005 +0000\n\nv1\n", commits[1])
+	sha, err := objects.WriteObject([]byte(fmt.Sprintf("tag %d\x00", len(tag))), []byte(tag), "", config.ObjectPath())
+	assert.NoError(t, err)
+	assert.NoError(t, refs.UpdateRef("refs/tags/v1", sha))
+
+	assert.Equal(t, fmt.Sprintf("v1-2-g%s\n", commits[3][:7]), describe(DescribeOptions{Candidates: 10, Abbrev: 7}, ""))
+	assert.Equal(t, fmt.Sprintf("v1-2-g%s\n", commits[3][:4]), describe(DescribeOptions{Candidates: 10, Abbrev: 2}, ""))
+	assert.Equal(t, "v1\n", describe(DescribeOptions{Candidates: 10, Abbrev: 0}, ""))
+	assert.Equal(t, "v1\n", describe(DescribeOptions{Candidates: 10, Abbrev: 7}, commits[1]))
+	assert.Equal(t, fmt.Sprintf("v1-0-g%s\n", commits[1][:7]), describe(DescribeOptions{Candidates: 10, Long: true, Abbrev: 7}, "v1"))
+	assert.Equal(t, "light\n", describe(DescribeOptions{Candidates: 10, Tags: true, Abbrev: 7}, "HEAD~3"))
+	assert.Equal(t, "fatal: Not a valid object name nosuch", describe(DescribeOptions{Candidates: 10, Abbrev: 7}, "nosuch"))
+	assert.Equal(t, "fatal: options '--long' and '--abbrev=0' cannot be used together", describe(DescribeOptions{Candidates: 10, Long: true}, ""))
+
+	assert.Equal(t, fmt.Sprintf("v1-2-g%s\n", commits[3][:7]), describe(DescribeOptions{Candidates: 10, Abbrev: 7, Dirty: "-dirty"}, ""))
+	writeFile(t, dir, "f", []byte("changed\n"))
+	assert.Equal(t, fmt.Sprintf("v1-2-g%s-dirty\n", commits[3][:7]), describe(DescribeOptions{Candidates: 10, Abbrev: 7, Dirty: "-dirty"}, ""))
+	assert.Equal(t, "fatal: option '--dirty' and commit-ishes cannot be used together", describe(DescribeOptions{Candidates: 10, Abbrev: 7, Dirty: "-dirty"}, "HEAD"))
+
+	// without candidates only a tagged commit is described
+	assert.Equal(t, fmt.Sprintf("fatal: no tag exactly matches '%s'", commits[3]), describe(DescribeOptions{Abbrev: 7}, ""))
+	assert.Equal(t, "v1\n", describe(DescribeOptions{Abbrev: 7}, commits[1]))
+	// the walk gives up at light, past the only candidate
+	assert.Equal(t, fmt.Sprintf("v1-2-g%s\n", commits[3][:7]), describe(DescribeOptions{Candidates: 1, Tags: true, Abbrev: 7}, ""))
+}
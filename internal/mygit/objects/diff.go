@@ -0,0 +1,57 @@
+package objects
+
+import (
+	"github.com/richardjennings/mygit/internal/mygit/gfs"
+	"sort"
+)
+
+// TreeChange describes a file which differs between two commits. From is nil
+// when the file was added and To is nil when the file was deleted.
+type TreeChange struct {
+	Path string
+	From *gfs.File
+	To   *gfs.File
+}
+
+// DiffCommits returns the files which differ between the trees of commits a
+// and b, sorted by path. Either commit may be nil representing an empty tree.
+func DiffCommits(a []byte, b []byte) ([]*TreeChange, error) {
+	var aFiles, bFiles []*gfs.File
+	var err error
+	if a != nil {
+		if aFiles, err = CommittedFiles(a); err != nil {
+			return nil, err
+		}
+	}
+	if b != nil {
+		if bFiles, err = CommittedFiles(b); err != nil {
+			return nil, err
+		}
+	}
+	return DiffFiles(aFiles, bFiles), nil
+}
+
+// DiffFiles returns the files which differ between two lists of files, sorted
+// by path.
+func DiffFiles(from []*gfs.File, to []*gfs.File) []*TreeChange {
+	var changes []*TreeChange
+	toSet := gfs.NewFileSet(to)
+	fromSet := gfs.NewFileSet(from)
+	for _, v := range from {
+		t, ok := toSet.Contains(v.Path)
+		if !ok {
+			changes = append(changes, &TreeChange{Path: v.Path, From: v})
+			continue
+		}
+		if !v.Sha.Same(t.Sha) || v.Mode != t.Mode {
+			changes = append(changes, &TreeChange{Path: v.Path, From: v, To: t})
+		}
+	}
+	for _, v := range to {
+		if _, ok := fromSet.Contains(v.Path); !ok {
+			changes = append(changes, &TreeChange{Path: v.Path, To: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
@@ -0,0 +1,316 @@
+package objects
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrHashMismatch is returned when the content of an object does not hash
+// to its name
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// CheckError is a syntax error in an object. ID names the check in the
+// manner of git fsck, such as treeNotSorted.
+type CheckError struct {
+	ID      string
+	Message string
+}
+
+func (e *CheckError) Error() string {
+	return fmt.Sprintf("%s: %s", e.ID, e.Message)
+}
+
+// Objects returns the hex hashes of the loose and packed objects in the
+// object store dir, sorted.
+func Objects(dir string) ([][]byte, error) {
+	seen := make(map[string]struct{})
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range entries {
+		if !d.IsDir() || len(d.Name()) != 2 {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(dir, d.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			sha := d.Name() + f.Name()
+			if hashIn(dir).IsHex(sha) {
+				seen[sha] = struct{}{}
+			}
+		}
+	}
+	ps, err := packsIn(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range ps {
+		for _, v := range p.names {
+			seen[hex.EncodeToString(v[:])] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for k := range seen {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	r := make([][]byte, len(names))
+	for i, v := range names {
+		r[i] = []byte(v)
+	}
+	return r, nil
+}
+
+// Verify reads the object named by the hex sha from the object store dir in
+// full, checking that it hashes to sha and that a commit, tree or tag is
+// well-formed. Syntax errors are returned as a *CheckError along with the
+// object type.
+func Verify(dir string, sha []byte) (objectType, error) {
+	obj, err := ReadObjectFrom(dir, sha)
+	if err != nil {
+		return ObjectInvalid, err
+	}
+	content, err := ReadContent(obj)
+	if err != nil {
+		return obj.Typ, err
+	}
+	if len(content) != obj.Length {
+		return obj.Typ, fmt.Errorf("object %s has length %d, not %d", sha, len(content), obj.Length)
+	}
+	switch obj.Typ {
+	case ObjectCommit:
+		err = checkCommit(obj.hash(), content)
+	case ObjectTree:
+		err = checkTree(obj.hash(), content)
+	case ObjectTag:
+		err = checkTag(obj.hash(), content)
+	}
+	return obj.Typ, err
+}
+
+// VerifyPacks checks the trailing checksums of the packs of the object
+// store dir and of their indexes.
+func VerifyPacks(dir string) error {
+	ps, err := packsIn(dir)
+	if err != nil {
+		return err
+	}
+	format := hashIn(dir)
+	for _, p := range ps {
+		pack, err := os.ReadFile(p.path)
+		if err != nil {
+			return err
+		}
+		idxPath := strings.TrimSuffix(p.path, ".pack") + ".idx"
+		idx, err := os.ReadFile(idxPath)
+		if err != nil {
+			return err
+		}
+		if len(pack) < 32 || !bytes.Equal(pack[:4], []byte("PACK")) {
+			return fmt.Errorf("%s is not a pack", p.path)
+		}
+		if !checksummed(format, pack) {
+			return fmt.Errorf("%s pack checksum mismatch", p.path)
+		}
+		if !checksummed(format, idx) {
+			return fmt.Errorf("%s index checksum mismatch", idxPath)
+		}
+		size := format.Size
+		if !bytes.Equal(idx[len(idx)-2*size:len(idx)-size], pack[len(pack)-size:]) {
+			return fmt.Errorf("%s does not match the index %s", p.path, idxPath)
+		}
+	}
+	return nil
+}
+
+// checksummed reports whether b ends with the hash in format of the rest
+// of b.
+func checksummed(h *config.ObjectFormat, b []byte) bool {
+	if len(b) < h.Size {
+		return false
+	}
+	return bytes.Equal(h.Sum(b[:len(b)-h.Size]), b[len(b)-h.Size:])
+}
+
+// checkCommit checks the tree, parent, author and committer lines of a
+// commit and that its headers are terminated.
+func checkCommit(format *config.ObjectFormat, content []byte) error {
+	if err := checkHeaders(content); err != nil {
+		return err
+	}
+	// missing lines are empty rather than out of range
+	lines := append(strings.Split(string(content), "\n"), "", "", "", "")
+	i := 0
+	tree, ok := strings.CutPrefix(lines[i], "tree ")
+	if !ok {
+		return &CheckError{"missingTree", "invalid format - expected 'tree' line"}
+	}
+	if !format.IsHex(tree) {
+		return &CheckError{"badTreeSha1", "invalid 'tree' line format - bad sha1"}
+	}
+	for i++; strings.HasPrefix(lines[i], "parent "); i++ {
+		if !format.IsHex(strings.TrimPrefix(lines[i], "parent ")) {
+			return &CheckError{"badParentSha1", "invalid 'parent' line format - bad sha1"}
+		}
+	}
+	author, ok := strings.CutPrefix(lines[i], "author ")
+	if !ok {
+		return &CheckError{"missingAuthor", "invalid format - expected 'author' line"}
+	}
+	if err := checkIdent(author); err != nil {
+		return err
+	}
+	committer, ok := strings.CutPrefix(lines[i+1], "committer ")
+	if !ok {
+		return &CheckError{"missingCommitter", "invalid format - expected 'committer' line"}
+	}
+	return checkIdent(committer)
+}
+
+// checkTag checks the object, type, tag and tagger lines of a tag.
+func checkTag(format *config.ObjectFormat, content []byte) error {
+	if err := checkHeaders(content); err != nil {
+		return err
+	}
+	// missing lines are empty rather than out of range
+	lines := append(strings.Split(string(content), "\n"), "", "", "", "")
+	object, ok := strings.CutPrefix(lines[0], "object ")
+	if !ok {
+		return &CheckError{"missingObject", "invalid format - expected 'object' line"}
+	}
+	if !format.IsHex(object) {
+		return &CheckError{"badObjectSha1", "invalid 'object' line format - bad sha1"}
+	}
+	typ, ok := strings.CutPrefix(lines[1], "type ")
+	if !ok {
+		return &CheckError{"missingTypeEntry", "invalid format - expected 'type' line"}
+	}
+	switch typ {
+	case "commit", "tree", "blob", "tag":
+	default:
+		return &CheckError{"badType", "invalid 'type' value"}
+	}
+	if !strings.HasPrefix(lines[2], "tag ") {
+		return &CheckError{"missingTagEntry", "invalid format - expected 'tag' line"}
+	}
+	if tagger, ok := strings.CutPrefix(lines[3], "tagger "); ok {
+		return checkIdent(tagger)
+	}
+	return nil
+}
+
+// checkHeaders checks that the headers of a commit or tag do not contain a
+// NUL and end with a newline.
+func checkHeaders(content []byte) error {
+	header, _, found := bytes.Cut(content, []byte("\n\n"))
+	if bytes.IndexByte(header, 0) >= 0 {
+		return &CheckError{"nulInHeader", "unterminated header: NUL in header"}
+	}
+	if !found && !bytes.HasSuffix(content, []byte("\n")) {
+		return &CheckError{"unterminatedHeader", "unterminated header"}
+	}
+	return nil
+}
+
+// checkIdent checks an identity such as Name <email> 1700000000 +0100.
+func checkIdent(ident string) error {
+	i := strings.IndexAny(ident, "<>")
+	switch {
+	case i < 0:
+		return &CheckError{"missingEmail", "invalid author/committer line - missing email"}
+	case ident[i] == '>':
+		return &CheckError{"badName", "invalid author/committer line - bad name"}
+	case i > 0 && ident[i-1] != ' ':
+		return &CheckError{"missingSpaceBeforeEmail", "invalid author/committer line - missing space before email"}
+	}
+	rest := ident[i+1:]
+	j := strings.IndexAny(rest, "<>")
+	if j < 0 || rest[j] != '>' {
+		return &CheckError{"badEmail", "invalid author/committer line - bad email"}
+	}
+	rest = rest[j+1:]
+	date, ok := strings.CutPrefix(rest, " ")
+	if !ok {
+		return &CheckError{"missingSpaceBeforeDate", "invalid author/committer line - missing space before date"}
+	}
+	ts, tz, ok := strings.Cut(date, " ")
+	if len(ts) > 1 && ts[0] == '0' {
+		return &CheckError{"zeroPaddedDate", "invalid author/committer line - zero-padded date"}
+	}
+	if _, err := strconv.ParseUint(ts, 10, 64); err != nil || !ok {
+		return &CheckError{"badDate", "invalid author/committer line - bad date"}
+	}
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') || strings.Trim(tz[1:], "0123456789") != "" {
+		return &CheckError{"badTimezone", "invalid author/committer line - bad time zone"}
+	}
+	return nil
+}
+
+// checkTree checks the modes, names and order of the entries of a tree.
+func checkTree(format *config.ObjectFormat, content []byte) error {
+	var prevName string
+	var prevMode uint64
+	size := format.Size
+	for first := true; len(content) > 0; first = false {
+		sp := bytes.IndexByte(content, ' ')
+		nul := bytes.IndexByte(content, 0)
+		if sp <= 0 || nul < sp || len(content) < nul+1+size {
+			return &CheckError{"badTree", "cannot be parsed as a tree"}
+		}
+		modeStr, name := string(content[:sp]), string(content[sp+1:nul])
+		content = content[nul+1+size:]
+		mode, err := strconv.ParseUint(modeStr, 8, 32)
+		if err != nil {
+			return &CheckError{"badTree", "cannot be parsed as a tree"}
+		}
+		switch {
+		case modeStr[0] == '0':
+			return &CheckError{"zeroPaddedFilemode", "contains zero-padded file modes"}
+		case mode != 0100644 && mode != 0100755 && mode != 0120000 && mode != 040000 && mode != 0160000:
+			return &CheckError{"badFilemode", "contains bad file modes"}
+		case name == "":
+			return &CheckError{"emptyName", "contains empty pathname"}
+		case strings.Contains(name, "/"):
+			return &CheckError{"fullPathname", "contains full pathnames"}
+		case name == ".":
+			return &CheckError{"hasDot", "contains '.'"}
+		case name == "..":
+			return &CheckError{"hasDotdot", "contains '..'"}
+		case strings.EqualFold(name, ".git"):
+			return &CheckError{"hasDotgit", "contains '.git'"}
+		}
+		if !first {
+			switch c := compareEntries(prevName, prevMode, name, mode); {
+			case c == 0 || prevName == name:
+				return &CheckError{"duplicateEntries", "contains duplicate file entries"}
+			case c > 0:
+				return &CheckError{"treeNotSorted", "not properly sorted"}
+			}
+		}
+		prevName, prevMode = name, mode
+	}
+	return nil
+}
+
+// compareEntries compares tree entry names as git orders them, with the
+// names of trees followed by a slash.
+func compareEntries(a string, aMode uint64, b string, bMode uint64) int {
+	if aMode == 040000 {
+		a += "/"
+	}
+	if bMode == 040000 {
+		b += "/"
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,52 @@
+package objects
+
+import (
+	"errors"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_Checks(t *testing.T) {
+	sha := strings.Repeat("a", 40)
+	raw := strings.Repeat("\x01", 20)
+	tests := []struct {
+		name    string
+		check   func(*config.ObjectFormat, []byte) error
+		content string
+		id      string
+	}{
+		{"commit", checkCommit, "tree " + sha + "\nparent " + sha + "\nauthor A <a@b> 1700000000 +0100\ncommitter C <c@d> 1700000000 -0500\n\nmessage\n", ""},
+		{"no tree", checkCommit, "parent " + sha + "\n\n", "missingTree"},
+		{"bad parent", checkCommit, "tree " + sha + "\nparent abc\n\n", "badParentSha1"},
+		{"no committer", checkCommit, "tree " + sha + "\nauthor A <a@b> 1 +0000\n\n", "missingCommitter"},
+		{"unterminated", checkCommit, "tree " + sha, "unterminatedHeader"},
+		{"bad email", checkCommit, "tree " + sha + "\nauthor A <a@b 1 +0000\n\n", "badEmail"},
+		{"no space", checkCommit, "tree " + sha + "\nauthor A<a@b> 1 +0000\n\n", "missingSpaceBeforeEmail"},
+		{"bad date", checkCommit, "tree " + sha + "\nauthor A <a@b> x +0000\n\n", "badDate"},
+		{"zero date", checkCommit, "tree " + sha + "\nauthor A <a@b> 01 +0000\n\n", "zeroPaddedDate"},
+		{"bad timezone", checkCommit, "tree " + sha + "\nauthor A <a@b> 1 0000\n\n", "badTimezone"},
+		{"tag", checkTag, "object " + sha + "\ntype commit\ntag v1\ntagger T <t@t> 1 +0000\n\nmessage\n", ""},
+		{"bad type", checkTag, "object " + sha + "\ntype thing\ntag v1\n\n", "badType"},
+		{"no tag", checkTag, "object " + sha + "\ntype commit\n\n", "missingTagEntry"},
+		{"tree", checkTree, "100644 a.c\x00" + raw + "40000 a\x00" + raw + "100755 b\x00" + raw, ""},
+		{"unsorted", checkTree, "40000 a\x00" + raw + "100644 a.c\x00" + raw, "treeNotSorted"},
+		{"duplicate", checkTree, "100644 a\x00" + raw + "40000 a\x00" + raw, "duplicateEntries"},
+		{"bad mode", checkTree, "100664 a\x00" + raw, "badFilemode"},
+		{"zero padded", checkTree, "040000 a\x00" + raw, "zeroPaddedFilemode"},
+		{"dot git", checkTree, "40000 .GIT\x00" + raw, "hasDotgit"},
+		{"truncated", checkTree, "100644 a\x00" + raw[:10], "badTree"},
+	}
+	for _, tt := range tests {
+		err := tt.check(config.SHA1, []byte(tt.content))
+		if tt.id == "" {
+			assert.NoError(t, err, tt.name)
+			continue
+		}
+		var check *CheckError
+		if assert.True(t, errors.As(err, &check), tt.name) {
+			assert.Equal(t, tt.id, check.ID, tt.name)
+		}
+	}
+}
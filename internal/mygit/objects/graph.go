@@ -0,0 +1,134 @@
+package objects
+
+// Ancestors returns the set of hex encoded commit hashes reachable from sha,
+// including sha itself.
+func Ancestors(sha []byte) (map[string]struct{}, error) {
+	seen := make(map[string]struct{})
+	if sha == nil {
+		return seen, nil
+	}
+	queue := [][]byte{sha}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if _, ok := seen[string(s)]; ok {
+			continue
+		}
+		seen[string(s)] = struct{}{}
+		c, err := ReadCommit(s)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, c.Parents...)
+	}
+	return seen, nil
+}
+
+// AheadBehind returns the number of commits reachable from a but not b, and
+// from b but not a.
+func AheadBehind(a []byte, b []byte) (int, int, error) {
+	as, err := Ancestors(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	bs, err := Ancestors(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	ahead, behind := 0, 0
+	for k := range as {
+		if _, ok := bs[k]; !ok {
+			ahead++
+		}
+	}
+	for k := range bs {
+		if _, ok := as[k]; !ok {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// IsAncestor reports whether the commit a is reachable from the commit b.
+func IsAncestor(a []byte, b []byte) (bool, error) {
+	if !Exists(a) {
+		return false, nil
+	}
+	bs, err := Ancestors(b)
+	if err != nil {
+		return false, err
+	}
+	_, ok := bs[string(a)]
+	return ok, nil
+}
+
+// Reachable returns the hex encoded hashes of the objects in the object store
+// dir reachable from tips which have reports as missing. Objects which have
+// reports as present are assumed to have all of their references present.
+func Reachable(dir string, tips [][]byte, have func(sha []byte) bool) ([][]byte, error) {
+	var r [][]byte
+	seen := make(map[string]struct{})
+	stack := append([][]byte{}, tips...)
+	for len(stack) > 0 {
+		sha := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := seen[string(sha)]; ok {
+			continue
+		}
+		seen[string(sha)] = struct{}{}
+		if have(sha) {
+			continue
+		}
+		obj, err := ReadObjectFrom(dir, sha)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, sha)
+		switch obj.Typ {
+		case ObjectCommit:
+			c, err := readCommit(obj)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, c.Parents...)
+			stack = append(stack, c.Tree)
+		case ObjectTree:
+			t, err := ReadTree(obj)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range t.Items {
+				// submodule commits are in another repository
+				if v.Mode != 0160000 {
+					stack = append(stack, v.Sha)
+				}
+			}
+		case ObjectTag:
+			t, err := readTag(obj)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, t.Object)
+		}
+	}
+	return r, nil
+}
+
+// Peel returns the hash of the object an annotated tag in the object store
+// dir points to, following nested tags, or sha if it is not a tag.
+func Peel(dir string, sha []byte) ([]byte, error) {
+	for {
+		obj, err := ReadObjectFrom(dir, sha)
+		if err != nil {
+			return nil, err
+		}
+		if obj.Typ != ObjectTag {
+			return sha, nil
+		}
+		t, err := readTag(obj)
+		if err != nil {
+			return nil, err
+		}
+		sha = t.Object
+	}
+}
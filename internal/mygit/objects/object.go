@@ -2,7 +2,9 @@ package objects
 
 import (
 	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
 	"io"
+	"path/filepath"
 	"time"
 )
 
@@ -11,11 +13,14 @@ type (
 		Path         string
 		Typ          objectType
 		Sha          []byte
+		Mode         uint32
 		Objects      []*Object
 		Length       int
 		HeaderLength int
 		ReadCloser   func() (io.ReadCloser, error)
-		//mode    string
+		// format is the object format of the object store the object was
+		// read from
+		format *config.ObjectFormat
 	}
 	objectType int
 	Commit     struct {
@@ -28,8 +33,34 @@ type (
 		Committer      string
 		CommitterEmail string
 		CommittedTime  time.Time
-		Sig            []byte
-		Message        []byte
+		// Sig is the value of the signature header followed by a newline
+		Sig []byte
+		// Headers are the header fields of a commit read from the object
+		// store in the order they were written, including those without a
+		// field such as encoding and mergetag.
+		Headers []*Header
+		// Message is the exact bytes following the blank line after the
+		// headers, nil if there is no blank line.
+		Message []byte
+	}
+	// Header is a header field of a commit. The value of a field written
+	// over several lines has its continuation lines joined by newlines.
+	Header struct {
+		Key   string
+		Value []byte
+		// NoSpace is set for a header read without a space after its key,
+		// so that it encodes back without one.
+		NoSpace bool
+	}
+	Tag struct {
+		Sha         []byte
+		Object      []byte
+		Typ         objectType
+		Name        string
+		Tagger      string
+		TaggerEmail string
+		TaggedTime  time.Time
+		Message     []byte
 	}
 	Tree struct {
 		Sha   []byte
@@ -40,6 +71,7 @@ type (
 	TreeItem struct {
 		Sha  []byte
 		Typ  objectType
+		Mode uint32
 		Path string
 	}
 )
@@ -49,8 +81,23 @@ const (
 	ObjectBlob
 	ObjectTree
 	ObjectCommit
+	ObjectTag
 )
 
+func (t objectType) String() string {
+	switch t {
+	case ObjectBlob:
+		return "blob"
+	case ObjectTree:
+		return "tree"
+	case ObjectCommit:
+		return "commit"
+	case ObjectTag:
+		return "tag"
+	}
+	return "invalid"
+}
+
 func (c Commit) String() string {
 	var o string
 	o += fmt.Sprintf("commit: %s\n", string(c.Sha))
@@ -63,3 +110,18 @@ func (c Commit) String() string {
 	o += fmt.Sprintf("message: \n%s\n", c.Message)
 	return o
 }
+
+// hash returns the object format of the object store o was read from, that
+// of the repository when it was not read.
+func (o *Object) hash() *config.ObjectFormat {
+	if o.format != nil {
+		return o.format
+	}
+	return config.Hash()
+}
+
+// hashIn returns the object format of the repository of the object store
+// dir.
+func hashIn(dir string) *config.ObjectFormat {
+	return config.HashOf(filepath.Dir(dir))
+}
@@ -0,0 +1,499 @@
+package objects
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	packObjectCommit   = 1
+	packObjectTree     = 2
+	packObjectBlob     = 3
+	packObjectTag      = 4
+	packObjectOfsDelta = 6
+	packObjectRefDelta = 7
+
+	// packCacheSize is the number of resolved delta bases kept in memory
+	packCacheSize = 256
+)
+
+var (
+	errObjectNotFound = errors.New("object not found")
+
+	packIdxMagic = []byte{0xff, 't', 'O', 'c'}
+
+	// packs caches parsed pack indexes by path
+	packs   = map[string]*pack{}
+	packsMu sync.Mutex
+)
+
+type (
+	// pack is a packfile with its version 2 index. Object names are sorted
+	// so that they can be found by binary search.
+	pack struct {
+		path string
+		// format is the object format of the repository of the pack
+		format  *config.ObjectFormat
+		names   [][]byte
+		offsets []int64
+		mu      sync.Mutex
+		cache   map[int64]*packObject
+	}
+	packObject struct {
+		typ     objectType
+		content []byte
+	}
+)
+
+// packsIn returns the packs in the pack directory of the object store dir.
+func packsIn(dir string) ([]*pack, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, "pack"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var r []*pack
+	for _, v := range entries {
+		if v.IsDir() || !strings.HasSuffix(v.Name(), ".idx") {
+			continue
+		}
+		p, err := openPack(dir, filepath.Join(dir, "pack", strings.TrimSuffix(v.Name(), ".idx")+".pack"))
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, p)
+	}
+	return r, nil
+}
+
+func openPack(dir string, path string) (*pack, error) {
+	packsMu.Lock()
+	defer packsMu.Unlock()
+	if p, ok := packs[path]; ok {
+		return p, nil
+	}
+	p := &pack{path: path, format: hashIn(dir), cache: map[int64]*packObject{}}
+	if err := p.readIndex(strings.TrimSuffix(path, ".pack") + ".idx"); err != nil {
+		return nil, err
+	}
+	packs[path] = p
+	return p, nil
+}
+
+// readIndex reads a version 2 pack index.
+func (p *pack) readIndex(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(b) < 8+256*4 || !bytes.Equal(b[0:4], packIdxMagic) {
+		return fmt.Errorf("unsupported pack index %s", path)
+	}
+	if v := binary.BigEndian.Uint32(b[4:8]); v != 2 {
+		return fmt.Errorf("unsupported pack index version %d", v)
+	}
+	size := p.format.Size
+	n := int(binary.BigEndian.Uint32(b[8+255*4:]))
+	names := 8 + 256*4
+	crcs := names + n*size
+	offsets := crcs + n*4
+	large := offsets + n*4
+	if len(b) < large+2*size {
+		return fmt.Errorf("invalid pack index %s", path)
+	}
+	p.names = make([][]byte, n)
+	p.offsets = make([]int64, n)
+	for i := 0; i < n; i++ {
+		p.names[i] = b[names+i*size : names+(i+1)*size]
+		off := binary.BigEndian.Uint32(b[offsets+i*4:])
+		if off&0x80000000 == 0 {
+			p.offsets[i] = int64(off)
+			continue
+		}
+		// the offset is an index into the table of 64-bit offsets
+		j := large + int(off&0x7fffffff)*8
+		if j+8 > len(b) {
+			return fmt.Errorf("invalid pack index %s", path)
+		}
+		p.offsets[i] = int64(binary.BigEndian.Uint64(b[j:]))
+	}
+	return nil
+}
+
+// find returns the offset of the object named by the raw sha.
+func (p *pack) find(sha []byte) (int64, bool) {
+	i := sort.Search(len(p.names), func(i int) bool {
+		return bytes.Compare(p.names[i], sha) >= 0
+	})
+	if i < len(p.names) && bytes.Equal(p.names[i], sha) {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// read returns the object at offset with deltas resolved. Base objects of
+// other packs or loose objects are read from the object store dir.
+func (p *pack) read(dir string, offset int64) (*packObject, error) {
+	p.mu.Lock()
+	o, ok := p.cache[offset]
+	p.mu.Unlock()
+	if ok {
+		return o, nil
+	}
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	o, err = p.readAt(dir, f, offset)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	if len(p.cache) >= packCacheSize {
+		p.cache = map[int64]*packObject{}
+	}
+	p.cache[offset] = o
+	p.mu.Unlock()
+	return o, nil
+}
+
+func (p *pack) readAt(dir string, f *os.File, offset int64) (*packObject, error) {
+	r := bufio.NewReader(io.NewSectionReader(f, offset, 1<<62))
+	typ, size, err := readPackObjectHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	var base *packObject
+	switch typ {
+	case packObjectOfsDelta:
+		rel, err := readOffset(r)
+		if err != nil {
+			return nil, err
+		}
+		if base, err = p.read(dir, offset-rel); err != nil {
+			return nil, err
+		}
+	case packObjectRefDelta:
+		sha := make([]byte, p.format.Size)
+		if _, err := io.ReadFull(r, sha); err != nil {
+			return nil, err
+		}
+		if base, err = p.readBase(dir, sha); err != nil {
+			return nil, err
+		}
+	}
+	content, err := inflate(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		t, err := packObjectType(typ)
+		if err != nil {
+			return nil, err
+		}
+		return &packObject{typ: t, content: content}, nil
+	}
+	content, err = applyDelta(base.content, content)
+	if err != nil {
+		return nil, err
+	}
+	return &packObject{typ: base.typ, content: content}, nil
+}
+
+// readBase reads a delta base named by the raw sha from this pack or
+// otherwise from the object store.
+func (p *pack) readBase(dir string, sha []byte) (*packObject, error) {
+	if off, ok := p.find(sha); ok {
+		return p.read(dir, off)
+	}
+	obj, err := ReadObjectFrom(dir, []byte(hex.EncodeToString(sha)))
+	if err != nil {
+		return nil, err
+	}
+	content, err := ReadContent(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &packObject{typ: obj.Typ, content: content}, nil
+}
+
+// readPackObjectHeader reads the type and inflated size of a pack entry.
+func readPackObjectHeader(r io.ByteReader) (int, int64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	typ := int(c>>4) & 7
+	size := int64(c & 0x0f)
+	shift := 4
+	for c&0x80 != 0 {
+		if c, err = r.ReadByte(); err != nil {
+			return 0, 0, err
+		}
+		size |= int64(c&0x7f) << shift
+		shift += 7
+	}
+	return typ, size, nil
+}
+
+// readOffset reads the negative relative offset of an ofs-delta base.
+func readOffset(r io.ByteReader) (int64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	off := int64(c & 0x7f)
+	for c&0x80 != 0 {
+		if c, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		off = ((off + 1) << 7) | int64(c&0x7f)
+	}
+	return off, nil
+}
+
+func packObjectType(typ int) (objectType, error) {
+	switch typ {
+	case packObjectCommit:
+		return ObjectCommit, nil
+	case packObjectTree:
+		return ObjectTree, nil
+	case packObjectBlob:
+		return ObjectBlob, nil
+	case packObjectTag:
+		return ObjectTag, nil
+	}
+	return ObjectInvalid, fmt.Errorf("invalid pack object type %d", typ)
+}
+
+func inflate(r io.Reader, size int64) ([]byte, error) {
+	z, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = z.Close() }()
+	b := make([]byte, size)
+	if _, err := io.ReadFull(z, b); err != nil {
+		return nil, err
+	}
+	// read to the end of the stream so that the checksum is consumed
+	if extra, err := io.Copy(io.Discard, z); err != nil {
+		return nil, err
+	} else if extra > 0 {
+		return nil, errors.New("inflated object larger than its size")
+	}
+	return b, nil
+}
+
+// applyDelta reconstructs an object from base and a delta of copy and insert
+// instructions.
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	srcSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != uint64(len(base)) {
+		return nil, errors.New("delta base size mismatch")
+	}
+	dstSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, dstSize)
+	for {
+		c, err := r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch {
+		case c&0x80 != 0:
+			// copy from base, the low bits say which offset and size bytes follow
+			var off, size uint32
+			for i := uint(0); i < 7; i++ {
+				if c&(1<<i) == 0 {
+					continue
+				}
+				b, err := r.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				if i < 4 {
+					off |= uint32(b) << (8 * i)
+				} else {
+					size |= uint32(b) << (8 * (i - 4))
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if uint64(off)+uint64(size) > uint64(len(base)) {
+				return nil, errors.New("delta copy out of range")
+			}
+			out = append(out, base[off:off+size]...)
+		case c != 0:
+			// insert the next c bytes
+			b := make([]byte, c)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return nil, err
+			}
+			out = append(out, b...)
+		default:
+			return nil, errors.New("invalid delta instruction")
+		}
+	}
+	if uint64(len(out)) != dstSize {
+		return nil, errors.New("delta result size mismatch")
+	}
+	return out, nil
+}
+
+// readPackedObject reads the object named by the hex sha from the packs of
+// the object store dir.
+func readPackedObject(dir string, sha []byte) (*Object, error) {
+	raw, err := hex.DecodeString(string(sha))
+	if err != nil {
+		return nil, err
+	}
+	ps, err := packsIn(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range ps {
+		off, ok := p.find(raw)
+		if !ok {
+			continue
+		}
+		po, err := p.read(dir, off)
+		if err != nil {
+			return nil, err
+		}
+		header := []byte(fmt.Sprintf("%s %d%s", po.typ, len(po.content), string(byte(0))))
+		return &Object{
+			Sha:          sha,
+			format:       p.format,
+			Typ:          po.typ,
+			Length:       len(po.content),
+			HeaderLength: len(header),
+			ReadCloser: func() (io.ReadCloser, error) {
+				return io.NopCloser(io.MultiReader(bytes.NewReader(header), bytes.NewReader(po.content))), nil
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", errObjectNotFound, sha)
+}
+
+// openPackedObject opens the object named by the hex sha in the packs of the
+// object store dir, returning its type and size and a reader of its content.
+func openPackedObject(dir string, sha []byte) (objectType, int64, io.ReadCloser, error) {
+	raw, err := hex.DecodeString(string(sha))
+	if err != nil {
+		return ObjectInvalid, 0, nil, err
+	}
+	ps, err := packsIn(dir)
+	if err != nil {
+		return ObjectInvalid, 0, nil, err
+	}
+	for _, p := range ps {
+		if off, ok := p.find(raw); ok {
+			return p.open(dir, off)
+		}
+	}
+	return ObjectInvalid, 0, nil, fmt.Errorf("%w: %s", errObjectNotFound, sha)
+}
+
+// open opens the object at offset for streaming. A whole object is inflated
+// from the pack as it is read, only a deltified object is resolved in memory
+// along with its bases.
+func (p *pack) open(dir string, offset int64) (objectType, int64, io.ReadCloser, error) {
+	p.mu.Lock()
+	o, ok := p.cache[offset]
+	p.mu.Unlock()
+	if !ok {
+		f, err := os.Open(p.path)
+		if err != nil {
+			return ObjectInvalid, 0, nil, err
+		}
+		r := bufio.NewReader(io.NewSectionReader(f, offset, 1<<62))
+		typ, size, err := readPackObjectHeader(r)
+		if err != nil {
+			_ = f.Close()
+			return ObjectInvalid, 0, nil, err
+		}
+		if typ != packObjectOfsDelta && typ != packObjectRefDelta {
+			t, err := packObjectType(typ)
+			if err != nil {
+				_ = f.Close()
+				return ObjectInvalid, 0, nil, err
+			}
+			z, err := zlib.NewReader(r)
+			if err != nil {
+				_ = f.Close()
+				return ObjectInvalid, 0, nil, err
+			}
+			return t, size, &looseReadCloser{ReadCloser: z, f: f}, nil
+		}
+		_ = f.Close()
+		if o, err = p.read(dir, offset); err != nil {
+			return ObjectInvalid, 0, nil, err
+		}
+	}
+	return o.typ, int64(len(o.content)), io.NopCloser(bytes.NewReader(o.content)), nil
+}
+
+// packed reports whether the object named by the hex sha is in a pack of the
+// object store dir.
+func packed(dir string, sha []byte) bool {
+	raw, err := hex.DecodeString(string(sha))
+	if err != nil {
+		return false
+	}
+	ps, err := packsIn(dir)
+	if err != nil {
+		return false
+	}
+	for _, p := range ps {
+		if _, ok := p.find(raw); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// withPrefix returns the hex hashes of the objects in the pack starting with
+// the hex prefix.
+func (p *pack) withPrefix(prefix string) [][]byte {
+	even := prefix[:len(prefix)&^1]
+	raw, err := hex.DecodeString(even)
+	if err != nil {
+		return nil
+	}
+	var r [][]byte
+	i := sort.Search(len(p.names), func(i int) bool {
+		return bytes.Compare(p.names[i], raw) >= 0
+	})
+	for ; i < len(p.names) && bytes.HasPrefix(p.names[i], raw); i++ {
+		sha := []byte(hex.EncodeToString(p.names[i]))
+		if bytes.HasPrefix(sha, []byte(prefix)) {
+			r = append(r, sha)
+		}
+	}
+	return r
+}
@@ -0,0 +1,151 @@
+package objects
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func Test_OpenPackedObject(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "objects")
+	base := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	// the delta copies the first 16 bytes of base and inserts "!"
+	delta := binary.AppendUvarint(nil, uint64(len(base)))
+	delta = binary.AppendUvarint(delta, 17)
+	delta = append(delta, 0x80|0x10, 16, 1, '!')
+	derived := append(append([]byte{}, base[:16]...), '!')
+	shas := testPack(t, dir, base, delta, derived)
+	assert.NoError(t, VerifyPacks(dir))
+
+	tests := []struct {
+		name    string
+		sha     []byte
+		content []byte
+		cached  bool
+	}{
+		{"whole", shas[0], base, false},
+		{"ofs delta", shas[1], derived, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := OpenObjectFrom(dir, tt.sha)
+			assert.NoError(t, err)
+			assert.Equal(t, ObjectBlob, r.Typ)
+			assert.Equal(t, int64(len(tt.content)), r.Size)
+			b, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.NoError(t, r.Close())
+			assert.Equal(t, tt.content, b)
+			// only deltified objects and their bases are resolved in memory
+			ps, err := packsIn(dir)
+			assert.NoError(t, err)
+			off, _ := ps[0].find(testRaw(tt.sha))
+			_, cached := ps[0].cache[off]
+			assert.Equal(t, tt.cached, cached)
+		})
+	}
+}
+
+// testPack writes a pack and its index to the object store dir holding the
+// blob base followed by an ofs-delta against it which results in derived,
+// returning the hex hashes of the two objects.
+func testPack(t *testing.T, dir string, base []byte, delta []byte, derived []byte) [][]byte {
+	pack := bytes.NewBuffer(nil)
+	pack.WriteString("PACK")
+	_ = binary.Write(pack, binary.BigEndian, []uint32{2, 2})
+	offsets := []int64{int64(pack.Len())}
+	testPackEntry(pack, packObjectBlob, base)
+	offsets = append(offsets, int64(pack.Len()))
+	testPackEntry(pack, packObjectOfsDelta, delta, testOffset(offsets[1]-offsets[0])...)
+	trailer := sha1.Sum(pack.Bytes())
+	pack.Write(trailer[:])
+
+	var shas [][]byte
+	byName := map[string]int64{}
+	for i, content := range [][]byte{base, derived} {
+		sum := sha1.Sum(append([]byte(fmt.Sprintf("blob %d\x00", len(content))), content...))
+		shas = append(shas, []byte(fmt.Sprintf("%x", sum)))
+		byName[string(sum[:])] = offsets[i]
+	}
+	names := make([]string, 0, len(byName))
+	for k := range byName {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	idx := bytes.NewBuffer(nil)
+	idx.Write(packIdxMagic)
+	_ = binary.Write(idx, binary.BigEndian, uint32(2))
+	for i := 0; i < 256; i++ {
+		var n uint32
+		for _, v := range names {
+			if int(v[0]) <= i {
+				n++
+			}
+		}
+		_ = binary.Write(idx, binary.BigEndian, n)
+	}
+	for _, v := range names {
+		idx.WriteString(v)
+	}
+	for range names {
+		_ = binary.Write(idx, binary.BigEndian, uint32(0))
+	}
+	for _, v := range names {
+		_ = binary.Write(idx, binary.BigEndian, uint32(byName[v]))
+	}
+	idx.Write(trailer[:])
+	sum := sha1.Sum(idx.Bytes())
+	idx.Write(sum[:])
+
+	path := filepath.Join(dir, "pack", "pack-test")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".pack", pack.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".idx", idx.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return shas
+}
+
+// testPackEntry appends an entry of typ to pack, the header followed by
+// extra such as a delta base offset and then the deflated content.
+func testPackEntry(pack *bytes.Buffer, typ int, content []byte, extra ...byte) {
+	size := len(content)
+	c := byte(typ<<4) | byte(size&0x0f)
+	for size >>= 4; size > 0; size >>= 7 {
+		pack.WriteByte(c | 0x80)
+		c = byte(size & 0x7f)
+	}
+	pack.WriteByte(c)
+	pack.Write(extra)
+	z := zlib.NewWriter(pack)
+	_, _ = z.Write(content)
+	_ = z.Close()
+}
+
+// testOffset encodes the relative offset of an ofs-delta base.
+func testOffset(rel int64) []byte {
+	b := []byte{byte(rel & 0x7f)}
+	for rel >>= 7; rel > 0; rel >>= 7 {
+		rel--
+		b = append([]byte{0x80 | byte(rel&0x7f)}, b...)
+	}
+	return b
+}
+
+func testRaw(sha []byte) []byte {
+	raw, _ := hex.DecodeString(string(sha))
+	return raw
+}
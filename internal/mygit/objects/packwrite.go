@@ -0,0 +1,235 @@
+package objects
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// WritePack writes a version 2 pack of the objects named by the hex hashes
+// shas, read from the object store dir, followed by the pack checksum.
+// Objects are stored whole, without deltas.
+func WritePack(w io.Writer, dir string, shas [][]byte) error {
+	h := hashIn(dir).New()
+	mw := io.MultiWriter(w, h)
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(shas)))
+	if _, err := mw.Write(header); err != nil {
+		return err
+	}
+	for _, sha := range shas {
+		if err := writePackObject(mw, dir, sha); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(h.Sum(nil))
+	return err
+}
+
+// writePackObject streams the object named by the hex sha from the object
+// store dir to w as a pack entry.
+func writePackObject(w io.Writer, dir string, sha []byte) error {
+	r, err := OpenObjectFrom(dir, sha)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	if _, err := w.Write(packObjectHeader(packType(r.Typ), int(r.Size))); err != nil {
+		return err
+	}
+	z := zlib.NewWriter(w)
+	if _, err := io.Copy(z, r); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+func packType(t objectType) int {
+	switch t {
+	case ObjectCommit:
+		return packObjectCommit
+	case ObjectTree:
+		return packObjectTree
+	case ObjectBlob:
+		return packObjectBlob
+	case ObjectTag:
+		return packObjectTag
+	}
+	return 0
+}
+
+// packObjectHeader encodes the type and size of a pack entry, the size is
+// spread over the low 4 bits of the first byte and 7 bits of each following
+// byte.
+func packObjectHeader(typ int, size int) []byte {
+	b := []byte{byte(typ<<4) | byte(size&0x0f)}
+	size >>= 4
+	for size > 0 {
+		b[len(b)-1] |= 0x80
+		b = append(b, byte(size&0x7f))
+		size >>= 7
+	}
+	return b
+}
+
+// hashingReader hashes and counts bytes as they are consumed so that the
+// position of each pack entry and the pack checksum are known exactly.
+type hashingReader struct {
+	r *bufio.Reader
+	h hash.Hash
+	n int64
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.h.Write(p[:n])
+	h.n += int64(n)
+	return n, err
+}
+
+func (h *hashingReader) ReadByte() (byte, error) {
+	b, err := h.r.ReadByte()
+	if err == nil {
+		h.h.Write([]byte{b})
+		h.n++
+	}
+	return b, err
+}
+
+// UnpackObjects reads a pack from r and writes each object to the object store
+// dir as a loose object, returning the hex hashes of the objects. Deltas may
+// refer to objects already in dir, as in the thin packs sent by servers.
+func UnpackObjects(r io.Reader, dir string) ([][]byte, error) {
+	format := hashIn(dir)
+	hr := &hashingReader{r: bufio.NewReader(r), h: format.New()}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(hr, header); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "PACK" {
+		return nil, errors.New("invalid pack signature")
+	}
+	if v := binary.BigEndian.Uint32(header[4:]); v != 2 && v != 3 {
+		return nil, fmt.Errorf("unsupported pack version %d", v)
+	}
+	n := int(binary.BigEndian.Uint32(header[8:]))
+	type pending struct {
+		base  []byte
+		delta []byte
+		off   int64
+	}
+	var shas [][]byte
+	var waiting []*pending
+	offsets := make(map[int64][]byte)
+	resolve := func(base []byte, delta []byte, off int64) error {
+		obj, err := ReadObjectFrom(dir, base)
+		if err != nil {
+			return err
+		}
+		content, err := ReadContent(obj)
+		if err != nil {
+			return err
+		}
+		if content, err = applyDelta(content, delta); err != nil {
+			return err
+		}
+		sha, err := writeLoose(dir, obj.Typ, content)
+		if err != nil {
+			return err
+		}
+		offsets[off] = sha
+		shas = append(shas, sha)
+		return nil
+	}
+	for i := 0; i < n; i++ {
+		off := hr.n
+		typ, size, err := readPackObjectHeader(hr)
+		if err != nil {
+			return nil, err
+		}
+		var base []byte
+		switch typ {
+		case packObjectOfsDelta:
+			rel, err := readOffset(hr)
+			if err != nil {
+				return nil, err
+			}
+			if base = offsets[off-rel]; base == nil {
+				return nil, fmt.Errorf("delta base at offset %d not found", off-rel)
+			}
+		case packObjectRefDelta:
+			raw := make([]byte, format.Size)
+			if _, err := io.ReadFull(hr, raw); err != nil {
+				return nil, err
+			}
+			base = []byte(hex.EncodeToString(raw))
+		}
+		content, err := inflate(hr, size)
+		if err != nil {
+			return nil, err
+		}
+		if base == nil {
+			t, err := packObjectType(typ)
+			if err != nil {
+				return nil, err
+			}
+			sha, err := writeLoose(dir, t, content)
+			if err != nil {
+				return nil, err
+			}
+			offsets[off] = sha
+			shas = append(shas, sha)
+			continue
+		}
+		if !ExistsIn(dir, base) {
+			// the base may come later in the pack
+			waiting = append(waiting, &pending{base: base, delta: content, off: off})
+			continue
+		}
+		if err := resolve(base, content, off); err != nil {
+			return nil, err
+		}
+	}
+	sum := hr.h.Sum(nil)
+	trailer := make([]byte, format.Size)
+	if _, err := io.ReadFull(hr.r, trailer); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sum, trailer) {
+		return nil, errors.New("pack checksum mismatch")
+	}
+	for len(waiting) > 0 {
+		var next []*pending
+		for _, v := range waiting {
+			if !ExistsIn(dir, v.base) {
+				next = append(next, v)
+				continue
+			}
+			if err := resolve(v.base, v.delta, v.off); err != nil {
+				return nil, err
+			}
+		}
+		if len(next) == len(waiting) {
+			return nil, fmt.Errorf("delta base %s not found", next[0].base)
+		}
+		waiting = next
+	}
+	return shas, nil
+}
+
+func writeLoose(dir string, t objectType, content []byte) ([]byte, error) {
+	header := []byte(fmt.Sprintf("%s %d%s", t, len(content), string(byte(0))))
+	sha, err := WriteObject(header, content, "", dir)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(sha)), nil
+}
@@ -8,11 +8,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/date"
 	"github.com/richardjennings/mygit/internal/mygit/gfs"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,7 +24,7 @@ func (o *Object) FlattenTree() []*gfs.File {
 	var objFiles []*gfs.File
 	if o.Typ == ObjectBlob {
 		s, _ := gfs.NewSha(o.Sha)
-		f := []*gfs.File{{Path: o.Path, Sha: s}}
+		f := []*gfs.File{{Path: o.Path, Sha: s, Mode: o.Mode}}
 		return f
 	}
 	for _, v := range o.Objects {
@@ -35,10 +38,24 @@ func (o *Object) FlattenTree() []*gfs.File {
 	return objFiles
 }
 
+// ReadObject reads the header of an object from the object store
 func ReadObject(sha []byte) (*Object, error) {
+	return ReadObjectFrom(config.ObjectPath(), sha)
+}
+
+// ReadObjectFrom reads the header of a loose or packed object from the object
+// store in directory dir.
+func ReadObjectFrom(dir string, sha []byte) (*Object, error) {
 	var err error
-	o := &Object{Sha: sha}
-	o.ReadCloser = ObjectReadCloser(sha)
+	format := hashIn(dir)
+	if len(sha) != format.HexSize() {
+		return nil, fmt.Errorf("invalid object name %s", sha)
+	}
+	if _, err := os.Stat(looseObjectPath(dir, sha)); errors.Is(err, os.ErrNotExist) {
+		return readPackedObject(dir, sha)
+	}
+	o := &Object{Sha: sha, format: format}
+	o.ReadCloser = objectReadCloser(dir, sha)
 	z, err := o.ReadCloser()
 	if err != nil {
 		return o, err
@@ -50,32 +67,201 @@ func ReadObject(sha []byte) (*Object, error) {
 		return nil, err
 	}
 	o.HeaderLength = len(p)
-	header := bytes.Fields(p)
+	o.Typ, o.Length, err = parseHeader(p)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
 
-	switch string(header[0]) {
+// parseHeader returns the type and content length of an object header such
+// as "blob 12\x00".
+func parseHeader(p []byte) (objectType, int, error) {
+	typ, size, ok := bytes.Cut(bytes.TrimSuffix(p, []byte{0}), []byte(" "))
+	if !ok {
+		return ObjectInvalid, 0, fmt.Errorf("invalid object header %q", p)
+	}
+	var t objectType
+	switch string(typ) {
 	case "commit":
-		o.Typ = ObjectCommit
+		t = ObjectCommit
 	case "tree":
-		o.Typ = ObjectTree
+		t = ObjectTree
 	case "blob":
-		o.Typ = ObjectBlob
+		t = ObjectBlob
+	case "tag":
+		t = ObjectTag
 	default:
-		return nil, fmt.Errorf("unknown %s", string(header[0]))
+		return ObjectInvalid, 0, fmt.Errorf("unknown %s", typ)
 	}
-	o.Length, err = strconv.Atoi(string(header[1][:len(header[1])-1]))
-	return o, err
+	n, err := strconv.Atoi(string(size))
+	if err != nil || n < 0 {
+		return ObjectInvalid, 0, fmt.Errorf("invalid object header %q", p)
+	}
+	return t, n, nil
+}
+
+// ObjectReader streams the content of an object, its header having been
+// read. Reading to the end checks that the object hashes to its name.
+type ObjectReader struct {
+	Typ  objectType
+	Size int64
+	sha  []byte
+	r    *bufio.Reader
+	h    hash.Hash
+	// remaining is the number of bytes of content not yet read
+	remaining int64
+	closer    io.Closer
+}
+
+// OpenObject opens the object named by the hex sha for streaming.
+func OpenObject(sha []byte) (*ObjectReader, error) {
+	return OpenObjectFrom(config.ObjectPath(), sha)
+}
+
+// OpenObjectFrom opens the object named by the hex sha in the object store
+// dir for streaming. Loose objects and whole packed objects are decompressed
+// as they are read, deltified packed objects are resolved in memory.
+func OpenObjectFrom(dir string, sha []byte) (*ObjectReader, error) {
+	format := hashIn(dir)
+	if len(sha) != format.HexSize() {
+		return nil, fmt.Errorf("invalid object name %s", sha)
+	}
+	h := format.New()
+	if _, err := os.Stat(looseObjectPath(dir, sha)); errors.Is(err, os.ErrNotExist) {
+		typ, size, rc, err := openPackedObject(dir, sha)
+		if err != nil {
+			return nil, err
+		}
+		_, _ = fmt.Fprintf(h, "%s %d\x00", typ, size)
+		return &ObjectReader{Typ: typ, Size: size, sha: sha, r: bufio.NewReader(rc), h: h, remaining: size, closer: rc}, nil
+	}
+	rc, err := objectReadCloser(dir, sha)()
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(rc)
+	p, err := r.ReadBytes(0)
+	if err != nil {
+		_ = rc.Close()
+		return nil, fmt.Errorf("invalid object %s", sha)
+	}
+	typ, size, err := parseHeader(p)
+	if err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+	_, _ = h.Write(p)
+	return &ObjectReader{Typ: typ, Size: int64(size), sha: sha, r: r, h: h, remaining: int64(size), closer: rc}, nil
+}
+
+// Read reads the content of the object. At the end of the content an error
+// wrapping ErrHashMismatch is returned instead of io.EOF if the object does
+// not hash to its name.
+func (o *ObjectReader) Read(p []byte) (int, error) {
+	if o.remaining == 0 {
+		if hex.EncodeToString(o.h.Sum(nil)) != string(o.sha) {
+			return 0, fmt.Errorf("%w for %s", ErrHashMismatch, o.sha)
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > o.remaining {
+		p = p[:o.remaining]
+	}
+	n, err := o.r.Read(p)
+	_, _ = o.h.Write(p[:n])
+	o.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		if o.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func (o *ObjectReader) Close() error {
+	return o.closer.Close()
+}
+
+// Exists reports whether the object named by the hex sha is in the object
+// store.
+func Exists(sha []byte) bool {
+	return ExistsIn(config.ObjectPath(), sha)
+}
+
+// ExistsIn reports whether the object named by the hex sha is in the object
+// store in directory dir, either loose or packed.
+func ExistsIn(dir string, sha []byte) bool {
+	if len(sha) != hashIn(dir).HexSize() {
+		return false
+	}
+	if _, err := os.Stat(looseObjectPath(dir, sha)); err == nil {
+		return true
+	}
+	return packed(dir, sha)
+}
+
+// ReadContent returns the content of an object without its header, checking
+// that the object hashes to its name.
+func ReadContent(obj *Object) ([]byte, error) {
+	r, err := obj.ReadCloser()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < obj.HeaderLength {
+		return nil, fmt.Errorf("invalid object %s", obj.Sha)
+	}
+	// the content must hash to the name of the object it was read for
+	if h := obj.hash(); len(obj.Sha) == h.HexSize() {
+		if hex.EncodeToString(h.Sum(b)) != string(obj.Sha) {
+			return nil, fmt.Errorf("%w for %s", ErrHashMismatch, obj.Sha)
+		}
+	}
+	return b[obj.HeaderLength:], nil
 }
 
 func ObjectReadCloser(sha []byte) func() (io.ReadCloser, error) {
+	return objectReadCloser(config.ObjectPath(), sha)
+}
+
+func objectReadCloser(dir string, sha []byte) func() (io.ReadCloser, error) {
 	return func() (io.ReadCloser, error) {
-		path := filepath.Join(config.ObjectPath(), string(sha[0:2]), string(sha[2:]))
-		f, err := os.OpenFile(path, os.O_RDONLY, 0644)
+		f, err := os.OpenFile(looseObjectPath(dir, sha), os.O_RDONLY, 0644)
 		if err != nil {
 			return nil, err
 		}
-		defer func() { _ = f.Close() }()
-		return zlib.NewReader(f)
+		z, err := zlib.NewReader(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return &looseReadCloser{ReadCloser: z, f: f}, nil
+	}
+}
+
+// looseReadCloser closes the loose object or pack file along with the zlib
+// reader
+type looseReadCloser struct {
+	io.ReadCloser
+	f *os.File
+}
+
+func (l *looseReadCloser) Close() error {
+	err := l.ReadCloser.Close()
+	if ferr := l.f.Close(); err == nil {
+		err = ferr
 	}
+	return err
+}
+
+func looseObjectPath(dir string, sha []byte) string {
+	return filepath.Join(dir, string(sha[0:2]), string(sha[2:]))
 }
 
 // ReadObjectTree reads an object from the object store
@@ -102,11 +288,17 @@ func ReadObjectTree(sha []byte) (*Object, error) {
 			return nil, err
 		}
 		for _, v := range tree.Items {
+			if v.Typ == ObjectBlob {
+				// blobs and submodule commits are not read
+				obj.Objects = append(obj.Objects, &Object{Sha: v.Sha, Typ: v.Typ, Path: v.Path, Mode: v.Mode})
+				continue
+			}
 			o, err := ReadObjectTree(v.Sha)
 			if err != nil {
 				return nil, err
 			}
 			o.Path = v.Path
+			o.Mode = v.Mode
 			if o.Typ != v.Typ {
 				return nil, errors.New("types did not match somehow")
 			}
@@ -141,9 +333,9 @@ func ReadTree(obj *Object) (*Tree, error) {
 		return nil, err
 	}
 	//
-	sha := make([]byte, 20)
+	sha := make([]byte, obj.hash().Size)
 	buf := bufio.NewReader(r)
-	// there should be a null byte after file path, then 20 byte sha
+	// there should be a null byte after file path, then the raw sha
 	for {
 		itm := &TreeItem{}
 		p, err = buf.ReadBytes(0)
@@ -155,8 +347,16 @@ func ReadTree(obj *Object) (*Tree, error) {
 			return nil, err
 		}
 		_, err = io.ReadFull(buf, sha)
-		item := bytes.Fields(p)
+		item := bytes.SplitN(p, []byte(" "), 2)
+		if len(item) != 2 {
+			return nil, fmt.Errorf("invalid tree entry in %s", obj.Sha)
+		}
 		itm.Sha = []byte(hex.EncodeToString(sha))
+		mode, perr := strconv.ParseUint(string(item[0]), 8, 32)
+		if perr != nil {
+			return nil, perr
+		}
+		itm.Mode = uint32(mode)
 		if string(item[0]) == "40000" {
 			itm.Typ = ObjectTree
 			if err != nil {
@@ -176,7 +376,8 @@ func ReadTree(obj *Object) (*Tree, error) {
 }
 
 func ReadHeadBytes(r io.ReadCloser, obj *Object) error {
-	n, err := r.Read(make([]byte, obj.HeaderLength))
+	// a single read of a zlib stream may return less than the header
+	n, err := io.ReadFull(r, make([]byte, obj.HeaderLength))
 	if err != nil {
 		return err
 	}
@@ -200,115 +401,179 @@ func ReadCommit(sha []byte) (*Commit, error) {
 // the author/committer information (which uses your user.name and user.email configuration settings and a timestamp);
 // a blank line, and then the commit message.
 func readCommit(obj *Object) (*Commit, error) {
-	r, err := obj.ReadCloser()
+	content, err := ReadContent(obj)
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = r.Close() }()
-	if err := ReadHeadBytes(r, obj); err != nil {
-		return nil, err
-	}
-	c := &Commit{Sha: obj.Sha}
-
-	s := bufio.NewScanner(r)
-	gpgsig := false
+	return ParseCommit(obj.Sha, content)
+}
 
-	for {
-		if !s.Scan() {
-			break
-		}
-		l := s.Bytes()
-		p := bytes.SplitN(l, []byte(" "), 2)
-		t := string(p[0])
-		if c.Tree == nil {
-			if t != "tree" {
-				return nil, fmt.Errorf("expected tree got %s", t)
+// ParseCommit parses the content of the commit object named sha, keeping
+// every header in order and the exact bytes of the message so that the
+// commit encodes back to content.
+func ParseCommit(sha []byte, content []byte) (*Commit, error) {
+	c := &Commit{Sha: sha}
+	header := content
+	if i := bytes.Index(content, []byte("\n\n")); i >= 0 {
+		header, c.Message = content[:i+1], content[i+2:]
+	}
+	for len(header) > 0 {
+		line, rest, _ := bytes.Cut(header, []byte("\n"))
+		header = rest
+		if len(line) > 0 && line[0] == ' ' {
+			// a continuation line of the previous header
+			if len(c.Headers) == 0 {
+				return nil, errors.New("expected tree got continuation line")
 			}
-			c.Tree = p[1]
+			h := c.Headers[len(c.Headers)-1]
+			h.Value = append(append(h.Value, '\n'), line[1:]...)
 			continue
 		}
-		// can be parent
-		if t == "parent" {
-			c.Parents = append(c.Parents, p[1])
-			continue
+		k, v, found := bytes.Cut(line, []byte(" "))
+		c.Headers = append(c.Headers, &Header{Key: string(k), Value: append([]byte{}, v...), NoSpace: !found})
+	}
+	if err := c.readHeaders(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readHeaders sets the fields of c from its headers, which must start with
+// the tree, then the parents, the author and the committer.
+func (c *Commit) readHeaders() error {
+	i := 0
+	next := func(key string) (*Header, error) {
+		if i == len(c.Headers) {
+			return nil, fmt.Errorf("expected %s got end of headers", key)
 		}
-		if c.Author == "" {
-			// should be author
-			if t != "author" {
-				return nil, fmt.Errorf("expected author got %s", t)
-			}
-			// decode author line
-			if err := readAuthor(p[1], c); err != nil {
-				return nil, err
-			}
-			continue
+		h := c.Headers[i]
+		if h.Key != key {
+			return nil, fmt.Errorf("expected %s got %s", key, h.Key)
 		}
-		if c.Committer == "" {
-			// should be committer
-			if t != "committer" {
-				return nil, fmt.Errorf("expected committer got %s", t)
-			}
-			// decode committer line
-			if err := readCommitter(p[1], c); err != nil {
-				return nil, err
-			}
-			continue
+		i++
+		return h, nil
+	}
+	h, err := next("tree")
+	if err != nil {
+		return err
+	}
+	c.Tree = h.Value
+	for i < len(c.Headers) && c.Headers[i].Key == "parent" {
+		c.Parents = append(c.Parents, c.Headers[i].Value)
+		i++
+	}
+	if h, err = next("author"); err != nil {
+		return err
+	}
+	if err := readAuthor(h.Value, c); err != nil {
+		return err
+	}
+	if h, err = next("committer"); err != nil {
+		return err
+	}
+	if err := readCommitter(h.Value, c); err != nil {
+		return err
+	}
+	for _, h := range c.Headers[i:] {
+		if h.Key == SignatureHeader() {
+			c.Sig = append(append([]byte{}, h.Value...), '\n')
 		}
-		// can be GPG Signature
-		if t == "gpgsig" {
-			gpgsig = true
-			continue
+	}
+	return nil
+}
+
+// Header returns the value of the first header of c called key.
+func (c *Commit) Header(key string) ([]byte, bool) {
+	for _, h := range c.Headers {
+		if h.Key == key {
+			return h.Value, true
 		}
-		if gpgsig {
-			if len(p[1]) == 0 {
-				continue
+	}
+	return nil, false
+}
+
+func ReadTag(sha []byte) (*Tag, error) {
+	o, err := ReadObject(sha)
+	if err != nil {
+		return nil, err
+	}
+	return readTag(o)
+}
+
+// readTag parses an annotated tag object, which names the tagged object and
+// its type, the tag name, optionally the tagger and then the message.
+func readTag(obj *Object) (*Tag, error) {
+	if obj.Typ != ObjectTag {
+		return nil, fmt.Errorf("object %s is a %s, not a tag", obj.Sha, obj.Typ)
+	}
+	content, err := ReadContent(obj)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tag{Sha: obj.Sha}
+	header, message, _ := bytes.Cut(content, []byte("\n\n"))
+	t.Message = message
+	for _, l := range bytes.Split(header, []byte("\n")) {
+		k, v, _ := bytes.Cut(l, []byte(" "))
+		switch string(k) {
+		case "object":
+			t.Object = v
+		case "type":
+			switch string(v) {
+			case "commit":
+				t.Typ = ObjectCommit
+			case "tree":
+				t.Typ = ObjectTree
+			case "blob":
+				t.Typ = ObjectBlob
+			case "tag":
+				t.Typ = ObjectTag
+			default:
+				return nil, fmt.Errorf("invalid type %s in tag %s", v, obj.Sha)
 			}
-			// @todo build up signature lines
-			if string(p[1]) != "-----END PGP SIGNATURE-----" {
-				c.Sig = append(c.Sig, l...)
-				c.Sig = append(c.Sig, []byte("\n")...)
-				continue
+		case "tag":
+			t.Name = string(v)
+		case "tagger":
+			var err error
+			if t.Tagger, t.TaggerEmail, t.TaggedTime, err = readIdent(v); err != nil {
+				return nil, err
 			}
-			gpgsig = false
-			continue
-		}
-		if len(c.Message) == 0 && len(l) < 2 {
-			continue
 		}
-		// now we have the message body hopefully
-		c.Message = append(c.Message, l...)
-		c.Message = append(c.Message, []byte("\n")...)
 	}
-
-	return c, nil
+	if len(t.Object) != obj.hash().HexSize() {
+		return nil, fmt.Errorf("invalid object in tag %s", obj.Sha)
+	}
+	return t, nil
 }
 
-func readAuthor(b []byte, c *Commit) error {
-	s := bytes.Index(b, []byte("<"))
-	e := bytes.Index(b, []byte(">"))
-	c.Author = string(b[0 : s-1])
-	c.AuthorEmail = string(b[s+1 : e])
-	ut, err := strconv.ParseInt(string(b[e+2:e+2+10]), 10, 64)
+// readIdent parses an identity such as Name <email> 1700000000 +0100,
+// returning the time in the zone of the identity. A malformed date is read
+// as the epoch, as git shows it.
+func readIdent(b []byte) (string, string, time.Time, error) {
+	s := bytes.IndexByte(b, '<')
+	e := bytes.LastIndexByte(b, '>')
+	if s < 0 || e < s {
+		return "", "", time.Time{}, fmt.Errorf("invalid identity %s", b)
+	}
+	name := string(bytes.TrimSpace(b[:s]))
+	email := string(b[s+1 : e])
+	t, err := date.ParseRaw(string(bytes.TrimSpace(b[e+1:])))
 	if err != nil {
-		return err
+		t = time.Unix(0, 0).UTC()
 	}
-	// @todo timezone part
-	c.AuthoredTime = time.Unix(ut, 0)
-	return nil
+	return name, email, t, nil
+}
+
+func readAuthor(b []byte, c *Commit) error {
+	var err error
+	c.Author, c.AuthorEmail, c.AuthoredTime, err = readIdent(b)
+	return err
 }
 
 func readCommitter(b []byte, c *Commit) error {
-	s := bytes.Index(b, []byte("<"))
-	e := bytes.Index(b, []byte(">"))
-	c.Committer = string(b[0 : s-1])
-	c.CommitterEmail = string(b[s+1 : e])
-	ut, err := strconv.ParseInt(string(b[e+2:e+2+10]), 10, 64)
-	if err != nil {
-		return err
-	}
-	// @todo timezone part
-	c.CommittedTime = time.Unix(ut, 0)
-	return nil
+	var err error
+	c.Committer, c.CommitterEmail, c.CommittedTime, err = readIdent(b)
+	return err
 }
 
 func CommittedFiles(sha []byte) ([]*gfs.File, error) {
@@ -318,3 +583,51 @@ func CommittedFiles(sha []byte) ([]*gfs.File, error) {
 	}
 	return obj.FlattenTree(), nil
 }
+
+// MinAbbrev is the shortest abbreviated object name which is resolved
+const MinAbbrev = 4
+
+// ErrAmbiguous is returned when an abbreviated object name matches more than
+// one object
+var ErrAmbiguous = errors.New("ambiguous object name")
+
+// ResolvePrefix returns the hex sha of the single object in the object store
+// dir whose name starts with the hex prefix.
+func ResolvePrefix(dir string, prefix string) ([]byte, error) {
+	prefix = strings.ToLower(prefix)
+	format := hashIn(dir)
+	if len(prefix) < MinAbbrev || len(prefix) > format.HexSize() {
+		return nil, fmt.Errorf("invalid object name %s", prefix)
+	}
+	if _, err := hex.DecodeString(prefix[:len(prefix)&^1]); err != nil {
+		return nil, fmt.Errorf("invalid object name %s", prefix)
+	}
+	found := make(map[string]struct{})
+	entries, err := os.ReadDir(filepath.Join(dir, prefix[:2]))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, v := range entries {
+		if strings.HasPrefix(v.Name(), prefix[2:]) && len(v.Name()) == format.HexSize()-2 {
+			found[prefix[:2]+v.Name()] = struct{}{}
+		}
+	}
+	ps, err := packsIn(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range ps {
+		for _, v := range p.withPrefix(prefix) {
+			found[string(v)] = struct{}{}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", errObjectNotFound, prefix)
+	case 1:
+		for k := range found {
+			return []byte(k), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: short object ID %s is ambiguous", ErrAmbiguous, prefix)
+}
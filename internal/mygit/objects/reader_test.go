@@ -0,0 +1,55 @@
+package objects
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_ParseCommit(t *testing.T) {
+	sha := strings.Repeat("a", 40)
+	header := "tree " + sha + "\nparent " + sha + "\nparent " + sha + "\nauthor A U Thor <a@b> 1700000000 +0100\ncommitter C <c@d> 1700000000 -0500\n"
+	sig := "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n=abcd\n-----END PGP SIGNATURE-----"
+	tests := []struct {
+		name    string
+		content string
+		headers int
+		message string
+	}{
+		{"plain", header + "\nsubject\n", 5, "subject\n"},
+		{"blank lines", header + "\n\nsubject\n\n\nbody\n\n", 5, "\nsubject\n\n\nbody\n\n"},
+		{"no trailing newline", header + "\nsubject", 5, "subject"},
+		{"empty message", header + "\n", 5, ""},
+		{"encoding", header + "encoding ISO-8859-1\n\nsubject\n", 6, "subject\n"},
+		{"unknown", header + "x-custom value\nx-custom value\n\nsubject\n", 7, "subject\n"},
+		{"no value", header + "x-empty\n\nsubject\n", 6, "subject\n"},
+		{"empty value", header + "x-empty \n\nsubject\n", 6, "subject\n"},
+		{"gpgsig", header + "gpgsig " + strings.ReplaceAll(sig, "\n", "\n ") + "\n\nsubject\n", 6, "subject\n"},
+		{"mergetag", header + "mergetag object " + sha + "\n type commit\n tag v1\n tagger T <t@t> 1 +0000\n \n tag message\n\nMerge tag 'v1'\n", 6, "Merge tag 'v1'\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCommit([]byte("sha"), []byte(tt.content))
+			assert.NoError(t, err)
+			assert.Equal(t, sha, string(c.Tree))
+			assert.Len(t, c.Parents, 2)
+			assert.Equal(t, "A U Thor", c.Author)
+			assert.Equal(t, "c@d", c.CommitterEmail)
+			assert.Len(t, c.Headers, tt.headers)
+			assert.Equal(t, tt.message, string(c.Message))
+			assert.Equal(t, tt.content, string(c.Bytes()))
+		})
+	}
+
+	c, err := ParseCommit(nil, []byte(header+"gpgsig "+strings.ReplaceAll(sig, "\n", "\n ")+"\n\nsubject\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, sig+"\n", string(c.Sig))
+	v, ok := c.Header("gpgsig")
+	assert.True(t, ok)
+	assert.Equal(t, sig, string(v))
+
+	_, err = ParseCommit(nil, []byte("author A <a@b> 1 +0000\n\n"))
+	assert.EqualError(t, err, "expected tree got author")
+	_, err = ParseCommit(nil, []byte("tree "+sha+"\ncommitter C <c@d> 1 +0000\n\n"))
+	assert.EqualError(t, err, "expected author got committer")
+}
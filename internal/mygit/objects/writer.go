@@ -3,16 +3,19 @@ package objects
 import (
 	"bytes"
 	"compress/zlib"
-	"crypto/sha1"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/date"
 	"github.com/richardjennings/mygit/internal/mygit/refs"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // WriteTree writes an Object Tree to the object store.
@@ -37,10 +40,12 @@ func (o *Object) writeTree() ([]byte, error) {
 	var content []byte
 	var mode string
 	for _, fo := range o.Objects {
-		// @todo add executable support
-		if fo.Typ == ObjectTree {
+		switch {
+		case fo.Typ == ObjectTree:
 			mode = "40000"
-		} else {
+		case fo.Mode != 0:
+			mode = strconv.FormatUint(uint64(fo.Mode), 8)
+		default:
 			mode = "100644"
 		}
 		// @todo replace base..
@@ -52,36 +57,76 @@ func (o *Object) writeTree() ([]byte, error) {
 
 // WriteObject writes an object to the object store
 func WriteObject(header []byte, content []byte, contentFile string, path string) ([]byte, error) {
-	var f *os.File
-	var err error
-	buf := bytes.NewBuffer(nil)
-	h := sha1.New()
-	z := zlib.NewWriter(buf)
-	r := io.MultiWriter(h, z)
-
-	if _, err := r.Write(header); err != nil {
-		return nil, err
-	}
-	if len(content) > 0 {
-		if _, err := r.Write(content); err != nil {
-			return nil, err
-		}
-	}
+	readers := []io.Reader{bytes.NewReader(header), bytes.NewReader(content)}
 	if contentFile != "" {
-		f, err = os.Open(contentFile)
+		f, err := os.Open(contentFile)
 		if err != nil {
 			return nil, err
 		}
-		if _, err := io.Copy(r, f); err != nil {
-			return nil, err
-		}
-		if err := f.Close(); err != nil {
-			return nil, err
+		defer func() { _ = f.Close() }()
+		readers = append(readers, f)
+	}
+	return writeObject(path, io.MultiReader(readers...))
+}
+
+// WriteStream writes an object of type typ with size bytes of content read
+// from r to the object store dir, returning the raw hash. The content is
+// not held in memory.
+func WriteStream(dir string, typ objectType, size int64, r io.Reader) ([]byte, error) {
+	header := strings.NewReader(fmt.Sprintf("%s %d%s", typ, size, string(byte(0))))
+	return writeObject(dir, io.MultiReader(header, &sizedReader{r: r, remaining: size}))
+}
+
+// sizedReader reads exactly remaining bytes from r, a short read is an error
+// rather than the end of the content.
+type sizedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (s *sizedReader) Read(p []byte) (int, error) {
+	if s.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.r.Read(p)
+	s.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		if s.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
 		}
+		err = nil
 	}
+	return n, err
+}
 
+// writeObject compresses the header and content read from r to a temporary
+// file in the object store dir, which is renamed into place once the hash is
+// known unless the object already exists.
+func writeObject(dir string, r io.Reader) ([]byte, error) {
+	tmp, err := os.CreateTemp(dir, "tmp_obj_")
+	if err != nil {
+		return nil, err
+	}
+	// the temporary file is gone once renamed
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	h := hashIn(dir).New()
+	z := zlib.NewWriter(tmp)
+	if _, err := io.Copy(io.MultiWriter(h, z), r); err != nil {
+		_ = tmp.Close()
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		_ = tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
 	sha := h.Sum(nil)
-	path = filepath.Join(path, hex.EncodeToString(sha)[:2])
+	path := filepath.Join(dir, hex.EncodeToString(sha)[:2])
 	// create object sha[:2] directory if needed
 	if err := os.MkdirAll(path, 0744); err != nil {
 		return nil, err
@@ -93,40 +138,82 @@ func WriteObject(header []byte, content []byte, contentFile string, path string)
 		// file exists
 		return sha, err
 	}
-	if err := z.Close(); err != nil {
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
 		return nil, err
 	}
-	return sha, os.WriteFile(path, buf.Bytes(), 0655)
+	return sha, os.Rename(tmp.Name(), path)
 }
 
 // WriteBlob writes a file to the object store as a blob and returns
 // a Blob Object representation.
 func WriteBlob(path string) (*Object, error) {
 	path = filepath.Join(config.Path(), path)
-	finfo, err := os.Stat(path)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	finfo, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
-	header := []byte(fmt.Sprintf("blob %d%s", finfo.Size(), string(byte(0))))
-	sha, err := WriteObject(header, nil, path, config.ObjectPath())
+	sha, err := WriteStream(config.ObjectPath(), ObjectBlob, finfo.Size(), f)
 	return &Object{Sha: sha, Path: path}, err
 }
 
+// HashBlob returns the hash of a file as a blob without writing it to the
+// object store.
+func HashBlob(path string) ([]byte, error) {
+	f, err := os.Open(filepath.Join(config.Path(), path))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	finfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := config.Hash().New()
+	if _, err := fmt.Fprintf(h, "blob %d%s", finfo.Size(), string(byte(0))); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// WriteBlobs writes files to the object store as blobs using a bounded pool
+// of workers. The returned Blob Objects are in the same order as paths.
+func WriteBlobs(paths []string) ([]*Object, error) {
+	objs := make([]*Object, len(paths))
+	errs := make([]error, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < config.Workers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				objs[j], errs[j] = WriteBlob(paths[j])
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
+
 func WriteCommit(c *Commit) ([]byte, error) {
-	var parentCommits string
-	for _, v := range c.Parents {
-		parentCommits += fmt.Sprintf("parent %s\n", v)
-	}
-	content := []byte(fmt.Sprintf(
-		"tree %s\n%sauthor %s %d +0000\ncommitter %s %d +0000\n\n%s",
-		hex.EncodeToString(c.Tree),
-		parentCommits,
-		c.Author,
-		c.AuthoredTime.Unix(),
-		c.Committer,
-		c.CommittedTime.Unix(),
-		c.Message,
-	))
+	content := c.Bytes()
 	header := []byte(fmt.Sprintf("commit %d%s", len(content), string(byte(0))))
 	sha, err := WriteObject(header, content, "", config.ObjectPath())
 	if err != nil {
@@ -138,3 +225,90 @@ func WriteCommit(c *Commit) ([]byte, error) {
 	}
 	return sha, refs.UpdateBranchHead(branch, sha)
 }
+
+// Bytes encodes c as the content of a commit object. A commit read from the
+// object store encodes to the exact bytes it was read from, otherwise the
+// headers are made from the fields of c.
+func (c *Commit) Bytes() []byte {
+	headers := c.Headers
+	if headers == nil {
+		headers = c.fieldHeaders()
+	}
+	var b bytes.Buffer
+	for _, h := range headers {
+		b.WriteString(h.Key)
+		if !h.NoSpace {
+			b.WriteByte(' ')
+		}
+		// continuation lines start with a space
+		b.Write(bytes.ReplaceAll(h.Value, []byte("\n"), []byte("\n ")))
+		b.WriteByte('\n')
+	}
+	if c.Message != nil {
+		b.WriteByte('\n')
+		b.Write(c.Message)
+	}
+	return b.Bytes()
+}
+
+// Payload is the encoding of c without its signatures, which is the content
+// signed.
+func (c *Commit) Payload() []byte {
+	p := *c
+	p.Sig = nil
+	if c.Headers != nil {
+		p.Headers = []*Header{}
+		for _, h := range c.Headers {
+			if h.Key != "gpgsig" && h.Key != "gpgsig-sha256" {
+				p.Headers = append(p.Headers, h)
+			}
+		}
+	}
+	return p.Bytes()
+}
+
+// SignatureHeader is the header of the signature of a commit, which is named
+// for the object format of the repository.
+func SignatureHeader() string {
+	if config.Hash() == config.SHA1 {
+		return "gpgsig"
+	}
+	return "gpgsig-sha256"
+}
+
+// fieldHeaders returns the headers of a new commit, with the hex hash of its
+// tree and parents.
+func (c *Commit) fieldHeaders() []*Header {
+	headers := []*Header{{Key: "tree", Value: c.Tree}}
+	for _, v := range c.Parents {
+		headers = append(headers, &Header{Key: "parent", Value: v})
+	}
+	headers = append(headers,
+		&Header{Key: "author", Value: []byte(fmt.Sprintf("%s <%s> %s", c.Author, c.AuthorEmail, date.FormatRaw(c.AuthoredTime)))},
+		&Header{Key: "committer", Value: []byte(fmt.Sprintf("%s <%s> %s", c.Committer, c.CommitterEmail, date.FormatRaw(c.CommittedTime)))},
+	)
+	if c.Sig != nil {
+		headers = append(headers, &Header{Key: SignatureHeader(), Value: bytes.TrimSuffix(c.Sig, []byte("\n"))})
+	}
+	return headers
+}
+
+// CopyObjects copies the objects named by the hex hashes shas from the
+// object store src to the object store dst.
+func CopyObjects(src string, dst string, shas [][]byte) error {
+	for _, sha := range shas {
+		r, err := OpenObjectFrom(src, sha)
+		if err != nil {
+			return err
+		}
+		written, err := WriteStream(dst, r.Typ, r.Size, r)
+		_ = r.Close()
+		if err != nil {
+			return err
+		}
+		if hex.EncodeToString(written) != string(sha) {
+			return fmt.Errorf("object %s is corrupt", sha)
+		}
+	}
+	return nil
+}
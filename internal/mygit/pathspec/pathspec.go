@@ -0,0 +1,341 @@
+package pathspec
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type (
+	// Pathspec matches repository relative paths against a list of pathspec
+	// patterns. Patterns are relative to the current directory unless the
+	// top magic is used, may contain wildcards and may use the exclude, icase,
+	// top, glob and literal magic in either long (:(exclude)) or short (:!)
+	// form.
+	Pathspec struct {
+		items []*item
+	}
+	item struct {
+		original string
+		pattern  string
+		exclude  bool
+		icase    bool
+		glob     bool
+		literal  bool
+		re       *regexp.Regexp
+	}
+)
+
+// Prefix returns the current working directory relative to the repository
+// root, or an empty string when the working directory is outside of it.
+func Prefix() string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(config.Path(), wd)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// New parses patterns relative to prefix. An empty list of patterns matches
+// every path.
+func New(prefix string, patterns ...string) (*Pathspec, error) {
+	p := &Pathspec{}
+	for _, v := range patterns {
+		it, err := parse(prefix, v)
+		if err != nil {
+			return nil, err
+		}
+		p.items = append(p.items, it)
+	}
+	return p, nil
+}
+
+func parse(prefix string, pattern string) (*item, error) {
+	it := &item{original: pattern}
+	top := false
+	if strings.HasPrefix(pattern, ":(") {
+		e := strings.Index(pattern, ")")
+		if e < 0 {
+			return nil, fmt.Errorf("fatal: Missing ')' at the end of pathspec magic in '%s'", pattern)
+		}
+		for _, m := range strings.Split(pattern[2:e], ",") {
+			switch strings.TrimSpace(m) {
+			case "top":
+				top = true
+			case "exclude":
+				it.exclude = true
+			case "icase":
+				it.icase = true
+			case "glob":
+				it.glob = true
+			case "literal":
+				it.literal = true
+			case "":
+			default:
+				return nil, fmt.Errorf("fatal: Invalid pathspec magic '%s' in '%s'", m, pattern)
+			}
+		}
+		pattern = pattern[e+1:]
+	} else if strings.HasPrefix(pattern, ":") {
+		pattern = pattern[1:]
+	short:
+		for len(pattern) > 0 {
+			switch pattern[0] {
+			case '/':
+				top = true
+			case '!', '^':
+				it.exclude = true
+			case ':':
+				pattern = pattern[1:]
+				break short
+			default:
+				break short
+			}
+			pattern = pattern[1:]
+		}
+	}
+	if it.glob && it.literal {
+		return nil, fmt.Errorf("fatal: 'literal' and 'glob' are incompatible")
+	}
+	// defaults can be set by environment as in git
+	if os.Getenv("GIT_GLOB_PATHSPECS") == "1" && !it.literal {
+		it.glob = true
+	}
+	if os.Getenv("GIT_LITERAL_PATHSPECS") == "1" {
+		it.literal, it.glob = true, false
+	}
+	if os.Getenv("GIT_ICASE_PATHSPECS") == "1" {
+		it.icase = true
+	}
+	pattern = filepath.ToSlash(pattern)
+	if !top && prefix != "" {
+		pattern = prefix + "/" + pattern
+	}
+	trailingSlash := strings.HasSuffix(pattern, "/")
+	pattern = path.Clean(pattern)
+	if pattern == ".." || strings.HasPrefix(pattern, "../") {
+		return nil, fmt.Errorf("fatal: %s: '%s' is outside repository", it.original, it.original)
+	}
+	if pattern == "." {
+		pattern = ""
+	}
+	if trailingSlash && pattern != "" {
+		pattern += "/"
+	}
+	it.pattern = pattern
+	if !it.literal && strings.ContainsAny(pattern, "*?[") {
+		expr := wildcardToRegexp(pattern, it.glob)
+		if it.icase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			return nil, fmt.Errorf("fatal: invalid pathspec '%s': %w", it.original, err)
+		}
+		it.re = re
+	}
+	return it, nil
+}
+
+// wildcardToRegexp translates a pathspec pattern into a regular expression.
+// Without glob magic wildcards match across directory separators as fnmatch
+// does, with glob magic only ** matches across directories.
+func wildcardToRegexp(pattern string, glob bool) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if !glob {
+				b.WriteString(".*")
+				continue
+			}
+			if i+1 < len(pattern) && pattern[i+1] == '*' && (i == 0 || pattern[i-1] == '/') {
+				j := i + 2
+				if j < len(pattern) && pattern[j] == '/' {
+					b.WriteString("(?:.*/)?")
+					i = j
+					continue
+				}
+				if j == len(pattern) {
+					b.WriteString(".*")
+					i = j - 1
+					continue
+				}
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			if glob {
+				b.WriteString("[^/]")
+			} else {
+				b.WriteString(".")
+			}
+		case '[':
+			e := strings.IndexByte(pattern[i+1:], ']')
+			if e < 0 {
+				b.WriteString("\\[")
+				continue
+			}
+			class := pattern[i+1 : i+1+e]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += e + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// Empty reports whether there are no patterns, in which case every path
+// matches.
+func (p *Pathspec) Empty() bool {
+	return p == nil || len(p.items) == 0
+}
+
+// Match reports whether path, relative to the repository root, matches the
+// pathspec. A path matches if it matches any pattern which is not an exclude
+// pattern, or there are only exclude patterns, and it matches no exclude
+// pattern.
+func (p *Pathspec) Match(path string) bool {
+	if p.Empty() {
+		return true
+	}
+	path = filepath.ToSlash(path)
+	included := true
+	for _, v := range p.items {
+		if !v.exclude {
+			included = false
+			break
+		}
+	}
+	for _, v := range p.items {
+		if !v.match(path) {
+			continue
+		}
+		if v.exclude {
+			return false
+		}
+		included = true
+	}
+	return included
+}
+
+// Unmatched returns the patterns which are not exclude patterns that match
+// none of paths.
+func (p *Pathspec) Unmatched(paths []string) []string {
+	if p.Empty() {
+		return nil
+	}
+	var r []string
+	for _, v := range p.items {
+		if v.exclude {
+			continue
+		}
+		found := false
+		for _, path := range paths {
+			if v.match(filepath.ToSlash(path)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r = append(r, v.original)
+		}
+	}
+	return r
+}
+
+// Recursive returns the patterns which are not exclude patterns that only
+// match paths by naming one of their parent directories.
+func (p *Pathspec) Recursive(paths []string) []string {
+	if p.Empty() {
+		return nil
+	}
+	var r []string
+	for _, v := range p.items {
+		if v.exclude {
+			continue
+		}
+		direct, viaDir := false, false
+		for _, path := range paths {
+			path = filepath.ToSlash(path)
+			if !v.match(path) {
+				continue
+			}
+			if v.matchDirect(path) {
+				direct = true
+				break
+			}
+			viaDir = true
+		}
+		if viaDir && !direct {
+			r = append(r, v.original)
+		}
+	}
+	return r
+}
+
+// matchDirect reports whether path is the pattern or matches the pattern
+// wildcards without considering parent directories.
+func (it *item) matchDirect(path string) bool {
+	if strings.HasSuffix(it.pattern, "/") {
+		return false
+	}
+	if it.icase {
+		if strings.EqualFold(path, it.pattern) {
+			return true
+		}
+	} else if path == it.pattern {
+		return true
+	}
+	return it.re != nil && it.re.MatchString(path)
+}
+
+// match reports whether path is the pattern, is within the directory named by
+// the pattern or matches the pattern wildcards.
+func (it *item) match(path string) bool {
+	pattern := strings.TrimSuffix(it.pattern, "/")
+	if pattern == "" {
+		return true
+	}
+	p := path
+	if it.icase {
+		pattern = strings.ToLower(pattern)
+		p = strings.ToLower(path)
+	}
+	if p == pattern && !strings.HasSuffix(it.pattern, "/") {
+		return true
+	}
+	if strings.HasPrefix(p, pattern+"/") {
+		return true
+	}
+	if it.re == nil {
+		return false
+	}
+	if it.re.MatchString(path) {
+		return true
+	}
+	// a wildcard pattern matching a leading directory matches its contents
+	for d := filepath.Dir(path); d != "."; d = filepath.Dir(d) {
+		if it.re.MatchString(filepath.ToSlash(d)) {
+			return true
+		}
+	}
+	return false
+}
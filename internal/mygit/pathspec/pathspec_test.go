@@ -0,0 +1,75 @@
+package pathspec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_Match(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		patterns []string
+		path     string
+		match    bool
+	}{
+		{"", nil, "a/b", true},
+		{"", []string{"."}, "a/b", true},
+		{"", []string{"a"}, "a", true},
+		{"", []string{"a"}, "a/b/c", true},
+		{"", []string{"a"}, "ab", false},
+		{"", []string{"a/"}, "a", false},
+		{"", []string{"a/"}, "a/b", true},
+		{"", []string{"*.go"}, "main.go", true},
+		{"", []string{"*.go"}, "cmd/main.go", true},
+		{"", []string{":(glob)*.go"}, "cmd/main.go", false},
+		{"", []string{":(glob)**/*.go"}, "cmd/main.go", true},
+		{"", []string{":(glob)cmd/**"}, "cmd/a/main.go", true},
+		{"", []string{"c?d"}, "cmd/main.go", true},
+		{"", []string{":(literal)*.go"}, "main.go", false},
+		{"", []string{":(literal)*.go"}, "*.go", true},
+		{"", []string{":(icase)README"}, "readme", true},
+		{"", []string{"README"}, "readme", false},
+		{"", []string{".", ":(exclude)*.md"}, "README.md", false},
+		{"", []string{".", ":!*.md"}, "main.go", true},
+		{"", []string{":^vendor"}, "vendor/x.go", false},
+		{"", []string{":^vendor"}, "main.go", true},
+		{"sub", []string{"x"}, "sub/x", true},
+		{"sub", []string{"x"}, "x", false},
+		{"sub", []string{":(top)x"}, "x", true},
+		{"sub", []string{":/x"}, "x", true},
+		{"sub", []string{"../x"}, "x", true},
+		{"sub", []string{"."}, "other/x", false},
+	}
+	for _, tt := range tests {
+		p, err := New(tt.prefix, tt.patterns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tt.match, p.Match(tt.path), "%s %v %s", tt.prefix, tt.patterns, tt.path)
+	}
+}
+
+func Test_Invalid(t *testing.T) {
+	_, err := New("", ":(unknown)x")
+	assert.Error(t, err)
+	_, err = New("", "../x")
+	assert.Error(t, err)
+	_, err = New("", ":(glob,literal)x")
+	assert.Error(t, err)
+}
+
+func Test_Unmatched(t *testing.T) {
+	p, err := New("", "a", "b*", ":!c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"b*"}, p.Unmatched([]string{"a/x", "c"}))
+}
+
+func Test_Recursive(t *testing.T) {
+	p, err := New("", "a", "b/c", "*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"a"}, p.Recursive([]string{"a/x", "b/c", "main.go"}))
+}
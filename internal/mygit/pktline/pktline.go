@@ -0,0 +1,210 @@
+package pktline
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// PacketType distinguishes data packets from the special zero length
+// packets of the git wire protocol.
+type PacketType int
+
+const (
+	Data PacketType = iota
+	Flush
+	Delim
+	ResponseEnd
+)
+
+const (
+	// MaxPayload is the largest payload of a single packet
+	MaxPayload = 65516
+
+	BandData     = 1
+	BandProgress = 2
+	BandError    = 3
+)
+
+type (
+	// Writer writes pkt-line framed packets
+	Writer struct {
+		w io.Writer
+	}
+	// Reader reads pkt-line framed packets
+	Reader struct {
+		r *bufio.Reader
+	}
+)
+
+// NewWriter returns a Writer of packets to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WritePacket writes p as a single data packet.
+func (w *Writer) WritePacket(p []byte) error {
+	if len(p) > MaxPayload {
+		return fmt.Errorf("packet of %d bytes exceeds maximum of %d", len(p), MaxPayload)
+	}
+	if _, err := fmt.Fprintf(w.w, "%04x", len(p)+4); err != nil {
+		return err
+	}
+	_, err := w.w.Write(p)
+	return err
+}
+
+// WriteString writes a formatted data packet.
+func (w *Writer) WriteString(format string, a ...interface{}) error {
+	return w.WritePacket([]byte(fmt.Sprintf(format, a...)))
+}
+
+// Flush writes a flush packet which ends a message.
+func (w *Writer) Flush() error {
+	_, err := io.WriteString(w.w, "0000")
+	return err
+}
+
+// Delim writes a delimiter packet which separates sections of a message.
+func (w *Writer) Delim() error {
+	_, err := io.WriteString(w.w, "0001")
+	return err
+}
+
+// ResponseEnd writes the packet ending a stateless response.
+func (w *Writer) ResponseEnd() error {
+	_, err := io.WriteString(w.w, "0002")
+	return err
+}
+
+// WriteBand writes p to a side-band channel, split into packets as needed.
+func (w *Writer) WriteBand(band byte, p []byte) error {
+	for len(p) > 0 {
+		n := len(p)
+		if n > MaxPayload-1 {
+			n = MaxPayload - 1
+		}
+		if err := w.WritePacket(append([]byte{band}, p[:n]...)); err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// BandWriter returns a writer of data to a side-band channel. Each write
+// becomes one or more packets so writes should be buffered.
+func (w *Writer) BandWriter(band byte) io.Writer {
+	return &bandWriter{w: w, band: band}
+}
+
+type bandWriter struct {
+	w    *Writer
+	band byte
+}
+
+func (b *bandWriter) Write(p []byte) (int, error) {
+	if err := b.w.WriteBand(b.band, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// NewReader returns a Reader of packets from r. A *bufio.Reader is used as is
+// so that data following the packets can be read from it.
+func NewReader(r io.Reader) *Reader {
+	if b, ok := r.(*bufio.Reader); ok {
+		return &Reader{r: b}
+	}
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadPacket returns the payload and type of the next packet.
+func (r *Reader) ReadPacket() ([]byte, PacketType, error) {
+	l := make([]byte, 4)
+	if _, err := io.ReadFull(r.r, l); err != nil {
+		return nil, Data, err
+	}
+	n, err := strconv.ParseUint(string(l), 16, 16)
+	if err != nil {
+		return nil, Data, fmt.Errorf("invalid packet length %q", l)
+	}
+	switch n {
+	case 0:
+		return nil, Flush, nil
+	case 1:
+		return nil, Delim, nil
+	case 2:
+		return nil, ResponseEnd, nil
+	case 3:
+		return nil, Data, fmt.Errorf("invalid packet length %q", l)
+	}
+	p := make([]byte, n-4)
+	if _, err := io.ReadFull(r.r, p); err != nil {
+		return nil, Data, err
+	}
+	return p, Data, nil
+}
+
+// ReadLine returns the payload of the next packet without a trailing newline.
+// The payload of special packets is empty. An ERR packet sent by the other
+// side is returned as an error.
+func (r *Reader) ReadLine() (string, PacketType, error) {
+	p, t, err := r.ReadPacket()
+	l := strings.TrimSuffix(string(p), "\n")
+	if err == nil && strings.HasPrefix(l, "ERR ") {
+		return "", t, fmt.Errorf("remote error: %s", strings.TrimPrefix(l, "ERR "))
+	}
+	return l, t, err
+}
+
+// Sideband returns a reader of the data band of side-band multiplexed packets
+// up to a flush packet. Progress messages are written to progress and an
+// error message ends the stream with an error.
+func (r *Reader) Sideband(progress io.Writer) io.Reader {
+	return &sideband{r: r, progress: progress}
+}
+
+type sideband struct {
+	r        *Reader
+	progress io.Writer
+	buf      []byte
+	done     bool
+}
+
+func (s *sideband) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		pkt, t, err := s.r.ReadPacket()
+		if err != nil {
+			return 0, err
+		}
+		if t != Data {
+			s.done = true
+			continue
+		}
+		if len(pkt) == 0 {
+			continue
+		}
+		switch pkt[0] {
+		case BandData:
+			s.buf = pkt[1:]
+		case BandProgress:
+			if s.progress != nil {
+				_, _ = s.progress.Write(pkt[1:])
+			}
+		case BandError:
+			return 0, errors.New(strings.TrimSpace(string(pkt[1:])))
+		default:
+			return 0, fmt.Errorf("invalid side-band %d", pkt[0])
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,58 @@
+package pktline
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func Test_Packets(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := NewWriter(buf)
+	assert.NoError(t, w.WriteString("command=%s\n", "ls-refs"))
+	assert.NoError(t, w.Delim())
+	assert.NoError(t, w.WritePacket([]byte("peel")))
+	assert.NoError(t, w.Flush())
+	assert.NoError(t, w.ResponseEnd())
+	assert.Equal(t, "0014command=ls-refs\n00010008peel00000002", buf.String())
+
+	r := NewReader(buf)
+	tests := []struct {
+		line string
+		typ  PacketType
+	}{
+		{"command=ls-refs", Data},
+		{"", Delim},
+		{"peel", Data},
+		{"", Flush},
+		{"", ResponseEnd},
+	}
+	for _, tt := range tests {
+		l, typ, err := r.ReadLine()
+		assert.NoError(t, err)
+		assert.Equal(t, tt.line, l)
+		assert.Equal(t, tt.typ, typ)
+	}
+	_, _, err := NewReader(bytes.NewBufferString("0003")).ReadPacket()
+	assert.Error(t, err)
+}
+
+func Test_Sideband(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := NewWriter(buf)
+	assert.NoError(t, w.WriteBand(BandData, []byte("PACK")))
+	assert.NoError(t, w.WriteBand(BandProgress, []byte("counting\n")))
+	assert.NoError(t, w.WriteBand(BandData, bytes.Repeat([]byte("x"), MaxPayload)))
+	assert.NoError(t, w.Flush())
+	progress := bytes.NewBuffer(nil)
+	b, err := io.ReadAll(NewReader(buf).Sideband(progress))
+	assert.NoError(t, err)
+	assert.Equal(t, 4+MaxPayload, len(b))
+	assert.Equal(t, "counting\n", progress.String())
+
+	buf.Reset()
+	assert.NoError(t, w.WriteBand(BandError, []byte("access denied\n")))
+	_, err = io.ReadAll(NewReader(buf).Sideband(nil))
+	assert.EqualError(t, err, "access denied")
+}
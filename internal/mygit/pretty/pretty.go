@@ -0,0 +1,274 @@
+package pretty
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/date"
+	"github.com/richardjennings/mygit/internal/mygit/mailmap"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is how a commit is shown by log, one of the built in formats such
+// as medium or a template of placeholders such as %h %s.
+type Format struct {
+	// Name is the built in format, empty for a template
+	Name string
+	// Template is the text and placeholders of a user format
+	Template string
+	// Terminator ends each commit of a template with a newline rather than
+	// separating commits with one
+	Terminator bool
+}
+
+// Medium is the format log shows commits in by default
+var Medium = &Format{Name: "medium"}
+
+var builtin = map[string]bool{
+	"oneline": true,
+	"short":   true,
+	"medium":  true,
+	"full":    true,
+	"fuller":  true,
+}
+
+// Parse parses the format of --pretty or --format: a built in format name,
+// format:<template>, tformat:<template> or a template containing %.
+func Parse(s string) (*Format, error) {
+	if builtin[s] {
+		return &Format{Name: s}, nil
+	}
+	if t, ok := strings.CutPrefix(s, "format:"); ok {
+		return &Format{Template: t}, nil
+	}
+	if t, ok := strings.CutPrefix(s, "tformat:"); ok {
+		return &Format{Template: t, Terminator: true}, nil
+	}
+	if strings.Contains(s, "%") {
+		return &Format{Template: s, Terminator: true}, nil
+	}
+	return nil, fmt.Errorf("fatal: invalid --pretty format: %s", s)
+}
+
+// Options configures how a commit is shown
+type Options struct {
+	// Date is the format of dates in built in formats and %ad and %cd
+	Date date.Mode
+	// Abbrev abbreviates the commit name in built in formats
+	Abbrev bool
+	// Mailmap maps the identities of built in formats and of %aN, %aE, %cN
+	// and %cE
+	Mailmap *mailmap.Mailmap
+}
+
+// AbbrevLength is the length of abbreviated object names
+const AbbrevLength = 7
+
+// Commit returns c in format f, without a newline after the last line.
+func (f *Format) Commit(c *objects.Commit, opts Options) string {
+	if f.Name == "" {
+		return expand(f.Template, c, opts)
+	}
+	sha := string(c.Sha)
+	if opts.Abbrev {
+		sha = abbrev(c.Sha)
+	}
+	if f.Name == "oneline" {
+		return sha + " " + Subject(c.Message)
+	}
+	var b strings.Builder
+	b.WriteString("commit " + sha + "\n")
+	if len(c.Parents) > 1 {
+		var parents []string
+		for _, p := range c.Parents {
+			parents = append(parents, abbrev(p))
+		}
+		b.WriteString("Merge: " + strings.Join(parents, " ") + "\n")
+	}
+	name, email := opts.Mailmap.Map(c.Author, c.AuthorEmail)
+	author := fmt.Sprintf("%s <%s>", name, email)
+	name, email = opts.Mailmap.Map(c.Committer, c.CommitterEmail)
+	committer := fmt.Sprintf("%s <%s>", name, email)
+	switch f.Name {
+	case "short":
+		b.WriteString("Author: " + author + "\n")
+	case "medium":
+		b.WriteString("Author: " + author + "\n")
+		b.WriteString("Date:   " + opts.Date.Format(c.AuthoredTime) + "\n")
+	case "full":
+		b.WriteString("Author: " + author + "\n")
+		b.WriteString("Commit: " + committer + "\n")
+	case "fuller":
+		b.WriteString("Author:     " + author + "\n")
+		b.WriteString("AuthorDate: " + opts.Date.Format(c.AuthoredTime) + "\n")
+		b.WriteString("Commit:     " + committer + "\n")
+		b.WriteString("CommitDate: " + opts.Date.Format(c.CommittedTime) + "\n")
+	}
+	lines := messageLines(c.Message)
+	if f.Name == "short" {
+		// only the subject paragraph
+		for i, l := range lines {
+			if strings.TrimSpace(l) == "" {
+				lines = lines[:i]
+				break
+			}
+		}
+	}
+	if len(lines) > 0 {
+		b.WriteString("\n")
+	}
+	for _, l := range lines {
+		b.WriteString("    " + l + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Separated reports whether commits in format f are separated by a blank
+// line, as built in formats other than oneline are.
+func (f *Format) Separated() bool {
+	return f.Name != "" && f.Name != "oneline"
+}
+
+// messageLines returns the lines of msg without leading and trailing blank
+// lines
+func messageLines(msg []byte) []string {
+	s := strings.Trim(string(msg), "\n")
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// Subject returns the first paragraph of msg joined into one line
+func Subject(msg []byte) string {
+	subject, _ := split(msg)
+	return subject
+}
+
+// split returns the subject of msg and the body after the blank lines
+// following it.
+func split(msg []byte) (string, string) {
+	lines := strings.SplitAfter(strings.TrimLeft(string(msg), "\n"), "\n")
+	var subject []string
+	i := 0
+	for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+		subject = append(subject, strings.TrimSpace(lines[i]))
+	}
+	for ; i < len(lines) && strings.TrimSpace(lines[i]) == ""; i++ {
+	}
+	return strings.Join(subject, " "), strings.Join(lines[i:], "")
+}
+
+func abbrev(sha []byte) string {
+	if len(sha) > AbbrevLength {
+		return string(sha[:AbbrevLength])
+	}
+	return string(sha)
+}
+
+// expand replaces the placeholders of template with the fields of c
+func expand(template string, c *objects.Commit, opts Options) string {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' || i+1 == len(template) {
+			b.WriteByte(template[i])
+			continue
+		}
+		v, n, ok := placeholder(template[i+1:], c, opts)
+		if !ok {
+			b.WriteByte('%')
+			continue
+		}
+		b.WriteString(v)
+		i += n
+	}
+	return b.String()
+}
+
+// placeholder returns the value of the placeholder at the start of p, which
+// follows a %, and its length.
+func placeholder(p string, c *objects.Commit, opts Options) (string, int, bool) {
+	switch p[0] {
+	case 'H':
+		return string(c.Sha), 1, true
+	case 'h':
+		return abbrev(c.Sha), 1, true
+	case 'T':
+		return string(c.Tree), 1, true
+	case 't':
+		return abbrev(c.Tree), 1, true
+	case 'P', 'p':
+		var parents []string
+		for _, v := range c.Parents {
+			if p[0] == 'p' {
+				parents = append(parents, abbrev(v))
+			} else {
+				parents = append(parents, string(v))
+			}
+		}
+		return strings.Join(parents, " "), 1, true
+	case 's':
+		return Subject(c.Message), 1, true
+	case 'b':
+		_, body := split(c.Message)
+		return body, 1, true
+	case 'B':
+		return string(c.Message), 1, true
+	case 'n':
+		return "\n", 1, true
+	case '%':
+		return "%", 1, true
+	case 'x':
+		if len(p) >= 3 {
+			if v, err := strconv.ParseUint(p[1:3], 16, 8); err == nil {
+				return string([]byte{byte(v)}), 3, true
+			}
+		}
+	case 'a', 'c':
+		if len(p) < 2 {
+			return "", 0, false
+		}
+		name, email, when := c.Author, c.AuthorEmail, c.AuthoredTime
+		if p[0] == 'c' {
+			name, email, when = c.Committer, c.CommitterEmail, c.CommittedTime
+		}
+		switch p[1] {
+		case 'n':
+			return name, 2, true
+		case 'e':
+			return email, 2, true
+		case 'N':
+			name, _ = opts.Mailmap.Map(name, email)
+			return name, 2, true
+		case 'E':
+			_, email = opts.Mailmap.Map(name, email)
+			return email, 2, true
+		}
+		if v, ok := formatDate(p[1], when, opts.Date); ok {
+			return v, 2, true
+		}
+	}
+	return "", 0, false
+}
+
+// formatDate formats when for the date placeholder %ad and its variants
+func formatDate(c byte, when time.Time, mode date.Mode) (string, bool) {
+	switch c {
+	case 'd':
+		return mode.Format(when), true
+	case 'r':
+		return date.Relative.Format(when), true
+	case 't':
+		return date.Unix.Format(when), true
+	case 'i':
+		return date.ISO.Format(when), true
+	case 'I':
+		return date.ISOStrict.Format(when), true
+	case 's':
+		return date.Short.Format(when), true
+	case 'D':
+		return date.RFC.Format(when), true
+	}
+	return "", false
+}
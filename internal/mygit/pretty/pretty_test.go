@@ -0,0 +1,50 @@
+package pretty
+
+import (
+	"github.com/richardjennings/mygit/internal/mygit/date"
+	"github.com/richardjennings/mygit/internal/mygit/mailmap"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_Format(t *testing.T) {
+	sha := strings.Repeat("a", 40)
+	content := "tree " + strings.Repeat("b", 40) + "\nparent " + strings.Repeat("c", 40) + "\nparent " + strings.Repeat("d", 40) +
+		"\nauthor A U Thor <a@b.c> 1700000000 +0100\ncommitter C O Mitter <c@d.e> 1700003600 +0000\n\nsubject\nline\n\nbody\n\nmore\n"
+	c, err := objects.ParseCommit([]byte(sha), []byte(content))
+	assert.NoError(t, err)
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{"oneline", sha + " subject line"},
+		{"short", "commit " + sha + "\nMerge: ccccccc ddddddd\nAuthor: A U Thor <a@b.c>\n\n    subject\n    line"},
+		{"medium", "commit " + sha + "\nMerge: ccccccc ddddddd\nAuthor: A U Thor <a@b.c>\nDate:   Tue Nov 14 23:13:20 2023 +0100\n\n    subject\n    line\n    \n    body\n    \n    more"},
+		{"full", "commit " + sha + "\nMerge: ccccccc ddddddd\nAuthor: A U Thor <a@b.c>\nCommit: C O Mitter <c@d.e>\n\n    subject\n    line\n    \n    body\n    \n    more"},
+		{"fuller", "commit " + sha + "\nMerge: ccccccc ddddddd\nAuthor:     A U Thor <a@b.c>\nAuthorDate: Tue Nov 14 23:13:20 2023 +0100\nCommit:     C O Mitter <c@d.e>\nCommitDate: Tue Nov 14 23:13:20 2023 +0000\n\n    subject\n    line\n    \n    body\n    \n    more"},
+		{"format:%h %t %p|%an <%ae>|%cn|%ad|%ai|%at|%cs", "aaaaaaa bbbbbbb ccccccc ddddddd|A U Thor <a@b.c>|C O Mitter|Tue Nov 14 23:13:20 2023 +0100|2023-11-14 23:13:20 +0100|1700000000|2023-11-14"},
+		{"%s%n[%b]%x41%%%z", "subject line\n[body\n\nmore\n]A%%z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			f, err := Parse(tt.format)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, f.Commit(c, Options{Date: date.Default}))
+		})
+	}
+	f, err := Parse("oneline")
+	assert.NoError(t, err)
+	assert.Equal(t, "aaaaaaa subject line", f.Commit(c, Options{Abbrev: true}))
+	m, err := mailmap.Parse(strings.NewReader("Author <author@b.c> <a@b.c>\n"))
+	assert.NoError(t, err)
+	f, err = Parse("%an %aN <%aE> %cN")
+	assert.NoError(t, err)
+	assert.Equal(t, "A U Thor Author <author@b.c> C O Mitter", f.Commit(c, Options{Mailmap: m}))
+	f, err = Parse("short")
+	assert.NoError(t, err)
+	assert.Contains(t, f.Commit(c, Options{Mailmap: m}), "\nAuthor: Author <author@b.c>\n")
+	_, err = Parse("bogus")
+	assert.EqualError(t, err, "fatal: invalid --pretty format: bogus")
+}
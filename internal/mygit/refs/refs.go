@@ -2,6 +2,7 @@ package refs
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func UpdateHead(branch string) error {
@@ -23,20 +25,198 @@ func UpdateBranchHead(branch string, sha []byte) error {
 
 // HeadSHA returns the hash pointed to by a branch
 func HeadSHA(currentBranch string) ([]byte, error) {
-	path := filepath.Join(config.RefsHeadsDirectory(), currentBranch)
-	bytes, err := os.ReadFile(path)
-	if err != nil && errors.Is(err, fs.ErrNotExist) {
+	sha, err := ReadRef(fmt.Sprintf("refs/heads/%s", currentBranch))
+	if err != nil {
+		return nil, err
+	}
+	if sha == nil {
 		// the default branch does not exist in refs/heads when there are no commits
 		if fmt.Sprintf("refs/heads/%s", currentBranch) == config.DefaultBranch {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("fatal: not a valid object name: '%s'", currentBranch)
-	} else if err != nil {
+	}
+	return sha, nil
+}
+
+// ReadRef returns the hash pointed to by a fully qualified ref such as
+// refs/remotes/origin/main, or nil if the ref does not exist.
+func ReadRef(name string) ([]byte, error) {
+	return ReadRefIn(config.GitPath(), name)
+}
+
+// ReadRefIn returns the hash pointed to by a fully qualified ref in the git
+// directory gitDir, following symbolic refs and falling back to packed-refs,
+// or nil if the ref does not exist.
+func ReadRefIn(gitDir string, name string) ([]byte, error) {
+	for i := 0; i < 5; i++ {
+		b, err := os.ReadFile(filepath.Join(gitDir, name))
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && len(b) == 0) {
+			packed, err := readPackedRefs(gitDir)
+			if err != nil {
+				return nil, err
+			}
+			return packed[name], nil
+		} else if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(b, []byte("ref: ")) {
+			name = strings.TrimSpace(string(b[5:]))
+			continue
+		}
+		n := config.HashOf(gitDir).HexSize()
+		if len(b) < n {
+			return nil, fmt.Errorf("fatal: invalid ref: %s", name)
+		}
+		return b[0:n], nil
+	}
+	return nil, fmt.Errorf("fatal: too many levels of symbolic refs: %s", name)
+}
+
+// SymbolicRefIn returns the ref pointed to by the symbolic ref name in the
+// git directory gitDir, such as HEAD, or an empty string if name is not a
+// symbolic ref.
+func SymbolicRefIn(gitDir string, name string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(gitDir, name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	if !bytes.HasPrefix(b, []byte("ref: ")) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(b[5:])), nil
+}
+
+// ListRefs returns the refs of the repository, see ListRefsIn.
+func ListRefs() (map[string][]byte, error) {
+	return ListRefsIn(config.GitPath())
+}
+
+// ListRefsIn returns the hashes pointed to by every ref under refs/ in the
+// git directory gitDir keyed by fully qualified name. Loose refs take
+// precedence over packed-refs.
+func ListRefsIn(gitDir string) (map[string][]byte, error) {
+	r, err := readPackedRefs(gitDir)
+	if err != nil {
 		return nil, err
-	} else if bytes == nil {
-		return nil, fmt.Errorf("fatal: not a valid object name: '%s'", currentBranch)
 	}
-	return bytes[0:40], nil
+	root := filepath.Join(gitDir, "refs")
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(gitDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		sha, err := ReadRefIn(gitDir, name)
+		if err != nil {
+			return err
+		}
+		if sha != nil {
+			r[name] = sha
+		}
+		return nil
+	})
+	return r, err
+}
+
+// PackedRefs returns the refs in the packed-refs file of the repository.
+func PackedRefs() (map[string][]byte, error) {
+	return readPackedRefs(config.GitPath())
+}
+
+// readPackedRefs reads the packed-refs file of the git directory gitDir,
+// ignoring peeled tag lines.
+func readPackedRefs(gitDir string) (map[string][]byte, error) {
+	r := map[string][]byte{}
+	f, err := os.Open(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return r, nil
+		}
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := s.Text()
+		if l == "" || l[0] == '#' || l[0] == '^' {
+			continue
+		}
+		p := strings.SplitN(l, " ", 2)
+		if len(p) != 2 || len(p[0]) != config.HashOf(gitDir).HexSize() {
+			return nil, fmt.Errorf("fatal: invalid packed-refs line: %s", l)
+		}
+		r[p[1]] = []byte(p[0])
+	}
+	return r, s.Err()
+}
+
+// UpdateRef points the fully qualified ref name at the raw sha.
+func UpdateRef(name string, sha []byte) error {
+	return UpdateRefIn(config.GitPath(), name, sha)
+}
+
+// UpdateRefIn points the fully qualified ref name in the git directory
+// gitDir at the raw sha, creating parent directories as needed.
+func UpdateRefIn(gitDir string, name string, sha []byte) error {
+	path := filepath.Join(gitDir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(hex.EncodeToString(sha)+"\n"), 0644)
+}
+
+// UpdateSymbolicRef points the symbolic ref name, such as
+// refs/remotes/origin/HEAD, at the fully qualified ref target.
+func UpdateSymbolicRef(name string, target string) error {
+	path := filepath.Join(config.GitPath(), name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(fmt.Sprintf("ref: %s\n", target)), 0644)
+}
+
+// Upstream returns the fully qualified remote tracking ref configured for
+// branch, or an empty string if the branch has no upstream.
+func Upstream(branch string) (string, error) {
+	c, err := config.RepositoryConfig()
+	if err != nil {
+		return "", err
+	}
+	remote, ok := c.Get(fmt.Sprintf("branch.%s.remote", branch))
+	if !ok {
+		return "", nil
+	}
+	merge, ok := c.Get(fmt.Sprintf("branch.%s.merge", branch))
+	if !ok {
+		return "", nil
+	}
+	if remote == "." {
+		return merge, nil
+	}
+	return fmt.Sprintf("refs/remotes/%s/%s", remote, strings.TrimPrefix(merge, "refs/heads/")), nil
+}
+
+// ShortName returns the abbreviated form of a fully qualified ref.
+func ShortName(ref string) string {
+	for _, p := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+		if strings.HasPrefix(ref, p) {
+			return strings.TrimPrefix(ref, p)
+		}
+	}
+	return ref
 }
 
 // CurrentBranch returns the name of the current branch
@@ -103,8 +283,8 @@ func CreateBranch(name string) error {
 		return err
 	}
 
-	sha := make([]byte, 20)
-	if _, err := hex.Decode(sha, head); err != nil {
+	sha, err := hex.DecodeString(string(head))
+	if err != nil {
 		return err
 	}
 	return UpdateBranchHead(name, sha)
@@ -113,3 +293,31 @@ func CreateBranch(name string) error {
 func DeleteBranch(name string) error {
 	return os.Remove(filepath.Join(config.RefsHeadsDirectory(), name))
 }
+
+// DeleteRefIn removes the fully qualified ref name from the git directory
+// gitDir, both the loose ref and any entry in packed-refs.
+func DeleteRefIn(gitDir string, name string) error {
+	if err := os.Remove(filepath.Join(gitDir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	path := filepath.Join(gitDir, "packed-refs")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var out []string
+	skipPeeled := false
+	for _, l := range strings.SplitAfter(string(b), "\n") {
+		if skipPeeled && strings.HasPrefix(l, "^") {
+			continue
+		}
+		skipPeeled = strings.HasSuffix(strings.TrimSuffix(l, "\n"), " "+name)
+		if !skipPeeled {
+			out = append(out, l)
+		}
+	}
+	return os.WriteFile(path, []byte(strings.Join(out, "")), 0644)
+}
@@ -0,0 +1,81 @@
+package refs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Refspec maps refs in a source repository to refs in a destination
+// repository, such as +refs/heads/*:refs/remotes/origin/*.
+type Refspec struct {
+	Src   string
+	Dst   string
+	Force bool
+}
+
+// ParseRefspec parses a refspec. Without a destination the destination is
+// empty, an empty source deletes the destination.
+func ParseRefspec(s string) (*Refspec, error) {
+	r := &Refspec{}
+	if strings.HasPrefix(s, "+") {
+		r.Force = true
+		s = s[1:]
+	}
+	src, dst, _ := strings.Cut(s, ":")
+	r.Src, r.Dst = src, dst
+	srcGlob, dstGlob := strings.Contains(src, "*"), strings.Contains(dst, "*")
+	if strings.Count(src, "*") > 1 || strings.Count(dst, "*") > 1 || (dst != "" && srcGlob != dstGlob) {
+		return nil, fmt.Errorf("fatal: invalid refspec '%s'", s)
+	}
+	if src == "" && (dst == "" || dstGlob) {
+		return nil, fmt.Errorf("fatal: invalid refspec '%s'", s)
+	}
+	return r, nil
+}
+
+// Match reports whether the fully qualified ref name matches the source of
+// the refspec and returns the corresponding destination.
+func (r *Refspec) Match(name string) (string, bool) {
+	i := strings.Index(r.Src, "*")
+	if i < 0 {
+		if name != r.Src {
+			return "", false
+		}
+		return r.Dst, true
+	}
+	prefix, suffix := r.Src[:i], r.Src[i+1:]
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) || len(name) < len(prefix)+len(suffix) {
+		return "", false
+	}
+	if r.Dst == "" {
+		return "", true
+	}
+	return strings.Replace(r.Dst, "*", name[len(prefix):len(name)-len(suffix)], 1), true
+}
+
+func (r *Refspec) String() string {
+	s := r.Src
+	if r.Dst != "" {
+		s += ":" + r.Dst
+	}
+	if r.Force {
+		s = "+" + s
+	}
+	return s
+}
+
+// Expand returns the fully qualified form of a short ref name such as main
+// given the existing refs, preferring tags over branches as git does for
+// ambiguous names.
+func Expand(name string, existing map[string][]byte) (string, bool) {
+	if name == "HEAD" || strings.HasPrefix(name, "refs/") {
+		_, ok := existing[name]
+		return name, ok
+	}
+	for _, p := range []string{"refs/tags/", "refs/heads/", "refs/remotes/"} {
+		if _, ok := existing[p+name]; ok {
+			return p + name, true
+		}
+	}
+	return "", false
+}
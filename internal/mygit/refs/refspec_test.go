@@ -0,0 +1,35 @@
+package refs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_Refspec(t *testing.T) {
+	tests := []struct {
+		refspec string
+		name    string
+		dst     string
+		match   bool
+	}{
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/main", "refs/remotes/origin/main", true},
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/a/b", "refs/remotes/origin/a/b", true},
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/tags/v1", "", false},
+		{"refs/heads/feature-*:refs/heads/*", "refs/heads/feature-x", "refs/heads/x", true},
+		{"refs/heads/main:refs/heads/other", "refs/heads/main", "refs/heads/other", true},
+		{"refs/heads/main", "refs/heads/main", "", true},
+		{":refs/heads/gone", "refs/heads/gone", "", false},
+	}
+	for _, tt := range tests {
+		r, err := ParseRefspec(tt.refspec)
+		assert.NoError(t, err, tt.refspec)
+		dst, ok := r.Match(tt.name)
+		assert.Equal(t, tt.match, ok, tt.refspec)
+		assert.Equal(t, tt.dst, dst, tt.refspec)
+		assert.Equal(t, tt.refspec, r.String())
+	}
+	for _, v := range []string{"refs/heads/*:refs/heads/main", "refs/*/*:refs/*", ":", ":refs/heads/*"} {
+		_, err := ParseRefspec(v)
+		assert.Error(t, err, v)
+	}
+}
@@ -0,0 +1,557 @@
+package mygit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/bundle"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/hooks"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"github.com/richardjennings/mygit/internal/mygit/transport"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FetchOptions configures Fetch
+type FetchOptions struct {
+	// Force updates local refs which are not fast-forwards
+	Force bool
+	// Tags fetches every tag in addition to the refspecs
+	Tags bool
+	// Quiet only reports errors
+	Quiet bool
+}
+
+// PushOptions configures Push
+type PushOptions struct {
+	// Force updates remote refs which are not fast-forwards
+	Force bool
+	// Quiet only reports errors
+	Quiet bool
+	// NoVerify bypasses the pre-push hook
+	NoVerify bool
+}
+
+type (
+	// remote is a configured remote or a url used directly
+	remote struct {
+		name     string
+		url      string
+		refspecs []*refs.Refspec
+	}
+	// refChange is the update of one ref reported by fetch and push
+	refChange struct {
+		src    string
+		dst    string
+		old    []byte
+		new    []byte
+		force  bool
+		status string
+		reason string
+	}
+)
+
+// resolveRemote returns the remote named name from the repository
+// configuration, or a remote without a name using name as its url.
+func resolveRemote(name string) (*remote, error) {
+	c, err := config.RepositoryConfig()
+	if err != nil {
+		return nil, err
+	}
+	r := &remote{url: name}
+	if url, ok := c.Get(fmt.Sprintf("remote.%s.url", name)); ok {
+		r.name, r.url = name, url
+		for _, v := range c.GetAll(fmt.Sprintf("remote.%s.fetch", name)) {
+			spec, err := refs.ParseRefspec(v)
+			if err != nil {
+				return nil, err
+			}
+			r.refspecs = append(r.refspecs, spec)
+		}
+	} else if _, err := transport.LocalGitDir(name); err != nil && !transport.IsHTTP(name) && !bundle.IsBundle(name) {
+		return nil, fmt.Errorf("fatal: '%s' does not appear to be a git repository", name)
+	}
+	return r, nil
+}
+
+// trackingRef returns the remote-tracking ref for the remote ref name
+// according to the fetch refspecs of the remote.
+func (r *remote) trackingRef(name string) (string, bool) {
+	for _, v := range r.refspecs {
+		if dst, ok := v.Match(name); ok && dst != "" {
+			return dst, true
+		}
+	}
+	return "", false
+}
+
+// Fetch downloads objects and refs from a remote repository. Without refspecs
+// the configured fetch refspecs of the remote are used. Tags pointing at
+// fetched commits are also fetched.
+func Fetch(o io.Writer, name string, refspecs []string, opts FetchOptions) error {
+	r, err := resolveRemote(name)
+	if err != nil {
+		return err
+	}
+	specs := r.refspecs
+	if len(refspecs) > 0 || r.name == "" {
+		specs = nil
+		if len(refspecs) == 0 {
+			refspecs = []string{"HEAD"}
+		}
+		for _, v := range refspecs {
+			spec, err := refs.ParseRefspec(v)
+			if err != nil {
+				return err
+			}
+			specs = append(specs, spec)
+		}
+	}
+	if opts.Tags {
+		specs = append(specs, &refs.Refspec{Src: "refs/tags/*", Dst: "refs/tags/*"})
+	}
+	t, err := transport.New(r.url, transport.WithProgress(progressWriter(o, opts.Quiet)))
+	if err != nil {
+		return err
+	}
+	advertised, err := t.Refs()
+	if err != nil {
+		return err
+	}
+	remoteRefs := make(map[string][]byte)
+	for _, v := range advertised {
+		remoteRefs[v.Name] = v.Sha
+	}
+	var changes []*refChange
+	for _, spec := range specs {
+		if !strings.Contains(spec.Src, "*") {
+			src, ok := refs.Expand(spec.Src, remoteRefs)
+			if !ok {
+				return fmt.Errorf("fatal: couldn't find remote ref %s", spec.Src)
+			}
+			dst := spec.Dst
+			if dst == "" {
+				// a configured remote also updates the remote-tracking ref
+				dst, _ = r.trackingRef(src)
+			} else if !strings.HasPrefix(dst, "refs/") {
+				dst = "refs/heads/" + dst
+			}
+			changes = append(changes, &refChange{src: src, dst: dst, new: remoteRefs[src], force: spec.Force})
+			continue
+		}
+		for _, v := range advertised {
+			if dst, ok := spec.Match(v.Name); ok {
+				changes = append(changes, &refChange{src: v.Name, dst: dst, new: v.Sha, force: spec.Force})
+			}
+		}
+	}
+	if err := fetchObjects(t, changes); err != nil {
+		return err
+	}
+	if !opts.Tags {
+		// follow tags pointing at objects which are now present
+		var tags []*refChange
+		for _, v := range advertised {
+			if !strings.HasPrefix(v.Name, "refs/tags/") {
+				continue
+			}
+			if existing, err := refs.ReadRef(v.Name); err != nil {
+				return err
+			} else if existing != nil {
+				continue
+			}
+			target := v.Peeled
+			if target == nil {
+				target = v.Sha
+			}
+			if objects.Exists(target) {
+				tags = append(tags, &refChange{src: v.Name, dst: v.Name, new: v.Sha})
+			}
+		}
+		if err := fetchObjects(t, tags); err != nil {
+			return err
+		}
+		changes = append(changes, tags...)
+	}
+	current, err := currentBranchRef()
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, c := range changes {
+		if c.dst == "" {
+			continue
+		}
+		if c.old, err = refs.ReadRef(c.dst); err != nil {
+			return err
+		}
+		if err := classifyChange(c, opts.Force, true); err != nil {
+			return err
+		}
+		if c.status == "!" {
+			failed = true
+			continue
+		}
+		if c.dst == current && !bytes.Equal(c.old, c.new) {
+			c.status, c.reason = "!", "refusing to fetch into current branch"
+			failed = true
+			continue
+		}
+		if c.status != "=" {
+			if err := updateRefHex(c.dst, c.new); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writeFetchHead(r.url, changes); err != nil {
+		return err
+	}
+	if !opts.Quiet {
+		printChanges(o, "From", r.url, changes)
+	}
+	if failed {
+		return errors.New("error: some local refs could not be updated")
+	}
+	return nil
+}
+
+// fetchObjects fetches the objects of changes which are missing locally.
+func fetchObjects(t transport.Transport, changes []*refChange) error {
+	var wants [][]byte
+	seen := make(map[string]struct{})
+	for _, c := range changes {
+		if _, ok := seen[string(c.new)]; ok || objects.Exists(c.new) {
+			continue
+		}
+		seen[string(c.new)] = struct{}{}
+		wants = append(wants, c.new)
+	}
+	if len(wants) == 0 {
+		return nil
+	}
+	return t.Fetch(wants)
+}
+
+// classifyChange sets the status of a ref change as git reports it. Tags are
+// never updated without force when fetching.
+func classifyChange(c *refChange, force bool, fetching bool) error {
+	force = force || c.force
+	kind := "branch"
+	if strings.HasPrefix(c.dst, "refs/tags/") {
+		kind = "tag"
+	} else if !strings.HasPrefix(c.dst, "refs/heads/") && !strings.HasPrefix(c.dst, "refs/remotes/") {
+		kind = "ref"
+	}
+	switch {
+	case bytes.Equal(c.old, c.new):
+		c.status, c.reason = "=", "[up to date]"
+	case c.new == nil:
+		c.status, c.reason = "-", "[deleted]"
+	case c.old == nil:
+		c.status, c.reason = "*", fmt.Sprintf("[new %s]", kind)
+	case kind == "tag" && force:
+		c.status = "+"
+	case kind == "tag":
+		c.status = "!"
+		if fetching {
+			c.reason = "would clobber existing tag"
+		} else {
+			c.reason = "already exists"
+		}
+	default:
+		ff, err := objects.IsAncestor(c.old, c.new)
+		if err != nil {
+			return err
+		}
+		switch {
+		case ff:
+			c.status = " "
+		case force:
+			c.status = "+"
+		case !objects.Exists(c.old):
+			c.status, c.reason = "!", "fetch first"
+		default:
+			c.status, c.reason = "!", "non-fast-forward"
+		}
+	}
+	return nil
+}
+
+// printChanges reports ref changes in the format used by git fetch and push.
+func printChanges(o io.Writer, direction string, url string, changes []*refChange) {
+	var lines []string
+	width := 0
+	for _, c := range changes {
+		if c.status != "=" && len(refs.ShortName(c.src)) > width {
+			width = len(refs.ShortName(c.src))
+		}
+	}
+	for _, c := range changes {
+		if c.status == "=" || c.status == "" {
+			continue
+		}
+		src := refs.ShortName(c.src)
+		if c.new == nil {
+			src = refs.ShortName(c.dst)
+		}
+		var summary, suffix string
+		switch c.status {
+		case " ":
+			summary = fmt.Sprintf("%.7s..%.7s", c.old, c.new)
+		case "+":
+			summary = fmt.Sprintf("%.7s...%.7s", c.old, c.new)
+			suffix = " (forced update)"
+		case "!":
+			summary = "[rejected]"
+			if strings.HasPrefix(c.reason, "remote: ") {
+				summary = "[remote rejected]"
+			}
+			suffix = fmt.Sprintf(" (%s)", strings.TrimPrefix(c.reason, "remote: "))
+		default:
+			summary = c.reason
+		}
+		if c.status == "-" {
+			lines = append(lines, fmt.Sprintf(" - %-17s %s\n", summary, src))
+			continue
+		}
+		dst := refs.ShortName(c.dst)
+		if c.dst == "" {
+			dst = "FETCH_HEAD"
+		}
+		lines = append(lines, fmt.Sprintf(" %s %-17s %-*s -> %s%s\n", c.status, summary, width, src, dst, suffix))
+	}
+	if len(lines) == 0 {
+		if direction == "To" {
+			_, _ = fmt.Fprintln(o, "Everything up-to-date")
+		}
+		return
+	}
+	_, _ = fmt.Fprintf(o, "%s %s\n", direction, url)
+	for _, l := range lines {
+		_, _ = fmt.Fprint(o, l)
+	}
+}
+
+// writeFetchHead records the fetched refs in FETCH_HEAD.
+func writeFetchHead(url string, changes []*refChange) error {
+	var b strings.Builder
+	for _, c := range changes {
+		desc := fmt.Sprintf("'%s'", c.src)
+		switch {
+		case strings.HasPrefix(c.src, "refs/heads/"):
+			desc = fmt.Sprintf("branch '%s'", refs.ShortName(c.src))
+		case strings.HasPrefix(c.src, "refs/tags/"):
+			desc = fmt.Sprintf("tag '%s'", refs.ShortName(c.src))
+		}
+		b.WriteString(fmt.Sprintf("%s\t\t%s of %s\n", c.new, desc, url))
+	}
+	return os.WriteFile(filepath.Join(config.GitPath(), "FETCH_HEAD"), []byte(b.String()), 0644)
+}
+
+// currentBranchRef returns the fully qualified name of the current branch.
+func currentBranchRef() (string, error) {
+	branch, err := refs.CurrentBranch()
+	if err != nil {
+		return "", err
+	}
+	return "refs/heads/" + branch, nil
+}
+
+// Push updates refs in a remote repository along with the objects they need.
+// Without refspecs the current branch is pushed to the branch of the same
+// name. An empty source in a refspec deletes the remote ref.
+func Push(o io.Writer, name string, refspecs []string, opts PushOptions) error {
+	r, err := resolveRemote(name)
+	if err != nil {
+		return err
+	}
+	current, err := currentBranchRef()
+	if err != nil {
+		return err
+	}
+	if len(refspecs) == 0 {
+		refspecs = []string{current}
+	}
+	localRefs, err := refs.ListRefs()
+	if err != nil {
+		return err
+	}
+	if sha, err := refs.ReadRef(current); err != nil {
+		return err
+	} else if sha != nil {
+		localRefs["HEAD"] = sha
+	}
+	t, err := transport.New(r.url, transport.WithProgress(progressWriter(o, opts.Quiet)))
+	if err != nil {
+		return err
+	}
+	advertised, err := t.Refs()
+	if err != nil {
+		return err
+	}
+	remoteRefs := make(map[string][]byte)
+	for _, v := range advertised {
+		remoteRefs[v.Name] = v.Sha
+	}
+	var changes []*refChange
+	for _, v := range refspecs {
+		spec, err := refs.ParseRefspec(v)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(spec.Src, "*") {
+			var names []string
+			for k := range localRefs {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			for _, k := range names {
+				if dst, ok := spec.Match(k); ok {
+					if dst == "" {
+						dst = k
+					}
+					changes = append(changes, &refChange{src: k, dst: dst, new: localRefs[k], force: spec.Force})
+				}
+			}
+			continue
+		}
+		c := &refChange{force: spec.Force}
+		if spec.Src != "" {
+			src, ok := refs.Expand(spec.Src, localRefs)
+			if !ok {
+				return fmt.Errorf("error: src refspec %s does not match any", spec.Src)
+			}
+			if src == "HEAD" {
+				src = current
+			}
+			c.src, c.new = src, localRefs[src]
+		}
+		c.dst = spec.Dst
+		switch {
+		case c.dst == "":
+			c.dst = c.src
+		case !strings.HasPrefix(c.dst, "refs/"):
+			if dst, ok := refs.Expand(c.dst, remoteRefs); ok {
+				c.dst = dst
+			} else if strings.HasPrefix(c.src, "refs/tags/") {
+				c.dst = "refs/tags/" + c.dst
+			} else {
+				c.dst = "refs/heads/" + c.dst
+			}
+		}
+		if c.src == "" && remoteRefs[c.dst] == nil {
+			return fmt.Errorf("error: unable to delete '%s': remote ref does not exist", spec.Dst)
+		}
+		changes = append(changes, c)
+	}
+	var updates []*transport.RefUpdate
+	var pushed []*refChange
+	for _, c := range changes {
+		c.old = remoteRefs[c.dst]
+		if err := classifyChange(c, opts.Force, false); err != nil {
+			return err
+		}
+		if c.status == "=" || c.status == "!" {
+			continue
+		}
+		updates = append(updates, &transport.RefUpdate{Name: c.dst, Old: c.old, New: c.new})
+		pushed = append(pushed, c)
+	}
+	if len(updates) > 0 && !opts.NoVerify {
+		name := r.name
+		if name == "" {
+			name = r.url
+		}
+		if err := runHook("pre-push", []string{name, r.url}, hooks.WithStdin(prePushInput(pushed)), hooks.WithOutput(o)); err != nil {
+			return fmt.Errorf("error: failed to push some refs to '%s'", r.url)
+		}
+	}
+	if len(updates) > 0 {
+		if err := t.Push(updates); err != nil {
+			return err
+		}
+	}
+	for i, u := range updates {
+		c := pushed[i]
+		if u.Err != nil {
+			c.status, c.reason = "!", "remote: "+u.Err.Error()
+			continue
+		}
+		// keep the remote-tracking ref in step with the remote
+		tracking, ok := r.trackingRef(c.dst)
+		if !ok {
+			continue
+		}
+		if c.new == nil {
+			err = refs.DeleteRefIn(config.GitPath(), tracking)
+		} else {
+			err = updateRefHex(tracking, c.new)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !opts.Quiet {
+		printChanges(o, "To", r.url, changes)
+	}
+	for _, c := range changes {
+		if c.status == "!" {
+			return fmt.Errorf("error: failed to push some refs to '%s'", r.url)
+		}
+	}
+	return nil
+}
+
+// prePushInput is the standard input of the pre-push hook, a line for each
+// ref to be updated with the local ref and object and the remote ref and
+// object.
+func prePushInput(changes []*refChange) io.Reader {
+	zero := string(config.Hash().ZeroHex())
+	var b bytes.Buffer
+	for _, c := range changes {
+		src, sha, old := c.src, string(c.new), string(c.old)
+		if c.new == nil {
+			src, sha = "(delete)", zero
+		}
+		if c.old == nil {
+			old = zero
+		}
+		_, _ = fmt.Fprintf(&b, "%s %s %s %s\n", src, sha, c.dst, old)
+	}
+	return &b
+}
+
+// progressWriter returns a writer prefixing each line of progress messages
+// from the remote, or discarding them when quiet.
+func progressWriter(o io.Writer, quiet bool) io.Writer {
+	if quiet {
+		return io.Discard
+	}
+	return &remoteProgress{w: o, start: true}
+}
+
+type remoteProgress struct {
+	w     io.Writer
+	start bool
+}
+
+func (p *remoteProgress) Write(b []byte) (int, error) {
+	var out []byte
+	for _, c := range b {
+		if p.start {
+			out = append(out, "remote: "...)
+			p.start = false
+		}
+		out = append(out, c)
+		p.start = c == '\n' || c == '\r'
+	}
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,261 @@
+package revision
+
+import (
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// refRules are the patterns tried in order when expanding a short ref name
+var refRules = []string{
+	"%s",
+	"refs/%s",
+	"refs/tags/%s",
+	"refs/heads/%s",
+	"refs/remotes/%s",
+	"refs/remotes/%s/HEAD",
+}
+
+// Range is a set of commits given by revisions to include and exclude, as in
+// "a..b" or "b ^a".
+type Range struct {
+	// Include are the hex hashes of the revisions to include
+	Include [][]byte
+	// Exclude are the hex hashes of the revisions whose history is excluded
+	Exclude [][]byte
+	// Refs are the full names of the included revisions which are refs,
+	// such as refs/heads/main for main
+	Refs []string
+}
+
+// ExpandRef returns the full name of an existing ref for the short name,
+// such as refs/heads/main for main.
+func ExpandRef(name string) (string, bool) {
+	if name == "@" {
+		name = config.DefaultHeadFile
+	}
+	if name == "" || strings.ContainsAny(name, "~^:") {
+		return "", false
+	}
+	for _, rule := range refRules {
+		full := fmt.Sprintf(rule, name)
+		if rule == "%s" && full != config.DefaultHeadFile && !strings.HasPrefix(full, "refs/") {
+			continue
+		}
+		if sha, err := refs.ReadRef(full); err == nil && sha != nil {
+			return full, true
+		}
+	}
+	return "", false
+}
+
+// Resolve returns the hex hash of the object named by rev. A rev is a ref
+// name, a full or abbreviated object name, or HEAD, followed by any of ~<n>
+// for the nth first-parent ancestor, ^<n> for the nth parent and ^{} or
+// ^{<type>} to peel tags.
+func Resolve(rev string) ([]byte, error) {
+	i := strings.IndexAny(rev, "~^")
+	if i < 0 {
+		i = len(rev)
+	}
+	sha, err := resolveName(rev[:i])
+	if err != nil {
+		return nil, err
+	}
+	for s := rev[i:]; s != ""; {
+		op := s[0]
+		s = s[1:]
+		if op == '^' && strings.HasPrefix(s, "{") {
+			end := strings.Index(s, "}")
+			if end < 0 {
+				return nil, fmt.Errorf("fatal: ambiguous argument '%s': unknown revision", rev)
+			}
+			if sha, err = peel(sha, s[1:end]); err != nil {
+				return nil, fmt.Errorf("fatal: ambiguous argument '%s': %w", rev, err)
+			}
+			s = s[end+1:]
+			continue
+		}
+		j := 0
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			j++
+		}
+		n := 1
+		if j > 0 {
+			if n, err = strconv.Atoi(s[:j]); err != nil {
+				return nil, err
+			}
+		}
+		s = s[j:]
+		if sha, err = ancestor(sha, op, n); err != nil {
+			return nil, fmt.Errorf("fatal: ambiguous argument '%s': unknown revision or path not in the working tree.", rev)
+		}
+	}
+	return sha, nil
+}
+
+// resolveName resolves a ref name or object name without suffixes.
+func resolveName(name string) ([]byte, error) {
+	if name == "" {
+		name = config.DefaultHeadFile
+	}
+	if full, ok := ExpandRef(name); ok {
+		return refs.ReadRef(full)
+	}
+	if len(name) >= objects.MinAbbrev && len(name) <= config.Hash().HexSize() {
+		if sha, err := objects.ResolvePrefix(config.ObjectPath(), name); err == nil {
+			return sha, nil
+		} else if errors.Is(err, objects.ErrAmbiguous) {
+			return nil, fmt.Errorf("fatal: short object ID %s is ambiguous", name)
+		}
+	}
+	return nil, fmt.Errorf("fatal: ambiguous argument '%s': unknown revision or path not in the working tree.", name)
+}
+
+// peel follows tags from sha to an object of type typ, any non-tag object
+// when typ is empty.
+func peel(sha []byte, typ string) ([]byte, error) {
+	for {
+		obj, err := objects.ReadObject(sha)
+		if err != nil {
+			return nil, err
+		}
+		if (typ == "" && obj.Typ != objects.ObjectTag) || obj.Typ.String() == typ {
+			return sha, nil
+		}
+		switch {
+		case obj.Typ == objects.ObjectTag:
+			t, err := objects.ReadTag(sha)
+			if err != nil {
+				return nil, err
+			}
+			sha = t.Object
+		case obj.Typ == objects.ObjectCommit && typ == "tree":
+			c, err := objects.ReadCommit(sha)
+			if err != nil {
+				return nil, err
+			}
+			sha = c.Tree
+		default:
+			return nil, fmt.Errorf("%s is not a %s", sha, typ)
+		}
+	}
+}
+
+// ancestor returns the nth first parent ancestor of sha for ~ and the nth
+// parent for ^, where ^0 is the commit itself.
+func ancestor(sha []byte, op byte, n int) ([]byte, error) {
+	sha, err := peel(sha, "commit")
+	if err != nil {
+		return nil, err
+	}
+	if op == '^' {
+		if n == 0 {
+			return sha, nil
+		}
+		c, err := objects.ReadCommit(sha)
+		if err != nil {
+			return nil, err
+		}
+		if n > len(c.Parents) {
+			return nil, fmt.Errorf("%s has no parent %d", sha, n)
+		}
+		return c.Parents[n-1], nil
+	}
+	for ; n > 0; n-- {
+		c, err := objects.ReadCommit(sha)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.Parents) == 0 {
+			return nil, fmt.Errorf("%s has no parent", sha)
+		}
+		sha = c.Parents[0]
+	}
+	return sha, nil
+}
+
+// ParseRange parses revisions such as "main", "^v1", "v1..main", "v1.." and
+// "..main" where a missing side of .. is HEAD. --all includes every ref,
+// --branches every branch and --tags every tag.
+func ParseRange(args []string) (*Range, error) {
+	r := &Range{}
+	include := func(rev string) error {
+		sha, err := Resolve(rev)
+		if err != nil {
+			return err
+		}
+		r.Include = append(r.Include, sha)
+		if full, ok := ExpandRef(rev); ok {
+			r.Refs = append(r.Refs, full)
+		}
+		return nil
+	}
+	exclude := func(rev string) error {
+		sha, err := Resolve(rev)
+		if err != nil {
+			return err
+		}
+		r.Exclude = append(r.Exclude, sha)
+		return nil
+	}
+	for _, arg := range args {
+		var err error
+		switch {
+		case arg == "--all" || arg == "--branches" || arg == "--tags":
+			err = r.includeRefs(arg)
+		case strings.Contains(arg, "..."):
+			err = fmt.Errorf("fatal: symmetric difference '%s' is not supported", arg)
+		case strings.Contains(arg, ".."):
+			from, to, _ := strings.Cut(arg, "..")
+			if from == "" {
+				from = config.DefaultHeadFile
+			}
+			if to == "" {
+				to = config.DefaultHeadFile
+			}
+			if err = exclude(from); err == nil {
+				err = include(to)
+			}
+		case strings.HasPrefix(arg, "^"):
+			err = exclude(arg[1:])
+		default:
+			err = include(arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *Range) includeRefs(arg string) error {
+	all, err := refs.ListRefs()
+	if err != nil {
+		return err
+	}
+	prefix := map[string]string{"--all": "refs/", "--branches": "refs/heads/", "--tags": "refs/tags/"}[arg]
+	names := make([]string, 0, len(all))
+	for k := range all {
+		if strings.HasPrefix(k, prefix) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		r.Include = append(r.Include, all[k])
+		r.Refs = append(r.Refs, k)
+	}
+	if arg == "--all" {
+		if sha, err := refs.ReadRef(config.DefaultHeadFile); err == nil && sha != nil {
+			r.Include = append(r.Include, sha)
+			r.Refs = append(r.Refs, config.DefaultHeadFile)
+		}
+	}
+	return nil
+}
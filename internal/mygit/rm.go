@@ -0,0 +1,182 @@
+package mygit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/gfs"
+	"github.com/richardjennings/mygit/internal/mygit/index"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/pathspec"
+	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RmOptions configures Rm
+type RmOptions struct {
+	// Cached only removes files from the index
+	Cached bool
+	// Recursive allows removing the contents of named directories
+	Recursive bool
+	// Force removes files with staged or local changes
+	Force bool
+	// Quiet does not list removed files
+	Quiet bool
+	// IgnoreUnmatch does not fail if a pattern matches no files
+	IgnoreUnmatch bool
+}
+
+// Rm removes files matching the pathspec patterns from the index and, unless
+// cached, from the working directory.
+func Rm(o io.Writer, opts RmOptions, paths ...string) error {
+	ps, err := pathspec.New(pathspec.Prefix(), paths...)
+	if err != nil {
+		return err
+	}
+	idx, err := index.ReadIndex()
+	if err != nil {
+		return err
+	}
+	commitSha, err := refs.LastCommit()
+	if err != nil {
+		return err
+	}
+	var commitFiles []*gfs.File
+	if commitSha != nil {
+		if commitFiles, err = objects.CommittedFiles(commitSha); err != nil {
+			return err
+		}
+	}
+	commitSet := gfs.NewFileSet(commitFiles)
+
+	var known []string
+	var matched []*gfs.File
+	for _, v := range idx.Files() {
+		known = append(known, v.Path)
+		if ps.Match(v.Path) {
+			matched = append(matched, v)
+		}
+	}
+	if unmatched := ps.Unmatched(known); len(unmatched) > 0 && !opts.IgnoreUnmatch {
+		return fmt.Errorf("fatal: pathspec '%s' did not match any files", unmatched[0])
+	}
+	if recursive := ps.Recursive(known); len(recursive) > 0 && !opts.Recursive {
+		return fmt.Errorf("fatal: not removing '%s' recursively without -r", recursive[0])
+	}
+	if !opts.Force {
+		if err := rmCheck(matched, commitSet, opts.Cached); err != nil {
+			return err
+		}
+	}
+
+	for _, v := range matched {
+		if err := idx.Rm(v.Path); err != nil {
+			return err
+		}
+	}
+	if err := idx.Write(); err != nil {
+		return err
+	}
+	for _, v := range matched {
+		if !opts.Quiet {
+			if _, err := fmt.Fprintf(o, "rm '%s'\n", v.Path); err != nil {
+				return err
+			}
+		}
+		if opts.Cached {
+			continue
+		}
+		if err := removeFile(v.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rmCheck returns an error listing files which would lose staged or local
+// changes if removed.
+func rmCheck(files []*gfs.File, commitSet *gfs.FileSet, cached bool) error {
+	var both, staged, local []string
+	for _, v := range files {
+		c, ok := commitSet.Contains(v.Path)
+		stagedChanged := !ok || !c.Sha.Same(v.Sha)
+		localChanged, err := worktreeChanged(v)
+		if err != nil {
+			return err
+		}
+		switch {
+		case stagedChanged && localChanged:
+			both = append(both, v.Path)
+		case cached:
+		case stagedChanged:
+			staged = append(staged, v.Path)
+		case localChanged:
+			local = append(local, v.Path)
+		}
+	}
+	var msgs []string
+	if len(both) > 0 {
+		msgs = append(msgs, rmCheckMessage(both, "staged content different from both the\nfile and the HEAD", "(use -f to force removal)"))
+	}
+	if len(staged) > 0 {
+		msgs = append(msgs, rmCheckMessage(staged, "changes staged in the index", "(use --cached to keep the file, or -f to force removal)"))
+	}
+	if len(local) > 0 {
+		msgs = append(msgs, rmCheckMessage(local, "local modifications", "(use --cached to keep the file, or -f to force removal)"))
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+func rmCheckMessage(paths []string, problem string, hint string) string {
+	msg := "error: the following file has " + problem + ":\n"
+	if len(paths) > 1 {
+		msg = "error: the following files have " + problem + ":\n"
+	}
+	for _, v := range paths {
+		msg += "    " + v + "\n"
+	}
+	return msg + hint
+}
+
+// worktreeChanged reports whether the working directory content of an index
+// file differs from the index. Missing files are not considered changed.
+func worktreeChanged(f *gfs.File) (bool, error) {
+	info, err := os.Lstat(filepath.Join(config.Path(), f.Path))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if f.Finfo != nil && info.ModTime().Equal(f.Finfo.ModTime()) {
+		return false, nil
+	}
+	sha, err := objects.HashBlob(f.Path)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(sha, f.Sha.AsBytes()), nil
+}
+
+// removeFile removes a file from the working directory along with any parent
+// directories left empty.
+func removeFile(path string) error {
+	if err := os.Remove(filepath.Join(config.Path(), path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for d := filepath.Dir(path); d != "."; d = filepath.Dir(d) {
+		if err := os.Remove(filepath.Join(config.Path(), d)); err != nil {
+			// not empty
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/server"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ServeOptions configures Serve
+type ServeOptions struct {
+	// HTTP is the address to serve the smart HTTP protocol on
+	HTTP string
+	// Command is the upload-pack or receive-pack command to serve over
+	// stdin and stdout, as in SSH_ORIGINAL_COMMAND for a forced command
+	Command string
+	// ReceivePack allows pushing over HTTP to every repository
+	ReceivePack bool
+}
+
+// Serve serves the repositories below the configured path, either over HTTP
+// or as a single command reading from r and writing to w. Hook output of
+// a command is written to e when the client does not multiplex it.
+func Serve(r io.Reader, w io.Writer, e io.Writer, opts ServeOptions) error {
+	if opts.HTTP != "" {
+		_, _ = fmt.Fprintf(e, "Serving %s on %s\n", config.Path(), opts.HTTP)
+		return http.ListenAndServe(opts.HTTP, &server.Handler{Root: config.Path(), ReceivePack: opts.ReceivePack})
+	}
+	service, path, err := parseServeCommand(opts.Command)
+	if err != nil {
+		return err
+	}
+	gitDir, err := server.Resolve(config.Path(), path)
+	if err != nil {
+		return fmt.Errorf("fatal: %w", err)
+	}
+	o := []server.Opt{
+		server.WithProtocol(server.ProtocolVersion(os.Getenv("GIT_PROTOCOL"))),
+		server.WithStderr(e),
+	}
+	if service == server.UploadPackService {
+		return server.UploadPack(gitDir, r, w, o...)
+	}
+	return server.ReceivePack(gitDir, r, w, o...)
+}
+
+// parseServeCommand parses a command such as git-upload-pack '/repo.git'
+// into the service and repository path.
+func parseServeCommand(command string) (string, string, error) {
+	command = strings.TrimSpace(command)
+	if strings.HasPrefix(command, "git ") {
+		command = "git-" + strings.TrimSpace(strings.TrimPrefix(command, "git "))
+	}
+	service, path, _ := strings.Cut(command, " ")
+	if service != server.UploadPackService && service != server.ReceivePackService {
+		return "", "", fmt.Errorf("fatal: unrecognized command '%s'", command)
+	}
+	path = strings.TrimSpace(path)
+	if len(path) >= 2 && path[0] == '\'' && path[len(path)-1] == '\'' {
+		path = path[1 : len(path)-1]
+	}
+	if path == "" || strings.ContainsAny(path, "'\n") {
+		return "", "", errors.New("fatal: invalid repository path")
+	}
+	return service, path, nil
+}
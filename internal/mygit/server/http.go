@@ -0,0 +1,92 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Handler serves the repositories below Root over the smart HTTP protocol.
+// Pushing is allowed when ReceivePack is set or the repository config sets
+// http.receivepack.
+type Handler struct {
+	Root        string
+	ReceivePack bool
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var repo, service string
+	advertise := false
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/info/refs"):
+		repo = strings.TrimSuffix(r.URL.Path, "/info/refs")
+		service = r.URL.Query().Get("service")
+		advertise = true
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/"+UploadPackService):
+		repo, service = strings.TrimSuffix(r.URL.Path, "/"+UploadPackService), UploadPackService
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/"+ReceivePackService):
+		repo, service = strings.TrimSuffix(r.URL.Path, "/"+ReceivePackService), ReceivePackService
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if service != UploadPackService && service != ReceivePackService {
+		// the dumb protocol is not supported
+		http.Error(w, "Request not supported", http.StatusForbidden)
+		return
+	}
+	gitDir, err := Resolve(h.Root, repo)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if service == ReceivePackService && !h.receivePack(gitDir) {
+		http.Error(w, "Service not enabled: 'receive-pack'", http.StatusForbidden)
+		return
+	}
+	version := ProtocolVersion(r.Header.Get("Git-Protocol"))
+	opts := []Opt{WithStatelessRPC(), WithProtocol(version)}
+	w.Header().Set("Cache-Control", "no-cache")
+	if advertise {
+		w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
+		if version != 2 || service == ReceivePackService {
+			if _, err := fmt.Fprintf(w, "%04x# service=%s\n0000", len(service)+15, service); err != nil {
+				return
+			}
+		}
+		opts = append(opts, WithAdvertiseRefs())
+	} else {
+		w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", service))
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		z, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer func() { _ = z.Close() }()
+		body = z
+	}
+	if service == UploadPackService {
+		_ = UploadPack(gitDir, body, w, opts...)
+		return
+	}
+	_ = ReceivePack(gitDir, body, w, opts...)
+}
+
+func (h *Handler) receivePack(gitDir string) bool {
+	if h.ReceivePack {
+		return true
+	}
+	cnf, err := config.ReadGitConfig(filepath.Join(gitDir, config.DefaultConfigFile))
+	if err != nil {
+		return false
+	}
+	v, _ := cnf.Bool("http.receivepack")
+	return v
+}
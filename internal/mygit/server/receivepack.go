@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/pktline"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+var receivePackCapabilities = []string{"report-status", "delete-refs", "side-band-64k", "quiet", "ofs-delta"}
+
+// ReceivePack serves a push to the repository at gitDir, reading the
+// commands and pack from r and writing the status report to w. The refs are
+// updated by UpdateRefs.
+func ReceivePack(gitDir string, r io.Reader, w io.Writer, opts ...Opt) error {
+	o := newOptions(opts)
+	pw := pktline.NewWriter(w)
+	if !o.statelessRPC || o.advertiseRefs {
+		if err := advertiseReceivePack(gitDir, pw); err != nil {
+			return err
+		}
+		if o.advertiseRefs {
+			return nil
+		}
+	}
+	br := bufio.NewReader(r)
+	pr := pktline.NewReader(br)
+	var commands []*Command
+	caps := make(map[string]bool)
+	format := config.HashOf(gitDir)
+	for {
+		l, t, err := pr.ReadLine()
+		if errors.Is(err, io.EOF) && len(commands) == 0 {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if t == pktline.Flush {
+			break
+		}
+		l, requested, ok := strings.Cut(l, "\x00")
+		if ok {
+			for _, v := range strings.Fields(requested) {
+				caps[v] = true
+			}
+		}
+		fields := strings.Fields(l)
+		if len(fields) != 3 || len(fields[0]) != format.HexSize() || len(fields[1]) != format.HexSize() {
+			return fmt.Errorf("protocol error: expected old/new/ref, got '%s'", l)
+		}
+		c := &Command{Name: fields[2], Old: []byte(fields[0]), New: []byte(fields[1])}
+		if bytes.Equal(c.Old, format.ZeroHex()) {
+			c.Old = nil
+		}
+		if bytes.Equal(c.New, format.ZeroHex()) {
+			c.New = nil
+		}
+		commands = append(commands, c)
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+	unpackErr := unpack(gitDir, br, commands)
+	// hook output goes to the client as side-band progress messages
+	out := o.stderr
+	if caps["side-band-64k"] {
+		out = pw.BandWriter(pktline.BandProgress)
+	}
+	if unpackErr != nil {
+		for _, c := range commands {
+			c.Err = errors.New("unpacker error")
+		}
+	} else if err := UpdateRefs(gitDir, commands, out); err != nil {
+		return err
+	}
+	if !caps["report-status"] {
+		if caps["side-band-64k"] {
+			return pw.Flush()
+		}
+		return nil
+	}
+	report := bytes.NewBuffer(nil)
+	rw := pktline.NewWriter(report)
+	if unpackErr != nil {
+		_ = rw.WriteString("unpack %s\n", unpackErr)
+	} else {
+		_ = rw.WriteString("unpack ok\n")
+	}
+	for _, c := range commands {
+		if c.Err != nil {
+			_ = rw.WriteString("ng %s %s\n", c.Name, c.Err)
+		} else {
+			_ = rw.WriteString("ok %s\n", c.Name)
+		}
+	}
+	_ = rw.Flush()
+	if !caps["side-band-64k"] {
+		_, err := w.Write(report.Bytes())
+		return err
+	}
+	if err := pw.WriteBand(pktline.BandData, report.Bytes()); err != nil {
+		return err
+	}
+	return pw.Flush()
+}
+
+func advertiseReceivePack(gitDir string, pw *pktline.Writer) error {
+	all, _, err := listRefs(gitDir)
+	if err != nil {
+		return err
+	}
+	caps := strings.Join(receivePackCapabilities, " ") + objectFormatCapability(gitDir) + " agent=" + agent
+	lines := make([]string, 0, len(all)+1)
+	for _, a := range all {
+		lines = append(lines, fmt.Sprintf("%s %s", a.sha, a.name))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, fmt.Sprintf("%s capabilities^{}", config.HashOf(gitDir).ZeroHex()))
+	}
+	lines[0] += "\x00" + caps
+	for _, l := range lines {
+		if err := pw.WriteString("%s\n", l); err != nil {
+			return err
+		}
+	}
+	return pw.Flush()
+}
+
+// unpack reads the pack following the commands into the object store of
+// gitDir. Only deletions are sent without a pack.
+func unpack(gitDir string, r *bufio.Reader, commands []*Command) error {
+	deletes := true
+	for _, c := range commands {
+		if c.New != nil {
+			deletes = false
+		}
+	}
+	if deletes {
+		return nil
+	}
+	_, err := objects.UnpackObjects(r, filepath.Join(gitDir, config.DefaultObjectsDirectory))
+	return err
+}
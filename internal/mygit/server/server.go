@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/hooks"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// UploadPackService serves fetches and clones
+	UploadPackService = "git-upload-pack"
+	// ReceivePackService serves pushes
+	ReceivePackService = "git-receive-pack"
+
+	agent = "mygit"
+)
+
+type (
+	options struct {
+		statelessRPC  bool
+		advertiseRefs bool
+		protocol      int
+		stderr        io.Writer
+	}
+	Opt func(o *options)
+)
+
+// WithStatelessRPC serves a single request and response, as used by the
+// smart HTTP protocol, instead of a conversation.
+func WithStatelessRPC() Opt {
+	return func(o *options) {
+		o.statelessRPC = true
+	}
+}
+
+// WithAdvertiseRefs only writes the ref or capability advertisement.
+func WithAdvertiseRefs() Opt {
+	return func(o *options) {
+		o.advertiseRefs = true
+	}
+}
+
+// WithProtocol sets the protocol version requested by the client, see
+// ProtocolVersion.
+func WithProtocol(version int) Opt {
+	return func(o *options) {
+		o.protocol = version
+	}
+}
+
+// WithStderr sets where hook output is written when the client has not
+// requested side-band messages.
+func WithStderr(w io.Writer) Opt {
+	return func(o *options) {
+		o.stderr = w
+	}
+}
+
+func newOptions(opts []Opt) *options {
+	o := &options{stderr: io.Discard}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// ProtocolVersion returns the protocol version requested by the value of a
+// Git-Protocol header or GIT_PROTOCOL environment variable such as
+// "version=2".
+func ProtocolVersion(v string) int {
+	for _, p := range strings.Split(v, ":") {
+		if p == "version=2" {
+			return 2
+		}
+	}
+	return 0
+}
+
+// Resolve returns the git directory of the repository at path below root.
+// Paths outside of root are not served.
+func Resolve(root string, path string) (string, error) {
+	rel := filepath.Clean("/" + filepath.FromSlash(strings.Trim(path, "/")))
+	dir := filepath.Join(root, rel)
+	for _, v := range []string{filepath.Join(dir, config.DefaultGitDirectory), dir, dir + ".git"} {
+		if _, err := os.Stat(filepath.Join(v, config.DefaultHeadFile)); err != nil {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(v, config.DefaultObjectsDirectory)); err == nil && fi.IsDir() {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("'%s' does not appear to be a git repository", strings.Trim(path, "/"))
+}
+
+// Command is a ref update requested by a push. Hashes are hex encoded, a nil
+// Old creates the ref and a nil New deletes it.
+type Command struct {
+	Name string
+	Old  []byte
+	New  []byte
+	// Err is set when the update is rejected
+	Err error
+}
+
+// orZero returns sha or the all zero hash in format used on the wire for a
+// missing ref.
+func orZero(format *config.ObjectFormat, sha []byte) []byte {
+	if sha == nil {
+		return format.ZeroHex()
+	}
+	return sha
+}
+
+// UpdateRefs applies commands to the repository at gitDir, whose object
+// store must already contain the new objects. The pre-receive hook may
+// decline all of the commands and the update hook each command, the
+// post-receive hook is run for the refs which were updated. Hook output is
+// written to out.
+func UpdateRefs(gitDir string, commands []*Command, out io.Writer) error {
+	format := config.HashOf(gitDir)
+	head, err := refs.SymbolicRefIn(gitDir, config.DefaultHeadFile)
+	if err != nil {
+		return err
+	}
+	bare := filepath.Base(gitDir) != config.DefaultGitDirectory
+	objectsDir := filepath.Join(gitDir, config.DefaultObjectsDirectory)
+	var accepted []*Command
+	for _, c := range commands {
+		current, err := refs.ReadRefIn(gitDir, c.Name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !strings.HasPrefix(c.Name, "refs/"):
+			c.Err = errors.New("funny refname")
+		case c.New != nil && !objects.ExistsIn(objectsDir, c.New):
+			c.Err = errors.New("missing necessary objects")
+		case !bytes.Equal(current, c.Old):
+			c.Err = errors.New("failed to update ref")
+		case !bare && c.Name == head && c.New == nil:
+			c.Err = errors.New("deletion of the current branch prohibited")
+		case !bare && c.Name == head:
+			c.Err = errors.New("branch is currently checked out")
+		default:
+			accepted = append(accepted, c)
+		}
+	}
+	if len(accepted) == 0 {
+		return nil
+	}
+	if err := hooks.Run(gitDir, "pre-receive", nil, hooks.WithStdin(hookInput(format, accepted)), hooks.WithOutput(out)); err != nil {
+		for _, c := range accepted {
+			c.Err = errors.New("pre-receive hook declined")
+		}
+		return nil
+	}
+	var updated []*Command
+	for _, c := range accepted {
+		args := []string{c.Name, string(orZero(format, c.Old)), string(orZero(format, c.New))}
+		if err := hooks.Run(gitDir, "update", args, hooks.WithOutput(out)); err != nil {
+			c.Err = errors.New("hook declined")
+			continue
+		}
+		if c.New == nil {
+			err = refs.DeleteRefIn(gitDir, c.Name)
+		} else {
+			err = updateRefHexIn(gitDir, c.Name, c.New)
+		}
+		if err != nil {
+			c.Err = errors.New("failed to update ref")
+			continue
+		}
+		updated = append(updated, c)
+	}
+	if len(updated) == 0 {
+		return nil
+	}
+	// the refs are already updated so the result of post-receive is ignored
+	_ = hooks.Run(gitDir, "post-receive", nil, hooks.WithStdin(hookInput(format, updated)), hooks.WithOutput(out))
+	return nil
+}
+
+func hookInput(format *config.ObjectFormat, commands []*Command) io.Reader {
+	b := bytes.NewBuffer(nil)
+	for _, c := range commands {
+		_, _ = fmt.Fprintf(b, "%s %s %s\n", orZero(format, c.Old), orZero(format, c.New), c.Name)
+	}
+	return b
+}
+
+func updateRefHexIn(gitDir string, name string, sha []byte) error {
+	raw, err := hex.DecodeString(string(sha))
+	if err != nil {
+		return err
+	}
+	return refs.UpdateRefIn(gitDir, name, raw)
+}
+
+// advertised is a ref and the object an annotated tag points to.
+type advertised struct {
+	name   string
+	sha    []byte
+	peeled []byte
+}
+
+// listRefs returns the refs of gitDir sorted by name and the branch HEAD
+// points to.
+func listRefs(gitDir string) ([]*advertised, string, error) {
+	all, err := refs.ListRefsIn(gitDir)
+	if err != nil {
+		return nil, "", err
+	}
+	head, err := refs.SymbolicRefIn(gitDir, config.DefaultHeadFile)
+	if err != nil {
+		return nil, "", err
+	}
+	objectsDir := filepath.Join(gitDir, config.DefaultObjectsDirectory)
+	var names []string
+	for k := range all {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var r []*advertised
+	for _, name := range names {
+		a := &advertised{name: name, sha: all[name]}
+		peeled, err := objects.Peel(objectsDir, a.sha)
+		if err != nil {
+			return nil, "", err
+		}
+		if !bytes.Equal(peeled, a.sha) {
+			a.peeled = peeled
+		}
+		r = append(r, a)
+	}
+	return r, head, nil
+}
+
+// objectFormatCapability is the object-format capability of the repository
+// at gitDir prefixed with a space, or empty for sha1 which clients assume.
+func objectFormatCapability(gitDir string) string {
+	format := config.HashOf(gitDir)
+	if format == config.SHA1 {
+		return ""
+	}
+	return " object-format=" + format.Name
+}
@@ -0,0 +1,441 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/pktline"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// uploadPackCapabilities are advertised by protocol v0 upload-pack, without
+// multi_ack the first common object found is acknowledged.
+var uploadPackCapabilities = []string{"side-band-64k", "ofs-delta", "thin-pack", "no-progress", "include-tag"}
+
+// UploadPack serves a fetch from the repository at gitDir, reading requests
+// from r and writing responses to w. Protocol v2 is used when requested,
+// otherwise protocol v0.
+func UploadPack(gitDir string, r io.Reader, w io.Writer, opts ...Opt) error {
+	o := newOptions(opts)
+	u := &uploadPack{
+		gitDir:     gitDir,
+		objectsDir: filepath.Join(gitDir, config.DefaultObjectsDirectory),
+		format:     config.HashOf(gitDir),
+		r:          pktline.NewReader(r),
+		w:          pktline.NewWriter(w),
+		out:        w,
+	}
+	if o.protocol == 2 {
+		return u.v2(o)
+	}
+	return u.v0(o)
+}
+
+type uploadPack struct {
+	gitDir     string
+	objectsDir string
+	// format is the object format of the repository
+	format *config.ObjectFormat
+	r      *pktline.Reader
+	w      *pktline.Writer
+	out    io.Writer
+}
+
+// fetchRequest is the negotiated state of a fetch.
+type fetchRequest struct {
+	wants      [][]byte
+	common     [][]byte
+	sideband   bool
+	progress   bool
+	includeTag bool
+}
+
+func (u *uploadPack) v0(o *options) error {
+	if !o.statelessRPC || o.advertiseRefs {
+		if err := u.advertiseV0(); err != nil {
+			return err
+		}
+		if o.advertiseRefs {
+			return nil
+		}
+	}
+	req := &fetchRequest{progress: true}
+	for {
+		l, t, err := u.r.ReadLine()
+		if errors.Is(err, io.EOF) && len(req.wants) == 0 {
+			// the client only wanted the advertisement
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if t == pktline.Flush {
+			break
+		}
+		if !strings.HasPrefix(l, "want ") {
+			return u.error(fmt.Sprintf("upload-pack: protocol error, expected to get object ID, not '%s'", l))
+		}
+		want, caps, _ := strings.Cut(strings.TrimPrefix(l, "want "), " ")
+		for _, c := range strings.Fields(caps) {
+			u.capability(req, c)
+		}
+		req.wants = append(req.wants, []byte(want))
+	}
+	if len(req.wants) == 0 {
+		return nil
+	}
+	if err := u.checkWants(req.wants); err != nil {
+		return err
+	}
+	for {
+		l, t, err := u.r.ReadLine()
+		if errors.Is(err, io.EOF) && o.statelessRPC {
+			// the next request continues the negotiation
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if t == pktline.Flush {
+			if len(req.common) == 0 {
+				if err := u.w.WriteString("NAK\n"); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if l == "done" {
+			break
+		}
+		sha := []byte(strings.TrimPrefix(l, "have "))
+		if !strings.HasPrefix(l, "have ") || !objects.ExistsIn(u.objectsDir, sha) {
+			continue
+		}
+		req.common = append(req.common, sha)
+		if len(req.common) == 1 {
+			if err := u.w.WriteString("ACK %s\n", sha); err != nil {
+				return err
+			}
+		}
+	}
+	if len(req.common) == 0 {
+		if err := u.w.WriteString("NAK\n"); err != nil {
+			return err
+		}
+	}
+	return u.sendPack(req)
+}
+
+func (u *uploadPack) advertiseV0() error {
+	all, head, err := listRefs(u.gitDir)
+	if err != nil {
+		return err
+	}
+	caps := strings.Join(uploadPackCapabilities, " ")
+	if head != "" {
+		caps += fmt.Sprintf(" symref=%s:%s", config.DefaultHeadFile, head)
+	}
+	caps += objectFormatCapability(u.gitDir) + " agent=" + agent
+	lines := make([]string, 0, len(all)+1)
+	for _, a := range all {
+		if a.name == head {
+			lines = append(lines, fmt.Sprintf("%s %s", a.sha, config.DefaultHeadFile))
+		}
+	}
+	for _, a := range all {
+		lines = append(lines, fmt.Sprintf("%s %s", a.sha, a.name))
+		if a.peeled != nil {
+			lines = append(lines, fmt.Sprintf("%s %s^{}", a.peeled, a.name))
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, fmt.Sprintf("%s capabilities^{}", u.format.ZeroHex()))
+	}
+	lines[0] += "\x00" + caps
+	for _, l := range lines {
+		if err := u.w.WriteString("%s\n", l); err != nil {
+			return err
+		}
+	}
+	return u.w.Flush()
+}
+
+func (u *uploadPack) v2(o *options) error {
+	if !o.statelessRPC || o.advertiseRefs {
+		lines := []string{"version 2", "agent=" + agent, "ls-refs", "fetch"}
+		if u.format != config.SHA1 {
+			lines = append(lines, "object-format="+u.format.Name)
+		}
+		for _, l := range lines {
+			if err := u.w.WriteString("%s\n", l); err != nil {
+				return err
+			}
+		}
+		if err := u.w.Flush(); err != nil {
+			return err
+		}
+		if o.advertiseRefs {
+			return nil
+		}
+	}
+	for {
+		command, args, err := u.readCommand()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch command {
+		case "":
+			// a flush without a command ends the conversation
+			return nil
+		case "ls-refs":
+			err = u.lsRefs(args)
+		case "fetch":
+			err = u.fetch(args)
+		default:
+			err = u.error(fmt.Sprintf("unknown command '%s'", command))
+		}
+		if err != nil || o.statelessRPC {
+			return err
+		}
+	}
+}
+
+// readCommand reads a protocol v2 command request, capabilities up to a
+// delimiter are ignored.
+func (u *uploadPack) readCommand() (string, []string, error) {
+	var command string
+	var args []string
+	section := 0
+	for {
+		l, t, err := u.r.ReadLine()
+		if err != nil {
+			return "", nil, err
+		}
+		switch t {
+		case pktline.Flush:
+			return command, args, nil
+		case pktline.Delim:
+			section++
+			continue
+		}
+		if section == 0 {
+			if v, ok := strings.CutPrefix(l, "command="); ok {
+				command = v
+			}
+			continue
+		}
+		args = append(args, l)
+	}
+}
+
+func (u *uploadPack) lsRefs(args []string) error {
+	var symrefs, peel bool
+	var prefixes []string
+	for _, v := range args {
+		switch {
+		case v == "symrefs":
+			symrefs = true
+		case v == "peel":
+			peel = true
+		case strings.HasPrefix(v, "ref-prefix "):
+			prefixes = append(prefixes, strings.TrimPrefix(v, "ref-prefix "))
+		}
+	}
+	match := func(name string) bool {
+		if len(prefixes) == 0 {
+			return true
+		}
+		for _, p := range prefixes {
+			if strings.HasPrefix(name, p) {
+				return true
+			}
+		}
+		return false
+	}
+	all, head, err := listRefs(u.gitDir)
+	if err != nil {
+		return err
+	}
+	if match(config.DefaultHeadFile) {
+		for _, a := range all {
+			if a.name != head {
+				continue
+			}
+			l := fmt.Sprintf("%s %s", a.sha, config.DefaultHeadFile)
+			if symrefs {
+				l += " symref-target:" + head
+			}
+			if err := u.w.WriteString("%s\n", l); err != nil {
+				return err
+			}
+		}
+	}
+	for _, a := range all {
+		if !match(a.name) {
+			continue
+		}
+		l := fmt.Sprintf("%s %s", a.sha, a.name)
+		if peel && a.peeled != nil {
+			l += " peeled:" + string(a.peeled)
+		}
+		if err := u.w.WriteString("%s\n", l); err != nil {
+			return err
+		}
+	}
+	return u.w.Flush()
+}
+
+func (u *uploadPack) fetch(args []string) error {
+	req := &fetchRequest{sideband: true, progress: true}
+	done := false
+	for _, v := range args {
+		switch {
+		case strings.HasPrefix(v, "want "):
+			req.wants = append(req.wants, []byte(strings.TrimPrefix(v, "want ")))
+		case strings.HasPrefix(v, "have "):
+			sha := []byte(strings.TrimPrefix(v, "have "))
+			if objects.ExistsIn(u.objectsDir, sha) {
+				req.common = append(req.common, sha)
+			}
+		case v == "done":
+			done = true
+		default:
+			u.capability(req, v)
+		}
+	}
+	if err := u.checkWants(req.wants); err != nil {
+		return err
+	}
+	if !done {
+		if err := u.w.WriteString("acknowledgments\n"); err != nil {
+			return err
+		}
+		if len(req.common) == 0 {
+			if err := u.w.WriteString("NAK\n"); err != nil {
+				return err
+			}
+			return u.w.Flush()
+		}
+		for _, v := range req.common {
+			if err := u.w.WriteString("ACK %s\n", v); err != nil {
+				return err
+			}
+		}
+		// any common commit is enough, there are no shallow clones
+		if err := u.w.WriteString("ready\n"); err != nil {
+			return err
+		}
+		if err := u.w.Delim(); err != nil {
+			return err
+		}
+	}
+	if err := u.w.WriteString("packfile\n"); err != nil {
+		return err
+	}
+	return u.sendPack(req)
+}
+
+func (u *uploadPack) capability(req *fetchRequest, c string) {
+	switch {
+	case c == "side-band-64k":
+		req.sideband = true
+	case c == "no-progress":
+		req.progress = false
+	case c == "include-tag":
+		req.includeTag = true
+	}
+}
+
+// checkWants rejects wants which are not in the repository.
+func (u *uploadPack) checkWants(wants [][]byte) error {
+	for _, v := range wants {
+		if !objects.ExistsIn(u.objectsDir, v) {
+			return u.error(fmt.Sprintf("upload-pack: not our ref %s", v))
+		}
+	}
+	return nil
+}
+
+// error sends msg to the client in an ERR packet and returns it.
+func (u *uploadPack) error(msg string) error {
+	_ = u.w.WriteString("ERR %s\n", msg)
+	return errors.New(msg)
+}
+
+// sendPack writes a pack of the objects reachable from the wants but not the
+// common objects, multiplexed with progress messages when side-band was
+// requested.
+func (u *uploadPack) sendPack(req *fetchRequest) error {
+	shas, err := u.packObjects(req)
+	if err != nil {
+		return err
+	}
+	if !req.sideband {
+		return objects.WritePack(u.out, u.objectsDir, shas)
+	}
+	if req.progress {
+		if err := u.w.WriteBand(pktline.BandProgress, []byte(fmt.Sprintf("Enumerating objects: %d, done.\n", len(shas)))); err != nil {
+			return err
+		}
+	}
+	bw := bufio.NewWriterSize(u.w.BandWriter(pktline.BandData), pktline.MaxPayload-1)
+	if err := objects.WritePack(bw, u.objectsDir, shas); err != nil {
+		_ = u.w.WriteBand(pktline.BandError, []byte(err.Error()))
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return u.w.Flush()
+}
+
+// packObjects returns the objects to send, with annotated tags of sent
+// commits when include-tag was requested.
+func (u *uploadPack) packObjects(req *fetchRequest) ([][]byte, error) {
+	theirs, err := objects.Reachable(u.objectsDir, req.common, func([]byte) bool { return false })
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]struct{}, len(theirs))
+	for _, v := range theirs {
+		known[string(v)] = struct{}{}
+	}
+	have := func(sha []byte) bool {
+		_, ok := known[string(sha)]
+		return ok
+	}
+	shas, err := objects.Reachable(u.objectsDir, req.wants, have)
+	if err != nil || !req.includeTag {
+		return shas, err
+	}
+	sent := make(map[string]struct{}, len(shas))
+	for _, v := range shas {
+		sent[string(v)] = struct{}{}
+		known[string(v)] = struct{}{}
+	}
+	all, _, err := listRefs(u.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range all {
+		if a.peeled == nil || !strings.HasPrefix(a.name, "refs/tags/") || have(a.sha) {
+			continue
+		}
+		if _, ok := sent[string(a.peeled)]; !ok {
+			continue
+		}
+		tags, err := objects.Reachable(u.objectsDir, [][]byte{a.sha}, have)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range tags {
+			known[string(v)] = struct{}{}
+		}
+		shas = append(shas, tags...)
+	}
+	return shas, nil
+}
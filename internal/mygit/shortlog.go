@@ -0,0 +1,140 @@
+package mygit
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/mailmap"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/pretty"
+	"github.com/richardjennings/mygit/internal/mygit/revision"
+	"io"
+	"sort"
+)
+
+// ShortlogOptions configures Shortlog
+type ShortlogOptions struct {
+	// Summary shows only the number of commits of each author
+	Summary bool
+	// Numbered sorts authors by their number of commits, most first
+	Numbered bool
+	// Email shows the email of each author
+	Email bool
+}
+
+// shortlogAuthor is an author and the subjects of their commits
+type shortlogAuthor struct {
+	name     string
+	subjects []string
+}
+
+// Shortlog writes the subjects of the commits in the range given by revs,
+// HEAD when empty, grouped by author as mapped by the mailmap. Authors are
+// sorted by name and their commits shown oldest first.
+func Shortlog(o io.Writer, opts ShortlogOptions, revs ...string) error {
+	if len(revs) == 0 {
+		revs = []string{config.DefaultHeadFile}
+	}
+	r, err := revision.ParseRange(revs)
+	if err != nil {
+		return err
+	}
+	m, err := mailmap.New()
+	if err != nil {
+		return err
+	}
+	commits, err := rangeCommits(r)
+	if err != nil {
+		return err
+	}
+	authors := make(map[string]*shortlogAuthor)
+	var sorted []*shortlogAuthor
+	for i := len(commits) - 1; i >= 0; i-- {
+		c := commits[i]
+		name, email := m.Map(c.Author, c.AuthorEmail)
+		if opts.Email {
+			name = fmt.Sprintf("%s <%s>", name, email)
+		}
+		a, ok := authors[name]
+		if !ok {
+			a = &shortlogAuthor{name: name}
+			authors[name] = a
+			sorted = append(sorted, a)
+		}
+		a.subjects = append(a.subjects, pretty.Subject(c.Message))
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if opts.Numbered && len(sorted[i].subjects) != len(sorted[j].subjects) {
+			return len(sorted[i].subjects) > len(sorted[j].subjects)
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	for _, a := range sorted {
+		if opts.Summary {
+			if _, err := fmt.Fprintf(o, "%6d\t%s\n", len(a.subjects), a.name); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(o, "%s (%d):\n", a.name, len(a.subjects)); err != nil {
+			return err
+		}
+		for _, s := range a.subjects {
+			if _, err := fmt.Fprintf(o, "      %s\n", s); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangeCommits returns the commits in r, most recently committed first
+func rangeCommits(r *revision.Range) ([]*objects.Commit, error) {
+	excluded := make(map[string]struct{})
+	for _, v := range r.Exclude {
+		sha, err := objects.Peel(config.ObjectPath(), v)
+		if err != nil {
+			return nil, err
+		}
+		ancestors, err := objects.Ancestors(sha)
+		if err != nil {
+			return nil, err
+		}
+		for k := range ancestors {
+			excluded[k] = struct{}{}
+		}
+	}
+	var commits []*objects.Commit
+	var queue [][]byte
+	for _, v := range r.Include {
+		sha, err := objects.Peel(config.ObjectPath(), v)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, sha)
+	}
+	seen := make(map[string]struct{})
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if _, ok := seen[string(sha)]; ok {
+			continue
+		}
+		seen[string(sha)] = struct{}{}
+		if _, ok := excluded[string(sha)]; ok {
+			continue
+		}
+		c, err := objects.ReadCommit(sha)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, c)
+		queue = append(queue, c.Parents...)
+	}
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].CommittedTime.After(commits[j].CommittedTime)
+	})
+	return commits, nil
+}
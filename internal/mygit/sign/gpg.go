@@ -0,0 +1,77 @@
+package sign
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// gpgSign signs payload with gpg, as git does, returning the armored
+// detached signature.
+func gpgSign(o *options, key string, payload []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(o.program, "--status-fd=2", "-bsau", key)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil || !bytes.Contains(stderr.Bytes(), []byte("[GNUPG:] SIG_CREATED ")) {
+		if stderr.Len() > 0 {
+			return nil, fmt.Errorf("%serror: gpg failed to sign the data", stderr.String())
+		}
+		return nil, errors.New("error: gpg failed to sign the data")
+	}
+	return stdout.Bytes(), nil
+}
+
+// gpgVerify verifies a detached signature of payload with gpg, reading the
+// result from the status lines gpg writes.
+func gpgVerify(o *options, payload []byte, sig []byte) (*Signature, error) {
+	f, err := os.CreateTemp("", ".git_vtag_tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := f.Write(sig); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	var status, stderr bytes.Buffer
+	cmd := exec.Command(o.program, "--keyid-format=long", "--status-fd=1", "--verify", f.Name(), "-")
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &status
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// a bad signature exits with a non-zero status
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) {
+			return nil, err
+		}
+	}
+	s := &Signature{Output: strings.TrimRight(stderr.String(), "\n")}
+	sc := bufio.NewScanner(&status)
+	for sc.Scan() {
+		fields := strings.SplitN(strings.TrimPrefix(sc.Text(), "[GNUPG:] "), " ", 3)
+		switch fields[0] {
+		case "GOODSIG":
+			s.Good = true
+			if len(fields) == 3 {
+				s.Key, s.Signer = fields[1], fields[2]
+			}
+		case "VALIDSIG":
+			if len(fields) > 1 {
+				s.Key = fields[1]
+			}
+		case "BADSIG", "ERRSIG", "EXPSIG", "EXPKEYSIG", "REVKEYSIG":
+			s.Good = false
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,122 @@
+package sign
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Formats of signature, as gpg.format names them
+const (
+	OpenPGP = "openpgp"
+	SSH     = "ssh"
+)
+
+// signaturePrefixes are the first lines of the signatures of each format
+var signaturePrefixes = map[string]string{
+	"-----BEGIN PGP SIGNATURE-----": OpenPGP,
+	"-----BEGIN PGP MESSAGE-----":   OpenPGP,
+	sshBegin:                        SSH,
+}
+
+type (
+	options struct {
+		program        string
+		allowedSigners string
+	}
+	Opt func(o *options)
+	// Signature is the result of verifying a signature
+	Signature struct {
+		// Good is whether the signature is valid for the payload and made
+		// by a trusted key
+		Good bool
+		// Untrusted is set when the signature is valid but its key is not
+		// trusted, such as an SSH key without a principal
+		Untrusted bool
+		// Signer is the principal of an SSH signature or the user id of an
+		// OpenPGP signature, empty when not known
+		Signer string
+		// Key is the fingerprint of the signing key
+		Key string
+		// Output describes the signature as git shows it
+		Output string
+	}
+)
+
+// WithProgram sets the gpg program used for OpenPGP signatures, which
+// defaults to gpg.
+func WithProgram(program string) Opt {
+	return func(o *options) {
+		o.program = program
+	}
+}
+
+// WithAllowedSigners sets the allowed signers file naming the principals of
+// the keys trusted to make SSH signatures.
+func WithAllowedSigners(path string) Opt {
+	return func(o *options) {
+		o.allowedSigners = path
+	}
+}
+
+func newOptions(opts []Opt) *options {
+	o := &options{program: "gpg"}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Sign returns the armored signature of payload in format made with key. An
+// SSH key is the path of a private key, or of its public key next to it. An
+// OpenPGP key is any key id gpg accepts.
+func Sign(format string, key string, payload []byte, opts ...Opt) ([]byte, error) {
+	o := newOptions(opts)
+	switch format {
+	case OpenPGP:
+		return gpgSign(o, key, payload)
+	case SSH:
+		return sshSign(key, payload)
+	}
+	return nil, fmt.Errorf("fatal: unsupported signature format %s", format)
+}
+
+// Verify verifies the armored signature sig of payload. A signature which
+// does not verify is returned as not Good, an error is returned when it
+// cannot be checked.
+func Verify(payload []byte, sig []byte, opts ...Opt) (*Signature, error) {
+	o := newOptions(opts)
+	switch Format(sig) {
+	case OpenPGP:
+		return gpgVerify(o, payload, sig)
+	case SSH:
+		return sshVerify(o, payload, sig)
+	}
+	return nil, fmt.Errorf("error: unknown signature format")
+}
+
+// Format returns the format of the armored signature sig, empty if it is
+// not a signature.
+func Format(sig []byte) string {
+	line, _, _ := bytes.Cut(sig, []byte("\n"))
+	return signaturePrefixes[string(line)]
+}
+
+// Split splits a signed tag into the payload and the signature appended to
+// it, the signature is nil when the tag is not signed.
+func Split(b []byte) ([]byte, []byte) {
+	match := -1
+	for i := 0; i < len(b); {
+		if Format(b[i:]) != "" {
+			match = i
+		}
+		n := bytes.IndexByte(b[i:], '\n')
+		if n < 0 {
+			break
+		}
+		i += n + 1
+	}
+	if match < 0 {
+		return b, nil
+	}
+	return b[:match], b[match:]
+}
@@ -0,0 +1,577 @@
+package sign
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// SSH signatures are in the format of ssh-keygen -Y sign, see PROTOCOL.sshsig
+// in OpenSSH.
+const (
+	sshBegin     = "-----BEGIN SSH SIGNATURE-----"
+	sshEnd       = "-----END SSH SIGNATURE-----"
+	sshMagic     = "SSHSIG"
+	sshVersion   = 1
+	sshNamespace = "git"
+	sshHash      = "sha512"
+	// openSSHMagic starts the private keys written by ssh-keygen
+	openSSHMagic = "openssh-key-v1\x00"
+)
+
+var errInvalidSSH = errors.New("error: invalid ssh key or signature")
+
+// sshCurves are the curves of ECDSA keys by their SSH name
+var sshCurves = map[string]elliptic.Curve{
+	"nistp256": elliptic.P256(),
+	"nistp384": elliptic.P384(),
+	"nistp521": elliptic.P521(),
+}
+
+// sshReader reads the fields of the SSH wire format, remembering the first
+// error.
+type sshReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sshReader) bytes(n uint32) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if int64(n) > int64(len(r.b)) {
+		r.err = errInvalidSSH
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *sshReader) uint32() uint32 {
+	b := r.bytes(4)
+	if r.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *sshReader) string() []byte {
+	return r.bytes(r.uint32())
+}
+
+func (r *sshReader) mpint() *big.Int {
+	return new(big.Int).SetBytes(r.string())
+}
+
+func sshString(b []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(b))), b...)
+}
+
+func sshMpint(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return sshString(b)
+}
+
+func curveName(c elliptic.Curve) string {
+	for k, v := range sshCurves {
+		if v == c {
+			return k
+		}
+	}
+	return ""
+}
+
+// curveHash is the hash ECDSA signatures use with curve c.
+func curveHash(c elliptic.Curve) crypto.Hash {
+	switch c {
+	case elliptic.P384():
+		return crypto.SHA384
+	case elliptic.P521():
+		return crypto.SHA512
+	}
+	return crypto.SHA256
+}
+
+func digest(h crypto.Hash, data []byte) []byte {
+	var w hash.Hash
+	switch h {
+	case crypto.SHA256:
+		w = sha256.New()
+	case crypto.SHA384:
+		w = sha512.New384()
+	default:
+		w = sha512.New()
+	}
+	w.Write(data)
+	return w.Sum(nil)
+}
+
+// marshalPublicKey encodes pub in the SSH wire format.
+func marshalPublicKey(pub crypto.PublicKey) ([]byte, error) {
+	var b []byte
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		b = append(b, sshString([]byte("ssh-ed25519"))...)
+		b = append(b, sshString(k)...)
+	case *rsa.PublicKey:
+		b = append(b, sshString([]byte("ssh-rsa"))...)
+		b = append(b, sshMpint(big.NewInt(int64(k.E)))...)
+		b = append(b, sshMpint(k.N)...)
+	case *ecdsa.PublicKey:
+		name := curveName(k.Curve)
+		if name == "" {
+			return nil, errInvalidSSH
+		}
+		b = append(b, sshString([]byte("ecdsa-sha2-"+name))...)
+		b = append(b, sshString([]byte(name))...)
+		b = append(b, sshString(elliptic.Marshal(k.Curve, k.X, k.Y))...)
+	default:
+		return nil, fmt.Errorf("error: unsupported ssh key type %T", pub)
+	}
+	return b, nil
+}
+
+// parsePublicKey decodes a public key in the SSH wire format, returning the
+// key and its type as ssh-keygen shows it.
+func parsePublicKey(b []byte) (crypto.PublicKey, string, error) {
+	r := &sshReader{b: b}
+	var pub crypto.PublicKey
+	var typ string
+	switch t := string(r.string()); {
+	case t == "ssh-ed25519":
+		k := r.string()
+		if len(k) != ed25519.PublicKeySize {
+			return nil, "", errInvalidSSH
+		}
+		pub, typ = ed25519.PublicKey(k), "ED25519"
+	case t == "ssh-rsa":
+		e := r.mpint()
+		n := r.mpint()
+		if !e.IsInt64() {
+			return nil, "", errInvalidSSH
+		}
+		pub, typ = &rsa.PublicKey{N: n, E: int(e.Int64())}, "RSA"
+	case strings.HasPrefix(t, "ecdsa-sha2-"):
+		curve, ok := sshCurves[string(r.string())]
+		if !ok {
+			return nil, "", errInvalidSSH
+		}
+		x, y := elliptic.Unmarshal(curve, r.string())
+		if x == nil {
+			return nil, "", errInvalidSSH
+		}
+		pub, typ = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, "ECDSA"
+	default:
+		return nil, "", fmt.Errorf("error: unsupported ssh key type %s", t)
+	}
+	if r.err != nil {
+		return nil, "", r.err
+	}
+	return pub, typ, nil
+}
+
+// fingerprint is the SHA256 fingerprint of a public key in the SSH wire
+// format, as ssh-keygen -l shows it.
+func fingerprint(pub []byte) string {
+	sum := sha256.Sum256(pub)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// readSSHKey reads the private key at path, a path to a public key reads
+// the private key next to it. Keys written by ssh-keygen and PEM encoded
+// keys are read, but not keys encrypted with a passphrase.
+func readSSHKey(path string) (crypto.Signer, error) {
+	if strings.HasPrefix(path, "key::") || strings.HasPrefix(path, "ssh-") {
+		return nil, errors.New("fatal: signing with a literal public key needs ssh-agent, set user.signingKey to the path of a private key")
+	}
+	path = strings.TrimSuffix(path, ".pub")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fatal: cannot read ssh signing key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("fatal: %s is not an ssh private key", path)
+	}
+	var key any
+	switch block.Type {
+	case "OPENSSH PRIVATE KEY":
+		key, err = parseOpenSSHKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("fatal: %s is not an ssh private key", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fatal: cannot read ssh signing key %s: %w", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("fatal: unsupported ssh key type %T", key)
+	}
+	return signer, nil
+}
+
+// parseOpenSSHKey parses the first key of an unencrypted openssh-key-v1
+// private key.
+func parseOpenSSHKey(b []byte) (crypto.Signer, error) {
+	if !bytes.HasPrefix(b, []byte(openSSHMagic)) {
+		return nil, errInvalidSSH
+	}
+	r := &sshReader{b: b[len(openSSHMagic):]}
+	cipher := string(r.string())
+	_ = r.string() // kdf name
+	_ = r.string() // kdf options
+	if r.uint32() < 1 {
+		return nil, errInvalidSSH
+	}
+	_ = r.string() // public key
+	r = &sshReader{b: r.string(), err: r.err}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if cipher != "none" {
+		return nil, errors.New("keys encrypted with a passphrase are not supported")
+	}
+	if r.uint32() != r.uint32() {
+		return nil, errInvalidSSH
+	}
+	var key crypto.Signer
+	switch t := string(r.string()); {
+	case t == "ssh-ed25519":
+		_ = r.string() // public key
+		k := r.string()
+		if len(k) != ed25519.PrivateKeySize {
+			return nil, errInvalidSSH
+		}
+		key = ed25519.PrivateKey(k)
+	case t == "ssh-rsa":
+		n := r.mpint()
+		e := r.mpint()
+		d := r.mpint()
+		_ = r.mpint() // iqmp
+		p := r.mpint()
+		q := r.mpint()
+		k := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		if r.err == nil {
+			if err := k.Validate(); err != nil {
+				return nil, err
+			}
+			k.Precompute()
+		}
+		key = k
+	case strings.HasPrefix(t, "ecdsa-sha2-"):
+		curve, ok := sshCurves[string(r.string())]
+		if !ok {
+			return nil, errInvalidSSH
+		}
+		x, y := elliptic.Unmarshal(curve, r.string())
+		d := r.mpint()
+		if x == nil {
+			return nil, errInvalidSSH
+		}
+		key = &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}
+	default:
+		return nil, fmt.Errorf("unsupported ssh key type %s", t)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return key, nil
+}
+
+// sshSignedData is the data signed for message, which is hashed with
+// hashAlg, nil if hashAlg is not supported.
+func sshSignedData(namespace string, hashAlg string, message []byte) []byte {
+	var h crypto.Hash
+	switch hashAlg {
+	case "sha256":
+		h = crypto.SHA256
+	case "sha512":
+		h = crypto.SHA512
+	default:
+		return nil
+	}
+	b := []byte(sshMagic)
+	b = append(b, sshString([]byte(namespace))...)
+	b = append(b, sshString(nil)...)
+	b = append(b, sshString([]byte(hashAlg))...)
+	return append(b, sshString(digest(h, message))...)
+}
+
+// sshRawSign signs data with key, returning the signature in the SSH wire
+// format.
+func sshRawSign(key crypto.Signer, data []byte) ([]byte, error) {
+	var algo string
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		algo, sig = "ssh-ed25519", ed25519.Sign(k, data)
+	case *rsa.PrivateKey:
+		algo = "rsa-sha2-512"
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA512, digest(crypto.SHA512, data))
+	case *ecdsa.PrivateKey:
+		algo = "ecdsa-sha2-" + curveName(k.Curve)
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest(curveHash(k.Curve), data))
+		if err == nil {
+			sig = append(sshMpint(r), sshMpint(s)...)
+		}
+	default:
+		return nil, fmt.Errorf("error: unsupported ssh key type %T", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(sshString([]byte(algo)), sshString(sig)...), nil
+}
+
+// sshRawVerify reports whether sig in the SSH wire format is a signature of
+// data made with pub.
+func sshRawVerify(pub crypto.PublicKey, data []byte, sig []byte) bool {
+	r := &sshReader{b: sig}
+	algo := string(r.string())
+	blob := r.string()
+	if r.err != nil || data == nil {
+		return false
+	}
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return algo == "ssh-ed25519" && ed25519.Verify(k, data, blob)
+	case *rsa.PublicKey:
+		h := crypto.SHA512
+		switch algo {
+		case "rsa-sha2-256":
+			h = crypto.SHA256
+		case "rsa-sha2-512":
+		default:
+			return false
+		}
+		return rsa.VerifyPKCS1v15(k, h, digest(h, data), blob) == nil
+	case *ecdsa.PublicKey:
+		if algo != "ecdsa-sha2-"+curveName(k.Curve) {
+			return false
+		}
+		r := &sshReader{b: blob}
+		rr := r.mpint()
+		s := r.mpint()
+		return r.err == nil && ecdsa.Verify(k, digest(curveHash(k.Curve), data), rr, s)
+	}
+	return false
+}
+
+// sshSign signs payload with the private key at path in the git namespace.
+func sshSign(path string, payload []byte) ([]byte, error) {
+	key, err := readSSHKey(path)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := marshalPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sshRawSign(key, sshSignedData(sshNamespace, sshHash, payload))
+	if err != nil {
+		return nil, err
+	}
+	b := []byte(sshMagic)
+	b = binary.BigEndian.AppendUint32(b, sshVersion)
+	b = append(b, sshString(pub)...)
+	b = append(b, sshString([]byte(sshNamespace))...)
+	b = append(b, sshString(nil)...)
+	b = append(b, sshString([]byte(sshHash))...)
+	b = append(b, sshString(sig)...)
+	return sshArmor(b), nil
+}
+
+// sshVerify verifies an SSH signature of payload, naming the signer from
+// the allowed signers file.
+func sshVerify(o *options, payload []byte, armored []byte) (*Signature, error) {
+	if o.allowedSigners == "" {
+		return nil, errors.New("error: gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")
+	}
+	b, err := sshDearmor(armored)
+	if err != nil {
+		return nil, err
+	}
+	r := &sshReader{b: b}
+	magic := string(r.bytes(uint32(len(sshMagic))))
+	version := r.uint32()
+	pubBlob := r.string()
+	namespace := string(r.string())
+	_ = r.string() // reserved
+	hashAlg := string(r.string())
+	sig := r.string()
+	if r.err != nil || magic != sshMagic || version != sshVersion {
+		return nil, errors.New("error: invalid ssh signature")
+	}
+	pub, typ, err := parsePublicKey(pubBlob)
+	if err != nil {
+		return nil, err
+	}
+	s := &Signature{Key: fingerprint(pubBlob)}
+	if namespace != sshNamespace || !sshRawVerify(pub, sshSignedData(namespace, hashAlg, payload), sig) {
+		s.Output = "Could not verify signature."
+		return s, nil
+	}
+	if s.Signer, err = findPrincipals(o.allowedSigners, pubBlob); err != nil {
+		return nil, err
+	}
+	// a key without a principal allowed to sign in the namespace is not
+	// trusted
+	s.Good, s.Untrusted = s.Signer != "", s.Signer == ""
+	if s.Signer == "" {
+		s.Output = fmt.Sprintf("Good %q signature with %s key %s\nNo principal matched.", namespace, typ, s.Key)
+	} else {
+		s.Output = fmt.Sprintf("Good %q signature for %s with %s key %s", namespace, s.Signer, typ, s.Key)
+	}
+	return s, nil
+}
+
+func sshArmor(b []byte) []byte {
+	enc := base64.StdEncoding.EncodeToString(b)
+	var buf bytes.Buffer
+	buf.WriteString(sshBegin + "\n")
+	for len(enc) > 70 {
+		buf.WriteString(enc[:70] + "\n")
+		enc = enc[70:]
+	}
+	buf.WriteString(enc + "\n")
+	buf.WriteString(sshEnd + "\n")
+	return buf.Bytes()
+}
+
+func sshDearmor(armored []byte) ([]byte, error) {
+	var enc strings.Builder
+	s := bufio.NewScanner(bytes.NewReader(armored))
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == sshEnd {
+			return base64.StdEncoding.DecodeString(enc.String())
+		}
+		if l != sshBegin {
+			enc.WriteString(l)
+		}
+	}
+	return nil, errors.New("error: invalid ssh signature")
+}
+
+// findPrincipals returns the principals of the key pub in the allowed
+// signers file at path, empty when the key is not allowed to sign in the
+// git namespace. Each line of the file has the principals, optional
+// options and a public key as in authorized_keys.
+func findPrincipals(path string, pub []byte) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.New("error: gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := splitUnquoted(line, func(r rune) bool { return r == ' ' || r == '\t' })
+		if len(fields) < 3 {
+			continue
+		}
+		principals, fields := fields[0], fields[1:]
+		var opts []string
+		if !isSSHKeyType(fields[0]) {
+			opts = splitUnquoted(fields[0], func(r rune) bool { return r == ',' })
+			fields = fields[1:]
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || !bytes.Equal(key, pub) || !allowsNamespace(opts) {
+			continue
+		}
+		return principals, nil
+	}
+	return "", nil
+}
+
+func isSSHKeyType(s string) bool {
+	return strings.HasPrefix(s, "ssh-") || strings.HasPrefix(s, "ecdsa-") || strings.HasPrefix(s, "sk-")
+}
+
+// allowsNamespace reports whether the options of an allowed signer allow
+// signatures in the git namespace. Certificate authorities are not
+// supported.
+func allowsNamespace(opts []string) bool {
+	for _, v := range opts {
+		k, value, _ := strings.Cut(v, "=")
+		switch strings.ToLower(k) {
+		case "cert-authority":
+			return false
+		case "namespaces":
+			found := false
+			for _, ns := range strings.Split(strings.Trim(value, `"`), ",") {
+				if ns == sshNamespace || ns == "*" {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// splitUnquoted splits s at runes for which sep is true outside of double
+// quotes.
+func splitUnquoted(s string, sep func(rune) bool) []string {
+	var fields []string
+	var field strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			field.WriteRune(r)
+		case !quoted && sep(r):
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteRune(r)
+		}
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
@@ -0,0 +1,526 @@
+package mygit
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/gfs"
+	"github.com/richardjennings/mygit/internal/mygit/index"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/pathspec"
+	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	StatusFormatLong StatusFormat = iota
+	StatusFormatShort
+	StatusFormatPorcelainV1
+	StatusFormatPorcelainV2
+)
+
+const (
+	// UntrackedFilesNormal shows untracked files, collapsing directories
+	// containing no tracked files into a single entry
+	UntrackedFilesNormal UntrackedFilesMode = iota
+	// UntrackedFilesAll shows every untracked file
+	UntrackedFilesAll
+	// UntrackedFilesNo does not show untracked files
+	UntrackedFilesNo
+)
+
+const zeroSha = "0000000000000000000000000000000000000000"
+
+type (
+	StatusFormat       int
+	UntrackedFilesMode int
+	// StatusOptions configures the output of Status
+	StatusOptions struct {
+		Format StatusFormat
+		// Branch includes branch and upstream tracking information in short
+		// and porcelain formats.
+		Branch bool
+		// NullTerminated terminates entries with NUL instead of LF and does
+		// not quote paths.
+		NullTerminated bool
+		UntrackedFiles UntrackedFilesMode
+		// Pathspec limits the output to matching paths
+		Pathspec []string
+	}
+	statusEntry struct {
+		path      string
+		origPath  string
+		x         string
+		y         string
+		headMode  uint32
+		idxMode   uint32
+		wdMode    uint32
+		headSha   *gfs.Sha
+		idxSha    *gfs.Sha
+		score     int
+		untracked bool
+	}
+	statusBranch struct {
+		name     string
+		oid      []byte
+		upstream string
+		gone     bool
+		ahead    int
+		behind   int
+	}
+)
+
+// Status displays the file statuses comparing the working directory
+// to the index and the index to the last commit (if any).
+func Status(o io.Writer, opts StatusOptions) error {
+	branch, err := readStatusBranch()
+	if err != nil {
+		return err
+	}
+	ps, err := pathspec.New(pathspec.Prefix(), opts.Pathspec...)
+	if err != nil {
+		return err
+	}
+	entries, err := statusEntries(branch.oid, opts.UntrackedFiles, ps)
+	if err != nil {
+		return err
+	}
+	switch opts.Format {
+	case StatusFormatShort, StatusFormatPorcelainV1:
+		return writeStatusShort(o, opts, branch, entries)
+	case StatusFormatPorcelainV2:
+		return writeStatusPorcelainV2(o, opts, branch, entries)
+	default:
+		return writeStatusLong(o, branch, entries)
+	}
+}
+
+func readStatusBranch() (*statusBranch, error) {
+	name, err := refs.CurrentBranch()
+	if err != nil {
+		return nil, err
+	}
+	oid, err := refs.HeadSHA(name)
+	if err != nil {
+		// @todo error types to check for e.g no previous commits as source of error
+		return nil, err
+	}
+	b := &statusBranch{name: name, oid: oid}
+	b.upstream, err = refs.Upstream(name)
+	if err != nil || b.upstream == "" {
+		return b, err
+	}
+	upstreamSha, err := refs.ReadRef(b.upstream)
+	if err != nil {
+		return nil, err
+	}
+	if upstreamSha == nil {
+		b.gone = true
+		return b, nil
+	}
+	b.ahead, b.behind, err = objects.AheadBehind(oid, upstreamSha)
+	return b, err
+}
+
+// ParseUntrackedFilesMode parses the value of --untracked-files or
+// status.showUntrackedFiles.
+func ParseUntrackedFilesMode(s string) (UntrackedFilesMode, error) {
+	switch s {
+	case "normal", "":
+		return UntrackedFilesNormal, nil
+	case "all":
+		return UntrackedFilesAll, nil
+	case "no":
+		return UntrackedFilesNo, nil
+	}
+	return UntrackedFilesNormal, fmt.Errorf("fatal: Invalid untracked files mode '%s'", s)
+}
+
+// statusEntries lists the changed files comparing the working directory to the
+// index and the index to commitSha. Tracked entries are sorted before untracked
+// entries.
+func statusEntries(commitSha []byte, mode UntrackedFilesMode, ps *pathspec.Pathspec) ([]*statusEntry, error) {
+	idx, err := index.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	files, err := index.Status(idx, commitSha)
+	if err != nil {
+		return nil, err
+	}
+	headFiles := gfs.NewFileSet(nil)
+	if commitSha != nil {
+		committed, err := objects.CommittedFiles(commitSha)
+		if err != nil {
+			return nil, err
+		}
+		headFiles = gfs.NewFileSet(committed)
+	}
+	idxFiles := gfs.NewFileSet(idx.Files())
+
+	var tracked, untracked []*statusEntry
+	for _, v := range files.Files() {
+		if v.IdxStatus == gfs.IndexNotUpdated && v.WdStatus == gfs.WDIndexAndWorkingTreeMatch {
+			continue
+		}
+		if !ps.Match(v.Path) {
+			continue
+		}
+		if v.WdStatus == gfs.WDUntracked {
+			untracked = append(untracked, &statusEntry{path: v.Path, x: "?", y: "?", untracked: true})
+			if v.IdxStatus != gfs.IndexDeletedInIndex {
+				continue
+			}
+		}
+		e := &statusEntry{path: v.Path, x: v.IdxStatus.String(), y: v.WdStatus.String()}
+		if v.WdStatus == gfs.WDUntracked {
+			e.y = " "
+		}
+		if f, ok := headFiles.Contains(v.Path); ok {
+			e.headSha = f.Sha
+			e.headMode = f.Mode
+			if e.headMode == 0 {
+				e.headMode = 0100644
+			}
+		}
+		if f, ok := idxFiles.Contains(v.Path); ok {
+			e.idxSha = f.Sha
+			e.idxMode = 0100644
+			if fi, ok := f.Finfo.(*gfs.Finfo); ok && fi.MMode != 0 {
+				e.idxMode = fi.MMode
+			}
+		}
+		if v.WdStatus != gfs.WDDeletedInWorktree && v.WdStatus != gfs.WDUntracked {
+			if info, err := os.Lstat(filepath.Join(config.Path(), v.Path)); err == nil {
+				e.wdMode = worktreeMode(info)
+			}
+		}
+		tracked = append(tracked, e)
+	}
+	tracked = detectRenames(tracked)
+	switch mode {
+	case UntrackedFilesNo:
+		untracked = nil
+	case UntrackedFilesNormal:
+		untracked = collapseUntracked(untracked, idxFiles)
+	}
+	sort.Slice(tracked, func(i, j int) bool { return tracked[i].path < tracked[j].path })
+	sort.Slice(untracked, func(i, j int) bool { return untracked[i].path < untracked[j].path })
+	return append(tracked, untracked...), nil
+}
+
+// detectRenames pairs files deleted from the index with files added to the
+// index with identical content as renames.
+func detectRenames(entries []*statusEntry) []*statusEntry {
+	deleted := make(map[string]*statusEntry)
+	for _, e := range entries {
+		if e.x == gfs.IndexDeletedInIndex.String() && e.headSha != nil {
+			if _, ok := deleted[e.headSha.AsHexString()]; !ok {
+				deleted[e.headSha.AsHexString()] = e
+			}
+		}
+	}
+	renamed := make(map[*statusEntry]bool)
+	for _, e := range entries {
+		if e.x != gfs.IndexAddedInIndex.String() || e.idxSha == nil {
+			continue
+		}
+		d, ok := deleted[e.idxSha.AsHexString()]
+		if !ok || renamed[d] {
+			continue
+		}
+		renamed[d] = true
+		e.x = gfs.IndexRenamedInIndex.String()
+		e.origPath = d.path
+		e.headSha = d.headSha
+		e.headMode = d.headMode
+		e.score = 100
+	}
+	var r []*statusEntry
+	for _, e := range entries {
+		if !renamed[e] {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// collapseUntracked replaces untracked files within directories that contain
+// no tracked files with a single entry for the top-most such directory.
+func collapseUntracked(untracked []*statusEntry, idxFiles *gfs.FileSet) []*statusEntry {
+	trackedDirs := make(map[string]struct{})
+	for _, v := range idxFiles.Files() {
+		for d := filepath.Dir(v.Path); d != "."; d = filepath.Dir(d) {
+			trackedDirs[d] = struct{}{}
+		}
+	}
+	var r []*statusEntry
+	seen := make(map[string]struct{})
+	for _, e := range untracked {
+		parts := strings.Split(e.path, string(filepath.Separator))
+		path := e.path
+		for i := 1; i < len(parts); i++ {
+			d := filepath.Join(parts[:i]...)
+			if _, ok := trackedDirs[d]; !ok {
+				path = d + string(filepath.Separator)
+				break
+			}
+		}
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		seen[path] = struct{}{}
+		r = append(r, &statusEntry{path: path, x: e.x, y: e.y, untracked: true})
+	}
+	return r
+}
+
+func worktreeMode(info os.FileInfo) uint32 {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return 0120000
+	case info.IsDir():
+		return 0040000
+	case info.Mode()&0111 != 0:
+		return 0100755
+	default:
+		return 0100644
+	}
+}
+
+func writeStatusShort(o io.Writer, opts StatusOptions, branch *statusBranch, entries []*statusEntry) error {
+	eol := "\n"
+	if opts.NullTerminated {
+		eol = "\x00"
+	}
+	buf := bytes.NewBuffer(nil)
+	if opts.Branch {
+		buf.WriteString("## ")
+		if branch.oid == nil {
+			buf.WriteString("No commits yet on ")
+		}
+		buf.WriteString(branch.name)
+		if branch.upstream != "" {
+			buf.WriteString("..." + refs.ShortName(branch.upstream))
+			switch {
+			case branch.gone:
+				buf.WriteString(" [gone]")
+			case branch.ahead > 0 && branch.behind > 0:
+				_, _ = fmt.Fprintf(buf, " [ahead %d, behind %d]", branch.ahead, branch.behind)
+			case branch.ahead > 0:
+				_, _ = fmt.Fprintf(buf, " [ahead %d]", branch.ahead)
+			case branch.behind > 0:
+				_, _ = fmt.Fprintf(buf, " [behind %d]", branch.behind)
+			}
+		}
+		buf.WriteString(eol)
+	}
+	for _, e := range entries {
+		buf.WriteString(e.x + e.y + " ")
+		switch {
+		case e.origPath == "":
+			buf.WriteString(statusPath(e.path, opts, true))
+		case opts.NullTerminated:
+			buf.WriteString(e.path + eol + e.origPath)
+		default:
+			buf.WriteString(statusPath(e.origPath, opts, true) + " -> " + statusPath(e.path, opts, true))
+		}
+		buf.WriteString(eol)
+	}
+	_, err := o.Write(buf.Bytes())
+	return err
+}
+
+func writeStatusPorcelainV2(o io.Writer, opts StatusOptions, branch *statusBranch, entries []*statusEntry) error {
+	eol := "\n"
+	sep := "\t"
+	if opts.NullTerminated {
+		eol = "\x00"
+		sep = "\x00"
+	}
+	buf := bytes.NewBuffer(nil)
+	if opts.Branch {
+		if branch.oid == nil {
+			buf.WriteString("# branch.oid (initial)" + eol)
+		} else {
+			buf.WriteString("# branch.oid " + string(branch.oid) + eol)
+		}
+		buf.WriteString("# branch.head " + branch.name + eol)
+		if branch.upstream != "" {
+			buf.WriteString("# branch.upstream " + refs.ShortName(branch.upstream) + eol)
+			if !branch.gone {
+				_, _ = fmt.Fprintf(buf, "# branch.ab +%d -%d%s", branch.ahead, branch.behind, eol)
+			}
+		}
+	}
+	for _, e := range entries {
+		if e.untracked {
+			buf.WriteString("? " + statusPath(e.path, opts, false) + eol)
+			continue
+		}
+		xy := strings.ReplaceAll(e.x+e.y, " ", ".")
+		if e.origPath == "" {
+			_, _ = fmt.Fprintf(buf, "1 %s N... %06o %06o %06o %s %s %s%s", xy, e.headMode, e.idxMode, e.wdMode, statusSha(e.headSha), statusSha(e.idxSha), statusPath(e.path, opts, false), eol)
+			continue
+		}
+		_, _ = fmt.Fprintf(buf, "2 %s N... %06o %06o %06o %s %s %s%d %s%s%s%s", xy, e.headMode, e.idxMode, e.wdMode, statusSha(e.headSha), statusSha(e.idxSha), e.x, e.score, statusPath(e.path, opts, false), sep, statusPath(e.origPath, opts, false), eol)
+	}
+	_, err := o.Write(buf.Bytes())
+	return err
+}
+
+func writeStatusLong(o io.Writer, branch *statusBranch, entries []*statusEntry) error {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("On branch " + branch.name + "\n")
+	if branch.upstream != "" {
+		upstream := refs.ShortName(branch.upstream)
+		switch {
+		case branch.gone:
+			_, _ = fmt.Fprintf(buf, "Your branch is based on '%s', but the upstream is gone.\n", upstream)
+		case branch.ahead > 0 && branch.behind > 0:
+			_, _ = fmt.Fprintf(buf, "Your branch and '%s' have diverged,\nand have %d and %d different commits each, respectively.\n", upstream, branch.ahead, branch.behind)
+		case branch.ahead > 0:
+			_, _ = fmt.Fprintf(buf, "Your branch is ahead of '%s' by %d %s.\n  (use \"mygit push\" to publish your local commits)\n", upstream, branch.ahead, plural(branch.ahead, "commit", "commits"))
+		case branch.behind > 0:
+			_, _ = fmt.Fprintf(buf, "Your branch is behind '%s' by %d %s, and can be fast-forwarded.\n", upstream, branch.behind, plural(branch.behind, "commit", "commits"))
+		default:
+			_, _ = fmt.Fprintf(buf, "Your branch is up to date with '%s'.\n", upstream)
+		}
+		buf.WriteString("\n")
+	}
+	if branch.oid == nil {
+		buf.WriteString("\nNo commits yet\n\n")
+	}
+	var staged, unstaged, untracked []*statusEntry
+	for _, e := range entries {
+		if e.untracked {
+			untracked = append(untracked, e)
+			continue
+		}
+		if e.x != " " {
+			staged = append(staged, e)
+		}
+		if e.y != " " {
+			unstaged = append(unstaged, e)
+		}
+	}
+	if len(staged) > 0 {
+		buf.WriteString("Changes to be committed:\n  (use \"mygit restore --staged <file>...\" to unstage)\n")
+		for _, e := range staged {
+			path := statusPath(e.path, StatusOptions{}, true)
+			if e.origPath != "" {
+				path = statusPath(e.origPath, StatusOptions{}, true) + " -> " + path
+			}
+			_, _ = fmt.Fprintf(buf, "\t%-12s%s\n", statusVerb(e.x)+":", path)
+		}
+		buf.WriteString("\n")
+	}
+	if len(unstaged) > 0 {
+		buf.WriteString("Changes not staged for commit:\n  (use \"mygit add <file>...\" to update what will be committed)\n  (use \"mygit restore <file>...\" to discard changes in working directory)\n")
+		for _, e := range unstaged {
+			_, _ = fmt.Fprintf(buf, "\t%-12s%s\n", statusVerb(e.y)+":", statusPath(e.path, StatusOptions{}, true))
+		}
+		buf.WriteString("\n")
+	}
+	if len(untracked) > 0 {
+		buf.WriteString("Untracked files:\n  (use \"mygit add <file>...\" to include in what will be committed)\n")
+		for _, e := range untracked {
+			buf.WriteString("\t" + statusPath(e.path, StatusOptions{}, true) + "\n")
+		}
+		buf.WriteString("\n")
+	}
+	switch {
+	case len(staged) > 0:
+	case len(unstaged) > 0:
+		buf.WriteString("no changes added to commit (use \"mygit add\")\n")
+	case len(untracked) > 0:
+		buf.WriteString("nothing added to commit but untracked files present (use \"mygit add\" to track)\n")
+	case branch.oid == nil:
+		buf.WriteString("nothing to commit (create/copy files and use \"mygit add\" to track)\n")
+	default:
+		buf.WriteString("nothing to commit, working tree clean\n")
+	}
+	_, err := o.Write(buf.Bytes())
+	return err
+}
+
+func statusVerb(s string) string {
+	switch s {
+	case gfs.IndexAddedInIndex.String():
+		return "new file"
+	case gfs.IndexDeletedInIndex.String():
+		return "deleted"
+	case gfs.IndexRenamedInIndex.String():
+		return "renamed"
+	case gfs.IndexCopiedInIndex.String():
+		return "copied"
+	case gfs.IndexTypeChangedInIndex.String():
+		return "typechange"
+	default:
+		return "modified"
+	}
+}
+
+func statusSha(s *gfs.Sha) string {
+	if s == nil {
+		return zeroSha
+	}
+	return s.AsHexString()
+}
+
+// statusPath quotes path in the manner of a C string literal if it contains
+// special characters, unless the output is NUL terminated.
+func statusPath(path string, opts StatusOptions, quoteSpace bool) string {
+	if opts.NullTerminated {
+		return path
+	}
+	return quotePath(path, quoteSpace)
+}
+
+func quotePath(path string, quoteSpace bool) string {
+	needsQuote := false
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c < 0x20 || c == '"' || c == '\\' || c >= 0x7f || (quoteSpace && c == ' ') {
+			needsQuote = true
+			break
+		}
+	}
+	if !needsQuote {
+		return path
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch c {
+		case '"':
+			b.WriteString("\\\"")
+		case '\\':
+			b.WriteString("\\\\")
+		case '\t':
+			b.WriteString("\\t")
+		case '\n':
+			b.WriteString("\\n")
+		default:
+			if c < 0x20 || c >= 0x7f {
+				_, _ = fmt.Fprintf(&b, "\\%03o", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func plural(n int, singular string, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
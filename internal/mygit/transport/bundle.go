@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/bundle"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"strings"
+)
+
+// bundleFile is the transport for bundle files, fetching unpacks the whole
+// pack of the bundle.
+type bundleFile struct {
+	path string
+}
+
+func (b *bundleFile) Refs() ([]*Ref, error) {
+	h, r, err := bundle.Open(b.path)
+	if err != nil {
+		return nil, err
+	}
+	_ = r.Close()
+	if err := checkObjectFormat(h.Capabilities[bundle.ObjectFormat]); err != nil {
+		return nil, err
+	}
+	var result []*Ref
+	for _, v := range h.Refs {
+		result = append(result, &Ref{Name: v.Name, Sha: v.Sha})
+	}
+	// a bundle does not record the target of HEAD, use a branch at the same
+	// commit
+	for _, v := range result {
+		if v.Name != config.DefaultHeadFile {
+			continue
+		}
+		for _, w := range result {
+			if strings.HasPrefix(w.Name, "refs/heads/") && bytes.Equal(w.Sha, v.Sha) {
+				v.Target = w.Name
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func (b *bundleFile) Fetch(wants [][]byte) error {
+	h, r, err := bundle.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	if err := CheckPrerequisites(h); err != nil {
+		return err
+	}
+	_, err = objects.UnpackObjects(r, config.ObjectPath())
+	return err
+}
+
+func (b *bundleFile) Push(updates []*RefUpdate) error {
+	return errors.New("fatal: cannot push to a bundle")
+}
+
+// CheckPrerequisites returns an error listing the prerequisite commits of
+// the bundle missing from the object store, or if the bundle is of another
+// object format.
+func CheckPrerequisites(h *bundle.Header) error {
+	if err := checkObjectFormat(h.Capabilities[bundle.ObjectFormat]); err != nil {
+		return err
+	}
+	var missing []string
+	for _, p := range h.Prerequisites {
+		if !objects.Exists(p.Sha) {
+			missing = append(missing, fmt.Sprintf("error: %s %s", p.Sha, p.Comment))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("error: Repository lacks these prerequisite commits:\n%s", strings.Join(missing, "\n"))
+}
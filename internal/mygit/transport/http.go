@@ -0,0 +1,500 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/pktline"
+	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// Agent identifies mygit to servers and clients
+	Agent = "mygit"
+
+	uploadPack  = "git-upload-pack"
+	receivePack = "git-receive-pack"
+
+	// haveBatch is the number of haves sent in each negotiation round
+	haveBatch = 32
+	// maxRounds limits negotiation before giving up and sending done
+	maxRounds = 8
+)
+
+// smartHTTP is the transport for the git smart HTTP protocol, protocol v2 is
+// used for fetching and the receive-pack protocol for pushing.
+type smartHTTP struct {
+	url      string
+	client   *http.Client
+	progress io.Writer
+}
+
+func newSmartHTTP(url string, o *options) *smartHTTP {
+	return &smartHTTP{url: strings.TrimSuffix(url, "/"), client: o.client, progress: o.progress}
+}
+
+// advertisement requests the capability or ref advertisement of service.
+func (s *smartHTTP) advertisement(service string, v2 bool) (*pktline.Reader, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/info/refs?service=%s", s.url, service), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", Agent)
+	if v2 {
+		req.Header.Set("Git-Protocol", "version=2")
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := readResponse(res)
+	if err != nil {
+		return nil, err
+	}
+	if ct := res.Header.Get("Content-Type"); ct != fmt.Sprintf("application/x-%s-advertisement", service) {
+		return nil, fmt.Errorf("fatal: %s/info/refs not valid: is this a git repository?", s.url)
+	}
+	r := pktline.NewReader(bytes.NewReader(body))
+	return r, nil
+}
+
+// capabilities reads the protocol v2 capability advertisement of a server
+// of the object format of the local repository.
+func (s *smartHTTP) capabilities() (map[string]string, error) {
+	caps, err := s.advertisedCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	return caps, checkObjectFormat(caps["object-format"])
+}
+
+// advertisedCapabilities reads the protocol v2 capability advertisement.
+func (s *smartHTTP) advertisedCapabilities() (map[string]string, error) {
+	r, err := s.advertisement(uploadPack, true)
+	if err != nil {
+		return nil, err
+	}
+	caps := make(map[string]string)
+	version := false
+	for {
+		l, t, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if t == pktline.Flush {
+			if version {
+				break
+			}
+			// the service line of older servers is followed by a flush
+			continue
+		}
+		if strings.HasPrefix(l, "# service=") {
+			continue
+		}
+		if l == "version 2" {
+			version = true
+			continue
+		}
+		if !version {
+			return nil, errors.New("fatal: server does not support protocol version 2")
+		}
+		k, v, _ := strings.Cut(l, "=")
+		caps[k] = v
+	}
+	return caps, nil
+}
+
+// command sends a protocol v2 command and returns the response body.
+func (s *smartHTTP) command(command string, args []string) (io.ReadCloser, error) {
+	body := bytes.NewBuffer(nil)
+	w := pktline.NewWriter(body)
+	if err := w.WriteString("command=%s\n", command); err != nil {
+		return nil, err
+	}
+	if err := w.WriteString("agent=%s\n", Agent); err != nil {
+		return nil, err
+	}
+	// servers assume sha1 unless told otherwise
+	if config.Hash() != config.SHA1 {
+		if err := w.WriteString("object-format=%s\n", config.Hash().Name); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Delim(); err != nil {
+		return nil, err
+	}
+	for _, v := range args {
+		if err := w.WriteString("%s\n", v); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return s.post(uploadPack, body, true)
+}
+
+func (s *smartHTTP) post(service string, body io.Reader, v2 bool) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%s", s.url, service), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", Agent)
+	req.Header.Set("Content-Type", fmt.Sprintf("application/x-%s-request", service))
+	req.Header.Set("Accept", fmt.Sprintf("application/x-%s-result", service))
+	if v2 {
+		req.Header.Set("Git-Protocol", "version=2")
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		return nil, fmt.Errorf("fatal: unable to access '%s': %s", s.url, res.Status)
+	}
+	return res.Body, nil
+}
+
+func readResponse(res *http.Response) ([]byte, error) {
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fatal: unable to access '%s': %s", res.Request.URL, res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func (s *smartHTTP) Refs() ([]*Ref, error) {
+	if _, err := s.capabilities(); err != nil {
+		return nil, err
+	}
+	body, err := s.command("ls-refs", []string{"symrefs", "peel"})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = body.Close() }()
+	r := pktline.NewReader(body)
+	var result []*Ref
+	for {
+		l, t, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if t != pktline.Data {
+			break
+		}
+		fields := strings.Split(l, " ")
+		if len(fields) < 2 || len(fields[0]) != config.Hash().HexSize() {
+			return nil, fmt.Errorf("invalid ls-refs response %q", l)
+		}
+		ref := &Ref{Sha: []byte(fields[0]), Name: fields[1]}
+		for _, a := range fields[2:] {
+			switch {
+			case strings.HasPrefix(a, "symref-target:"):
+				ref.Target = strings.TrimPrefix(a, "symref-target:")
+			case strings.HasPrefix(a, "peeled:"):
+				ref.Peeled = []byte(strings.TrimPrefix(a, "peeled:"))
+			}
+		}
+		result = append(result, ref)
+	}
+	return result, nil
+}
+
+func (s *smartHTTP) Fetch(wants [][]byte) error {
+	caps, err := s.capabilities()
+	if err != nil {
+		return err
+	}
+	if _, ok := caps["fetch"]; !ok {
+		return errors.New("fatal: server does not support fetch")
+	}
+	n, err := newNegotiator()
+	if err != nil {
+		return err
+	}
+	var common [][]byte
+	for round := 0; ; round++ {
+		haves := n.next(haveBatch)
+		done := len(haves) == 0 || round == maxRounds
+		var args []string
+		for _, v := range wants {
+			args = append(args, fmt.Sprintf("want %s", v))
+		}
+		args = append(args, "ofs-delta", "include-tag")
+		for _, v := range append(common, haves...) {
+			args = append(args, fmt.Sprintf("have %s", v))
+		}
+		if done {
+			args = append(args, "done")
+		}
+		body, err := s.command("fetch", args)
+		if err != nil {
+			return err
+		}
+		acks, packed, err := s.readFetchResponse(body)
+		_ = body.Close()
+		if err != nil {
+			return err
+		}
+		if packed {
+			return nil
+		}
+		if done {
+			return errors.New("fatal: server did not send a pack")
+		}
+		common = append(common, acks...)
+	}
+}
+
+// readFetchResponse reads the sections of a fetch response, unpacking the
+// packfile section when present. It returns the acknowledged haves and
+// whether a pack was received.
+func (s *smartHTTP) readFetchResponse(body io.Reader) ([][]byte, bool, error) {
+	r := pktline.NewReader(body)
+	var acks [][]byte
+	for {
+		section, t, err := r.ReadLine()
+		if err != nil {
+			return nil, false, err
+		}
+		if t == pktline.Flush {
+			return acks, false, nil
+		}
+		if t != pktline.Data {
+			continue
+		}
+		if section == "packfile" {
+			if _, err := objects.UnpackObjects(r.Sideband(s.progress), config.ObjectPath()); err != nil {
+				return nil, false, err
+			}
+			return acks, true, nil
+		}
+		// acknowledgments, shallow-info and wanted-refs sections are lines up
+		// to a delimiter or flush
+		for {
+			l, t, err := r.ReadLine()
+			if err != nil {
+				return nil, false, err
+			}
+			if t == pktline.Flush {
+				return acks, false, nil
+			}
+			if t != pktline.Data {
+				break
+			}
+			if section == "acknowledgments" && strings.HasPrefix(l, "ACK ") {
+				acks = append(acks, []byte(strings.TrimPrefix(l, "ACK ")))
+			}
+		}
+	}
+}
+
+func (s *smartHTTP) Push(updates []*RefUpdate) error {
+	r, err := s.advertisement(receivePack, false)
+	if err != nil {
+		return err
+	}
+	advertised := make(map[string][]byte)
+	caps := make(map[string]bool)
+	for {
+		l, t, err := r.ReadLine()
+		if err != nil {
+			return err
+		}
+		if t == pktline.Flush {
+			if len(caps) > 0 || len(advertised) > 0 {
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(l, "# service=") {
+			continue
+		}
+		l, c, ok := strings.Cut(l, "\x00")
+		if ok {
+			format := ""
+			for _, v := range strings.Fields(c) {
+				caps[v] = true
+				if name, ok := strings.CutPrefix(v, "object-format="); ok {
+					format = name
+				}
+			}
+			if err := checkObjectFormat(format); err != nil {
+				return err
+			}
+		}
+		sha, name, _ := strings.Cut(l, " ")
+		if name != "capabilities^{}" {
+			advertised[name] = []byte(sha)
+		}
+	}
+	body := bytes.NewBuffer(nil)
+	w := pktline.NewWriter(body)
+	requested := []string{"report-status", fmt.Sprintf("agent=%s", Agent)}
+	if caps["side-band-64k"] {
+		requested = append(requested, "side-band-64k")
+	}
+	if format := "object-format=" + config.Hash().Name; caps[format] {
+		requested = append(requested, format)
+	}
+	var tips [][]byte
+	for i, u := range updates {
+		old, new := u.Old, u.New
+		if old == nil {
+			old = config.Hash().ZeroHex()
+		}
+		if new == nil {
+			new = config.Hash().ZeroHex()
+		} else {
+			tips = append(tips, u.New)
+		}
+		line := fmt.Sprintf("%s %s %s", old, new, u.Name)
+		if i == 0 {
+			line += "\x00" + strings.Join(requested, " ")
+		}
+		if err := w.WriteString("%s\n", line); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(tips) > 0 {
+		shas, err := pushObjects(tips, advertised)
+		if err != nil {
+			return err
+		}
+		if err := objects.WritePack(body, config.ObjectPath(), shas); err != nil {
+			return err
+		}
+	}
+	res, err := s.post(receivePack, body, false)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Close() }()
+	var status io.Reader = res
+	if caps["side-band-64k"] {
+		status = pktline.NewReader(res).Sideband(s.progress)
+	}
+	return readReportStatus(pktline.NewReader(status), updates)
+}
+
+// pushObjects returns the objects reachable from tips which are not
+// reachable from the refs advertised by the remote.
+func pushObjects(tips [][]byte, advertised map[string][]byte) ([][]byte, error) {
+	var remoteTips [][]byte
+	for _, v := range advertised {
+		if objects.Exists(v) {
+			remoteTips = append(remoteTips, v)
+		}
+	}
+	theirs, err := objects.Reachable(config.ObjectPath(), remoteTips, func([]byte) bool { return false })
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]struct{}, len(theirs))
+	for _, v := range theirs {
+		known[string(v)] = struct{}{}
+	}
+	return objects.Reachable(config.ObjectPath(), tips, func(sha []byte) bool {
+		_, ok := known[string(sha)]
+		return ok
+	})
+}
+
+// readReportStatus sets the result of each update from a report-status
+// response.
+func readReportStatus(r *pktline.Reader, updates []*RefUpdate) error {
+	l, _, err := r.ReadLine()
+	if err != nil {
+		return err
+	}
+	if l != "unpack ok" {
+		return fmt.Errorf("error: remote unpack failed: %s", strings.TrimPrefix(l, "unpack "))
+	}
+	byName := make(map[string]*RefUpdate)
+	for _, u := range updates {
+		byName[u.Name] = u
+	}
+	for {
+		l, t, err := r.ReadLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if t == pktline.Flush {
+			return nil
+		}
+		status, rest, _ := strings.Cut(l, " ")
+		name, reason, _ := strings.Cut(rest, " ")
+		u, ok := byName[name]
+		if !ok {
+			continue
+		}
+		if status == "ng" {
+			u.Err = errors.New(reason)
+		}
+	}
+}
+
+// negotiator yields local commits as haves, newest first, starting from the
+// tips of all local refs.
+type negotiator struct {
+	queue []*objects.Commit
+	seen  map[string]struct{}
+}
+
+func newNegotiator() (*negotiator, error) {
+	n := &negotiator{seen: make(map[string]struct{})}
+	all, err := refs.ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range all {
+		sha, err := objects.Peel(config.ObjectPath(), v)
+		if err != nil {
+			continue
+		}
+		n.push(sha)
+	}
+	return n, nil
+}
+
+func (n *negotiator) push(sha []byte) {
+	if _, ok := n.seen[string(sha)]; ok {
+		return
+	}
+	n.seen[string(sha)] = struct{}{}
+	c, err := objects.ReadCommit(sha)
+	if err != nil {
+		// not a commit
+		return
+	}
+	n.queue = append(n.queue, c)
+	sort.SliceStable(n.queue, func(i, j int) bool {
+		return n.queue[i].CommittedTime.After(n.queue[j].CommittedTime)
+	})
+}
+
+func (n *negotiator) next(max int) [][]byte {
+	var r [][]byte
+	for len(r) < max && len(n.queue) > 0 {
+		c := n.queue[0]
+		n.queue = n.queue[1:]
+		r = append(r, c.Sha)
+		for _, p := range c.Parents {
+			n.push(p)
+		}
+	}
+	return r
+}
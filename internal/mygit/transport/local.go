@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"bytes"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/refs"
+	"github.com/richardjennings/mygit/internal/mygit/server"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// local is the transport for repositories on the local filesystem, objects
+// are read and written directly in the object store of the other repository
+// and refs are updated as receive-pack would, including its hooks.
+type local struct {
+	gitDir     string
+	objectsDir string
+	progress   io.Writer
+}
+
+func newLocal(url string, o *options) (*local, error) {
+	gitDir, err := LocalGitDir(url)
+	if err != nil {
+		return nil, err
+	}
+	format, err := localObjectFormat(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkObjectFormat(format); err != nil {
+		return nil, err
+	}
+	return &local{
+		gitDir:     gitDir,
+		objectsDir: filepath.Join(gitDir, config.DefaultObjectsDirectory),
+		progress:   o.progress,
+	}, nil
+}
+
+// localObjectFormat returns the name of the object format of the repository
+// gitDir, empty for SHA-1.
+func localObjectFormat(gitDir string) (string, error) {
+	cnf, err := config.ReadGitConfig(filepath.Join(gitDir, config.DefaultConfigFile))
+	if err != nil {
+		return "", err
+	}
+	format, _ := cnf.Get("extensions.objectformat")
+	return format, nil
+}
+
+func (l *local) Refs() ([]*Ref, error) {
+	all, err := refs.ListRefsIn(l.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	var r []*Ref
+	head, err := refs.SymbolicRefIn(l.gitDir, config.DefaultHeadFile)
+	if err != nil {
+		return nil, err
+	}
+	if sha, err := refs.ReadRefIn(l.gitDir, config.DefaultHeadFile); err != nil {
+		return nil, err
+	} else if sha != nil {
+		r = append(r, &Ref{Name: config.DefaultHeadFile, Sha: sha, Target: head})
+	}
+	var names []string
+	for k := range all {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ref := &Ref{Name: name, Sha: all[name]}
+		peeled, err := objects.Peel(l.objectsDir, ref.Sha)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(peeled, ref.Sha) {
+			ref.Peeled = peeled
+		}
+		r = append(r, ref)
+	}
+	return r, nil
+}
+
+func (l *local) Fetch(wants [][]byte) error {
+	missing, err := objects.Reachable(l.objectsDir, wants, objects.Exists)
+	if err != nil {
+		return err
+	}
+	return objects.CopyObjects(l.objectsDir, config.ObjectPath(), missing)
+}
+
+func (l *local) Push(updates []*RefUpdate) error {
+	var tips [][]byte
+	for _, u := range updates {
+		if u.New != nil {
+			tips = append(tips, u.New)
+		}
+	}
+	missing, err := objects.Reachable(config.ObjectPath(), tips, func(sha []byte) bool {
+		return objects.ExistsIn(l.objectsDir, sha)
+	})
+	if err != nil {
+		return err
+	}
+	if err := objects.CopyObjects(config.ObjectPath(), l.objectsDir, missing); err != nil {
+		return err
+	}
+	commands := make([]*server.Command, len(updates))
+	for i, u := range updates {
+		commands[i] = &server.Command{Name: u.Name, Old: u.Old, New: u.New}
+	}
+	if err := server.UpdateRefs(l.gitDir, commands, l.progress); err != nil {
+		return err
+	}
+	for i, u := range updates {
+		u.Err = commands[i].Err
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package transport
+
+import (
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/bundle"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// Transport exchanges objects and refs with a remote repository
+	Transport interface {
+		// Refs returns the refs of the remote repository, including HEAD
+		Refs() ([]*Ref, error)
+		// Fetch copies the objects reachable from wants which are missing
+		// from the local object store
+		Fetch(wants [][]byte) error
+		// Push copies the objects needed by updates to the remote repository
+		// and then updates its refs. The Err of an update is set when the
+		// remote rejects it.
+		Push(updates []*RefUpdate) error
+	}
+	// Ref is a ref advertised by a remote repository. Hashes are hex encoded.
+	Ref struct {
+		Name string
+		Sha  []byte
+		// Peeled is the object an annotated tag points to
+		Peeled []byte
+		// Target is the ref pointed to by a symbolic ref such as HEAD
+		Target string
+	}
+	// RefUpdate changes a ref in a remote repository from Old to New. A nil
+	// Old creates the ref, a nil New deletes it.
+	RefUpdate struct {
+		Name string
+		Old  []byte
+		New  []byte
+		Err  error
+	}
+)
+
+type (
+	options struct {
+		progress io.Writer
+		client   *http.Client
+	}
+	Opt func(o *options) error
+)
+
+// WithProgress sets where progress messages from the remote are written.
+func WithProgress(w io.Writer) Opt {
+	return func(o *options) error {
+		o.progress = w
+		return nil
+	}
+}
+
+// WithHTTPClient sets the client used for http and https urls.
+func WithHTTPClient(c *http.Client) Opt {
+	return func(o *options) error {
+		o.client = c
+		return nil
+	}
+}
+
+// New returns a Transport for the repository at url, which is either an http
+// or https url, the path of a bundle file or a local path.
+func New(url string, opts ...Opt) (Transport, error) {
+	o := &options{progress: io.Discard, client: http.DefaultClient}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if IsHTTP(url) {
+		return newSmartHTTP(url, o), nil
+	}
+	if bundle.IsBundle(url) {
+		return &bundleFile{path: url}, nil
+	}
+	return newLocal(url, o)
+}
+
+// ObjectFormat returns the object format of the repository at url, which is
+// read from the configuration of a local repository, the header of a bundle
+// or the capabilities advertised by an http server.
+func ObjectFormat(url string, opts ...Opt) (*config.ObjectFormat, error) {
+	o := &options{progress: io.Discard, client: http.DefaultClient}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	var name string
+	switch {
+	case IsHTTP(url):
+		caps, err := newSmartHTTP(url, o).advertisedCapabilities()
+		if err != nil {
+			return nil, err
+		}
+		name = caps["object-format"]
+	case bundle.IsBundle(url):
+		h, r, err := bundle.Open(url)
+		if err != nil {
+			return nil, err
+		}
+		_ = r.Close()
+		name = h.Capabilities[bundle.ObjectFormat]
+	default:
+		gitDir, err := LocalGitDir(url)
+		if err != nil {
+			return nil, err
+		}
+		if name, err = localObjectFormat(gitDir); err != nil {
+			return nil, err
+		}
+	}
+	return config.LookupObjectFormat(name)
+}
+
+// IsHTTP reports whether url uses the smart HTTP transport.
+func IsHTTP(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// LocalGitDir returns the git directory of the repository at path, which is
+// either a working directory containing a git directory or a bare
+// repository.
+func LocalGitDir(path string) (string, error) {
+	path, err := filepath.Abs(strings.TrimPrefix(path, "file://"))
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range []string{filepath.Join(path, config.DefaultGitDirectory), path} {
+		if _, err := os.Stat(filepath.Join(dir, config.DefaultHeadFile)); err != nil {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(dir, config.DefaultObjectsDirectory)); err == nil && fi.IsDir() {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("fatal: repository '%s' does not exist", path)
+}
+
+// checkObjectFormat returns an error unless the object format called name,
+// which is SHA-1 when empty, is that of the local repository.
+func checkObjectFormat(name string) error {
+	f, err := config.LookupObjectFormat(name)
+	if err != nil {
+		return err
+	}
+	if f != config.Hash() {
+		return fmt.Errorf("fatal: mismatched algorithms: client %s; server %s", config.Hash().Name, f.Name)
+	}
+	return nil
+}
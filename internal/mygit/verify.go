@@ -0,0 +1,168 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"github.com/richardjennings/mygit/internal/mygit/config"
+	"github.com/richardjennings/mygit/internal/mygit/objects"
+	"github.com/richardjennings/mygit/internal/mygit/revision"
+	"github.com/richardjennings/mygit/internal/mygit/sign"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VerifyCommit verifies the signatures of the commits revs, writing the
+// result for each as git does. An error is returned for the first commit
+// which is not signed or whose signature does not verify.
+func VerifyCommit(o io.Writer, revs ...string) error {
+	for _, rev := range revs {
+		sha, err := revision.Resolve(rev)
+		if err != nil {
+			return err
+		}
+		obj, err := objects.ReadObject(sha)
+		if err != nil {
+			return err
+		}
+		if obj.Typ != objects.ObjectCommit {
+			return fmt.Errorf("error: %s: cannot verify a non-commit object of type %s.", rev, obj.Typ)
+		}
+		c, err := objects.ReadCommit(sha)
+		if err != nil {
+			return err
+		}
+		if err := verify(o, rev, c.Payload(), c.Sig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyTag verifies the signatures of the annotated tags names, which are
+// appended to the tag message.
+func VerifyTag(o io.Writer, names ...string) error {
+	for _, name := range names {
+		sha, err := revision.Resolve(name)
+		if err != nil {
+			return err
+		}
+		obj, err := objects.ReadObject(sha)
+		if err != nil {
+			return err
+		}
+		if obj.Typ != objects.ObjectTag {
+			return fmt.Errorf("error: %s: cannot verify a non-tag object of type %s.", name, obj.Typ)
+		}
+		content, err := objects.ReadContent(obj)
+		if err != nil {
+			return err
+		}
+		payload, sig := sign.Split(content)
+		if err := verify(o, name, payload, sig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verify(o io.Writer, name string, payload []byte, sig []byte) error {
+	if sig == nil {
+		return fmt.Errorf("error: no signature found in %s", name)
+	}
+	s, err := sign.Verify(payload, sig, signOptions()...)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(o, s.Output)
+	if s.Untrusted {
+		return fmt.Errorf("error: %s is signed by an untrusted key", name)
+	}
+	if !s.Good {
+		return fmt.Errorf("error: could not verify the signature of %s", name)
+	}
+	return nil
+}
+
+// showSignature writes the result of verifying the signature of c, if it
+// is signed, for log --show-signature.
+func showSignature(o io.Writer, c *objects.Commit) {
+	if c.Sig == nil {
+		return
+	}
+	s, err := sign.Verify(c.Payload(), c.Sig, signOptions()...)
+	if err != nil {
+		_, _ = fmt.Fprintln(o, err)
+		return
+	}
+	_, _ = fmt.Fprintln(o, s.Output)
+}
+
+// signCommits reports whether Commit signs, as commit.gpgSign configures
+// unless the options say otherwise.
+func signCommits(opts CommitOptions) bool {
+	if opts.NoSign || opts.Sign {
+		return opts.Sign && !opts.NoSign
+	}
+	cnf, err := config.RepositoryConfig()
+	if err != nil {
+		return false
+	}
+	v, _ := cnf.Bool("commit.gpgSign")
+	return v
+}
+
+// signPayload signs payload in the format of gpg.format with key, or with
+// user.signingKey when key is empty. Without a key OpenPGP signatures are
+// made with the key of the committer identity.
+func signPayload(payload []byte, key string) ([]byte, error) {
+	format := sign.OpenPGP
+	if v, ok := config.Value("gpg.format"); ok {
+		format = v
+	}
+	if format != sign.OpenPGP && format != sign.SSH {
+		return nil, fmt.Errorf("error: invalid value for 'gpg.format': '%s'", format)
+	}
+	if key == "" {
+		key, _ = config.Value("user.signingKey")
+	}
+	if key == "" {
+		if format == sign.SSH {
+			return nil, errors.New("fatal: either user.signingkey or gpg.ssh.defaultKeyCommand needs to be configured")
+		}
+		key = fmt.Sprintf("%s <%s>", config.CommitterName(), config.CommitterEmail())
+	}
+	if format == sign.SSH {
+		key = expandPath(key)
+	}
+	return sign.Sign(format, key, payload, signOptions()...)
+}
+
+// signOptions are the options of sign from the repository configuration.
+func signOptions() []sign.Opt {
+	var opts []sign.Opt
+	if v, ok := config.Value("gpg.openpgp.program"); ok {
+		opts = append(opts, sign.WithProgram(v))
+	} else if v, ok := config.Value("gpg.program"); ok {
+		opts = append(opts, sign.WithProgram(v))
+	}
+	if v, ok := config.Value("gpg.ssh.allowedSignersFile"); ok {
+		opts = append(opts, sign.WithAllowedSigners(expandPath(v)))
+	}
+	return opts
+}
+
+// expandPath expands a leading ~/ to the home directory and makes a
+// relative path relative to the working directory of the repository.
+func expandPath(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	if filepath.IsAbs(path) || strings.HasPrefix(path, "key::") || strings.HasPrefix(path, "ssh-") {
+		return path
+	}
+	return filepath.Join(config.Path(), path)
+}